@@ -9,6 +9,8 @@
 package opaque
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 
@@ -25,19 +27,73 @@ import (
 )
 
 var (
-	// errInvalidMaskedLength happens when unmasking a masked response.
-	errInvalidMaskedLength = errors.New("invalid masked response length")
-
 	// errKe1Missing happens when GenerateKE3 is called and the client has no Ke1 in state.
 	errKe1Missing = errors.New("missing KE1 in client state")
+
+	// errNoLoginToSuspend happens when SuspendLogin is called without a previous successful call to GenerateKE3.
+	errNoLoginToSuspend = errors.New("no login to suspend: GenerateKE3 must succeed first")
+
+	// errInvalidResumptionState happens when the state given to ResumeLogin can't be decoded.
+	errInvalidResumptionState = errors.New("invalid login resumption state")
+
+	// ErrServerPublicKeyMismatch indicates that the server public key in a RegistrationResponse does not match the
+	// expected, pinned, server public key.
+	ErrServerPublicKeyMismatch = errors.New("server public key does not match the expected key")
+
+	// ErrMaskingNonceReuse indicates that the server reused a masking nonce already seen by the client.
+	ErrMaskingNonceReuse = errors.New("masking nonce reused by the server")
+
+	// ErrCredentialIdentifierTooLong indicates that a client-chosen credential identifier is longer than the 65535 bytes
+	// that can be encoded.
+	ErrCredentialIdentifierTooLong = errors.New("credential identifier is too long: must be at most 65535 bytes")
+
+	// ErrEmptyPassword indicates that the password given to RegistrationInitChecked or GenerateKE1Checked is empty,
+	// which is likely an application bug.
+	ErrEmptyPassword = errors.New("empty password")
+
+	// ErrServerIdentityMismatch indicates that the server public key or identity recovered during login does not
+	// match the expected, pinned, value given in GenerateKE3Options. It is only returned once the envelope has been
+	// authenticated, so a wrong password is reported as ErrBadPassword instead.
+	ErrServerIdentityMismatch = errors.New("server identity does not match the expected identity")
+
+	// ErrBadPassword indicates that the client could not unmask the credential response or authenticate its envelope,
+	// most likely because of a wrong password. See ErrEnvelopeCorrupt for the limits of this classification.
+	ErrBadPassword = errors.New("wrong password or corrupted credential response")
+
+	// ErrEnvelopeCorrupt indicates that the envelope failed authentication although the unmasked server public key
+	// matches GenerateKE3Options.ExpectedServerPublicKey. The masking key, which is derived from the password, was thus
+	// right, and the envelope or the identities were corrupted or tampered with.
+	//
+	// This classification is best-effort: a wrong password and a corrupted record can't be told apart in general. The
+	// server public key is masked under a key derived from the password, so a wrong password garbles it, as does a
+	// corrupted masking key. Without an expected server public key to compare it to, any unmasking or envelope
+	// authentication failure is therefore reported as ErrBadPassword. Mismatched client or server identities, or KSF
+	// parameters, are reported like a wrong password or a corrupted envelope too.
+	ErrEnvelopeCorrupt = errors.New("envelope is corrupted")
+
+	// ErrInvalidMaskedResponse indicates that the masked response in KE2 is not of the server public key and envelope's
+	// combined length, e.g. from a malicious server or a KE2 built by hand, and can't be unmasked.
+	ErrInvalidMaskedResponse = errors.New("invalid masked response length")
+
+	// ErrEmptyRegistrationUpload indicates that a registration upload container holds neither a RegistrationRequest
+	// nor a RegistrationRecord.
+	ErrEmptyRegistrationUpload = errors.New("registration upload holds no message")
 )
 
+// NonceStore keeps track of nonces. Seen records the nonce, and returns whether it has already been recorded before.
+type NonceStore interface {
+	Seen(nonce []byte) bool
+}
+
 // Client represents an OPAQUE Client, exposing its functions and holding its state.
 type Client struct {
 	Deserialize *Deserializer
 	OPRF        *oprf.Client
 	Ake         *ake.Client
 	conf        *internal.Configuration
+	nonces      NonceStore
+	exportKey   []byte
+	ke3         []byte
 }
 
 // NewClient returns a new Client instantiation given the application Configuration.
@@ -46,6 +102,10 @@ func NewClient(c *Configuration) (*Client, error) {
 		c = DefaultConfiguration()
 	}
 
+	if err := c.checkKSF(); err != nil {
+		return nil, err
+	}
+
 	conf, err := c.toInternal()
 	if err != nil {
 		return nil, err
@@ -54,28 +114,135 @@ func NewClient(c *Configuration) (*Client, error) {
 	return &Client{
 		OPRF:        conf.OPRF.Client(),
 		Ake:         ake.NewClient(),
-		Deserialize: &Deserializer{conf: conf},
+		Deserialize: newDeserializer(c, conf),
 		conf:        conf,
+		nonces:      nil,
+		exportKey:   nil,
+		ke3:         nil,
 	}, nil
 }
 
+// NewClientStrict returns a new Client instantiation as NewClient does, but rejects configurations failing
+// Configuration.StrictVerify or Configuration.ValidateKSFStrength.
+func NewClientStrict(c *Configuration) (*Client, error) {
+	if c == nil {
+		c = DefaultConfiguration()
+	}
+
+	if err := c.StrictVerify(); err != nil {
+		return nil, err
+	}
+
+	if err := c.ValidateKSFStrength(); err != nil {
+		return nil, err
+	}
+
+	return NewClient(c)
+}
+
+// SetSeenMaskingNonces sets an optional store of the masking nonces seen in the servers' credential responses, so that
+// GenerateKE3 fails with ErrMaskingNonceReuse if a server reuses one. Setting a nil store disables the check.
+func (c *Client) SetSeenMaskingNonces(store NonceStore) {
+	c.nonces = store
+}
+
+// Reset clears the client's per-session values, i.e. the OPRF blind, the AKE values and session key, the export key,
+// and any login resumption state, so that the instance can safely be reused for a new registration or login. The
+// configuration and the masking nonce store are kept.
+func (c *Client) Reset() {
+	c.OPRF = c.conf.OPRF.Client()
+	c.Ake.Flush()
+	c.Ake.Ke1 = nil
+	c.exportKey = nil
+	c.ke3 = nil
+}
+
 // GetConf returns the internal configuration.
 func (c *Client) GetConf() *internal.Configuration {
 	return c.conf
 }
 
-// buildPRK derives the randomized password from the OPRF output.
-func (c *Client) buildPRK(evaluation *ecc.Element, ksfSalt, kdfSalt []byte, ksfLength int) []byte {
+// Group returns the AKE group the client was built with, which recommended configurations also use for the OPRF.
+func (c *Client) Group() Group {
+	return Group(c.conf.Group)
+}
+
+// stretching holds the optional key stretching settings of a registration or login.
+type stretching struct {
+	progress     func(fraction float64)
+	preStretched bool
+}
+
+// report calls the progress callback, if set.
+func (s stretching) report(fraction float64) {
+	if s.progress != nil {
+		s.progress(fraction)
+	}
+}
+
+// buildPRK derives the randomized password from the OPRF output. If preStretched is set, the KSF is skipped, as with
+// the identity KSF. The KSFs can't be run in chunks, so progress is reported before and after the KSF.
+func (c *Client) buildPRK(
+	evaluation *ecc.Element,
+	ksfSalt, kdfSalt []byte,
+	ksfLength int,
+	s stretching,
+) []byte {
 	output := c.OPRF.Finalize(evaluation)
-	stretched := c.conf.KSF.Harden(output, ksfSalt, ksfLength)
+
+	stretched := output
+	if !s.preStretched {
+		s.report(0)
+		stretched = c.conf.KSF.Harden(output, ksfSalt, ksfLength)
+		s.report(1)
+	}
 
 	return c.conf.KDF.Extract(kdfSalt, encoding.Concat(output, stretched))
 }
 
+// buildPRKContext derives the randomized password from the OPRF output, running the KSF in a goroutine and returning
+// ctx.Err() if the context is done before it finishes. The KSF is not interruptible: it continues in the background and
+// its result is discarded. Progress is reported from the calling goroutine, and not on completion if the context is
+// done first.
+func (c *Client) buildPRKContext(
+	ctx context.Context,
+	evaluation *ecc.Element,
+	ksfSalt, kdfSalt []byte,
+	ksfLength int,
+	s stretching,
+) ([]byte, error) {
+	if ctx.Done() == nil || s.preStretched {
+		return c.buildPRK(evaluation, ksfSalt, kdfSalt, ksfLength, s), nil
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	output := c.OPRF.Finalize(evaluation)
+	result := make(chan []byte, 1)
+
+	s.report(0)
+
+	go func() {
+		result <- c.conf.KSF.Harden(output, ksfSalt, ksfLength)
+	}()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case stretched := <-result:
+		s.report(1)
+		return c.conf.KDF.Extract(kdfSalt, encoding.Concat(output, stretched)), nil
+	}
+}
+
 // ClientRegistrationInitOptions enables setting internal client values for the client registration.
 type ClientRegistrationInitOptions struct {
 	// OPRFBlind: optional.
 	OPRFBlind *ecc.Scalar
+	// AllowEmptyPassword: optional, lets RegistrationInitChecked accept an empty password.
+	AllowEmptyPassword bool
 }
 
 func getClientRegistrationInitBlind(options []ClientRegistrationInitOptions) *ecc.Scalar {
@@ -86,25 +253,67 @@ func getClientRegistrationInitBlind(options []ClientRegistrationInitOptions) *ec
 	return options[0].OPRFBlind
 }
 
+// oprfBlind returns the given blind if set, and a random scalar from the configuration's random source otherwise.
+func (c *Client) oprfBlind(blind *ecc.Scalar) *ecc.Scalar {
+	if blind != nil {
+		return blind
+	}
+
+	return c.conf.RandomScalar(c.conf.OPRF.Group())
+}
+
 // RegistrationInit returns a RegistrationRequest message blinding the given password.
 func (c *Client) RegistrationInit(
 	password []byte,
 	options ...ClientRegistrationInitOptions,
 ) *message.RegistrationRequest {
-	m := c.OPRF.Blind(password, getClientRegistrationInitBlind(options))
+	m := c.OPRF.Blind(password, c.oprfBlind(getClientRegistrationInitBlind(options)))
 
 	return &message.RegistrationRequest{
 		BlindedMessage: m,
 	}
 }
 
+// RegistrationInitChecked behaves like RegistrationInit, but returns ErrEmptyPassword if the password is empty, unless
+// the AllowEmptyPassword option is set.
+func (c *Client) RegistrationInitChecked(
+	password []byte,
+	options ...ClientRegistrationInitOptions,
+) (*message.RegistrationRequest, error) {
+	if len(password) == 0 && (len(options) == 0 || !options[0].AllowEmptyPassword) {
+		return nil, ErrEmptyPassword
+	}
+
+	return c.RegistrationInit(password, options...), nil
+}
+
+// RegistrationInitWithIdentifier behaves like RegistrationInit, but returns the serialized RegistrationRequest followed
+// by the 2-byte length-prefixed, application-chosen, credentialIdentifier, for the server to bind the registration to
+// it. The server extracts both with Deserializer.RegistrationRequestWithIdentifier, and must use the identifier as the
+// credential identifier in RegistrationResponse and the ClientRecord. The identifier is not secret, nor authenticated
+// by the protocol: the server must verify that the client is allowed to register it.
+func (c *Client) RegistrationInitWithIdentifier(
+	password, credentialIdentifier []byte,
+	options ...ClientRegistrationInitOptions,
+) ([]byte, error) {
+	if len(credentialIdentifier) > maxIdentityLength {
+		return nil, ErrCredentialIdentifierTooLong
+	}
+
+	request := c.RegistrationInit(password, options...)
+
+	return encoding.Concat(request.Serialize(), encoding.EncodeVector(credentialIdentifier)), nil
+}
+
 // ClientRegistrationFinalizeOptions enables setting optional client values for the client registration.
 type ClientRegistrationFinalizeOptions struct {
 	// ClientIdentity: optional.
 	ClientIdentity []byte
 	// ServerIdentity: optional.
 	ServerIdentity []byte
-	// EnvelopeNonce : optional.
+	// EnvelopeNonce: optional, the nonce of the envelope, which defaults to a random one. Together with
+	// ClientRegistrationInitOptions.OPRFBlind, it makes the registration record reproducible, e.g. for test vectors. It
+	// must be of the configured nonce length, and must never be reused in production.
 	EnvelopeNonce []byte
 	// KDFSalt: optional.
 	KDFSalt []byte
@@ -114,6 +323,13 @@ type ClientRegistrationFinalizeOptions struct {
 	KSFParameters []int
 	// KSFLength: optional.
 	KSFLength uint32
+	// KSFProgress: optional, called with the fraction of the KSF completed, e.g. to show a progress bar during a long
+	// Argon2id run. The KSFs can't be run in chunks, so it is called with 0 before and 1 after the KSF. It is not
+	// called if PreStretched is set.
+	KSFProgress func(fraction float64)
+	// PreStretched: optional, skips the KSF for passwords already stretched by the application, e.g. in a browser
+	// worker. Login must then set GenerateKE3Options.PreStretched too, or it fails.
+	PreStretched bool
 }
 
 func (c *Client) initClientRegistrationFinalizeOptions(
@@ -148,58 +364,163 @@ func (c *Client) RegistrationFinalize(
 	resp *message.RegistrationResponse,
 	options ...ClientRegistrationFinalizeOptions,
 ) (record *message.RegistrationRecord, exportKey []byte) {
+	// Without cancellation, the registration can't fail.
+	record, exportKey, _ = c.registrationFinalize(context.Background(), resp, options)
+
+	return record, exportKey
+}
+
+// RegistrationFinalizeContext behaves like RegistrationFinalize, but returns ctx.Err() if the context is done before
+// the key stretching function finishes. Since the KSF is not interruptible, its work continues in the background, but
+// its result is discarded.
+func (c *Client) RegistrationFinalizeContext(
+	ctx context.Context,
+	resp *message.RegistrationResponse,
+	options ...ClientRegistrationFinalizeOptions,
+) (record *message.RegistrationRecord, exportKey []byte, err error) {
+	return c.registrationFinalize(ctx, resp, options)
+}
+
+func (c *Client) registrationFinalize(
+	ctx context.Context,
+	resp *message.RegistrationResponse,
+	options []ClientRegistrationFinalizeOptions,
+) (*message.RegistrationRecord, []byte, error) {
 	credentials, ksfSalt, kdfSalt, ksfLength := c.initClientRegistrationFinalizeOptions(options)
-	randomizedPassword := c.buildPRK(resp.EvaluatedMessage, ksfSalt, kdfSalt, ksfLength)
+
+	var s stretching
+	if len(options) != 0 {
+		s = stretching{progress: options[0].KSFProgress, preStretched: options[0].PreStretched}
+	}
+
+	randomizedPassword, err := c.buildPRKContext(
+		ctx,
+		resp.EvaluatedMessage,
+		ksfSalt,
+		kdfSalt,
+		ksfLength,
+		s,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	maskingKey := c.conf.KDF.Expand(randomizedPassword, []byte(tag.MaskingKey), c.conf.KDF.Size())
 	envelope, clientPublicKey, exportKey := keyrecovery.Store(c.conf, randomizedPassword, resp.Pks, credentials)
+	c.exportKey = exportKey
 
 	return &message.RegistrationRecord{
 		PublicKey:  clientPublicKey,
 		MaskingKey: maskingKey,
 		Envelope:   envelope.Serialize(),
-	}, exportKey
+	}, exportKey, nil
+}
+
+// RegistrationFinalizeWithIdentities behaves like RegistrationFinalize, but first verifies that the server public key
+// in the RegistrationResponse matches the expected, pinned, serverPublicKey. This protects against a malicious
+// registration server substituting its own key.
+func (c *Client) RegistrationFinalizeWithIdentities(
+	resp *message.RegistrationResponse,
+	serverPublicKey []byte,
+	options ...ClientRegistrationFinalizeOptions,
+) (record *message.RegistrationRecord, exportKey []byte, err error) {
+	expected := c.conf.Group.NewElement()
+	if err = expected.Decode(serverPublicKey); err != nil {
+		return nil, nil, fmt.Errorf("expected server public key: %w", err)
+	}
+
+	if resp == nil || resp.Pks == nil || resp.Pks.Group() != c.conf.Group || !expected.Equal(resp.Pks) {
+		return nil, nil, ErrServerPublicKeyMismatch
+	}
+
+	record, exportKey = c.RegistrationFinalize(resp, options...)
+
+	return record, exportKey, nil
+}
+
+// RegistrationUpload returns a single container holding the serialized request and record, each 2-byte length-prefixed,
+// for applications to send one blob per registration phase: the container holds the request in the first phase, and
+// the record in the second, with the other left nil. The server extracts them with Deserializer.RegistrationUpload.
+// It returns ErrEmptyRegistrationUpload if both are nil.
+func (c *Client) RegistrationUpload(
+	request *message.RegistrationRequest,
+	record *message.RegistrationRecord,
+) ([]byte, error) {
+	if request == nil && record == nil {
+		return nil, ErrEmptyRegistrationUpload
+	}
+
+	var serializedRequest, serializedRecord []byte
+	if request != nil {
+		serializedRequest = request.Serialize()
+	}
+
+	if record != nil {
+		serializedRecord = record.Serialize()
+	}
+
+	return encoding.Concat(encoding.EncodeVector(serializedRequest), encoding.EncodeVector(serializedRecord)), nil
 }
 
 // GenerateKE1Options enable setting optional values for the session, which default to secure random values if not
 // set.
 type GenerateKE1Options struct {
-	// OPRFBlind: optional.
+	// OPRFBlind: optional, the scalar blinding the password, e.g. for reproducible tests or test vectors.
 	OPRFBlind *ecc.Scalar
-	// KeyShareSeed: optional.
+	// KeyShareSeed: optional, the seed from which the client's ephemeral key share is derived.
 	KeyShareSeed []byte
-	// AKENonce: optional.
+	// AKENonce: optional, the client nonce.
 	AKENonce []byte
-	// AKENonceLength: optional, overrides the default length of the nonce to be created if no nonce is provided.
+	// AKENonceLength: optional, overrides the configuration's nonce length for the nonce to be created if no nonce is
+	// provided. Note that messages are only deserializable if their nonces are of the configuration's nonce length.
 	AKENonceLength uint32
+	// AllowEmptyPassword: optional, lets GenerateKE1Checked accept an empty password.
+	AllowEmptyPassword bool
 }
 
-func getGenerateKE1Options(options []GenerateKE1Options) (*ecc.Scalar, ake.Options) {
+func getGenerateKE1Options(options []GenerateKE1Options, nonceLength int) (*ecc.Scalar, ake.Options) {
 	if len(options) != 0 {
-		return options[0].OPRFBlind, ake.Options{
+		op := ake.Options{
 			KeyShareSeed: options[0].KeyShareSeed,
 			Nonce:        options[0].AKENonce,
 			NonceLength:  options[0].AKENonceLength,
 		}
+
+		if op.NonceLength == 0 {
+			op.NonceLength = uint32(nonceLength) //nolint:gosec // the nonce length is verified in the configuration.
+		}
+
+		return options[0].OPRFBlind, op
 	}
 
 	return nil, ake.Options{
 		KeyShareSeed: nil,
 		Nonce:        nil,
-		NonceLength:  internal.NonceLength,
+		NonceLength:  uint32(nonceLength), //nolint:gosec // the nonce length is verified in the configuration.
 	}
 }
 
 // GenerateKE1 initiates the authentication process, returning a KE1 message blinding the given password.
 func (c *Client) GenerateKE1(password []byte, options ...GenerateKE1Options) *message.KE1 {
-	blind, akeOptions := getGenerateKE1Options(options)
-	m := c.OPRF.Blind(password, blind)
-	ke1 := c.Ake.Start(c.conf.Group, akeOptions)
+	blind, akeOptions := getGenerateKE1Options(options, c.conf.NonceLen)
+	m := c.OPRF.Blind(password, c.oprfBlind(blind))
+	ke1 := c.Ake.Start(c.conf, akeOptions)
 	ke1.CredentialRequest = message.NewCredentialRequest(m)
 	c.Ake.Ke1 = ke1.Serialize()
 
 	return ke1
 }
 
+// GenerateKE1Checked behaves like GenerateKE1, but returns ErrEmptyPassword if the password is empty, unless the
+// AllowEmptyPassword option is set.
+func (c *Client) GenerateKE1Checked(password []byte, options ...GenerateKE1Options) (*message.KE1, error) {
+	if len(password) == 0 && (len(options) == 0 || !options[0].AllowEmptyPassword) {
+		return nil, ErrEmptyPassword
+	}
+
+	return c.GenerateKE1(password, options...), nil
+}
+
 // GenerateKE3Options enable setting optional client values for the client registration.
 type GenerateKE3Options struct {
 	// ClientIdentity: optional.
@@ -214,6 +535,52 @@ type GenerateKE3Options struct {
 	KSFParameters []int
 	// KSFLength: optional.
 	KSFLength uint32
+	// ExpectedServerPublicKey: optional, if set the login fails with ErrServerIdentityMismatch when the server public
+	// key recovered from the KE2 message is not this encoded key.
+	ExpectedServerPublicKey []byte
+	// ExpectedServerIdentity: optional, if set the login fails with ErrServerIdentityMismatch when the server identity
+	// used in the AKE is not this identity. That identity is ServerIdentity if set, and the server public key otherwise.
+	ExpectedServerIdentity []byte
+	// AssociatedData: optional, data bound into the AKE transcript, e.g. a TLS exporter value for channel binding. It
+	// must be the same as the server's, or the login fails, and at most 65535 bytes long.
+	AssociatedData []byte
+	// KSFProgress: optional, called with the fraction of the KSF completed, as ClientRegistrationFinalizeOptions.
+	KSFProgress func(fraction float64)
+	// PreStretched: optional, skips the KSF for passwords already stretched by the application. It must be the same as
+	// ClientRegistrationFinalizeOptions.PreStretched at registration, or the login fails.
+	PreStretched bool
+}
+
+// verifyExpectedServer verifies the recovered server public key and identity against the values pinned in options.
+func verifyExpectedServer(options []GenerateKE3Options, serverIdentity, serverPublicKey []byte) error {
+	if len(options) == 0 {
+		return nil
+	}
+
+	if options[0].ExpectedServerPublicKey != nil && !bytes.Equal(options[0].ExpectedServerPublicKey, serverPublicKey) {
+		return ErrServerIdentityMismatch
+	}
+
+	if serverIdentity == nil {
+		serverIdentity = serverPublicKey
+	}
+
+	if options[0].ExpectedServerIdentity != nil && !bytes.Equal(options[0].ExpectedServerIdentity, serverIdentity) {
+		return ErrServerIdentityMismatch
+	}
+
+	return nil
+}
+
+// recoveryFailure returns ErrEnvelopeCorrupt if the unmasked server public key matches the expected one, meaning the
+// password was right, and ErrBadPassword otherwise.
+func recoveryFailure(options []GenerateKE3Options, serverPublicKey []byte) error {
+	if len(options) != 0 && options[0].ExpectedServerPublicKey != nil &&
+		bytes.Equal(options[0].ExpectedServerPublicKey, serverPublicKey) {
+		return ErrEnvelopeCorrupt
+	}
+
+	return ErrBadPassword
 }
 
 func (c *Client) initGenerateKE3Options(options []GenerateKE3Options) (*ake.Identities, []byte, []byte, int) {
@@ -221,6 +588,7 @@ func (c *Client) initGenerateKE3Options(options []GenerateKE3Options) (*ake.Iden
 		return &ake.Identities{
 			ClientIdentity: nil,
 			ServerIdentity: nil,
+			AssociatedData: nil,
 		}, nil, nil, c.conf.Group.ElementLength()
 	}
 
@@ -236,6 +604,7 @@ func (c *Client) initGenerateKE3Options(options []GenerateKE3Options) (*ake.Iden
 	return &ake.Identities{
 		ClientIdentity: options[0].ClientIdentity,
 		ServerIdentity: options[0].ServerIdentity,
+		AssociatedData: options[0].AssociatedData,
 	}, options[0].KSFSalt, options[0].KDFSalt, ksfLength
 }
 
@@ -244,25 +613,84 @@ func (c *Client) initGenerateKE3Options(options []GenerateKE3Options) (*ake.Iden
 func (c *Client) GenerateKE3(
 	ke2 *message.KE2, options ...GenerateKE3Options,
 ) (ke3 *message.KE3, exportKey []byte, err error) {
+	return c.generateKE3(context.Background(), ke2, options)
+}
+
+// GenerateKE3Context behaves like GenerateKE3, but returns ctx.Err() if the context is done before the key stretching
+// function finishes. Since the KSF is not interruptible, its work continues in the background, but its result is
+// discarded.
+func (c *Client) GenerateKE3Context(
+	ctx context.Context,
+	ke2 *message.KE2,
+	options ...GenerateKE3Options,
+) (ke3 *message.KE3, exportKey []byte, err error) {
+	return c.generateKE3(ctx, ke2, options)
+}
+
+func (c *Client) generateKE3(
+	ctx context.Context,
+	ke2 *message.KE2,
+	options []GenerateKE3Options,
+) (ke3 *message.KE3, exportKey []byte, err error) {
+	c.exportKey = nil
+	c.ke3 = nil
+
 	if len(c.Ake.Ke1) == 0 {
 		return nil, nil, errKe1Missing
 	}
 
 	// This test is very important as it avoids buffer overflows in subsequent parsing.
 	if len(ke2.MaskedResponse) != c.conf.Group.ElementLength()+c.conf.EnvelopeSize {
-		return nil, nil, errInvalidMaskedLength
+		return nil, nil, ErrInvalidMaskedResponse
+	}
+
+	if c.nonces != nil && c.nonces.Seen(ke2.MaskingNonce) {
+		return nil, nil, ErrMaskingNonceReuse
 	}
 
 	identities, ksfSalt, kdfSalt, ksfLength := c.initGenerateKE3Options(options)
 
 	// Finalize the OPRF.
-	randomizedPassword := c.buildPRK(ke2.EvaluatedMessage, ksfSalt, kdfSalt, ksfLength)
+	var s stretching
+	if len(options) != 0 {
+		s = stretching{progress: options[0].KSFProgress, preStretched: options[0].PreStretched}
+	}
+
+	randomizedPassword, err := c.buildPRKContext(ctx, ke2.EvaluatedMessage, ksfSalt, kdfSalt, ksfLength, s)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The randomized password is password-equivalent, and is not kept once the login is finalized.
+	defer clear(randomizedPassword)
+
+	ke3, exportKey, err = c.finalizeKE3(ke2, randomizedPassword, identities, options)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c.ke3 = ke3.Serialize()
+
+	return ke3, exportKey, nil
+}
+
+func (c *Client) finalizeKE3(
+	ke2 *message.KE2,
+	randomizedPassword []byte,
+	identities *ake.Identities,
+	options []GenerateKE3Options,
+) (*message.KE3, []byte, error) {
+	c.exportKey = nil
 
 	// Decrypt the masked response.
 	serverPublicKey, serverPublicKeyBytes,
 		envelope, err := masking.Unmask(c.conf, randomizedPassword, ke2.MaskingNonce, ke2.MaskedResponse)
 	if err != nil {
-		return nil, nil, fmt.Errorf("unmasking: %w", err)
+		return nil, nil, fmt.Errorf("unmasking: %w: %w", err, ErrBadPassword)
+	}
+
+	if len(identities.AssociatedData) > c.conf.MaxIdentityLength() {
+		return nil, nil, ErrAssociatedDataTooLong
 	}
 
 	// Recover the client keys.
@@ -275,21 +703,142 @@ func (c *Client) GenerateKE3(
 		identities.ServerIdentity,
 		envelope)
 	if err != nil {
-		return nil, nil, fmt.Errorf("key recovery: %w", err)
+		return nil, nil, fmt.Errorf("key recovery: %w: %w", err, recoveryFailure(options, serverPublicKeyBytes))
+	}
+
+	// The pinned values are only checked once the envelope authenticated the server public key, since a wrong password
+	// garbles it.
+	if err = verifyExpectedServer(options, identities.ServerIdentity, serverPublicKeyBytes); err != nil {
+		return nil, nil, err
 	}
 
 	// Finalize the AKE.
 	identities.SetIdentities(clientPublicKey, serverPublicKeyBytes)
 
-	ke3, err = c.Ake.Finalize(c.conf, identities, clientSecretKey, serverPublicKey, ke2)
+	ke3, err := c.Ake.Finalize(c.conf, identities, clientSecretKey, serverPublicKey, ke2)
 	if err != nil {
 		return nil, nil, fmt.Errorf("finalizing AKE: %w", err)
 	}
 
+	c.exportKey = exportKey
+
 	return ke3, exportKey, nil
 }
 
+// LoginFinishOptions are the options of FinishLogin, which are the same as GenerateKE3's.
+type LoginFinishOptions = GenerateKE3Options
+
+// LoginResult groups the outputs of a successful client login: the KE3 message to send to the server, the session key,
+// and the export key.
+type LoginResult struct {
+	KE3        *message.KE3
+	SessionKey []byte
+	ExportKey  []byte
+}
+
+// FinishLogin behaves like GenerateKE3, but returns the KE3 message, the session key, and the export key together, so
+// that they don't need to be fetched with separate accessors.
+func (c *Client) FinishLogin(ke2 *message.KE2, options ...LoginFinishOptions) (*LoginResult, error) {
+	ke3, exportKey, err := c.GenerateKE3(ke2, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LoginResult{
+		KE3:        ke3,
+		SessionKey: c.SessionKey(),
+		ExportKey:  exportKey,
+	}, nil
+}
+
+// SuspendLogin returns the client's login state after a successful call to GenerateKE3, so that the KE3 message can
+// be re-sent with ResumeLogin, e.g. on a new Client after a transport reconnect, without re-running the expensive
+// KSF. The state holds the KE3 message, and the session and export keys of the login. It holds no password-equivalent
+// value, as the randomized password is discarded once GenerateKE3 completes.
+//
+// The state is secret, as it gives access to the session and the export key. It must never be persisted nor leave the
+// client, and should be discarded as soon as the login completes. Resuming only succeeds if the server hasn't
+// discarded its AKE state of that session, i.e. hasn't called LoginFinish or Reset since the KE2, and it is up to the
+// server to expire that state within a short window (seconds).
+func (c *Client) SuspendLogin() ([]byte, error) {
+	if c.ke3 == nil || c.Ake.SessionKey() == nil || c.exportKey == nil {
+		return nil, errNoLoginToSuspend
+	}
+
+	return encoding.Concatenate(
+		encoding.EncodeVector(c.ke3),
+		encoding.EncodeVector(c.Ake.SessionKey()),
+		encoding.EncodeVector(c.exportKey),
+	), nil
+}
+
+func decodeResumptionState(state []byte) ([][]byte, error) {
+	const nbValues = 3
+
+	values := make([][]byte, nbValues)
+	offset := 0
+
+	for i := range values {
+		v, o, err := encoding.DecodeVector(state[offset:])
+		if err != nil {
+			return nil, errInvalidResumptionState
+		}
+
+		values[i] = v
+		offset += o
+	}
+
+	if offset != len(state) {
+		return nil, errInvalidResumptionState
+	}
+
+	return values, nil
+}
+
+// ResumeLogin restores a login state returned by SuspendLogin and returns the same KE3 message and export key as the
+// suspended session, without re-running the OPRF and KSF. On success, SessionKey() returns the session key. See
+// SuspendLogin for the security constraints.
+func (c *Client) ResumeLogin(state []byte) (ke3 *message.KE3, exportKey []byte, err error) {
+	values, err := decodeResumptionState(state)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ke3, err = c.Deserialize.KE3(values[0])
+	if err != nil {
+		return nil, nil, errInvalidResumptionState
+	}
+
+	if len(values[1]) != c.conf.KDF.Size() || len(values[2]) != c.conf.KDF.Size() {
+		return nil, nil, errInvalidResumptionState
+	}
+
+	c.Ake.Resume(values[1])
+	c.exportKey = values[2]
+	c.ke3 = values[0]
+
+	return ke3, c.exportKey, nil
+}
+
+// ExportKey returns the export key if the previous call to RegistrationFinalize(), GenerateKE3(), or ResumeLogin() was
+// successful, and nil otherwise. The export key is the same at registration and login for the same password.
+func (c *Client) ExportKey() []byte {
+	return c.exportKey
+}
+
+// TranscriptHash returns the hash of the handshake preamble, binding the session to its transcript, if the previous
+// call to GenerateKE3() was successful. It can be logged for audit purposes, and matches the server's.
+func (c *Client) TranscriptHash() []byte {
+	return c.Ake.TranscriptHash()
+}
+
 // SessionKey returns the session key if the previous call to GenerateKE3() was successful.
 func (c *Client) SessionKey() []byte {
 	return c.Ake.SessionKey()
 }
+
+// ExpectedServerMAC returns the server MAC the KE2 message was verified against, in constant time, if the previous
+// call to GenerateKE3() was successful. It mirrors the server's ExpectedMAC().
+func (c *Client) ExpectedServerMAC() []byte {
+	return c.Ake.ExpectedServerMAC()
+}
@@ -9,16 +9,32 @@
 package opaque
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 
 	"github.com/bytemare/ecc"
 
 	"github.com/bytemare/opaque/internal"
+	"github.com/bytemare/opaque/internal/encoding"
 	"github.com/bytemare/opaque/message"
 )
 
 var (
+	// ErrTrailingBytes indicates that the input is longer than the expected encoded length of the message.
+	ErrTrailingBytes = errors.New("trailing bytes after the message for the configuration")
+
+	// ErrRecordVersionUnsupported indicates that a serialized ClientRecord was created with another RecordVersion, and
+	// must be migrated, e.g. by having the client register again.
+	ErrRecordVersionUnsupported = errors.New("unsupported client record version")
+
+	// ErrVectorTooLong indicates that a length-prefixed field announces a length above its maximum sensible length,
+	// e.g. a component of sealed key material longer than any seed or key.
+	ErrVectorTooLong = encoding.ErrVectorTooLong
+
+	// ErrCiphersuiteMismatch indicates that a wrapped message was produced under a different configuration.
+	ErrCiphersuiteMismatch = message.ErrCiphersuiteMismatch
+
 	errInvalidMessageLength = errors.New("invalid message length for the configuration")
 	errInvalidBlindedData   = errors.New("blinded data is an invalid point")
 	errInvalidClientEPK     = errors.New("invalid ephemeral client public key")
@@ -30,14 +46,62 @@ var (
 
 // Deserializer exposes the message deserialization functions.
 type Deserializer struct {
-	conf *internal.Configuration
+	conf   *internal.Configuration
+	public *Configuration
+}
+
+// newDeserializer returns a Deserializer for the internal configuration, keeping a copy of the public configuration it
+// was built from.
+func newDeserializer(c *Configuration, conf *internal.Configuration) *Deserializer {
+	return &Deserializer{conf: conf, public: c.Clone()}
+}
+
+// Configuration returns a copy of the configuration the deserializer was built from, e.g. for code that receives a
+// Server's or Client's Deserializer but not their configuration. It returns nil for a zero Deserializer.
+func (d *Deserializer) Configuration() *Configuration {
+	if d.public == nil {
+		return nil
+	}
+
+	return d.public.Clone()
+}
+
+// ConfigFingerprint returns a short fingerprint of the deserializer's configuration. Applications can prefix messages
+// with it using message.WrapWithFingerprint, so that a peer using a different configuration gets
+// ErrCiphersuiteMismatch from Unwrap instead of confusing length or decoding errors.
+func (d *Deserializer) ConfigFingerprint() []byte {
+	return bytes.Clone(d.conf.Fingerprint)
+}
+
+// Wrap prefixes the serialized message with the deserializer's configuration fingerprint.
+func (d *Deserializer) Wrap(msg []byte) []byte {
+	return message.WrapWithFingerprint(d.conf.Fingerprint, msg)
+}
+
+// Unwrap strips the configuration fingerprint from a wrapped message, and returns ErrCiphersuiteMismatch if it was
+// produced under a different configuration. The returned message can then be given to the matching deserialization
+// method.
+func (d *Deserializer) Unwrap(wrapped []byte) ([]byte, error) {
+	return message.UnwrapFingerprint(d.conf.Fingerprint, wrapped)
+}
+
+// checkLength returns ErrTrailingBytes if the input is longer than expected, and errInvalidMessageLength if shorter.
+func checkLength(input []byte, expected int) error {
+	switch {
+	case len(input) > expected:
+		return ErrTrailingBytes
+	case len(input) < expected:
+		return errInvalidMessageLength
+	default:
+		return nil
+	}
 }
 
 // RegistrationRequest takes a serialized RegistrationRequest message and returns a deserialized
 // RegistrationRequest structure.
 func (d *Deserializer) RegistrationRequest(registrationRequest []byte) (*message.RegistrationRequest, error) {
-	if len(registrationRequest) != d.conf.OPRF.Group().ElementLength() {
-		return nil, errInvalidMessageLength
+	if err := checkLength(registrationRequest, d.conf.OPRF.Group().ElementLength()); err != nil {
+		return nil, err
 	}
 
 	blindedMessage := d.conf.OPRF.Group().NewElement()
@@ -48,6 +112,73 @@ func (d *Deserializer) RegistrationRequest(registrationRequest []byte) (*message
 	return &message.RegistrationRequest{BlindedMessage: blindedMessage}, nil
 }
 
+// RegistrationRequestWithIdentifier takes the output of Client.RegistrationInitWithIdentifier, and returns the
+// deserialized RegistrationRequest and the client-chosen credential identifier. An empty identifier is decoded as nil.
+func (d *Deserializer) RegistrationRequestWithIdentifier(
+	data []byte,
+) (request *message.RegistrationRequest, credentialIdentifier []byte, err error) {
+	requestLength := d.conf.OPRF.Group().ElementLength()
+	if len(data) < requestLength {
+		return nil, nil, errInvalidMessageLength
+	}
+
+	if request, err = d.RegistrationRequest(data[:requestLength]); err != nil {
+		return nil, nil, err
+	}
+
+	prefix := internal.IdentityLengthPrefix(false)
+
+	credentialIdentifier, offset, err := decodeOptionalVector(data[requestLength:], prefix)
+	if err != nil {
+		return nil, nil, fmt.Errorf("decoding the credential identifier: %w", err)
+	}
+
+	if requestLength+offset != len(data) {
+		return nil, nil, ErrTrailingBytes
+	}
+
+	return request, credentialIdentifier, nil
+}
+
+// RegistrationUpload takes the output of Client.RegistrationUpload, and returns the deserialized RegistrationRequest
+// and RegistrationRecord it holds, either of which is nil if absent. It returns ErrEmptyRegistrationUpload if it holds
+// neither.
+func (d *Deserializer) RegistrationUpload(
+	data []byte,
+) (request *message.RegistrationRequest, record *message.RegistrationRecord, err error) {
+	serializedRequest, offset, err := encoding.DecodeVector(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("decoding the registration request: %w", err)
+	}
+
+	serializedRecord, o, err := encoding.DecodeVector(data[offset:])
+	if err != nil {
+		return nil, nil, fmt.Errorf("decoding the registration record: %w", err)
+	}
+
+	if offset+o != len(data) {
+		return nil, nil, ErrTrailingBytes
+	}
+
+	if len(serializedRequest) == 0 && len(serializedRecord) == 0 {
+		return nil, nil, ErrEmptyRegistrationUpload
+	}
+
+	if len(serializedRequest) != 0 {
+		if request, err = d.RegistrationRequest(serializedRequest); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if len(serializedRecord) != 0 {
+		if record, err = d.RegistrationRecord(serializedRecord); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return request, record, nil
+}
+
 func (d *Deserializer) registrationResponseLength() int {
 	return d.conf.OPRF.Group().ElementLength() + d.conf.Group.ElementLength()
 }
@@ -55,8 +186,8 @@ func (d *Deserializer) registrationResponseLength() int {
 // RegistrationResponse takes a serialized RegistrationResponse message and returns a deserialized
 // RegistrationResponse structure.
 func (d *Deserializer) RegistrationResponse(registrationResponse []byte) (*message.RegistrationResponse, error) {
-	if len(registrationResponse) != d.registrationResponseLength() {
-		return nil, errInvalidMessageLength
+	if err := checkLength(registrationResponse, d.registrationResponseLength()); err != nil {
+		return nil, err
 	}
 
 	evaluatedMessage := d.conf.OPRF.Group().NewElement()
@@ -76,19 +207,19 @@ func (d *Deserializer) RegistrationResponse(registrationResponse []byte) (*messa
 }
 
 func (d *Deserializer) recordLength() int {
-	return d.conf.Group.ElementLength() + d.conf.Hash.Size() + d.conf.EnvelopeSize
+	return d.conf.Group.ElementLength() + d.conf.KDF.Size() + d.conf.EnvelopeSize
 }
 
 // RegistrationRecord takes a serialized RegistrationRecord message and returns a deserialized
 // RegistrationRecord structure.
 func (d *Deserializer) RegistrationRecord(record []byte) (*message.RegistrationRecord, error) {
-	if len(record) != d.recordLength() {
-		return nil, errInvalidMessageLength
+	if err := checkLength(record, d.recordLength()); err != nil {
+		return nil, err
 	}
 
 	pk := record[:d.conf.Group.ElementLength()]
-	maskingKey := record[d.conf.Group.ElementLength() : d.conf.Group.ElementLength()+d.conf.Hash.Size()]
-	env := record[d.conf.Group.ElementLength()+d.conf.Hash.Size():]
+	maskingKey := record[d.conf.Group.ElementLength() : d.conf.Group.ElementLength()+d.conf.KDF.Size()]
+	env := record[d.conf.Group.ElementLength()+d.conf.KDF.Size():]
 
 	pku := d.conf.Group.NewElement()
 	if err := pku.Decode(pk); err != nil {
@@ -102,6 +233,71 @@ func (d *Deserializer) RegistrationRecord(record []byte) (*message.RegistrationR
 	}, nil
 }
 
+// decodeOptionalVector decodes a vector with a length prefix of size bytes, returning nil data for an empty vector.
+func decodeOptionalVector(in []byte, size uint16) ([]byte, int, error) {
+	data, offset, err := encoding.DecodeVectorLen(in, int(size))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if len(data) == 0 {
+		data = nil
+	}
+
+	return data, offset, nil
+}
+
+// ClientRecord takes a serialized ClientRecord and returns a deserialized ClientRecord structure. Empty credential
+// identifiers and client identities are decoded as nil. It returns ErrRecordVersionUnsupported if the record was not
+// serialized with the current RecordVersion, and ErrIdentityTooLong for a record with 4-byte length prefixes if the
+// configuration doesn't set LongIdentities.
+func (d *Deserializer) ClientRecord(data []byte) (*ClientRecord, error) {
+	recordLength := d.recordLength()
+	if len(data) < 1+recordLength {
+		return nil, errInvalidMessageLength
+	}
+
+	if data[0]&^longRecordFlag != RecordVersion {
+		return nil, ErrRecordVersionUnsupported
+	}
+
+	long := data[0]&longRecordFlag != 0
+	if long && !d.conf.LongIdentities {
+		return nil, ErrIdentityTooLong
+	}
+
+	prefix := internal.IdentityLengthPrefix(long)
+	data = data[1:]
+
+	record, err := d.RegistrationRecord(data[:recordLength])
+	if err != nil {
+		return nil, err
+	}
+
+	credentialIdentifier, offset, err := decodeOptionalVector(data[recordLength:], prefix)
+	if err != nil {
+		return nil, fmt.Errorf("decoding the credential identifier: %w", err)
+	}
+
+	offset += recordLength
+
+	clientIdentity, o, err := decodeOptionalVector(data[offset:], prefix)
+	if err != nil {
+		return nil, fmt.Errorf("decoding the client identity: %w", err)
+	}
+
+	if offset+o != len(data) {
+		return nil, ErrTrailingBytes
+	}
+
+	return &ClientRecord{
+		RegistrationRecord:   record,
+		CredentialIdentifier: credentialIdentifier,
+		ClientIdentity:       clientIdentity,
+		PreviousOPRFSeed:     false,
+	}, nil
+}
+
 func (d *Deserializer) deserializeCredentialRequest(input []byte) (*message.CredentialRequest, error) {
 	blindedMessage := d.conf.OPRF.Group().NewElement()
 	if err := blindedMessage.Decode(input[:d.conf.OPRF.Group().ElementLength()]); err != nil {
@@ -126,13 +322,14 @@ func (d *Deserializer) deserializeCredentialResponse(
 }
 
 func (d *Deserializer) ke1Length() int {
-	return d.conf.OPRF.Group().ElementLength() + d.conf.NonceLen + d.conf.Group.ElementLength()
+	return d.conf.OPRF.Group().ElementLength() + d.conf.NonceLen + d.conf.Group.ElementLength() +
+		d.conf.KEM.EncapsulationKeyLength()
 }
 
 // KE1 takes a serialized KE1 message and returns a deserialized KE1 structure.
 func (d *Deserializer) KE1(ke1 []byte) (*message.KE1, error) {
-	if len(ke1) != d.ke1Length() {
-		return nil, errInvalidMessageLength
+	if err := checkLength(ke1, d.ke1Length()); err != nil {
+		return nil, err
 	}
 
 	request, err := d.deserializeCredentialRequest(ke1)
@@ -140,22 +337,30 @@ func (d *Deserializer) KE1(ke1 []byte) (*message.KE1, error) {
 		return nil, err
 	}
 
-	nonceU := ke1[d.conf.OPRF.Group().ElementLength() : d.conf.OPRF.Group().ElementLength()+d.conf.NonceLen]
+	offset := d.conf.OPRF.Group().ElementLength()
+	nonceU := ke1[offset : offset+d.conf.NonceLen]
+	offset += d.conf.NonceLen
 
 	epku := d.conf.Group.NewElement()
-	if err = epku.Decode(ke1[d.conf.OPRF.Group().ElementLength()+d.conf.NonceLen:]); err != nil {
+	if err = epku.Decode(ke1[offset : offset+d.conf.Group.ElementLength()]); err != nil {
 		return nil, errInvalidClientEPK
 	}
 
+	var ek []byte
+	if d.conf.KEM != internal.NoKEM {
+		ek = ke1[offset+d.conf.Group.ElementLength():]
+	}
+
 	return &message.KE1{
 		CredentialRequest:    request,
 		ClientNonce:          nonceU,
 		ClientPublicKeyshare: epku,
+		KEMEncapsulationKey:  ek,
 	}, nil
 }
 
 func (d *Deserializer) ke2LengthWithoutCreds() int {
-	return d.conf.NonceLen + d.conf.Group.ElementLength() + d.conf.MAC.Size()
+	return d.conf.NonceLen + d.conf.Group.ElementLength() + d.conf.KEM.CiphertextLength() + d.conf.MAC.Size()
 }
 
 func (d *Deserializer) credentialResponseLength() int {
@@ -168,8 +373,8 @@ func (d *Deserializer) KE2(ke2 []byte) (*message.KE2, error) {
 	maxResponseLength := d.credentialResponseLength()
 
 	// Verify it matches the size of a legal KE2
-	if len(ke2) != maxResponseLength+d.ke2LengthWithoutCreds() {
-		return nil, errInvalidMessageLength
+	if err := checkLength(ke2, maxResponseLength+d.ke2LengthWithoutCreds()); err != nil {
+		return nil, err
 	}
 
 	cresp, err := d.deserializeCredentialResponse(ke2, maxResponseLength)
@@ -181,6 +386,13 @@ func (d *Deserializer) KE2(ke2 []byte) (*message.KE2, error) {
 	offset := maxResponseLength + d.conf.NonceLen
 	epk := ke2[offset : offset+d.conf.Group.ElementLength()]
 	offset += d.conf.Group.ElementLength()
+
+	var ciphertext []byte
+	if d.conf.KEM != internal.NoKEM {
+		ciphertext = ke2[offset : offset+d.conf.KEM.CiphertextLength()]
+		offset += d.conf.KEM.CiphertextLength()
+	}
+
 	mac := ke2[offset:]
 
 	epks := d.conf.Group.NewElement()
@@ -192,14 +404,15 @@ func (d *Deserializer) KE2(ke2 []byte) (*message.KE2, error) {
 		CredentialResponse:   cresp,
 		ServerNonce:          nonceS,
 		ServerPublicKeyshare: epks,
+		KEMCiphertext:        ciphertext,
 		ServerMac:            mac,
 	}, nil
 }
 
 // KE3 takes a serialized KE3 message and returns a deserialized KE3 structure.
 func (d *Deserializer) KE3(ke3 []byte) (*message.KE3, error) {
-	if len(ke3) != d.conf.MAC.Size() {
-		return nil, errInvalidMessageLength
+	if err := checkLength(ke3, d.conf.MAC.Size()); err != nil {
+		return nil, err
 	}
 
 	return &message.KE3{ClientMac: ke3}, nil
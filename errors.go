@@ -0,0 +1,41 @@
+// SPDX-License-Identifier: MIT
+//
+// Copyright (C) 2020-2025 Daniel Bourdrez. All Rights Reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree or at
+// https://spdx.org/licenses/MIT.html
+
+package opaque
+
+// Error associates one of the package's exported sentinel errors with the underlying error that caused it, so that
+// errors.Is matches both the sentinel and the cause, and errors.As can retrieve either.
+type Error struct {
+	// Err is the exported sentinel error describing the failure.
+	Err error
+
+	// Cause is the underlying error, e.g. returned by a decoding function.
+	Cause error
+}
+
+// newError returns an *Error wrapping the sentinel and its cause.
+func newError(sentinel, cause error) *Error {
+	return &Error{
+		Err:   sentinel,
+		Cause: cause,
+	}
+}
+
+// Error returns the sentinel's message followed by the cause's.
+func (e *Error) Error() string {
+	if e.Cause == nil {
+		return e.Err.Error()
+	}
+
+	return e.Err.Error() + ": " + e.Cause.Error()
+}
+
+// Unwrap returns the sentinel and the cause, for errors.Is and errors.As.
+func (e *Error) Unwrap() []error {
+	return []error{e.Err, e.Cause}
+}
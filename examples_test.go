@@ -238,7 +238,10 @@ func Example_registration() {
 		}
 
 		// The server uses its public key and secret OPRF seed created at the setup.
-		response := server.RegistrationResponse(request, pks, credID, secretOprfSeed)
+		response, err := server.RegistrationResponse(request, pks, credID, secretOprfSeed)
+		if err != nil {
+			log.Fatalln(err)
+		}
 
 		// The server responds with its serialized response.
 		message2 = response.Serialize()
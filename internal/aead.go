@@ -0,0 +1,77 @@
+// SPDX-License-Identifier: MIT
+//
+// Copyright (C) 2020-2025 Daniel Bourdrez. All Rights Reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree or at
+// https://spdx.org/licenses/MIT.html
+
+package internal
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"fmt"
+)
+
+// SealKeyLength is the length of keys used to seal data at rest.
+const SealKeyLength = 32
+
+var (
+	// ErrSealKeyLength happens when the key used to seal or open data is not SealKeyLength bytes long.
+	ErrSealKeyLength = errors.New("invalid sealing key length")
+
+	// ErrSealOpen happens when sealed data fails authentication, i.e. it was tampered with or the key is wrong.
+	ErrSealOpen = errors.New("sealed data failed authentication")
+)
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != SealKeyLength {
+		return nil, ErrSealKeyLength
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("initializing the cipher: %w", err)
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("initializing the AEAD: %w", err)
+	}
+
+	return aead, nil
+}
+
+// Seal encrypts and authenticates the plaintext and additional data with AES-256-GCM under key, and returns the random
+// nonce prepended to the ciphertext.
+func Seal(key, plaintext, additionalData []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := RandomBytes(aead.NonceSize())
+
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// Open authenticates and decrypts data produced by Seal with the same key and additional data.
+func Open(key, sealed, additionalData []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed) < aead.NonceSize()+aead.Overhead() {
+		return nil, ErrSealOpen
+	}
+
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], additionalData)
+	if err != nil {
+		return nil, ErrSealOpen
+	}
+
+	return plaintext, nil
+}
@@ -10,6 +10,8 @@
 package ake
 
 import (
+	"encoding/binary"
+
 	"github.com/bytemare/ecc"
 
 	"github.com/bytemare/opaque/internal"
@@ -27,23 +29,18 @@ func KeyGen(id ecc.Group) (privateKey, publicKey []byte) {
 	return scalar.Encode(), point.Encode()
 }
 
-func diffieHellman(s *ecc.Scalar, e *ecc.Element) *ecc.Element {
-	/*
-		if id == ecc.Ristretto255Sha512 || id == ecc.P256Sha256 {
-			e.Copy().Multiply(s)
-		}
+func diffieHellman(s *ecc.Scalar, e *ecc.Element) []byte {
+	dh := internal.GetElement(e.Group())
+	defer internal.PutElement(dh)
 
-		if id == ecc.Curve25519 {
-			// TODO
-		}
-	*/
-	return e.Copy().Multiply(s)
+	return dh.Set(e).Multiply(s).Encode()
 }
 
-// Identities holds the client and server identities.
+// Identities holds the client and server identities, and the optional associated data bound into the transcript.
 type Identities struct {
 	ClientIdentity []byte
 	ServerIdentity []byte
+	AssociatedData []byte
 }
 
 // SetIdentities sets the client and server identities to their respective public key if not set.
@@ -69,9 +66,9 @@ type Options struct {
 	NonceLength uint32
 }
 
-func (o *Options) init() {
+func (o *Options) init(conf *internal.Configuration) {
 	if o.KeyShareSeed == nil {
-		o.KeyShareSeed = internal.RandomBytes(internal.SeedLength)
+		o.KeyShareSeed = conf.RandomBytes(internal.SeedLength)
 	}
 
 	if o.NonceLength == 0 {
@@ -79,7 +76,7 @@ func (o *Options) init() {
 	}
 
 	if len(o.Nonce) == 0 {
-		o.Nonce = internal.RandomBytes(int(o.NonceLength))
+		o.Nonce = conf.RandomBytes(int(o.NonceLength))
 	}
 }
 
@@ -109,11 +106,11 @@ func (v *values) flush() {
 
 // setOptions sets optional values.
 // There's no effect if ephemeralSecretKey and nonce have already been set in a previous call.
-func (v *values) setOptions(g ecc.Group, options Options) *ecc.Element {
-	options.init()
+func (v *values) setOptions(conf *internal.Configuration, options Options) *ecc.Element {
+	options.init(conf)
 
 	if v.ephemeralSecretKey == nil {
-		v.ephemeralSecretKey = oprf.IDFromGroup(g).
+		v.ephemeralSecretKey = oprf.IDFromGroup(conf.Group).
 			DeriveKey(options.KeyShareSeed, []byte(tag.DeriveDiffieHellmanKeyPair))
 	}
 
@@ -121,7 +118,7 @@ func (v *values) setOptions(g ecc.Group, options Options) *ecc.Element {
 		v.nonce = options.Nonce
 	}
 
-	return g.Base().Multiply(v.ephemeralSecretKey)
+	return conf.Group.Base().Multiply(v.ephemeralSecretKey)
 }
 
 func k3dh(
@@ -132,41 +129,47 @@ func k3dh(
 	p3 *ecc.Element,
 	s3 *ecc.Scalar,
 ) []byte {
-	e1 := diffieHellman(s1, p1).Encode()
-	e2 := diffieHellman(s2, p2).Encode()
-	e3 := diffieHellman(s3, p3).Encode()
+	e1 := diffieHellman(s1, p1)
+	e2 := diffieHellman(s2, p2)
+	e3 := diffieHellman(s3, p3)
 
 	return encoding.Concat3(e1, e2, e3)
 }
 
 func core3DH(
 	conf *internal.Configuration, identities *Identities, ikm, ke1 []byte, ke2 *message.KE2,
-) (sessionSecret, macS, macC []byte) {
+) (sessionSecret, macS, macC, preamble []byte) {
 	initTranscript(conf, identities, ke1, ke2)
 
-	serverMacKey, clientMacKey, sessionSecret := deriveKeys(conf.KDF, ikm, conf.Hash.Sum()) // preamble
-	serverMac := conf.MAC.MAC(serverMacKey, conf.Hash.Sum())                                // transcript2
+	preamble = conf.Hash.Sum()
+	serverMacKey, clientMacKey, sessionSecret := deriveKeys(conf.KDF, ikm, preamble)
+	serverMac := conf.MAC.MAC(serverMacKey, preamble) // transcript2
 	conf.Hash.Write(serverMac)
 	transcript3 := conf.Hash.Sum()
 	clientMac := conf.MAC.MAC(clientMacKey, transcript3)
 
-	return sessionSecret, serverMac, clientMac
+	return sessionSecret, serverMac, clientMac, preamble
 }
 
-func buildLabel(length int, label, context []byte) []byte {
-	return encoding.Concat3(
-		encoding.I2OSP(length, 2),
-		encoding.EncodeVectorLen(append([]byte(tag.LabelPrefix), label...), 1),
-		encoding.EncodeVectorLen(context, 1))
-}
+// appendLabel appends the HKDF label to dst, which is I2OSP(length, 2) || EncodeVectorLen(LabelPrefix || label, 1) ||
+// EncodeVectorLen(context, 1).
+func appendLabel(dst []byte, length int, label, context []byte) []byte {
+	dst = binary.BigEndian.AppendUint16(dst, uint16(length)) //nolint:gosec // the length is a hash output size.
+	dst = append(dst, byte(len(tag.LabelPrefix)+len(label)))
+	dst = append(dst, tag.LabelPrefix...)
+	dst = append(dst, label...)
+	dst = append(dst, byte(len(context)))
 
-func expand(h *internal.KDF, secret, hkdfLabel []byte) []byte {
-	return h.Expand(secret, hkdfLabel, h.Size())
+	return append(dst, context...)
 }
 
 func expandLabel(h *internal.KDF, secret, label, context []byte) []byte {
-	hkdfLabel := buildLabel(h.Size(), label, context)
-	return expand(h, secret, hkdfLabel)
+	hkdfLabel := internal.GetBuffer()
+	defer internal.PutBuffer(hkdfLabel)
+
+	*hkdfLabel = appendLabel(*hkdfLabel, h.Size(), label, context)
+
+	return h.Expand(secret, *hkdfLabel, h.Size())
 }
 
 func deriveSecret(h *internal.KDF, secret, label, context []byte) []byte {
@@ -174,11 +177,34 @@ func deriveSecret(h *internal.KDF, secret, label, context []byte) []byte {
 }
 
 func initTranscript(conf *internal.Configuration, identities *Identities, ke1 []byte, ke2 *message.KE2) {
-	encodedClientID := encoding.EncodeVector(identities.ClientIdentity)
-	encodedServerID := encoding.EncodeVector(identities.ServerIdentity)
-	conf.Hash.Write(encoding.Concatenate([]byte(tag.VersionTag), encoding.EncodeVector(conf.Context),
-		encodedClientID, ke1,
-		encodedServerID, ke2.CredentialResponse.Serialize(), ke2.ServerNonce, ke2.ServerPublicKeyshare.Encode()))
+	// Each component is written directly into the hash, so that large contexts and identities are not copied into a
+	// single buffer. The result is the same as hashing the concatenation of the components.
+	conf.Hash.Reset()
+	conf.Hash.Write([]byte(tag.VersionTag))
+	writeVector(conf, conf.Context)
+
+	// Associated data is only written when set, so that the transcript without it is the standard one.
+	if len(identities.AssociatedData) != 0 {
+		conf.Hash.Write([]byte(tag.AssociatedData))
+		writeVector(conf, identities.AssociatedData)
+	}
+
+	writeVector(conf, identities.ClientIdentity)
+	conf.Hash.Write(ke1)
+	writeVector(conf, identities.ServerIdentity)
+	conf.Hash.Write(ke2.CredentialResponse.EvaluatedMessage.Encode())
+	conf.Hash.Write(ke2.CredentialResponse.MaskingNonce)
+	conf.Hash.Write(ke2.CredentialResponse.MaskedResponse)
+	conf.Hash.Write(ke2.ServerNonce)
+	conf.Hash.Write(ke2.ServerPublicKeyshare.Encode())
+	conf.Hash.Write(ke2.KEMCiphertext)
+}
+
+// writeVector writes the input with an encoding of its length into the transcript hash, as
+// Configuration.EncodeIdentity does.
+func writeVector(conf *internal.Configuration, input []byte) {
+	conf.Hash.Write(encoding.I2OSP(len(input), conf.IdentityLengthPrefix()))
+	conf.Hash.Write(input)
 }
 
 func deriveKeys(h *internal.KDF, ikm, context []byte) (serverMacKey, clientMacKey, sessionSecret []byte) {
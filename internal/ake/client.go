@@ -9,6 +9,7 @@
 package ake
 
 import (
+	"crypto/mlkem"
 	"errors"
 
 	"github.com/bytemare/ecc"
@@ -22,8 +23,11 @@ var errAkeInvalidServerMac = errors.New("invalid server mac")
 // Client exposes the client's AKE functions and holds its state.
 type Client struct {
 	values
-	Ke1           []byte
-	sessionSecret []byte
+	kemDecapsulationKey *mlkem.DecapsulationKey768
+	Ke1                 []byte
+	sessionSecret       []byte
+	transcriptHash      []byte
+	serverMac           []byte
 }
 
 // NewClient returns a new, empty, 3DH client.
@@ -33,19 +37,35 @@ func NewClient() *Client {
 			ephemeralSecretKey: nil,
 			nonce:              nil,
 		},
-		Ke1:           nil,
-		sessionSecret: nil,
+		kemDecapsulationKey: nil,
+		Ke1:                 nil,
+		sessionSecret:       nil,
+		transcriptHash:      nil,
+		serverMac:           nil,
 	}
 }
 
-// Start initiates the 3DH protocol, and returns a KE1 message with clientInfo.
-func (c *Client) Start(cs ecc.Group, options Options) *message.KE1 {
-	epk := c.setOptions(cs, options)
+// Start initiates the 3DH protocol, and returns a KE1 message with clientInfo. If the configuration uses a KEM, a
+// fresh decapsulation key is generated and its encapsulation key is added to KE1.
+func (c *Client) Start(conf *internal.Configuration, options Options) *message.KE1 {
+	epk := c.setOptions(conf, options)
+
+	var ek []byte
+
+	if conf.KEM != internal.NoKEM {
+		if c.kemDecapsulationKey == nil {
+			// A random seed always has the right length, so this can't fail.
+			c.kemDecapsulationKey, _ = internal.NewKEMDecapsulationKey(conf.RandomBytes(mlkem.SeedSize))
+		}
+
+		ek = c.kemDecapsulationKey.EncapsulationKey().Bytes()
+	}
 
 	return &message.KE1{
 		CredentialRequest:    nil,
 		ClientNonce:          c.nonce,
 		ClientPublicKeyshare: epk,
+		KEMEncapsulationKey:  ek,
 	}
 }
 
@@ -66,13 +86,29 @@ func (c *Client) Finalize(
 		ke2.ServerPublicKeyshare,
 		clientSecretKey,
 	)
-	sessionSecret, serverMac, clientMac := core3DH(conf, identities, ikm, c.Ke1, ke2)
+
+	if conf.KEM != internal.NoKEM {
+		if c.kemDecapsulationKey == nil {
+			return nil, internal.ErrInvalidKEMKeyShare
+		}
+
+		kemSecret, err := c.kemDecapsulationKey.Decapsulate(ke2.KEMCiphertext)
+		if err != nil {
+			return nil, internal.ErrInvalidKEMKeyShare
+		}
+
+		ikm = append(ikm, kemSecret...)
+	}
+
+	sessionSecret, serverMac, clientMac, preamble := core3DH(conf, identities, ikm, c.Ke1, ke2)
 
 	if !conf.MAC.Equal(serverMac, ke2.ServerMac) {
 		return nil, errAkeInvalidServerMac
 	}
 
 	c.sessionSecret = sessionSecret
+	c.transcriptHash = preamble
+	c.serverMac = serverMac
 
 	return &message.KE3{ClientMac: clientMac}, nil
 }
@@ -82,8 +118,28 @@ func (c *Client) SessionKey() []byte {
 	return c.sessionSecret
 }
 
+// TranscriptHash returns the hash of the handshake preamble if a previous call to Finalize() was successful.
+func (c *Client) TranscriptHash() []byte {
+	return c.transcriptHash
+}
+
+// ExpectedServerMAC returns the server MAC verified in KE2 if a previous call to Finalize() was successful.
+func (c *Client) ExpectedServerMAC() []byte {
+	return c.serverMac
+}
+
 // Flush sets all the client's session related internal AKE values to nil.
 func (c *Client) Flush() {
 	c.flush()
+	c.kemDecapsulationKey = nil
 	c.sessionSecret = nil
+	c.transcriptHash = nil
+	c.serverMac = nil
+}
+
+// Resume flushes the client's session values and sets the session key of a previously suspended session.
+func (c *Client) Resume(sessionSecret []byte) {
+	c.Flush()
+	c.Ke1 = nil
+	c.sessionSecret = sessionSecret
 }
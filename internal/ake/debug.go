@@ -0,0 +1,57 @@
+// SPDX-License-Identifier: MIT
+//
+// Copyright (C) 2020-2025 Daniel Bourdrez. All Rights Reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree or at
+// https://spdx.org/licenses/MIT.html
+
+//go:build opaquedebug
+
+package ake
+
+import (
+	"bytes"
+
+	"github.com/bytemare/ecc"
+)
+
+// Snapshot holds a copy of the server's ephemeral AKE values and derived secrets. It contains secrets, and is only
+// available in builds with the opaquedebug tag, for replay-based and cross-implementation debugging.
+type Snapshot struct {
+	EphemeralSecretKey *ecc.Scalar
+	Nonce              []byte
+	SessionSecret      []byte
+	ClientMac          []byte
+}
+
+// DebugSnapshot returns a copy of the server's ephemeral secret key, nonce, session secret, and expected client MAC,
+// which are nil if not set.
+func (s *Server) DebugSnapshot() *Snapshot {
+	var esk *ecc.Scalar
+	if s.ephemeralSecretKey != nil {
+		esk = s.ephemeralSecretKey.Copy()
+	}
+
+	return &Snapshot{
+		EphemeralSecretKey: esk,
+		Nonce:              bytes.Clone(s.nonce),
+		SessionSecret:      bytes.Clone(s.sessionSecret),
+		ClientMac:          bytes.Clone(s.clientMac),
+	}
+}
+
+// RestoreSnapshot replaces the server's state with a copy of the snapshot. A following Response then uses the
+// snapshot's ephemeral secret key and nonce, reproducing the snapshotted handshake for the same inputs, and Finalize
+// verifies KE3 against its client MAC.
+func (s *Server) RestoreSnapshot(snapshot *Snapshot) {
+	s.Flush()
+
+	if snapshot.EphemeralSecretKey != nil {
+		s.ephemeralSecretKey = snapshot.EphemeralSecretKey.Copy()
+	}
+
+	s.nonce = bytes.Clone(snapshot.Nonce)
+	s.sessionSecret = bytes.Clone(snapshot.SessionSecret)
+	s.clientMac = bytes.Clone(snapshot.ClientMac)
+}
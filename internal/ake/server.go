@@ -14,6 +14,7 @@ import (
 	"github.com/bytemare/ecc"
 
 	"github.com/bytemare/opaque/internal"
+	"github.com/bytemare/opaque/internal/tag"
 	"github.com/bytemare/opaque/message"
 )
 
@@ -22,8 +23,13 @@ var errStateNotEmpty = errors.New("existing state is not empty")
 // Server exposes the server's AKE functions and holds its state.
 type Server struct {
 	values
-	clientMac     []byte
-	sessionSecret []byte
+	clientMac      []byte
+	sessionSecret  []byte
+	transcriptHash []byte
+
+	// ke1Buffer is reused across handshakes to serialize KE1 into the transcript. It only ever holds public values and
+	// is therefore not flushed.
+	ke1Buffer []byte
 }
 
 // NewServer returns a new, empty, 3DH server.
@@ -33,12 +39,15 @@ func NewServer() *Server {
 			ephemeralSecretKey: nil,
 			nonce:              nil,
 		},
-		clientMac:     nil,
-		sessionSecret: nil,
+		clientMac:      nil,
+		sessionSecret:  nil,
+		transcriptHash: nil,
+		ke1Buffer:      nil,
 	}
 }
 
-// Response produces a 3DH server response message.
+// Response produces a 3DH server response message. If the configuration uses a KEM, a shared secret is encapsulated
+// to the client's encapsulation key in KE1 and mixed into the 3DH key material.
 func (s *Server) Response(
 	conf *internal.Configuration,
 	identities *Identities,
@@ -47,13 +56,26 @@ func (s *Server) Response(
 	ke1 *message.KE1,
 	response *message.CredentialResponse,
 	options Options,
-) *message.KE2 {
-	epks := s.setOptions(conf.Group, options)
+) (*message.KE2, error) {
+	var kemSecret, ciphertext []byte
+
+	if conf.KEM != internal.NoKEM {
+		var err error
+		if kemSecret, ciphertext, err = internal.KEMEncapsulate(
+			ke1.KEMEncapsulationKey,
+			kemRandom(conf, options.KeyShareSeed),
+		); err != nil {
+			return nil, err
+		}
+	}
+
+	epks := s.setOptions(conf, options)
 
 	ke2 := &message.KE2{
 		CredentialResponse:   response,
 		ServerNonce:          s.nonce,
 		ServerPublicKeyshare: epks,
+		KEMCiphertext:        ciphertext,
 		ServerMac:            nil,
 	}
 
@@ -65,12 +87,15 @@ func (s *Server) Response(
 		clientPublicKey,
 		s.ephemeralSecretKey,
 	)
-	sessionSecret, serverMac, clientMac := core3DH(conf, identities, ikm, ke1.Serialize(), ke2)
+	ikm = append(ikm, kemSecret...)
+	s.ke1Buffer = ke1.SerializeTo(s.ke1Buffer[:0])
+	sessionSecret, serverMac, clientMac, preamble := core3DH(conf, identities, ikm, s.ke1Buffer, ke2)
 	s.sessionSecret = sessionSecret
+	s.transcriptHash = preamble
 	s.clientMac = clientMac
 	ke2.ServerMac = serverMac
 
-	return ke2
+	return ke2, nil
 }
 
 // Finalize verifies the authentication tag contained in ke3.
@@ -83,6 +108,11 @@ func (s *Server) SessionKey() []byte {
 	return s.sessionSecret
 }
 
+// TranscriptHash returns the hash of the handshake preamble if a previous call to Response() was successful.
+func (s *Server) TranscriptHash() []byte {
+	return s.transcriptHash
+}
+
 // ExpectedMAC returns the expected client MAC if a previous call to Response() was successful.
 func (s *Server) ExpectedMAC() []byte {
 	return s.clientMac
@@ -98,6 +128,20 @@ func (s *Server) SerializeState() []byte {
 	return state
 }
 
+// kemRandom returns the randomness of a reproducible KEM encapsulation, derived from the key share seed if set, or
+// drawn from the configuration's custom random source if set, and nil otherwise.
+func kemRandom(conf *internal.Configuration, keyShareSeed []byte) []byte {
+	if keyShareSeed != nil {
+		return conf.KDF.Expand(keyShareSeed, []byte(tag.KEMEncapsulation), internal.KEMRandomLength)
+	}
+
+	if conf.Rand != nil {
+		return conf.RandomBytes(internal.KEMRandomLength)
+	}
+
+	return nil
+}
+
 // SetState will set the given clientMac and sessionSecret in the server's internal state.
 func (s *Server) SetState(clientMac, sessionSecret []byte) error {
 	if len(s.clientMac) != 0 || len(s.sessionSecret) != 0 {
@@ -115,4 +159,5 @@ func (s *Server) Flush() {
 	s.flush()
 	s.clientMac = nil
 	s.sessionSecret = nil
+	s.transcriptHash = nil
 }
@@ -13,10 +13,13 @@ import (
 	"crypto/rand"
 	"errors"
 	"fmt"
+	"io"
 
 	"github.com/bytemare/ecc"
 
+	"github.com/bytemare/opaque/internal/encoding"
 	"github.com/bytemare/opaque/internal/oprf"
+	"github.com/bytemare/opaque/internal/tag"
 )
 
 const (
@@ -32,15 +35,63 @@ var ErrConfigurationInvalidLength = errors.New("invalid encoded configuration le
 
 // Configuration is the internal representation of the instance runtime parameters.
 type Configuration struct {
-	KDF          *KDF
-	MAC          *Mac
-	Hash         *Hash
-	KSF          *KSF
-	OPRF         oprf.Identifier
-	Context      []byte
-	NonceLen     int
-	EnvelopeSize int
-	Group        ecc.Group
+	Rand           io.Reader
+	KDF            *KDF
+	MAC            *Mac
+	Hash           *Hash
+	KSF            *KSF
+	OPRF           oprf.Identifier
+	Context        []byte
+	Fingerprint    []byte
+	NonceLen       int
+	EnvelopeSize   int
+	Group          ecc.Group
+	KEM            KEM
+	LongIdentities bool
+}
+
+const (
+	// maxIdentityLength is the maximum length of an identity with the default 2-byte length prefix.
+	maxIdentityLength = 1<<16 - 1
+
+	// maxLongIdentityLength is the maximum length of an identity with LongIdentities, which fits in an int on all
+	// platforms.
+	maxLongIdentityLength = 1<<31 - 1
+)
+
+// IdentityLengthPrefix returns the byte length of the length prefix of identities, the context, and associated data in
+// the envelope and the AKE transcript, i.e. 4 with LongIdentities, and 2 otherwise.
+func (c *Configuration) IdentityLengthPrefix() uint16 {
+	return IdentityLengthPrefix(c.LongIdentities)
+}
+
+// MaxIdentityLength returns the maximum length of an identity, the context, or associated data.
+func (c *Configuration) MaxIdentityLength() int {
+	return MaxIdentityLength(c.LongIdentities)
+}
+
+// IdentityLengthPrefix returns the byte length of the length prefix of identities, i.e. 4 with long identities, and 2
+// otherwise.
+func IdentityLengthPrefix(long bool) uint16 {
+	if long {
+		return 4
+	}
+
+	return 2
+}
+
+// MaxIdentityLength returns the maximum length of an identity with or without long identities.
+func MaxIdentityLength(long bool) int {
+	if long {
+		return maxLongIdentityLength
+	}
+
+	return maxIdentityLength
+}
+
+// EncodeIdentity returns the input prefixed with its length on IdentityLengthPrefix bytes.
+func (c *Configuration) EncodeIdentity(input []byte) []byte {
+	return encoding.EncodeVectorLen(input, c.IdentityLengthPrefix())
 }
 
 // RandomBytes returns random bytes of length len (wrapper for crypto/rand).
@@ -53,3 +104,42 @@ func RandomBytes(length int) []byte {
 
 	return r
 }
+
+// RandomBytesFrom returns random bytes of length len read from source, or from crypto/rand if source is nil.
+func RandomBytesFrom(source io.Reader, length int) []byte {
+	if source == nil {
+		return RandomBytes(length)
+	}
+
+	r := make([]byte, length)
+	if _, err := io.ReadFull(source, r); err != nil {
+		panic(fmt.Errorf("unexpected error in generating random bytes : %w", err))
+	}
+
+	return r
+}
+
+// RandomScalarFrom returns a random non-zero scalar of the group, derived from bytes read from source, or from
+// crypto/rand if source is nil.
+func RandomScalarFrom(source io.Reader, g ecc.Group) *ecc.Scalar {
+	if source == nil {
+		return g.NewScalar().Random()
+	}
+
+	for {
+		s := g.HashToScalar(RandomBytesFrom(source, 2*SeedLength), []byte(tag.RandomScalar))
+		if !s.IsZero() {
+			return s
+		}
+	}
+}
+
+// RandomBytes returns random bytes of length len from the configuration's random source.
+func (c *Configuration) RandomBytes(length int) []byte {
+	return RandomBytesFrom(c.Rand, length)
+}
+
+// RandomScalar returns a random non-zero scalar of the group from the configuration's random source.
+func (c *Configuration) RandomScalar(g ecc.Group) *ecc.Scalar {
+	return RandomScalarFrom(c.Rand, g)
+}
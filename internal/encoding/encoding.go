@@ -12,6 +12,9 @@ package encoding
 import "errors"
 
 var (
+	// ErrVectorTooLong indicates that the length prefix of a vector exceeds the maximum length expected for it.
+	ErrVectorTooLong = errors.New("vector length exceeds the maximum length")
+
 	errHeaderLength = errors.New("insufficient header length for decoding")
 	errTotalLength  = errors.New("insufficient total length for decoding")
 )
@@ -26,12 +29,17 @@ func EncodeVector(input []byte) []byte {
 	return EncodeVectorLen(input, 2)
 }
 
-func decodeVectorLen(in []byte, size int) (data []byte, offset int, err error) {
+func decodeVectorLen(in []byte, size, maxLength int) (data []byte, offset int, err error) {
 	if len(in) < size {
 		return nil, 0, errHeaderLength
 	}
 
+	// A 4-byte length can overflow a 32-bit int.
 	dataLen := OS2IP(in[0:size])
+	if dataLen < 0 || dataLen > maxLength {
+		return nil, 0, ErrVectorTooLong
+	}
+
 	offset = size + dataLen
 
 	if len(in) < offset {
@@ -43,5 +51,16 @@ func decodeVectorLen(in []byte, size int) (data []byte, offset int, err error) {
 
 // DecodeVector returns the byte-slice of length indexed in the first two bytes.
 func DecodeVector(in []byte) (data []byte, offset int, err error) {
-	return decodeVectorLen(in, 2)
+	return decodeVectorLen(in, 2, 1<<16-1)
+}
+
+// DecodeVectorLen returns the byte-slice of length indexed in the first size bytes.
+func DecodeVectorLen(in []byte, size int) (data []byte, offset int, err error) {
+	return decodeVectorLen(in, size, 1<<31-1)
+}
+
+// DecodeVectorLenMax behaves like DecodeVectorLen, but returns ErrVectorTooLong if the length indexed in the first
+// size bytes exceeds maxLength, before checking it against the input length.
+func DecodeVectorLenMax(in []byte, size, maxLength int) (data []byte, offset int, err error) {
+	return decodeVectorLen(in, size, maxLength)
 }
@@ -11,44 +11,106 @@ package internal
 import (
 	"crypto"
 	"crypto/hmac"
+	"io"
 
 	"github.com/bytemare/hash"
 	"github.com/bytemare/ksf"
 )
 
+// KDFProvider is a key derivation function with HKDF's Extract and Expand interface.
+type KDFProvider interface {
+	// Extract returns a pseudorandom key of Size() bytes from the input keying material and the salt.
+	Extract(salt, ikm []byte) []byte
+
+	// Expand returns length bytes derived from the pseudorandom key and info.
+	Expand(key, info []byte, length int) []byte
+
+	// Size returns the output size of the Extract method.
+	Size() int
+}
+
+// MACProvider is a message authentication code with HMAC's interface.
+type MACProvider interface {
+	// MAC computes a MAC of Size() bytes over the message using key.
+	MAC(key, message []byte) []byte
+
+	// Size returns the MAC's output length.
+	Size() int
+}
+
+// hkdf implements KDFProvider with HKDF over a hash function.
+type hkdf struct {
+	h *hash.Fixed
+}
+
+func (k hkdf) Extract(salt, ikm []byte) []byte {
+	return k.h.HKDFExtract(ikm, salt)
+}
+
+func (k hkdf) Expand(key, info []byte, length int) []byte {
+	return k.h.HKDFExpand(key, info, length)
+}
+
+func (k hkdf) Size() int {
+	return k.h.Size()
+}
+
+// hmacProvider implements MACProvider with HMAC over a hash function.
+type hmacProvider struct {
+	h *hash.Fixed
+}
+
+func (m hmacProvider) MAC(key, message []byte) []byte {
+	return m.h.Hmac(message, key)
+}
+
+func (m hmacProvider) Size() int {
+	return m.h.Size()
+}
+
 // NewKDF returns a newly instantiated KDF.
 func NewKDF(id crypto.Hash) *KDF {
-	return &KDF{h: hash.FromCrypto(id).GetHashFunction()}
+	return &KDF{p: hkdf{h: hash.FromCrypto(id).GetHashFunction()}}
+}
+
+// NewCustomKDF returns a KDF using the provider instead of HKDF.
+func NewCustomKDF(provider KDFProvider) *KDF {
+	return &KDF{p: provider}
 }
 
-// KDF wraps a hash function and exposes KDF methods.
+// KDF wraps a key derivation function and exposes KDF methods.
 type KDF struct {
-	h *hash.Fixed
+	p KDFProvider
 }
 
 // Extract exposes an Extract only KDF method.
 func (k *KDF) Extract(salt, ikm []byte) []byte {
-	return k.h.HKDFExtract(ikm, salt)
+	return k.p.Extract(salt, ikm)
 }
 
 // Expand exposes an Expand only KDF method.
 func (k *KDF) Expand(key, info []byte, length int) []byte {
-	return k.h.HKDFExpand(key, info, length)
+	return k.p.Expand(key, info, length)
 }
 
 // Size returns the output size of the Extract method.
 func (k *KDF) Size() int {
-	return k.h.Size()
+	return k.p.Size()
 }
 
 // NewMac returns a newly instantiated Mac.
 func NewMac(id crypto.Hash) *Mac {
-	return &Mac{h: hash.FromCrypto(id).GetHashFunction()}
+	return &Mac{p: hmacProvider{h: hash.FromCrypto(id).GetHashFunction()}}
 }
 
-// Mac wraps a hash function and exposes Message Authentication Code methods.
+// NewCustomMac returns a Mac using the provider instead of HMAC.
+func NewCustomMac(provider MACProvider) *Mac {
+	return &Mac{p: provider}
+}
+
+// Mac wraps a message authentication code and exposes its methods.
 type Mac struct {
-	h *hash.Fixed
+	p MACProvider
 }
 
 // Equal returns a constant-time comparison of the input.
@@ -58,22 +120,28 @@ func (m *Mac) Equal(a, b []byte) bool {
 
 // MAC computes a MAC over the message using key.
 func (m *Mac) MAC(key, message []byte) []byte {
-	return m.h.Hmac(message, key)
+	return m.p.MAC(key, message)
 }
 
 // Size returns the MAC's output length.
 func (m *Mac) Size() int {
-	return m.h.Size()
+	return m.p.Size()
 }
 
 // NewHash returns a newly instantiated Hash.
 func NewHash(id crypto.Hash) *Hash {
-	return &Hash{h: hash.FromCrypto(id).GetHashFunction()}
+	return &Hash{sink: nil, h: hash.FromCrypto(id).GetHashFunction()}
 }
 
 // Hash wraps a hash function and exposes only necessary hashing methods.
 type Hash struct {
-	h *hash.Fixed
+	sink io.Writer
+	h    *hash.Fixed
+}
+
+// SetSink sets a writer receiving a copy of all subsequent input written to the hash, or disables it if nil.
+func (h *Hash) SetSink(sink io.Writer) {
+	h.sink = sink
 }
 
 // Size returns the output size of the hashing function.
@@ -86,9 +154,18 @@ func (h *Hash) Sum() []byte {
 	return h.h.Sum(nil)
 }
 
-// Write adds input to the running state.
+// Write adds input to the running state, and copies it to the sink if set. Errors from the sink are ignored.
 func (h *Hash) Write(p []byte) {
 	_, _ = h.h.Write(p)
+
+	if h.sink != nil {
+		_, _ = h.sink.Write(p)
+	}
+}
+
+// Reset resets the running state.
+func (h *Hash) Reset() {
+	h.h.Reset()
 }
 
 // NewKSF returns a newly instantiated KSF.
@@ -0,0 +1,90 @@
+// SPDX-License-Identifier: MIT
+//
+// Copyright (C) 2020-2025 Daniel Bourdrez. All Rights Reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree or at
+// https://spdx.org/licenses/MIT.html
+
+package internal
+
+import (
+	"crypto/mlkem"
+	"errors"
+)
+
+var (
+	// ErrInvalidKEMKeyShare indicates that a KEM encapsulation key or ciphertext is malformed.
+	ErrInvalidKEMKeyShare = errors.New("invalid KEM key share")
+
+	// ErrDerandomizedKEM indicates that a reproducible KEM encapsulation was requested but is not available.
+	ErrDerandomizedKEM = errors.New("derandomized KEM encapsulation is not available")
+)
+
+// KEMRandomLength is the length of the randomness of a derandomized KEM encapsulation.
+const KEMRandomLength = 32
+
+// KEM identifies the optional key encapsulation mechanism used alongside 3DH.
+type KEM byte
+
+const (
+	// NoKEM disables the hybrid key share, and only 3DH is used.
+	NoKEM KEM = iota
+
+	// MLKEM768 is ML-KEM-768 as specified in FIPS 203.
+	MLKEM768
+)
+
+// Available returns whether the KEM is supported.
+func (k KEM) Available() bool {
+	return k == MLKEM768
+}
+
+// EncapsulationKeyLength returns the length of an encoded encapsulation key, or 0 if no KEM is used.
+func (k KEM) EncapsulationKeyLength() int {
+	if k == MLKEM768 {
+		return mlkem.EncapsulationKeySize768
+	}
+
+	return 0
+}
+
+// CiphertextLength returns the length of a KEM ciphertext, or 0 if no KEM is used.
+func (k KEM) CiphertextLength() int {
+	if k == MLKEM768 {
+		return mlkem.CiphertextSize768
+	}
+
+	return 0
+}
+
+// NewKEMDecapsulationKey derives a decapsulation key from seed, or from a random seed if it is nil.
+func NewKEMDecapsulationKey(seed []byte) (*mlkem.DecapsulationKey768, error) {
+	if seed == nil {
+		seed = RandomBytes(mlkem.SeedSize)
+	}
+
+	dk, err := mlkem.NewDecapsulationKey768(seed)
+	if err != nil {
+		return nil, ErrInvalidKEMKeyShare
+	}
+
+	return dk, nil
+}
+
+// KEMEncapsulate returns a shared secret and its ciphertext for the encoded encapsulation key. If random is not nil,
+// the encapsulation is derandomized with these KEMRandomLength bytes, so that it is reproducible.
+func KEMEncapsulate(encapsulationKey, random []byte) (sharedSecret, ciphertext []byte, err error) {
+	ek, err := mlkem.NewEncapsulationKey768(encapsulationKey)
+	if err != nil {
+		return nil, nil, ErrInvalidKEMKeyShare
+	}
+
+	if random != nil {
+		return encapsulateDerandomized(ek, random)
+	}
+
+	sharedSecret, ciphertext = ek.Encapsulate()
+
+	return sharedSecret, ciphertext, nil
+}
@@ -0,0 +1,31 @@
+// SPDX-License-Identifier: MIT
+//
+// Copyright (C) 2020-2025 Daniel Bourdrez. All Rights Reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree or at
+// https://spdx.org/licenses/MIT.html
+
+//go:build go1.26
+
+package internal
+
+import (
+	"crypto/mlkem"
+	"crypto/mlkem/mlkemtest"
+	"fmt"
+)
+
+// encapsulateDerandomized runs ML-KEM.Encaps_internal from FIPS 203 with the given randomness. It fails in FIPS
+// 140-only mode, which forbids it.
+func encapsulateDerandomized(
+	ek *mlkem.EncapsulationKey768,
+	random []byte,
+) (sharedSecret, ciphertext []byte, err error) {
+	sharedSecret, ciphertext, err = mlkemtest.Encapsulate768(ek, random)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", ErrDerandomizedKEM, err)
+	}
+
+	return sharedSecret, ciphertext, nil
+}
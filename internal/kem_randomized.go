@@ -0,0 +1,22 @@
+// SPDX-License-Identifier: MIT
+//
+// Copyright (C) 2020-2025 Daniel Bourdrez. All Rights Reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree or at
+// https://spdx.org/licenses/MIT.html
+
+//go:build !go1.26
+
+package internal
+
+import (
+	"crypto/mlkem"
+	"fmt"
+)
+
+// encapsulateDerandomized always fails, as derandomized encapsulation is only exposed by the standard library from
+// Go 1.26 on.
+func encapsulateDerandomized(_ *mlkem.EncapsulationKey768, _ []byte) (sharedSecret, ciphertext []byte, err error) {
+	return nil, nil, fmt.Errorf("%w: requires Go 1.26", ErrDerandomizedKEM)
+}
@@ -49,7 +49,10 @@ func authTag(conf *internal.Configuration, randomizedPassword, nonce, ctc []byte
 }
 
 // cleartextCredentials assumes that clientPublicKey, serverPublicKey are non-nil valid group elements.
-func cleartextCredentials(clientPublicKey, serverPublicKey, clientIdentity, serverIdentity []byte) []byte {
+func cleartextCredentials(
+	conf *internal.Configuration,
+	clientPublicKey, serverPublicKey, clientIdentity, serverIdentity []byte,
+) []byte {
 	if clientIdentity == nil {
 		clientIdentity = clientPublicKey
 	}
@@ -60,8 +63,8 @@ func cleartextCredentials(clientPublicKey, serverPublicKey, clientIdentity, serv
 
 	return encoding.Concat3(
 		serverPublicKey,
-		encoding.EncodeVector(serverIdentity),
-		encoding.EncodeVector(clientIdentity),
+		conf.EncodeIdentity(serverIdentity),
+		conf.EncodeIdentity(clientIdentity),
 	)
 }
 
@@ -82,11 +85,12 @@ func Store(
 	// testing: integrated to support testing with set nonce
 	nonce := credentials.EnvelopeNonce
 	if nonce == nil {
-		nonce = internal.RandomBytes(conf.NonceLen)
+		nonce = conf.RandomBytes(conf.NonceLen)
 	}
 
 	_, pku = deriveDiffieHellmanKeyPair(conf, randomizedPassword, nonce)
 	ctc := cleartextCredentials(
+		conf,
 		pku.Encode(),
 		serverPublicKey.Encode(),
 		credentials.ClientIdentity,
@@ -111,6 +115,7 @@ func Recover(
 ) (clientSecretKey *ecc.Scalar, clientPublicKey *ecc.Element, export []byte, err error) {
 	clientSecretKey, clientPublicKey = deriveDiffieHellmanKeyPair(conf, randomizedPassword, envelope.Nonce)
 	ctc := cleartextCredentials(
+		conf,
 		clientPublicKey.Encode(),
 		serverPublicKey,
 		clientIdentity,
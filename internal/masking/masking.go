@@ -10,6 +10,7 @@
 package masking
 
 import (
+	"crypto/subtle"
 	"errors"
 
 	"github.com/bytemare/ecc"
@@ -38,11 +39,14 @@ func Mask(
 	// testing: integrated to support testing, to force values.
 	nonce = nonceIn
 	if len(nonce) == 0 {
-		nonce = internal.RandomBytes(conf.NonceLen)
+		nonce = conf.RandomBytes(conf.NonceLen)
 	}
 
-	clearText := encoding.Concat(serverPublicKey, envelope)
-	maskedResponse = xorResponse(conf, maskingKey, nonce, clearText)
+	clearText := internal.GetBuffer()
+	defer internal.PutBuffer(clearText)
+
+	*clearText = append(append(*clearText, serverPublicKey...), envelope...)
+	maskedResponse = xorResponse(conf, maskingKey, nonce, *clearText)
 
 	return nonce, maskedResponse
 }
@@ -53,7 +57,7 @@ func Unmask(
 	conf *internal.Configuration,
 	randomizedPassword, nonce, maskedResponse []byte,
 ) (serverPublicKey *ecc.Element, serverPublicKeyBytes []byte, envelope *keyrecovery.Envelope, err error) {
-	maskingKey := conf.KDF.Expand(randomizedPassword, []byte(tag.MaskingKey), conf.Hash.Size())
+	maskingKey := conf.KDF.Expand(randomizedPassword, []byte(tag.MaskingKey), conf.KDF.Size())
 	clearText := xorResponse(conf, maskingKey, nonce, maskedResponse)
 	serverPublicKeyBytes = clearText[:conf.Group.ElementLength()]
 	env := clearText[conf.Group.ElementLength():]
@@ -72,7 +76,7 @@ func Unmask(
 
 // xorResponse is used to encrypt and decrypt the response in KE2.
 // It returns a new byte slice containing the byte-by-byte xor-ing of the in argument and a constructed pad,
-// which must be of the same length.
+// which must be of the same length. The pad is freshly allocated, and is xor-ed in place in constant time.
 func xorResponse(c *internal.Configuration, key, nonce, in []byte) []byte {
 	pad := c.KDF.Expand(
 		key,
@@ -80,12 +84,7 @@ func xorResponse(c *internal.Configuration, key, nonce, in []byte) []byte {
 		c.Group.ElementLength()+c.EnvelopeSize,
 	)
 
-	dst := make([]byte, len(pad))
-
-	// if the size is fixed, we could unroll the loop
-	for i, r := range pad {
-		dst[i] = r ^ in[i]
-	}
+	subtle.XORBytes(pad, pad, in)
 
-	return dst
+	return pad
 }
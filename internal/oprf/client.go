@@ -17,13 +17,19 @@ import (
 	"github.com/bytemare/opaque/internal/tag"
 )
 
-var errInvalidInput = errors.New("invalid input - OPRF input deterministically maps to the group identity element")
+var (
+	errInvalidInput = errors.New("invalid input - OPRF input deterministically maps to the group identity element")
+
+	// ErrInvalidProof indicates that the server's proof of correct evaluation could not be verified.
+	ErrInvalidProof = errors.New("invalid OPRF evaluation proof")
+)
 
 // Client implements the OPRF client and holds its state.
 type Client struct {
 	blind *ecc.Scalar
 	Identifier
 	input []byte
+	mode  Mode
 }
 
 // Blind masks the input.
@@ -34,7 +40,7 @@ func (c *Client) Blind(input []byte, blind *ecc.Scalar) *ecc.Element {
 		c.blind = c.Group().NewScalar().Random()
 	}
 
-	p := c.Group().HashToGroup(input, c.dst(tag.OPRFPointPrefix))
+	p := c.Group().HashToGroup(input, c.modeDST(c.mode, tag.OPRFPointPrefix))
 	if p.IsIdentity() {
 		panic(errInvalidInput)
 	}
@@ -59,3 +65,27 @@ func (c *Client) Finalize(evaluation *ecc.Element) []byte {
 
 	return c.hashTranscript(c.input, u)
 }
+
+// VerifiableClient implements the VOPRF client, verifying the server's proof of correct evaluation.
+type VerifiableClient struct {
+	*Client
+	serverPublicKey *ecc.Element
+	blinded         *ecc.Element
+}
+
+// Blind masks the input.
+func (v *VerifiableClient) Blind(input []byte, blind *ecc.Scalar) *ecc.Element {
+	v.blinded = v.Client.Blind(input, blind)
+	return v.blinded.Copy()
+}
+
+// Finalize verifies the proof of correct evaluation, and terminates the OPRF by unblinding the evaluation and hashing
+// the transcript.
+func (v *VerifiableClient) Finalize(evaluation *ecc.Element, proof []byte) ([]byte, error) {
+	if v.blinded == nil ||
+		!v.VerifyProof(v.serverPublicKey, []*ecc.Element{v.blinded}, []*ecc.Element{evaluation}, proof) {
+		return nil, ErrInvalidProof
+	}
+
+	return v.Client.Finalize(evaluation), nil
+}
@@ -45,14 +45,37 @@ const (
 	maxDeriveKeyPairTries = 255
 )
 
+// Mode distinguishes between the OPRF modes.
+type Mode byte
+
+const (
+	// Base is the base OPRF mode, used by OPAQUE.
+	Base Mode = iota
+
+	// Verifiable is the VOPRF mode, where the server proves correct evaluation with its committed key.
+	Verifiable
+)
+
 func (i Identifier) dst(prefix string) []byte {
-	return encoding.Concat([]byte(prefix), i.contextString())
+	return i.modeDST(Base, prefix)
+}
+
+func (i Identifier) modeDST(mode Mode, prefix string) []byte {
+	return encoding.Concat([]byte(prefix), i.modeContextString(mode))
 }
 
 func (i Identifier) contextString() []byte {
 	return encoding.Concatenate([]byte(tag.OPRFVersionPrefix), []byte(i))
 }
 
+func (i Identifier) modeContextString(mode Mode) []byte {
+	if mode == Base {
+		return i.contextString()
+	}
+
+	return encoding.Concatenate([]byte(tag.OPRFVersion), []byte{byte(mode), '-'}, []byte(i))
+}
+
 func (i Identifier) hash(input ...[]byte) []byte {
 	h := map[Identifier]crypto.Hash{
 		Ristretto255Sha512: crypto.SHA512,
@@ -100,7 +123,11 @@ func (i Identifier) Group() ecc.Group {
 
 // DeriveKey returns a scalar deterministically generated from the input.
 func (i Identifier) DeriveKey(seed, info []byte) *ecc.Scalar {
-	dst := encoding.Concat([]byte(tag.DeriveKeyPairInternal), i.contextString())
+	return i.deriveKey(Base, seed, info)
+}
+
+func (i Identifier) deriveKey(mode Mode, seed, info []byte) *ecc.Scalar {
+	dst := i.modeDST(mode, tag.DeriveKeyPairInternal)
 	deriveInput := encoding.Concat(seed, encoding.EncodeVector(info))
 
 	var (
@@ -126,11 +153,32 @@ func (i Identifier) DeriveKeyPair(seed, info []byte) (*ecc.Scalar, *ecc.Element)
 	return sk, i.Group().Base().Multiply(sk)
 }
 
+// DeriveVerifiableKeyPair returns a valid keypair for the verifiable mode deterministically generated from the input.
+func (i Identifier) DeriveVerifiableKeyPair(seed, info []byte) (*ecc.Scalar, *ecc.Element) {
+	sk := i.deriveKey(Verifiable, seed, info)
+	return sk, i.Group().Base().Multiply(sk)
+}
+
 // Client returns an OPRF client.
 func (i Identifier) Client() *Client {
 	return &Client{
 		Identifier: i,
 		input:      nil,
 		blind:      nil,
+		mode:       Base,
+	}
+}
+
+// VerifiableClient returns a VOPRF client verifying evaluations against the server's public key.
+func (i Identifier) VerifiableClient(serverPublicKey *ecc.Element) *VerifiableClient {
+	return &VerifiableClient{
+		Client: &Client{
+			Identifier: i,
+			input:      nil,
+			blind:      nil,
+			mode:       Verifiable,
+		},
+		serverPublicKey: serverPublicKey,
+		blinded:         nil,
 	}
 }
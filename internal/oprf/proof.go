@@ -0,0 +1,121 @@
+// SPDX-License-Identifier: MIT
+//
+// Copyright (C) 2020-2025 Daniel Bourdrez. All Rights Reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree or at
+// https://spdx.org/licenses/MIT.html
+
+package oprf
+
+import (
+	"github.com/bytemare/ecc"
+
+	"github.com/bytemare/opaque/internal/encoding"
+	"github.com/bytemare/opaque/internal/tag"
+)
+
+// computeComposites returns the composite elements M and Z of the batched proof. If the private key is set, Z is
+// computed directly from it.
+func (i Identifier) computeComposites(
+	privateKey *ecc.Scalar,
+	publicKey *ecc.Element,
+	blinded, evaluated []*ecc.Element,
+) (m, z *ecc.Element) {
+	g := i.Group()
+	dst := i.modeDST(Verifiable, tag.OPRFHashToScalarPrefix)
+	seedDST := i.modeDST(Verifiable, tag.OPRFSeedPrefix)
+	seed := i.hash(encoding.EncodeVector(publicKey.Encode()), encoding.EncodeVector(seedDST))
+	encSeed := encoding.EncodeVector(seed)
+
+	m = g.NewElement()
+	z = g.NewElement()
+
+	for j := range blinded {
+		transcript := encoding.Concatenate(
+			encSeed,
+			encoding.I2OSP(j, 2),
+			encoding.EncodeVector(blinded[j].Encode()),
+			encoding.EncodeVector(evaluated[j].Encode()),
+			[]byte(tag.OPRFComposite),
+		)
+		d := g.HashToScalar(transcript, dst)
+		m.Add(blinded[j].Copy().Multiply(d))
+
+		if privateKey == nil {
+			z.Add(evaluated[j].Copy().Multiply(d))
+		}
+	}
+
+	if privateKey != nil {
+		z = m.Copy().Multiply(privateKey)
+	}
+
+	return m, z
+}
+
+func (i Identifier) challenge(publicKey, m, z, t2, t3 *ecc.Element) *ecc.Scalar {
+	transcript := encoding.Concatenate(
+		encoding.EncodeVector(publicKey.Encode()),
+		encoding.EncodeVector(m.Encode()),
+		encoding.EncodeVector(z.Encode()),
+		encoding.EncodeVector(t2.Encode()),
+		encoding.EncodeVector(t3.Encode()),
+		[]byte(tag.OPRFChallenge),
+	)
+
+	return i.Group().HashToScalar(transcript, i.modeDST(Verifiable, tag.OPRFHashToScalarPrefix))
+}
+
+// generateProof returns the encoded proof that the evaluated elements are the blinded elements multiplied by the
+// private key, whose public key is the given one. If random is nil, a random scalar is used.
+func (i Identifier) generateProof(
+	privateKey, random *ecc.Scalar,
+	publicKey *ecc.Element,
+	blinded, evaluated []*ecc.Element,
+) []byte {
+	if random == nil {
+		random = i.Group().NewScalar().Random()
+	}
+
+	m, z := i.computeComposites(privateKey, publicKey, blinded, evaluated)
+	t2 := i.Group().Base().Multiply(random)
+	t3 := m.Copy().Multiply(random)
+	c := i.challenge(publicKey, m, z, t2, t3)
+	s := random.Copy().Subtract(c.Copy().Multiply(privateKey))
+
+	return encoding.Concat(c.Encode(), s.Encode())
+}
+
+// VerifyProof returns whether the proof attests that the evaluated elements are the blinded elements multiplied by the
+// private key corresponding to the public key.
+func (i Identifier) VerifyProof(publicKey *ecc.Element, blinded, evaluated []*ecc.Element, proof []byte) bool {
+	g := i.Group()
+	scalarLength := g.ScalarLength()
+
+	if publicKey == nil || len(blinded) == 0 || len(blinded) != len(evaluated) || len(proof) != 2*scalarLength {
+		return false
+	}
+
+	for j := range blinded {
+		if blinded[j] == nil || evaluated[j] == nil {
+			return false
+		}
+	}
+
+	c := g.NewScalar()
+	if err := c.Decode(proof[:scalarLength]); err != nil {
+		return false
+	}
+
+	s := g.NewScalar()
+	if err := s.Decode(proof[scalarLength:]); err != nil {
+		return false
+	}
+
+	m, z := i.computeComposites(nil, publicKey, blinded, evaluated)
+	t2 := g.Base().Multiply(s).Add(publicKey.Copy().Multiply(c))
+	t3 := m.Copy().Multiply(s).Add(z.Copy().Multiply(c))
+
+	return i.challenge(publicKey, m, z, t2, t3).Equal(c)
+}
@@ -16,3 +16,19 @@ import (
 func (i Identifier) Evaluate(privateKey *ecc.Scalar, blindedElement *ecc.Element) *ecc.Element {
 	return blindedElement.Copy().Multiply(privateKey)
 }
+
+// VerifiableEvaluate evaluates the blinded inputs with the given key, and returns the evaluations and the proof of their
+// correctness against the public key. If random is nil, a random scalar is used for the proof.
+func (i Identifier) VerifiableEvaluate(
+	privateKey, random *ecc.Scalar,
+	blinded []*ecc.Element,
+) (evaluated []*ecc.Element, proof []byte) {
+	evaluated = make([]*ecc.Element, len(blinded))
+	for j, b := range blinded {
+		evaluated[j] = i.Evaluate(privateKey, b)
+	}
+
+	publicKey := i.Group().Base().Multiply(privateKey)
+
+	return evaluated, i.generateProof(privateKey, random, publicKey, blinded, evaluated)
+}
@@ -0,0 +1,79 @@
+// SPDX-License-Identifier: MIT
+//
+// Copyright (C) 2020-2025 Daniel Bourdrez. All Rights Reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree or at
+// https://spdx.org/licenses/MIT.html
+
+package internal
+
+import (
+	"sync"
+
+	"github.com/bytemare/ecc"
+)
+
+// bufferCapacity is the initial capacity of pooled scratch buffers, large enough for the masked response of all
+// supported groups and hash functions with the default nonce length.
+const bufferCapacity = 256
+
+var (
+	// bufferPool holds scratch buffers reused across protocol executions to reduce allocations. Pooling never changes
+	// any output, and buffers and elements are wiped before being returned to their pool, as they can hold secrets.
+	bufferPool = sync.Pool{
+		New: func() any {
+			b := make([]byte, 0, bufferCapacity)
+			return &b
+		},
+	}
+
+	// elementPools maps an ecc.Group to a *sync.Pool of its elements.
+	elementPools sync.Map
+)
+
+// GetBuffer returns an empty scratch buffer, which must be returned with PutBuffer once it is no longer referenced.
+func GetBuffer() *[]byte {
+	b, _ := bufferPool.Get().(*[]byte)
+
+	return b
+}
+
+// PutBuffer wipes the scratch buffer and returns it to the pool.
+func PutBuffer(b *[]byte) {
+	clear((*b)[:cap(*b)])
+	*b = (*b)[:0]
+
+	bufferPool.Put(b)
+}
+
+func elementPool(g ecc.Group) *sync.Pool {
+	if p, ok := elementPools.Load(g); ok {
+		pool, _ := p.(*sync.Pool)
+		return pool
+	}
+
+	p, _ := elementPools.LoadOrStore(g, &sync.Pool{
+		New: func() any {
+			return g.NewElement()
+		},
+	})
+	pool, _ := p.(*sync.Pool)
+
+	return pool
+}
+
+// GetElement returns a scratch element of the group, which must be returned with PutElement once it is no longer
+// referenced. Its value is undefined.
+func GetElement(g ecc.Group) *ecc.Element {
+	e, _ := elementPool(g).Get().(*ecc.Element)
+
+	return e
+}
+
+// PutElement resets the scratch element to the identity element, wiping e.g. a Diffie-Hellman output, and returns it
+// to the pool.
+func PutElement(e *ecc.Element) {
+	e.Set(nil)
+	elementPool(e.Group()).Put(e)
+}
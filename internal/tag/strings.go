@@ -16,6 +16,21 @@ const (
 	// OPRFVersionPrefix is a string explicitly stating the version name.
 	OPRFVersionPrefix = "OPRFV1-\x00-"
 
+	// OPRFVersion is the version name, to be followed by the mode byte in the context string.
+	OPRFVersion = "OPRFV1-"
+
+	// OPRFHashToScalarPrefix is the DST prefix to use for HashToScalar operations in proofs.
+	OPRFHashToScalarPrefix = "HashToScalar-"
+
+	// OPRFSeedPrefix is the DST prefix used in the composite seed transcript of proofs.
+	OPRFSeedPrefix = "Seed-"
+
+	// OPRFComposite is the DST suffix used in the composite transcript of proofs.
+	OPRFComposite = "Composite"
+
+	// OPRFChallenge is the DST suffix used in the challenge transcript of proofs.
+	OPRFChallenge = "Challenge"
+
 	// DeriveKeyPairInternal is the internal DeriveKeyPair tag as defined in VOPRF.
 	DeriveKeyPairInternal = "DeriveKeyPair"
 
@@ -74,4 +89,46 @@ const (
 
 	// DeriveKeyPair is the server's OPRF hash-to-scalar dst.
 	DeriveKeyPair = "OPAQUE-DeriveKeyPair"
+
+	// ExpandServerKeyPair is the server's AKE key pair seed KDF dst.
+	ExpandServerKeyPair = "ServerKeyPair"
+
+	// FakeRecordSeed is the server's fake record seed KDF dst.
+	FakeRecordSeed = "FakeRecordSeed"
+
+	// FakeMaskingKey is the fake record's masking key KDF dst.
+	FakeMaskingKey = "FakeMaskingKey"
+
+	// FakeClientKey is the fake record's client key pair seed KDF dst.
+	FakeClientKey = "FakeClientKey"
+
+	// RandomScalar is the hash-to-scalar dst for scalars drawn from a custom random source.
+	RandomScalar = "OPAQUE-RandomScalar"
+
+	// ConfigFingerprint is the dst of the configuration fingerprint.
+	ConfigFingerprint = "OPAQUE-ConfigFingerprint"
+
+	// SealedState is the additional data bound to a sealed AKE server state.
+	SealedState = "OPAQUE-SealedAKEState"
+
+	// AssociatedData prefixes the associated data in the transcript.
+	AssociatedData = "OPAQUE-AssociatedData"
+
+	// CredentialIdentifier is the dst for credential identifiers derived from usernames.
+	CredentialIdentifier = "OPAQUE-CredentialIdentifier"
+
+	// SealedKeyMaterial is the additional data bound to sealed server key material.
+	SealedKeyMaterial = "OPAQUE-SealedKeyMaterial"
+
+	// SealedClientRecord is the additional data bound to sealed client records.
+	SealedClientRecord = "OPAQUE-SealedClientRecord"
+
+	// DummyLogin is the dst of the fake credential identifiers derived from blinded messages in dummy logins.
+	DummyLogin = "OPAQUE-DummyLogin"
+
+	// TaggedState is the MAC dst of a tagged AKE server state.
+	TaggedState = "OPAQUE-TaggedAKEState"
+
+	// KEMEncapsulation is the KDF dst of the KEM encapsulation randomness derived from a key share seed.
+	KEMEncapsulation = "OPAQUE-KEMEncapsulation"
 )
@@ -0,0 +1,157 @@
+// SPDX-License-Identifier: MIT
+//
+// Copyright (C) 2020-2025 Daniel Bourdrez. All Rights Reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree or at
+// https://spdx.org/licenses/MIT.html
+
+package opaque
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/bytemare/opaque/internal"
+	"github.com/bytemare/opaque/internal/encoding"
+	"github.com/bytemare/opaque/internal/tag"
+)
+
+// maxKeyMaterialComponentLength is the maximum length of a sealed key material component other than the server
+// identity, i.e. the largest OPRF seed, scalar, or element length.
+const maxKeyMaterialComponentLength = 67
+
+// ErrInvalidKeyMaterial indicates that sealed key material was authenticated but can't be decoded, or that its
+// secret key doesn't match its public key.
+var ErrInvalidKeyMaterial = errors.New("invalid sealed key material")
+
+func (s *Server) sealedKeyMaterialAD() []byte {
+	return encoding.Concat([]byte(tag.SealedKeyMaterial), s.conf.Fingerprint)
+}
+
+// ExportKeyMaterialSealed returns the server's key material, i.e. its identity, AKE key pair, OPRF seed, and the
+// previous OPRF seed and legacy AKE key pair if set, encrypted and authenticated with AES-256-GCM under the 32-byte
+// key, e.g. for a warm-standby server to load it with ImportKeyMaterialSealed. The sealed key material is bound to the
+// configuration, and the key must be secret and shared only among server instances.
+func (s *Server) ExportKeyMaterialSealed(key []byte) ([]byte, error) {
+	if s.keyMaterial == nil {
+		return nil, ErrNoServerKeyMaterial
+	}
+
+	var legacySecretKey []byte
+	if s.legacySecretKey != nil {
+		legacySecretKey = s.legacySecretKey.Encode()
+	}
+
+	plaintext := encoding.Concatenate(
+		s.conf.EncodeIdentity(s.serverIdentity),
+		encoding.EncodeVector(s.serverSecretKey.Encode()),
+		encoding.EncodeVector(s.serverPublicKey),
+		encoding.EncodeVector(s.oprfSeed),
+		encoding.EncodeVector(s.previousOPRFSeed),
+		encoding.EncodeVector(legacySecretKey),
+		encoding.EncodeVector(s.legacyPublicKey),
+	)
+	defer clear(plaintext)
+
+	sealed, err := internal.Seal(key, plaintext, s.sealedKeyMaterialAD())
+	if err != nil {
+		return nil, fmt.Errorf("sealing key material: %w", err)
+	}
+
+	return sealed, nil
+}
+
+// ImportKeyMaterialSealed decrypts key material produced by ExportKeyMaterialSealed under the same key and with the
+// same configuration, and sets it as the server's key material, replacing the current one. It returns
+// ErrStateAuthentication if the data was tampered with or the key is wrong, and ErrInvalidKeyMaterial if the secret key
+// doesn't match the public key.
+func (s *Server) ImportKeyMaterialSealed(key, data []byte) error {
+	plaintext, err := internal.Open(key, data, s.sealedKeyMaterialAD())
+	if err != nil {
+		if errors.Is(err, internal.ErrSealOpen) {
+			return ErrStateAuthentication
+		}
+
+		return fmt.Errorf("opening key material: %w", err)
+	}
+	defer clear(plaintext)
+
+	components, err := s.decodeKeyMaterial(plaintext)
+	if err != nil {
+		return err
+	}
+
+	identity, secretKey, publicKey, oprfSeed := components[0], components[1], components[2], components[3]
+	previousSeed, legacySecretKey, legacyPublicKey := components[4], components[5], components[6]
+
+	if err = s.checkKeyPair(secretKey, publicKey); err != nil {
+		return err
+	}
+
+	if previousSeed == nil {
+		err = s.SetKeyMaterial(identity, secretKey, publicKey, oprfSeed)
+	} else {
+		err = s.SetKeyMaterialWithPreviousSeed(identity, secretKey, publicKey, oprfSeed, previousSeed)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if legacySecretKey == nil {
+		return nil
+	}
+
+	if err = s.checkKeyPair(legacySecretKey, legacyPublicKey); err != nil {
+		return err
+	}
+
+	return s.SetLegacyServerKey(legacySecretKey, legacyPublicKey)
+}
+
+// decodeKeyMaterial returns copies of the length-prefixed components of the sealed key material, which are nil if
+// empty. The server identity's length prefix depends on the configuration, as in the transcript.
+func (s *Server) decodeKeyMaterial(plaintext []byte) ([][]byte, error) {
+	components := make([][]byte, 7)
+	offset := 0
+
+	for i := range components {
+		prefixLength, maxLength := 2, maxKeyMaterialComponentLength
+		if i == 0 {
+			prefixLength, maxLength = int(s.conf.IdentityLengthPrefix()), s.conf.MaxIdentityLength()
+		}
+
+		component, o, err := encoding.DecodeVectorLenMax(plaintext[offset:], prefixLength, maxLength)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidKeyMaterial, err)
+		}
+
+		if len(component) != 0 {
+			components[i] = slices.Clone(component)
+		}
+
+		offset += o
+	}
+
+	if offset != len(plaintext) {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidKeyMaterial, ErrTrailingBytes)
+	}
+
+	return components, nil
+}
+
+// checkKeyPair returns ErrInvalidKeyMaterial if the secret key can't be decoded or doesn't match the public key.
+func (s *Server) checkKeyPair(secretKey, publicKey []byte) error {
+	sk := s.conf.Group.NewScalar()
+	if err := sk.Decode(secretKey); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidKeyMaterial, err)
+	}
+
+	if !slices.Equal(s.conf.Group.Base().Multiply(sk).Encode(), publicKey) {
+		return ErrInvalidKeyMaterial
+	}
+
+	return nil
+}
@@ -31,6 +31,20 @@ func (c *CredentialRequest) Serialize() []byte {
 	return c.BlindedMessage.Encode()
 }
 
+// SerializeTo appends the byte encoding of CredentialRequest to dst and returns the extended slice.
+func (c *CredentialRequest) SerializeTo(dst []byte) []byte {
+	return append(dst, c.BlindedMessage.Encode()...)
+}
+
+// Equal returns whether c and other hold the same values.
+func (c *CredentialRequest) Equal(other *CredentialRequest) bool {
+	if both, one := bothNil(c, other); both || one {
+		return both
+	}
+
+	return elementEqual(c.BlindedMessage, other.BlindedMessage)
+}
+
 // CredentialResponse represents a credential response message.
 type CredentialResponse struct {
 	EvaluatedMessage *ecc.Element `json:"evaluatedMessage"`
@@ -51,3 +65,27 @@ func NewCredentialResponse(message *ecc.Element, nonce, response []byte) *Creden
 func (c *CredentialResponse) Serialize() []byte {
 	return encoding.Concat3(c.EvaluatedMessage.Encode(), c.MaskingNonce, c.MaskedResponse)
 }
+
+// SerializeTo appends the byte encoding of CredentialResponse to dst and returns the extended slice.
+func (c *CredentialResponse) SerializeTo(dst []byte) []byte {
+	dst = append(dst, c.EvaluatedMessage.Encode()...)
+	dst = append(dst, c.MaskingNonce...)
+
+	return append(dst, c.MaskedResponse...)
+}
+
+// Equal returns whether c and other hold the same values, comparing the masking fields in constant time.
+func (c *CredentialResponse) Equal(other *CredentialResponse) bool {
+	if both, one := bothNil(c, other); both || one {
+		return both
+	}
+
+	return elementEqual(c.EvaluatedMessage, other.EvaluatedMessage) &&
+		bytesEqual(c.MaskingNonce, other.MaskingNonce) &&
+		bytesEqual(c.MaskedResponse, other.MaskedResponse)
+}
+
+// EvaluatedElement returns the byte encoding of the server's evaluated OPRF element.
+func (c *CredentialResponse) EvaluatedElement() []byte {
+	return c.EvaluatedMessage.Encode()
+}
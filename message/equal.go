@@ -0,0 +1,34 @@
+// SPDX-License-Identifier: MIT
+//
+// Copyright (C) 2020-2025 Daniel Bourdrez. All Rights Reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree or at
+// https://spdx.org/licenses/MIT.html
+
+package message
+
+import (
+	"crypto/subtle"
+
+	"github.com/bytemare/ecc"
+)
+
+// elementEqual returns whether both elements are nil, or are equal elements of the same group.
+func elementEqual(a, b *ecc.Element) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	return a.Group() == b.Group() && a.Equal(b)
+}
+
+// bytesEqual returns a constant-time comparison of the input.
+func bytesEqual(a, b []byte) bool {
+	return subtle.ConstantTimeCompare(a, b) == 1
+}
+
+// bothNil returns whether both pointers are nil, and whether exactly one of them is nil.
+func bothNil[T any](a, b *T) (both, one bool) {
+	return a == nil && b == nil, (a == nil) != (b == nil)
+}
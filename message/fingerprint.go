@@ -0,0 +1,39 @@
+// SPDX-License-Identifier: MIT
+//
+// Copyright (C) 2020-2025 Daniel Bourdrez. All Rights Reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree or at
+// https://spdx.org/licenses/MIT.html
+
+package message
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/bytemare/opaque/internal/encoding"
+)
+
+// FingerprintLength is the length of a configuration fingerprint.
+const FingerprintLength = 8
+
+// ErrCiphersuiteMismatch indicates that a wrapped message was produced under a different configuration.
+var ErrCiphersuiteMismatch = errors.New("message fingerprint does not match the configuration")
+
+// WrapWithFingerprint prefixes the serialized message with the configuration fingerprint, as returned by
+// Deserializer.ConfigFingerprint(). The fingerprint is not part of the protocol: it only lets the receiver detect
+// that its peer uses a different configuration before deserializing the message.
+func WrapWithFingerprint(fingerprint, msg []byte) []byte {
+	return encoding.Concat(fingerprint, msg)
+}
+
+// UnwrapFingerprint strips the fingerprint from a message wrapped with WrapWithFingerprint, and returns
+// ErrCiphersuiteMismatch if it doesn't match the given fingerprint.
+func UnwrapFingerprint(fingerprint, wrapped []byte) ([]byte, error) {
+	if len(wrapped) < len(fingerprint) || !bytes.Equal(wrapped[:len(fingerprint)], fingerprint) {
+		return nil, ErrCiphersuiteMismatch
+	}
+
+	return wrapped[len(fingerprint):], nil
+}
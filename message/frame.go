@@ -0,0 +1,58 @@
+// SPDX-License-Identifier: MIT
+//
+// Copyright (C) 2020-2025 Daniel Bourdrez. All Rights Reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree or at
+// https://spdx.org/licenses/MIT.html
+
+package message
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// frameHeaderLength is the length of the big-endian length prefix of a frame.
+const frameHeaderLength = 4
+
+// MaxFrameLength is the maximum message length ReadFrame accepts, to prevent a peer from making the reader allocate
+// arbitrary amounts of memory. It defaults to 1 MiB, and can be changed to fit the application's messages.
+var MaxFrameLength uint32 = 1 << 20
+
+// ErrFrameTooLarge indicates that a frame announces a message longer than MaxFrameLength.
+var ErrFrameTooLarge = errors.New("framed message exceeds the maximum frame length")
+
+// Frame prefixes the serialized message with its length, encoded on 4 bytes in big-endian, for use over byte streams.
+func Frame(msg []byte) []byte {
+	out := make([]byte, frameHeaderLength, frameHeaderLength+len(msg))
+	binary.BigEndian.PutUint32(out, uint32(len(msg)))
+
+	return append(out, msg...)
+}
+
+// ReadFrame reads exactly one message framed with Frame from r. It returns ErrFrameTooLarge if the announced length
+// exceeds MaxFrameLength, and io.ErrUnexpectedEOF if the stream ends before the whole frame is read.
+func ReadFrame(r io.Reader) ([]byte, error) {
+	var header [frameHeaderLength]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+
+	length := binary.BigEndian.Uint32(header[:])
+	if length > MaxFrameLength {
+		return nil, ErrFrameTooLarge
+	}
+
+	msg := make([]byte, length)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, io.ErrUnexpectedEOF
+		}
+
+		return nil, err
+	}
+
+	return msg, nil
+}
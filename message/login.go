@@ -15,34 +15,92 @@ import (
 	"github.com/bytemare/opaque/internal/encoding"
 )
 
-// KE1 is the first message of the login flow, created by the client and sent to the server.
+// KE1 is the first message of the login flow, created by the client and sent to the server. KEMEncapsulationKey is
+// only set if the configuration uses a hybrid KEM key share.
 type KE1 struct {
 	*CredentialRequest
 	ClientPublicKeyshare *ecc.Element `json:"clientPublicKeyshare"`
 	ClientNonce          []byte       `json:"clientNonce"`
+	KEMEncapsulationKey  []byte       `json:"kemEncapsulationKey,omitempty"`
 }
 
 // Serialize returns the byte encoding of KE1.
 func (m *KE1) Serialize() []byte {
-	return encoding.Concat3(m.CredentialRequest.Serialize(), m.ClientNonce, m.ClientPublicKeyshare.Encode())
+	return encoding.Concatenate(
+		m.CredentialRequest.Serialize(),
+		m.ClientNonce,
+		m.ClientPublicKeyshare.Encode(),
+		m.KEMEncapsulationKey,
+	)
 }
 
-// KE2 is the second message of the login flow, created by the server and sent to the client.
+// SerializeTo appends the byte encoding of KE1 to dst and returns the extended slice. The appended bytes are the same
+// as those returned by Serialize, but dst can be reused across calls to avoid allocating a new buffer each time.
+func (m *KE1) SerializeTo(dst []byte) []byte {
+	dst = m.CredentialRequest.SerializeTo(dst)
+	dst = append(dst, m.ClientNonce...)
+	dst = append(dst, m.ClientPublicKeyshare.Encode()...)
+
+	return append(dst, m.KEMEncapsulationKey...)
+}
+
+// Equal returns whether m and other hold the same values.
+func (m *KE1) Equal(other *KE1) bool {
+	if both, one := bothNil(m, other); both || one {
+		return both
+	}
+
+	return m.CredentialRequest.Equal(other.CredentialRequest) &&
+		elementEqual(m.ClientPublicKeyshare, other.ClientPublicKeyshare) &&
+		bytesEqual(m.ClientNonce, other.ClientNonce) &&
+		bytesEqual(m.KEMEncapsulationKey, other.KEMEncapsulationKey)
+}
+
+// KE2 is the second message of the login flow, created by the server and sent to the client. KEMCiphertext is only
+// set if the configuration uses a hybrid KEM key share.
 type KE2 struct {
 	*CredentialResponse
 	ServerPublicKeyshare *ecc.Element `json:"serverPublicKeyshare"`
 	ServerNonce          []byte       `json:"serverNonce"`
+	KEMCiphertext        []byte       `json:"kemCiphertext,omitempty"`
 	ServerMac            []byte       `json:"serverMac"`
 }
 
 // Serialize returns the byte encoding of KE2.
 func (m *KE2) Serialize() []byte {
-	return encoding.Concat(
+	return encoding.Concatenate(
 		m.CredentialResponse.Serialize(),
-		encoding.Concat3(m.ServerNonce, m.ServerPublicKeyshare.Encode(), m.ServerMac),
+		m.ServerNonce,
+		m.ServerPublicKeyshare.Encode(),
+		m.KEMCiphertext,
+		m.ServerMac,
 	)
 }
 
+// SerializeTo appends the byte encoding of KE2 to dst and returns the extended slice. The appended bytes are the same
+// as those returned by Serialize, but dst can be reused across calls to avoid allocating a new buffer each time.
+func (m *KE2) SerializeTo(dst []byte) []byte {
+	dst = m.CredentialResponse.SerializeTo(dst)
+	dst = append(dst, m.ServerNonce...)
+	dst = append(dst, m.ServerPublicKeyshare.Encode()...)
+	dst = append(dst, m.KEMCiphertext...)
+
+	return append(dst, m.ServerMac...)
+}
+
+// Equal returns whether m and other hold the same values, comparing the MAC and masking fields in constant time.
+func (m *KE2) Equal(other *KE2) bool {
+	if both, one := bothNil(m, other); both || one {
+		return both
+	}
+
+	return m.CredentialResponse.Equal(other.CredentialResponse) &&
+		elementEqual(m.ServerPublicKeyshare, other.ServerPublicKeyshare) &&
+		bytesEqual(m.ServerNonce, other.ServerNonce) &&
+		bytesEqual(m.KEMCiphertext, other.KEMCiphertext) &&
+		bytesEqual(m.ServerMac, other.ServerMac)
+}
+
 // KE3 is the third and last message of the login flow, created by the client and sent to the server.
 type KE3 struct {
 	ClientMac []byte `json:"clientMac"`
@@ -52,3 +110,17 @@ type KE3 struct {
 func (k KE3) Serialize() []byte {
 	return k.ClientMac
 }
+
+// SerializeTo appends the byte encoding of KE3 to dst and returns the extended slice.
+func (k KE3) SerializeTo(dst []byte) []byte {
+	return append(dst, k.ClientMac...)
+}
+
+// Equal returns whether k and other hold the same MAC, compared in constant time.
+func (k *KE3) Equal(other *KE3) bool {
+	if both, one := bothNil(k, other); both || one {
+		return both
+	}
+
+	return bytesEqual(k.ClientMac, other.ClientMac)
+}
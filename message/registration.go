@@ -9,11 +9,29 @@
 package message
 
 import (
+	"errors"
+
 	"github.com/bytemare/ecc"
 
+	"github.com/bytemare/opaque/internal"
 	"github.com/bytemare/opaque/internal/encoding"
 )
 
+var (
+	// ErrNilRecord indicates that the record is nil.
+	ErrNilRecord = errors.New("record is nil")
+
+	// ErrInvalidRecordPublicKey indicates that the record's client public key is not a valid element of the group,
+	// or is the identity element.
+	ErrInvalidRecordPublicKey = errors.New("record has invalid client public key")
+
+	// ErrInvalidMaskingKeyLength indicates that the record's masking key is not of the KDF's output length.
+	ErrInvalidMaskingKeyLength = errors.New("record has invalid masking key length")
+
+	// ErrInvalidEnvelopeLength indicates that the record's envelope is not of the nonce and MAC's combined length.
+	ErrInvalidEnvelopeLength = errors.New("record has invalid envelope length")
+)
+
 // RegistrationRequest is the first message of the registration flow, created by the client and sent to the server.
 type RegistrationRequest struct {
 	BlindedMessage *ecc.Element `json:"blindedMessage"`
@@ -24,6 +42,15 @@ func (r *RegistrationRequest) Serialize() []byte {
 	return r.BlindedMessage.Encode()
 }
 
+// Equal returns whether r and other hold the same values.
+func (r *RegistrationRequest) Equal(other *RegistrationRequest) bool {
+	if both, one := bothNil(r, other); both || one {
+		return both
+	}
+
+	return elementEqual(r.BlindedMessage, other.BlindedMessage)
+}
+
 // RegistrationResponse is the second message of the registration flow, created by the server and sent to the client.
 type RegistrationResponse struct {
 	EvaluatedMessage *ecc.Element `json:"evaluatedMessage"`
@@ -35,6 +62,15 @@ func (r *RegistrationResponse) Serialize() []byte {
 	return encoding.Concat(r.EvaluatedMessage.Encode(), r.Pks.Encode())
 }
 
+// Equal returns whether r and other hold the same values.
+func (r *RegistrationResponse) Equal(other *RegistrationResponse) bool {
+	if both, one := bothNil(r, other); both || one {
+		return both
+	}
+
+	return elementEqual(r.EvaluatedMessage, other.EvaluatedMessage) && elementEqual(r.Pks, other.Pks)
+}
+
 // RegistrationRecord represents the client record sent as the last registration message by the client to the server.
 type RegistrationRecord struct {
 	PublicKey  *ecc.Element `json:"clientPublicKey"`
@@ -46,3 +82,36 @@ type RegistrationRecord struct {
 func (r *RegistrationRecord) Serialize() []byte {
 	return encoding.Concat3(r.PublicKey.Encode(), r.MaskingKey, r.Envelope)
 }
+
+// Validate checks the integrity of the record against the configuration, e.g. after loading it from storage, and
+// returns an error on the first invalid field.
+func (r *RegistrationRecord) Validate(conf *internal.Configuration) error {
+	if r == nil {
+		return ErrNilRecord
+	}
+
+	if r.PublicKey == nil || r.PublicKey.Group() != conf.Group || r.PublicKey.IsIdentity() {
+		return ErrInvalidRecordPublicKey
+	}
+
+	if len(r.MaskingKey) != conf.KDF.Size() {
+		return ErrInvalidMaskingKeyLength
+	}
+
+	if len(r.Envelope) != conf.NonceLen+conf.MAC.Size() {
+		return ErrInvalidEnvelopeLength
+	}
+
+	return nil
+}
+
+// Equal returns whether r and other hold the same values, comparing the masking key and envelope in constant time.
+func (r *RegistrationRecord) Equal(other *RegistrationRecord) bool {
+	if both, one := bothNil(r, other); both || one {
+		return both
+	}
+
+	return elementEqual(r.PublicKey, other.PublicKey) &&
+		bytesEqual(r.MaskingKey, other.MaskingKey) &&
+		bytesEqual(r.Envelope, other.Envelope)
+}
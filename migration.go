@@ -0,0 +1,121 @@
+// SPDX-License-Identifier: MIT
+//
+// Copyright (C) 2020-2025 Daniel Bourdrez. All Rights Reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree or at
+// https://spdx.org/licenses/MIT.html
+
+package opaque
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+)
+
+// MigrationPlan describes what moving a deployment from one configuration to another implies for the existing records
+// and server key material, e.g. for operators to script a forced re-registration. It is returned by
+// Configuration.MigrationPlan.
+type MigrationPlan struct {
+	// Incompatible lists the parameters that differ and invalidate existing records or server key material, e.g. "KDF".
+	Incompatible []string
+	// Changed lists the parameters that differ but keep records and key material valid, e.g. "context". Clients and
+	// servers must still switch to the new configuration together, or their logins fail.
+	Changed []string
+	// RegenerateMaskingKeys indicates that the records' masking keys can't be used with the new configuration.
+	RegenerateMaskingKeys bool
+	// RegenerateEnvelopes indicates that the records' envelopes and client public keys can't be used with the new
+	// configuration.
+	RegenerateEnvelopes bool
+	// RegenerateServerKeys indicates that the server's AKE key pair or OPRF seed can't be used with the new
+	// configuration.
+	RegenerateServerKeys bool
+}
+
+// ReRegistrationRequired returns whether the existing records must be replaced. Since they derive from the clients'
+// passwords, this requires each client to register again.
+func (p *MigrationPlan) ReRegistrationRequired() bool {
+	return p.RegenerateMaskingKeys || p.RegenerateEnvelopes
+}
+
+// migrationParameter is a configuration parameter compared by MigrationPlan, with what a difference invalidates.
+type migrationParameter struct {
+	name        string
+	differs     bool
+	maskingKeys bool
+	envelopes   bool
+	serverKeys  bool
+}
+
+// MigrationPlan compares c, the new configuration, to the old one, and returns the MigrationPlan of moving from the
+// latter to the former. A nil old configuration is the default configuration. It returns an error if either
+// configuration is invalid.
+//
+// The KSF parameters are compared as set in the configurations, so parameters set per call with
+// ClientRegistrationFinalizeOptions.KSFParameters must be compared by the application. Custom KDF and MAC providers
+// can't be compared, and are ignored.
+func (c *Configuration) MigrationPlan(old *Configuration) (*MigrationPlan, error) {
+	if old == nil {
+		old = DefaultConfiguration()
+	}
+
+	if err := old.verify(); err != nil {
+		return nil, fmt.Errorf("old configuration: %w", err)
+	}
+
+	if err := c.verify(); err != nil {
+		return nil, fmt.Errorf("new configuration: %w", err)
+	}
+
+	// A different hash length requires a new OPRF seed, from which the OPRF keys, and thus the records, derive.
+	hashLength := c.Hash.Size() != old.Hash.Size()
+
+	plan := &MigrationPlan{
+		Incompatible:          nil,
+		Changed:               nil,
+		RegenerateMaskingKeys: false,
+		RegenerateEnvelopes:   false,
+		RegenerateServerKeys:  false,
+	}
+
+	for _, param := range []migrationParameter{
+		{"OPRF group", c.OPRF != old.OPRF, true, true, false},
+		{"AKE group", c.AKE != old.AKE, false, true, true},
+		{"KSF", c.KSF != old.KSF, true, true, false},
+		{"KSF parameters", c.KSF == old.KSF &&
+			!slices.Equal(c.effectiveKSFParameters(), old.effectiveKSFParameters()), true, true, false},
+		{"KDF", c.KDF != old.KDF, true, true, false},
+		{"MAC", c.MAC != old.MAC, false, true, false},
+		{"Hash", c.Hash != old.Hash, hashLength, hashLength, hashLength},
+		{"nonce length", c.nonceLength() != old.nonceLength(), false, true, false},
+		{"long identities", c.LongIdentities != old.LongIdentities, false, true, false},
+		{"KEM", c.KEM != old.KEM, false, false, false},
+		{"context", !bytes.Equal(c.Context, old.Context), false, false, false},
+	} {
+		if !param.differs {
+			continue
+		}
+
+		if !param.maskingKeys && !param.envelopes && !param.serverKeys {
+			plan.Changed = append(plan.Changed, param.name)
+			continue
+		}
+
+		plan.Incompatible = append(plan.Incompatible, param.name)
+		plan.RegenerateMaskingKeys = plan.RegenerateMaskingKeys || param.maskingKeys
+		plan.RegenerateEnvelopes = plan.RegenerateEnvelopes || param.envelopes
+		plan.RegenerateServerKeys = plan.RegenerateServerKeys || param.serverKeys
+	}
+
+	return plan, nil
+}
+
+// effectiveKSFParameters returns the KSF parameters set in the configuration, or the KSF's defaults if none are set.
+func (c *Configuration) effectiveKSFParameters() []int {
+	if len(c.ksfParameters) != 0 || c.KSF == 0 {
+		return c.ksfParameters
+	}
+
+	return c.KSF.Get().Params()
+}
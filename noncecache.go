@@ -0,0 +1,75 @@
+// SPDX-License-Identifier: MIT
+//
+// Copyright (C) 2020-2025 Daniel Bourdrez. All Rights Reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree or at
+// https://spdx.org/licenses/MIT.html
+
+package opaque
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// NonceCache is a NonceStore remembering a bounded number of recently seen nonces, for a bounded time. When full, the
+// least recently recorded nonce is evicted. It is safe for concurrent use.
+type NonceCache struct {
+	entries map[string]*list.Element
+	order   *list.List
+	ttl     time.Duration
+	size    int
+	mu      sync.Mutex
+}
+
+type nonceCacheEntry struct {
+	seen  time.Time
+	nonce string
+}
+
+// NewNonceCache returns a NonceCache holding at most size nonces, each for the ttl duration. A zero ttl keeps nonces
+// until they are evicted, and a size lower than 1 is set to 1.
+func NewNonceCache(size int, ttl time.Duration) *NonceCache {
+	size = max(size, 1)
+
+	return &NonceCache{
+		entries: make(map[string]*list.Element, size),
+		order:   list.New(),
+		ttl:     ttl,
+		size:    size,
+		mu:      sync.Mutex{},
+	}
+}
+
+// Seen records the nonce, and returns whether it has already been recorded within the cache's window.
+func (n *NonceCache) Seen(nonce []byte) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	now := time.Now()
+
+	if e, ok := n.entries[string(nonce)]; ok {
+		entry := e.Value.(*nonceCacheEntry) //nolint:forcetypeassert // the list only holds *nonceCacheEntry.
+		if n.ttl == 0 || now.Sub(entry.seen) < n.ttl {
+			return true
+		}
+
+		// The nonce expired: record it again as a fresh one.
+		entry.seen = now
+		n.order.MoveToFront(e)
+
+		return false
+	}
+
+	n.entries[string(nonce)] = n.order.PushFront(&nonceCacheEntry{seen: now, nonce: string(nonce)})
+
+	if n.order.Len() > n.size {
+		oldest := n.order.Back()
+		n.order.Remove(oldest)
+		delete(n.entries, oldest.Value.(*nonceCacheEntry).nonce) //nolint:forcetypeassert // see above.
+	}
+
+	return false
+}
@@ -10,12 +10,25 @@
 // pre-computation attacks. It enables a client to authenticate to a server without ever revealing its password to the
 // server. Protocol details can be found on the IETF RFC page (https://datatracker.ietf.org/doc/draft-irtf-cfrg-opaque)
 // and on the GitHub specification repository (https://github.com/cfrg/draft-irtf-cfrg-opaque).
+//
+// Clients and servers run synchronously on the calling goroutine, and randomness is read from crypto/rand unless
+// Configuration.Rand is set, so that they can be used under single-threaded runtimes like js/wasm. The only exception
+// are the Context variants of the client methods, e.g. GenerateKE3Context, which run the KSF in a goroutine if the
+// context can be canceled.
 package opaque
 
 import (
+	"bytes"
 	"crypto"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"math"
+	"slices"
 
 	"github.com/bytemare/ecc"
 	"github.com/bytemare/hash"
@@ -25,6 +38,7 @@ import (
 	"github.com/bytemare/opaque/internal/ake"
 	"github.com/bytemare/opaque/internal/encoding"
 	"github.com/bytemare/opaque/internal/oprf"
+	"github.com/bytemare/opaque/internal/tag"
 	"github.com/bytemare/opaque/message"
 )
 
@@ -35,17 +49,27 @@ const (
 	// RistrettoSha512 identifies the Ristretto255 group and SHA-512.
 	RistrettoSha512 = Group(ecc.Ristretto255Sha512)
 
-	// decaf448Shake256 identifies the Decaf448 group and Shake-256.
+	// decaf448Shake256 identifies the Decaf448 group and Shake-256. It is not available, since the underlying
+	// github.com/bytemare/ecc library does not implement Decaf448, and its identifier is therefore rejected.
 	// decaf448Shake256 = 2.
 
 	// P256Sha256 identifies the NIST P-256 group and SHA-256.
 	P256Sha256 = Group(ecc.P256Sha256)
 
-	// P384Sha512 identifies the NIST P-384 group and SHA-384.
-	P384Sha512 = Group(ecc.P384Sha384)
+	// P384Sha384 identifies the NIST P-384 group and SHA-384, as in the P384-SHA384 OPRF ciphersuite of RFC 9497.
+	P384Sha384 = Group(ecc.P384Sha384)
+
+	// P384Sha512 is the former, misleading, name of P384Sha384: the group has always used SHA-384, not SHA-512.
+	//
+	// Deprecated: use P384Sha384, which is the same value.
+	P384Sha512 = P384Sha384
 
-	// P521Sha512 identifies the NIST P-512 group and SHA-512.
+	// P521Sha512 identifies the NIST P-521 group and SHA-512.
 	P521Sha512 = Group(ecc.P521Sha512)
+
+	// P-224 with SHA-256 is not available: neither github.com/bytemare/ecc nor RFC 9497 define a P-224 OPRF
+	// ciphersuite, so there is no hash-to-curve suite, identifier, or test vector to interoperate with. It can be added
+	// once the ecc library supports it.
 )
 
 // Available returns whether the Group byte is recognized in this implementation. This allows to fail early when
@@ -53,10 +77,26 @@ const (
 func (g Group) Available() bool {
 	return g == RistrettoSha512 ||
 		g == P256Sha256 ||
-		g == P384Sha512 ||
+		g == P384Sha384 ||
 		g == P521Sha512
 }
 
+// String returns the name of the Group, e.g. "RistrettoSha512".
+func (g Group) String() string {
+	switch g {
+	case RistrettoSha512:
+		return "RistrettoSha512"
+	case P256Sha256:
+		return "P256Sha256"
+	case P384Sha384:
+		return "P384Sha384"
+	case P521Sha512:
+		return "P521Sha512"
+	default:
+		return fmt.Sprintf("Group(%d)", byte(g))
+	}
+}
+
 // OPRF returns the OPRF Identifier used in the Ciphersuite.
 func (g Group) OPRF() oprf.Identifier {
 	return oprf.IDFromGroup(g.Group())
@@ -67,7 +107,82 @@ func (g Group) Group() ecc.Group {
 	return ecc.Group(g)
 }
 
-const confIDsLength = 6
+// ElementLength returns the byte length of an encoded element (e.g. a public key) in the group, or 0 if the group is
+// not available. NIST curve elements are compressed, e.g. 67 bytes for P-521.
+func (g Group) ElementLength() int {
+	if !g.Available() {
+		return 0
+	}
+
+	return g.Group().ElementLength()
+}
+
+// ScalarLength returns the byte length of an encoded scalar (e.g. a secret key) in the group, or 0 if the group is not
+// available, e.g. 66 bytes for P-521.
+func (g Group) ScalarLength() int {
+	if !g.Available() {
+		return 0
+	}
+
+	return g.Group().ScalarLength()
+}
+
+// KEM identifies the optional key encapsulation mechanism whose shared secret is mixed into the AKE alongside 3DH.
+type KEM byte
+
+const (
+	// MLKEM768 identifies ML-KEM-768, as specified in FIPS 203.
+	MLKEM768 = KEM(internal.MLKEM768)
+)
+
+// Available returns whether the KEM byte is recognized in this implementation.
+func (k KEM) Available() bool {
+	return internal.KEM(k).Available()
+}
+
+// String returns the name of the KEM, or "none" if it is not set.
+func (k KEM) String() string {
+	switch k {
+	case 0:
+		return "none"
+	case MLKEM768:
+		return "MLKEM768"
+	default:
+		return fmt.Sprintf("KEM(%d)", byte(k))
+	}
+}
+
+// KDFProvider is a key derivation function with HKDF's Extract and Expand interface, for Configuration.CustomKDF.
+type KDFProvider = internal.KDFProvider
+
+// MACProvider is a message authentication code with HMAC's interface, for Configuration.CustomMAC.
+type MACProvider = internal.MACProvider
+
+// ksfName returns the name of the key stretching function, or "Identity" if it is not set.
+func ksfName(id ksf.Identifier) string {
+	switch id {
+	case 0:
+		return "Identity"
+	case ksf.Argon2id:
+		return "Argon2id"
+	case ksf.Scrypt:
+		return "Scrypt"
+	case ksf.PBKDF2Sha512:
+		return "PBKDF2Sha512"
+	default:
+		return fmt.Sprintf("KSF(%d)", byte(id))
+	}
+}
+
+const (
+	confIDsLength = 6
+
+	// maxNonceLength is the maximum nonce length that can be encoded in a serialized configuration.
+	maxNonceLength = 1<<16 - 1
+
+	// maxIdentityLength is the maximum length of an identity, as encoded with a 2-byte length prefix.
+	maxIdentityLength = 1<<16 - 1
+)
 
 var (
 	errInvalidOPRFid = errors.New("invalid OPRF group id")
@@ -76,30 +191,114 @@ var (
 	errInvalidHASHid = errors.New("invalid Hash id")
 	errInvalidKSFid  = errors.New("invalid KSF id")
 	errInvalidAKEid  = errors.New("invalid AKE group id")
+	errInvalidKEMid  = errors.New("invalid KEM id")
+
+	errShortKeyPairSeed = errors.New("key pair seed is too short")
+
+	errShortCredentialIdentifierSalt = errors.New("credential identifier salt is too short")
+
+	errInvalidNonceLength = errors.New("invalid nonce length: must be at least 32 and at most 65535 bytes")
+
+	errInvalidKSFParameters = errors.New("invalid number or values of KSF parameters")
+
+	errContextTooLong = errors.New("context is too long: must be at most 65535 bytes, or 2^31-1 with LongIdentities")
+
+	// ErrIncompatibleConfiguration indicates that a peer's configuration uses different parameters.
+	ErrIncompatibleConfiguration = errors.New("incompatible configuration")
+
+	// ErrKSFRequired indicates that a client or server was created from a configuration without a key stretching
+	// function, without AllowNoKSF being set.
+	ErrKSFRequired = errors.New("no key stretching function set: set AllowNoKSF to explicitly use none")
+
+	// ErrReservedContext indicates that the configuration's context starts with a prefix reserved for internal tags.
+	ErrReservedContext = errors.New("context starts with a reserved prefix")
 )
 
+// reservedContextPrefixes are the prefixes of internal tags a context can't start with: the version tag preceding the
+// context in the AKE transcript, and the OPRF version prefix. They are harmless, since the context is length-prefixed,
+// but make transcripts confusing to debug.
+var reservedContextPrefixes = []string{tag.VersionTag, tag.OPRFVersion}
+
 // Configuration represents an OPAQUE configuration. Note that OprfGroup and AKEGroup are recommended to be the same,
 // as well as KDF, MAC, Hash should be the same.
 type Configuration struct {
+	// Rand optionally sets the source of randomness of the clients and servers created from the configuration, e.g.
+	// for deterministic tests or hardware-backed entropy, and defaults to crypto/rand when nil. It is used for nonces,
+	// blinds, key shares, keys, and fake records, but not for the nonces of sealed states, which always come from
+	// crypto/rand. It is not part of the serialized configuration, and a deterministic source must never be used in
+	// production.
+	Rand io.Reader `json:"-"`
+	// TranscriptSink optionally receives a copy of all the bytes written to the AKE transcript hash, e.g. to debug
+	// interoperability failures in test harnesses. It doesn't change the hash output, is nil by default, and is not
+	// part of the serialized configuration. It exposes the protocol messages and must not be set in production.
+	TranscriptSink io.Writer `json:"-"`
+	// CustomKDF optionally replaces the HKDF instantiated from KDF, e.g. with a hardware-backed implementation. KDF
+	// must still be valid, and is serialized as is, but the provider is not: a custom provider breaks wire
+	// compatibility with any peer not using a matching one, which the serialized configuration, its fingerprint, and
+	// Compatible can't detect.
+	CustomKDF KDFProvider `json:"-"`
+	// CustomMAC optionally replaces the HMAC instantiated from MAC, with the same caveats as CustomKDF.
+	CustomMAC MACProvider `json:"-"`
+	// Context is the application context bound into the AKE transcript. It must be at most 65535 bytes long, or 2^31-1
+	// with LongIdentities, and must not start with the reserved "OPAQUEv1-" and "OPRFV1-" prefixes of internal tags, or
+	// clients and servers can't be created from the configuration, which returns ErrReservedContext.
 	Context []byte
-	KDF     crypto.Hash    `json:"kdf"`
-	MAC     crypto.Hash    `json:"mac"`
-	Hash    crypto.Hash    `json:"hash"`
-	KSF     ksf.Identifier `json:"ksf"`
-	OPRF    Group          `json:"oprf"`
-	AKE     Group          `json:"group"`
+	// ksfParameters are the KSF parameters set with SetKSFParameters, or nil for the KSF's defaults.
+	ksfParameters []int
+	// Policy optionally enforces recommendations on the configuration, and is not part of the serialized
+	// configuration. StrictVerify, NewClientStrict, and NewServerStrict enforce the recommended same groups and hash
+	// functions.
+	Policy *SecurityPolicy `json:"policy,omitempty"`
+	KDF    crypto.Hash     `json:"kdf"`
+	MAC    crypto.Hash     `json:"mac"`
+	Hash   crypto.Hash     `json:"hash"`
+	// NonceLength optionally sets the length of the nonces used in the protocol, and defaults to 32 bytes when zero.
+	NonceLength uint32 `json:"nonceLength,omitempty"`
+	// KSF is the key stretching function. A zero KSF disables password stretching: the OPRF output is used as is, and
+	// a server compromise then allows cheap offline dictionary attacks on the stolen records. To avoid deploying this
+	// by accident, e.g. with a zero-valued or deserialized configuration, NewClient and NewServer return ErrKSFRequired
+	// for a zero KSF unless AllowNoKSF is set.
+	KSF  ksf.Identifier `json:"ksf"`
+	OPRF Group          `json:"oprf"`
+	AKE  Group          `json:"group"`
+	// KEM optionally enables a hybrid post-quantum key share: the client sends an encapsulation key in KE1, the server
+	// responds with a ciphertext in KE2, and the encapsulated secret is mixed into the 3DH key derivation, so that the
+	// session key stays secret if either exchange holds. It is disabled when zero. The server's encapsulation is only
+	// reproducible, from the Rand source or the KeyShareSeed of GenerateKE2Options, with Go 1.26 or later outside of
+	// FIPS 140-only mode, and GenerateKE2 returns ErrDerandomizedKEM otherwise.
+	KEM KEM `json:"kem,omitempty"`
+	// AllowNoKSF allows a zero KSF, which should only be done for test vectors or when passwords are already stretched
+	// by the application. It is not part of the serialized configuration.
+	AllowNoKSF bool `json:"allowNoKSF,omitempty"`
+	// LongIdentities optionally switches the length prefixes of the identities, context, and associated data in the
+	// envelope and the AKE transcript from 2 to 4 bytes, e.g. for applications using large certificates as identities,
+	// which can then be up to 2^31-1 bytes long. It is not wire-compatible with the default: clients and servers must
+	// both set it, and records registered without it can't be used with it, nor the other way around. It is part of
+	// the serialized configuration, whose context then also has a 4-byte length prefix, so that its fingerprint and
+	// Compatible detect a mismatch.
+	LongIdentities bool `json:"longIdentities,omitempty"`
 }
 
 // DefaultConfiguration returns a default configuration with strong parameters.
 func DefaultConfiguration() *Configuration {
 	return &Configuration{
-		OPRF:    RistrettoSha512,
-		AKE:     RistrettoSha512,
-		KSF:     ksf.Argon2id,
-		KDF:     crypto.SHA512,
-		MAC:     crypto.SHA512,
-		Hash:    crypto.SHA512,
-		Context: nil,
+		OPRF:           RistrettoSha512,
+		AKE:            RistrettoSha512,
+		KSF:            ksf.Argon2id,
+		KDF:            crypto.SHA512,
+		MAC:            crypto.SHA512,
+		Hash:           crypto.SHA512,
+		NonceLength:    0,
+		KEM:            0,
+		AllowNoKSF:     false,
+		LongIdentities: false,
+		Rand:           nil,
+		TranscriptSink: nil,
+		CustomKDF:      nil,
+		CustomMAC:      nil,
+		Context:        nil,
+		Policy:         nil,
+		ksfParameters:  nil,
 	}
 }
 
@@ -115,12 +314,61 @@ func (c *Configuration) Server() (*Server, error) {
 
 // GenerateOPRFSeed returns a OPRF seed valid in the given configuration.
 func (c *Configuration) GenerateOPRFSeed() []byte {
-	return RandomBytes(c.Hash.Size())
+	return internal.RandomBytesFrom(c.Rand, c.Hash.Size())
 }
 
 // KeyGen returns a key pair in the AKE ecc.
 func (c *Configuration) KeyGen() (secretKey, publicKey []byte) {
-	return ake.KeyGen(ecc.Group(c.AKE))
+	if c.Rand == nil {
+		return ake.KeyGen(ecc.Group(c.AKE))
+	}
+
+	sk := internal.RandomScalarFrom(c.Rand, c.AKE.Group())
+
+	return sk.Encode(), c.AKE.Group().Base().Multiply(sk).Encode()
+}
+
+// DeriveKeyPair deterministically derives an AKE key pair from the seed and info, allowing to regenerate the same key
+// pair, e.g. after disaster recovery, without storing the secret key. The seed must be secret, uniformly random, and
+// at least 32 bytes long.
+func (c *Configuration) DeriveKeyPair(seed, info []byte) (secretKey, publicKey []byte, err error) {
+	conf, err := c.toInternal()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(seed) < internal.SeedLength {
+		return nil, nil, errShortKeyPairSeed
+	}
+
+	expanded := conf.KDF.Expand(seed, encoding.SuffixString(info, tag.ExpandServerKeyPair), internal.SeedLength)
+	sk, pk := oprf.IDFromGroup(conf.Group).DeriveKeyPair(expanded, []byte(tag.DeriveDiffieHellmanKeyPair))
+
+	if sk.IsZero() {
+		return nil, nil, ErrZeroSKS
+	}
+
+	return sk.Encode(), pk.Encode(), nil
+}
+
+// CredentialIdentifier deterministically derives a fixed-length credential identifier from the username, with the
+// KDF keyed by the salt. This avoids using raw usernames as credential identifiers, which have variable lengths and
+// leak information. The salt must be secret, held by the server, at least 32 bytes long, and never change: the same
+// username must always map to the same identifier, or the client's records can't be found nor their OPRF keys
+// re-derived. The identifier has the KDF's output length.
+func (c *Configuration) CredentialIdentifier(salt, username []byte) ([]byte, error) {
+	conf, err := c.toInternal()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(salt) < internal.SeedLength {
+		return nil, errShortCredentialIdentifierSalt
+	}
+
+	prk := conf.KDF.Extract(salt, username)
+
+	return conf.KDF.Expand(prk, []byte(tag.CredentialIdentifier), conf.KDF.Size()), nil
 }
 
 // verify returns an error on the first non-compliant parameter, nil otherwise.
@@ -149,9 +397,124 @@ func (c *Configuration) verify() error {
 		return errInvalidKSFid
 	}
 
+	if c.NonceLength != 0 && (c.NonceLength < internal.NonceLength || c.NonceLength > maxNonceLength) {
+		return errInvalidNonceLength
+	}
+
+	if c.KEM != 0 && !c.KEM.Available() {
+		return errInvalidKEMid
+	}
+
+	if len(c.ksfParameters) != 0 && !validKSFParameters(c.KSF, c.ksfParameters) {
+		return errInvalidKSFParameters
+	}
+
+	if len(c.Context) > c.maxContextLength() {
+		return errContextTooLong
+	}
+
+	for _, prefix := range reservedContextPrefixes {
+		if bytes.HasPrefix(c.Context, []byte(prefix)) {
+			return ErrReservedContext
+		}
+	}
+
+	return c.Policy.verify(c)
+}
+
+// Clone returns a deep copy of the configuration, so that mutating one doesn't affect the other.
+func (c *Configuration) Clone() *Configuration {
+	clone := *c
+	clone.Context = bytes.Clone(c.Context)
+	clone.ksfParameters = slices.Clone(c.ksfParameters)
+
+	if c.Policy != nil {
+		policy := *c.Policy
+		clone.Policy = &policy
+	}
+
+	return &clone
+}
+
+// WithContextSuffix returns a clone of the configuration whose context is its context followed by the length-prefixed
+// suffix, e.g. to derive a tenant-specific context from a shared base context, so that the transcripts and session
+// keys of different tenants are isolated. The suffix has a 2-byte length prefix, or a 4-byte one with LongIdentities.
+// Clients and servers must use the same composed context, or their logins fail. It returns an error if the composed
+// context is longer than 65535 bytes, or 2^31-1 with LongIdentities.
+func (c *Configuration) WithContextSuffix(suffix []byte) (*Configuration, error) {
+	prefix := c.contextLengthPrefix()
+	if len(c.Context)+int(prefix)+len(suffix) > c.maxContextLength() {
+		return nil, errContextTooLong
+	}
+
+	clone := c.Clone()
+	clone.Context = slices.Concat(c.Context, encoding.EncodeVectorLen(suffix, prefix))
+
+	return clone, nil
+}
+
+// ksfParameterCount returns the number of parameters the key stretching function takes.
+func ksfParameterCount(id ksf.Identifier) int {
+	switch id {
+	case ksf.Argon2id, ksf.Scrypt:
+		return 3
+	case ksf.PBKDF2Sha512:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// validKSFParameters returns whether the parameters are of the right number and values for the key stretching
+// function, which would otherwise panic when stretching a password: all must be positive, Scrypt's N must be a power
+// of 2, r*p must be below 2^30, and Argon2id's time and memory must fit 32 bits and its threads 8 bits.
+func validKSFParameters(id ksf.Identifier, params []int) bool {
+	if len(params) != ksfParameterCount(id) || slices.ContainsFunc(params, func(p int) bool { return p < 1 }) {
+		return false
+	}
+
+	switch id {
+	case ksf.Scrypt:
+		n, r, p := params[0], params[1], params[2]
+		return n > 1 && n&(n-1) == 0 && r < 1<<30/p
+	case ksf.Argon2id:
+		return uint64(params[0]) <= math.MaxUint32 && uint64(params[1]) <= math.MaxUint32 && params[2] <= math.MaxUint8
+	default:
+		return true
+	}
+}
+
+// SetKSFParameters sets the parameters of the key stretching function used by clients built from this configuration,
+// e.g. time, memory, and threads for Argon2id, N, r, and p for Scrypt, or the number of iterations for PBKDF2. These
+// parameters are not part of the wire format nor of the serialized configuration: they only affect the derivation of
+// the randomized password. Registration and login must therefore use identical parameters, as mismatched parameters
+// yield a different randomized password and make the login silently fail with an envelope recovery error. Calling it
+// without parameters resets to the KSF's defaults. It returns an error if the parameters are not of the right number, or
+// if their values are invalid for the KSF, e.g. a Scrypt N that is not a power of 2, or zero Argon2id threads.
+func (c *Configuration) SetKSFParameters(params ...int) error {
+	if len(params) != 0 && !validKSFParameters(c.KSF, params) {
+		return errInvalidKSFParameters
+	}
+
+	if len(params) == 0 {
+		c.ksfParameters = nil
+		return nil
+	}
+
+	c.ksfParameters = slices.Clone(params)
+
 	return nil
 }
 
+// nonceLength returns the configured nonce length, or the default if none is set.
+func (c *Configuration) nonceLength() int {
+	if c.NonceLength == 0 {
+		return internal.NonceLength
+	}
+
+	return int(c.NonceLength)
+}
+
 // toInternal builds the internal representation of the configuration parameters.
 func (c *Configuration) toInternal() (*internal.Configuration, error) {
 	if err := c.verify(); err != nil {
@@ -160,22 +523,232 @@ func (c *Configuration) toInternal() (*internal.Configuration, error) {
 
 	g := c.AKE.Group()
 	o := c.OPRF.OPRF()
+	kdf := internal.NewKDF(c.KDF)
+	if c.CustomKDF != nil {
+		kdf = internal.NewCustomKDF(c.CustomKDF)
+	}
+
 	mac := internal.NewMac(c.MAC)
+	if c.CustomMAC != nil {
+		mac = internal.NewCustomMac(c.CustomMAC)
+	}
+
+	encoded, err := c.AppendBinary(make([]byte, 0, c.encodedLength()))
+	if err != nil {
+		return nil, err
+	}
+
+	nonceLength := c.nonceLength()
 	ip := &internal.Configuration{
-		OPRF:         o,
-		Group:        g,
-		KSF:          internal.NewKSF(c.KSF),
-		KDF:          internal.NewKDF(c.KDF),
-		MAC:          mac,
-		Hash:         internal.NewHash(c.Hash),
-		NonceLen:     internal.NonceLength,
-		EnvelopeSize: internal.NonceLength + mac.Size(),
-		Context:      c.Context,
+		OPRF:           o,
+		Group:          g,
+		KSF:            internal.NewKSF(c.KSF),
+		KDF:            kdf,
+		MAC:            mac,
+		Hash:           internal.NewHash(c.Hash),
+		NonceLen:       nonceLength,
+		EnvelopeSize:   nonceLength + mac.Size(),
+		Context:        bytes.Clone(c.Context),
+		Fingerprint:    configFingerprint(encoded),
+		Rand:           c.Rand,
+		KEM:            internal.KEM(c.KEM),
+		LongIdentities: c.LongIdentities,
+	}
+
+	ip.Hash.SetSink(c.TranscriptSink)
+
+	if len(c.ksfParameters) != 0 {
+		ip.KSF.Parameterize(c.ksfParameters...)
 	}
 
 	return ip, nil
 }
 
+// configFingerprint returns a short digest of the serialized configuration, used to detect mismatched configurations.
+func configFingerprint(encoded []byte) []byte {
+	digest := sha256.Sum256(encoding.Concat([]byte(tag.ConfigFingerprint), encoded))
+	return digest[:message.FingerprintLength]
+}
+
+// HasKSF returns whether the configuration sets a key stretching function. See Configuration.KSF for the implications
+// of not setting one.
+func (c *Configuration) HasKSF() bool {
+	return c.KSF != 0
+}
+
+// checkKSF returns ErrKSFRequired if the configuration has no KSF, unless this is explicitly allowed.
+func (c *Configuration) checkKSF() error {
+	if !c.HasKSF() && !c.AllowNoKSF {
+		return ErrKSFRequired
+	}
+
+	return nil
+}
+
+// SessionKeyLength returns the length of the session key established by a login, i.e. the KDF's output length, or 0
+// if the KDF is not available.
+func (c *Configuration) SessionKeyLength() int {
+	if !c.KDF.Available() {
+		return 0
+	}
+
+	if c.CustomKDF != nil {
+		return c.CustomKDF.Size()
+	}
+
+	return c.KDF.Size()
+}
+
+// MACLength returns the length of the MACs in KE2 and KE3 and in the envelope, or 0 if the MAC is not available.
+func (c *Configuration) MACLength() int {
+	if !c.MAC.Available() {
+		return 0
+	}
+
+	if c.CustomMAC != nil {
+		return c.CustomMAC.Size()
+	}
+
+	return c.MAC.Size()
+}
+
+// RegistrationRecordSize returns the length of a serialized RegistrationRecord, i.e. the client public key, the masking
+// key, and the envelope, or 0 if the configuration is invalid. A serialized ClientRecord additionally holds the
+// credential identifier and client identity.
+func (c *Configuration) RegistrationRecordSize() int {
+	d, err := c.Deserializer()
+	if err != nil {
+		return 0
+	}
+
+	return d.recordLength()
+}
+
+// KE1Size returns the length of a serialized KE1 message, or 0 if the configuration is invalid.
+func (c *Configuration) KE1Size() int {
+	d, err := c.Deserializer()
+	if err != nil {
+		return 0
+	}
+
+	return d.ke1Length()
+}
+
+// KE2Size returns the length of a serialized KE2 message, or 0 if the configuration is invalid.
+func (c *Configuration) KE2Size() int {
+	d, err := c.Deserializer()
+	if err != nil {
+		return 0
+	}
+
+	return d.credentialResponseLength() + d.ke2LengthWithoutCreds()
+}
+
+// KE3Size returns the length of a serialized KE3 message, i.e. the MAC's output length, or 0 if the configuration is
+// invalid.
+func (c *Configuration) KE3Size() int {
+	if c.verify() != nil {
+		return 0
+	}
+
+	return c.MACLength()
+}
+
+// Compatible deserializes the peer's serialized configuration, and returns an ErrIncompatibleConfiguration error naming
+// the first parameter that differs from c's, or nil if they match. The context is application-specific, and is not
+// compared. The nonce length, KEM, and long identities are compared too, since they change the message formats or the
+// transcript.
+func (c *Configuration) Compatible(peer []byte) error {
+	p, err := DeserializeConfiguration(peer)
+	if err != nil {
+		return fmt.Errorf("%w: decoding the peer configuration: %w", ErrIncompatibleConfiguration, err)
+	}
+
+	type parameter struct {
+		name        string
+		local, peer any
+	}
+
+	for _, param := range []parameter{
+		{"OPRF group", c.OPRF, p.OPRF},
+		{"AKE group", c.AKE, p.AKE},
+		{"KSF", ksfName(c.KSF), ksfName(p.KSF)},
+		{"KDF", c.KDF, p.KDF},
+		{"MAC", c.MAC, p.MAC},
+		{"Hash", c.Hash, p.Hash},
+		{"nonce length", c.nonceLength(), p.nonceLength()},
+		{"KEM", c.KEM, p.KEM},
+		{"long identities", c.LongIdentities, p.LongIdentities},
+	} {
+		if param.local != param.peer {
+			return fmt.Errorf("%w: %s %v differs from the peer's %v",
+				ErrIncompatibleConfiguration, param.name, param.local, param.peer)
+		}
+	}
+
+	return nil
+}
+
+// DebugString returns a human-readable rendering of the configuration with the names of its primitives instead of their
+// identifiers, for logging and debugging interoperability issues. Its format is not stable, and must not be parsed.
+func (c *Configuration) DebugString() string {
+	return fmt.Sprintf(
+		"OPRF: %s, AKE: %s, KSF: %s, KDF: %s, MAC: %s, Hash: %s, NonceLength: %d, KEM: %s, Context: %d bytes",
+		c.OPRF, c.AKE, ksfName(c.KSF), c.KDF, c.MAC, c.Hash, c.nonceLength(), c.KEM, len(c.Context),
+	)
+}
+
+// ParseClientPublicKey decodes a client AKE public key, e.g. as returned by ClientRecord.ClientPublicKeyBytes, and
+// returns an error wrapping message.ErrInvalidRecordPublicKey if it isn't a valid element of the AKE group.
+func (c *Configuration) ParseClientPublicKey(encoded []byte) (*ecc.Element, error) {
+	d, err := c.Deserializer()
+	if err != nil {
+		return nil, err
+	}
+
+	pk, err := d.DecodeAkePublicKey(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", message.ErrInvalidRecordPublicKey, err)
+	}
+
+	return pk, nil
+}
+
+// ValidateKE1 cheaply checks a KE1 message without a server, e.g. for a front-end proxy to reject malformed messages
+// before routing them to the authentication backend. It returns ErrMalformedKE1 if KE1 has no credential request,
+// ErrInvalidBlindedMessage or ErrInvalidClientKeyShare if the blinded message or the client key share is missing, of
+// another group, or the identity element, ErrInvalidClientNonce if the client nonce is not of the configured length,
+// and ErrInvalidKEMKeyShare if the KEM encapsulation key is not of the configured KEM's length. A valid KE1 can still
+// fail at the server, e.g. if it is a replay.
+func (c *Configuration) ValidateKE1(ke1 *message.KE1) error {
+	if err := c.verify(); err != nil {
+		return err
+	}
+
+	if ke1 == nil || ke1.CredentialRequest == nil {
+		return ErrMalformedKE1
+	}
+
+	if ke1.BlindedMessage == nil || ke1.BlindedMessage.Group() != c.OPRF.Group() || ke1.BlindedMessage.IsIdentity() {
+		return ErrInvalidBlindedMessage
+	}
+
+	if ke1.ClientPublicKeyshare == nil || ke1.ClientPublicKeyshare.Group() != c.AKE.Group() ||
+		ke1.ClientPublicKeyshare.IsIdentity() {
+		return ErrInvalidClientKeyShare
+	}
+
+	if len(ke1.ClientNonce) != c.nonceLength() {
+		return ErrInvalidClientNonce
+	}
+
+	if c.KEM != 0 && len(ke1.KEMEncapsulationKey) != internal.KEM(c.KEM).EncapsulationKeyLength() {
+		return ErrInvalidKEMKeyShare
+	}
+
+	return nil
+}
+
 // Deserializer returns a pointer to a Deserializer structure allowing deserialization of messages in the given
 // configuration.
 func (c *Configuration) Deserializer() (*Deserializer, error) {
@@ -184,21 +757,112 @@ func (c *Configuration) Deserializer() (*Deserializer, error) {
 		return nil, err
 	}
 
-	return &Deserializer{conf: conf}, nil
+	return newDeserializer(c, conf), nil
 }
 
-// Serialize returns the byte encoding of the Configuration structure.
+// Serialize returns the byte encoding of the Configuration structure. A non-default NonceLength is appended as a
+// 2-byte integer, so that configurations using the default nonce length keep the same encoding. If a KEM is set, the
+// nonce length is always appended, followed by the KEM identifier byte. With LongIdentities, the context has a 4-byte
+// length prefix, and the nonce length, KEM identifier byte, and a final 1 byte are always appended. It panics if the
+// context is too long, see AppendBinary.
 func (c *Configuration) Serialize() []byte {
-	ids := []byte{
+	encoded, err := c.AppendBinary(make([]byte, 0, c.encodedLength()))
+	if err != nil {
+		panic(err)
+	}
+
+	return encoded
+}
+
+// longIdentitiesFlag is the last byte of the encoding of configurations with LongIdentities.
+const longIdentitiesFlag = 1
+
+// contextLengthPrefix returns the byte length of the context's length prefix in the configuration's encoding and in the
+// transcript.
+func (c *Configuration) contextLengthPrefix() uint16 {
+	return internal.IdentityLengthPrefix(c.LongIdentities)
+}
+
+// maxContextLength returns the maximum length of the context, i.e. 65535 bytes, or 2^31-1 with LongIdentities.
+func (c *Configuration) maxContextLength() int {
+	return internal.MaxIdentityLength(c.LongIdentities)
+}
+
+// encodedLength returns the length of the Configuration's byte encoding.
+func (c *Configuration) encodedLength() int {
+	length := confIDsLength + int(c.contextLengthPrefix()) + len(c.Context)
+
+	if c.LongIdentities {
+		return length + 4
+	}
+
+	if c.KEM != 0 || (c.NonceLength != 0 && c.NonceLength != internal.NonceLength) {
+		length += 2
+	}
+
+	if c.KEM != 0 {
+		length++
+	}
+
+	return length
+}
+
+// AppendBinary appends the byte encoding of the Configuration, as returned by Serialize, to b and returns the extended
+// buffer. It implements encoding.BinaryAppender, and doesn't allocate if b has enough capacity. It returns an error if
+// the context is longer than 65535 bytes, or 2^31-1 with LongIdentities.
+func (c *Configuration) AppendBinary(b []byte) ([]byte, error) {
+	if len(c.Context) > c.maxContextLength() {
+		return nil, errContextTooLong
+	}
+
+	b = append(b,
 		byte(c.OPRF),
 		byte(c.AKE),
 		byte(c.KSF),
 		byte(c.KDF),
 		byte(c.MAC),
 		byte(c.Hash),
+	)
+
+	if c.LongIdentities {
+		b = binary.BigEndian.AppendUint32(b, uint32(len(c.Context))) //nolint:gosec // overflow is checked beforehand.
+		b = append(b, c.Context...)
+		b = binary.BigEndian.AppendUint16(b, uint16(c.nonceLength())) //nolint:gosec // a valid length fits.
+
+		return append(b, byte(c.KEM), longIdentitiesFlag), nil
+	}
+
+	b = binary.BigEndian.AppendUint16(b, uint16(len(c.Context))) //nolint:gosec // overflow is checked beforehand.
+	b = append(b, c.Context...)
+
+	if c.KEM != 0 || (c.NonceLength != 0 && c.NonceLength != internal.NonceLength) {
+		b = binary.BigEndian.AppendUint16(b, uint16(c.nonceLength())) //nolint:gosec // a valid length fits.
+	}
+
+	if c.KEM != 0 {
+		b = append(b, byte(c.KEM))
+	}
+
+	return b, nil
+}
+
+// MarshalBinary returns the byte encoding of the Configuration, as returned by Serialize. It implements
+// encoding.BinaryMarshaler.
+func (c *Configuration) MarshalBinary() ([]byte, error) {
+	return c.AppendBinary(make([]byte, 0, c.encodedLength()))
+}
+
+// UnmarshalBinary decodes the byte encoding of a Configuration into c, as DeserializeConfiguration does. It implements
+// encoding.BinaryUnmarshaler.
+func (c *Configuration) UnmarshalBinary(data []byte) error {
+	conf, err := DeserializeConfiguration(data)
+	if err != nil {
+		return err
 	}
 
-	return encoding.Concatenate(ids, encoding.EncodeVector(c.Context))
+	*c = *conf
+
+	return nil
 }
 
 // DeserializeConfiguration decodes the input and returns a Parameter structure.
@@ -208,19 +872,71 @@ func DeserializeConfiguration(encoded []byte) (*Configuration, error) {
 		return nil, internal.ErrConfigurationInvalidLength
 	}
 
-	ctx, _, err := encoding.DecodeVector(encoded[confIDsLength:])
+	ctx, offset, err := encoding.DecodeVector(encoded[confIDsLength:])
 	if err != nil {
 		return nil, fmt.Errorf("decoding the configuration context: %w", err)
 	}
 
+	remaining := encoded[confIDsLength+offset:]
+
+	// Only configurations with LongIdentities, whose context has a 4-byte length prefix, have more than 3 bytes after
+	// the context when decoded with a 2-byte prefix, since the first 2 bytes of a 4-byte length are far below it.
+	longIdentities := len(remaining) > 3
+	if longIdentities {
+		ctx, offset, err = encoding.DecodeVectorLen(encoded[confIDsLength:], 4)
+		if err != nil {
+			return nil, fmt.Errorf("decoding the configuration context: %w", err)
+		}
+
+		remaining = encoded[confIDsLength+offset:]
+		if len(remaining) != 4 || remaining[3] != longIdentitiesFlag {
+			return nil, internal.ErrConfigurationInvalidLength
+		}
+
+		remaining = remaining[:3]
+	}
+
+	var (
+		nonceLength uint32
+		kem         KEM
+	)
+
+	switch len(remaining) {
+	case 0:
+	case 2:
+		nonceLength = uint32(encoding.OS2IP(remaining)) //nolint:gosec // a 2-byte integer can't overflow.
+	case 3:
+		nonceLength = uint32(encoding.OS2IP(remaining[:2])) //nolint:gosec // a 2-byte integer can't overflow.
+		if nonceLength == internal.NonceLength {
+			nonceLength = 0
+		}
+
+		kem = KEM(remaining[2])
+		if kem == 0 && !longIdentities {
+			return nil, errInvalidKEMid
+		}
+	default:
+		return nil, internal.ErrConfigurationInvalidLength
+	}
+
 	c := &Configuration{
-		OPRF:    Group(encoded[0]),
-		AKE:     Group(encoded[1]),
-		KSF:     ksf.Identifier(encoded[2]),
-		KDF:     crypto.Hash(encoded[3]),
-		MAC:     crypto.Hash(encoded[4]),
-		Hash:    crypto.Hash(encoded[5]),
-		Context: ctx,
+		OPRF:           Group(encoded[0]),
+		AKE:            Group(encoded[1]),
+		KSF:            ksf.Identifier(encoded[2]),
+		KDF:            crypto.Hash(encoded[3]),
+		MAC:            crypto.Hash(encoded[4]),
+		Hash:           crypto.Hash(encoded[5]),
+		NonceLength:    nonceLength,
+		KEM:            kem,
+		AllowNoKSF:     false,
+		LongIdentities: longIdentities,
+		Rand:           nil,
+		TranscriptSink: nil,
+		CustomKDF:      nil,
+		CustomMAC:      nil,
+		Context:        ctx,
+		Policy:         nil,
+		ksfParameters:  nil,
 	}
 
 	if err2 := c.verify(); err2 != nil {
@@ -230,6 +946,89 @@ func DeserializeConfiguration(encoded []byte) (*Configuration, error) {
 	return c, nil
 }
 
+// configurationJSON is the JSON representation of a Configuration, with a hex encoded context.
+type configurationJSON struct {
+	Policy         *SecurityPolicy `json:"policy,omitempty"`
+	Context        string          `json:"context"`
+	KDF            crypto.Hash     `json:"kdf"`
+	MAC            crypto.Hash     `json:"mac"`
+	Hash           crypto.Hash     `json:"hash"`
+	NonceLength    uint32          `json:"nonceLength,omitempty"`
+	KSF            ksf.Identifier  `json:"ksf"`
+	OPRF           Group           `json:"oprf"`
+	AKE            Group           `json:"group"`
+	KEM            KEM             `json:"kem,omitempty"`
+	AllowNoKSF     bool            `json:"allowNoKSF,omitempty"`
+	LongIdentities bool            `json:"longIdentities,omitempty"`
+}
+
+// MarshalJSON returns the JSON encoding of the Configuration, with the context encoded in hex.
+func (c *Configuration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&configurationJSON{
+		Policy:         c.Policy,
+		Context:        hex.EncodeToString(c.Context),
+		KDF:            c.KDF,
+		MAC:            c.MAC,
+		Hash:           c.Hash,
+		NonceLength:    c.NonceLength,
+		KSF:            c.KSF,
+		OPRF:           c.OPRF,
+		AKE:            c.AKE,
+		KEM:            c.KEM,
+		AllowNoKSF:     c.AllowNoKSF,
+		LongIdentities: c.LongIdentities,
+	})
+}
+
+// UnmarshalJSON decodes the JSON encoded Configuration into c. It rejects unknown fields and invalid parameters.
+func (c *Configuration) UnmarshalJSON(data []byte) error {
+	var j configurationJSON
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(&j); err != nil {
+		return fmt.Errorf("decoding the JSON configuration: %w", err)
+	}
+
+	ctx, err := hex.DecodeString(j.Context)
+	if err != nil {
+		return fmt.Errorf("decoding the configuration context: %w", err)
+	}
+
+	if len(ctx) == 0 {
+		ctx = nil
+	}
+
+	conf := Configuration{
+		Rand:           nil,
+		TranscriptSink: nil,
+		CustomKDF:      nil,
+		CustomMAC:      nil,
+		Context:        ctx,
+		Policy:         j.Policy,
+		ksfParameters:  nil,
+		KDF:            j.KDF,
+		MAC:            j.MAC,
+		Hash:           j.Hash,
+		NonceLength:    j.NonceLength,
+		KSF:            j.KSF,
+		OPRF:           j.OPRF,
+		AKE:            j.AKE,
+		KEM:            j.KEM,
+		AllowNoKSF:     j.AllowNoKSF,
+		LongIdentities: j.LongIdentities,
+	}
+
+	if err = conf.verify(); err != nil {
+		return err
+	}
+
+	*c = conf
+
+	return nil
+}
+
 // GetFakeRecord creates a fake Client record to be used when no existing client record exists,
 // to defend against client enumeration techniques.
 func (c *Configuration) GetFakeRecord(credentialIdentifier []byte) (*ClientRecord, error) {
@@ -238,27 +1037,87 @@ func (c *Configuration) GetFakeRecord(credentialIdentifier []byte) (*ClientRecor
 		return nil, err
 	}
 
-	scalar := i.Group.NewScalar().Random()
-	publicKey := i.Group.Base().Multiply(scalar)
+	return newFakeRecord(i, credentialIdentifier), nil
+}
+
+// FakeRecordGenerator returns a function creating fake Client records as GetFakeRecord does, but verifying and
+// converting the configuration only once, for use in loops pre-generating many fake records.
+func (c *Configuration) FakeRecordGenerator() (func(credentialIdentifier []byte) *ClientRecord, error) {
+	i, err := c.toInternal()
+	if err != nil {
+		return nil, err
+	}
+
+	return func(credentialIdentifier []byte) *ClientRecord {
+		return newFakeRecord(i, credentialIdentifier)
+	}, nil
+}
+
+func newFakeRecord(conf *internal.Configuration, credentialIdentifier []byte) *ClientRecord {
+	scalar := conf.RandomScalar(conf.Group)
+	publicKey := conf.Group.Base().Multiply(scalar)
 
 	regRecord := &message.RegistrationRecord{
 		PublicKey:  publicKey,
-		MaskingKey: RandomBytes(i.KDF.Size()),
-		Envelope:   make([]byte, internal.NonceLength+i.MAC.Size()),
+		MaskingKey: conf.RandomBytes(conf.KDF.Size()),
+		Envelope:   make([]byte, conf.EnvelopeSize),
 	}
 
 	return &ClientRecord{
 		CredentialIdentifier: credentialIdentifier,
 		ClientIdentity:       nil,
+		PreviousOPRFSeed:     false,
 		RegistrationRecord:   regRecord,
-	}, nil
+	}
 }
 
-// ClientRecord is a server-side structure enabling the storage of user relevant information.
+// ClientRecord is a server-side structure enabling the storage of user relevant information. PreviousOPRFSeed marks a
+// record registered under the previous OPRF seed during a seed rotation (see Server.SetKeyMaterialWithPreviousSeed),
+// and is not part of the serialized record: applications must store it alongside. ClientIdentity must be at most 65535
+// bytes long, or 2^31-1 with Configuration.LongIdentities, or GenerateKE2 returns ErrIdentityTooLong.
 type ClientRecord struct {
 	*message.RegistrationRecord
 	CredentialIdentifier []byte
 	ClientIdentity       []byte
+	PreviousOPRFSeed     bool
+}
+
+// RecordVersion is the version of the envelope and key derivations used to create records, prepended to serialized
+// ClientRecords. It changes when a new version of this library can't log in with records created by a previous one, so
+// that Deserializer.ClientRecord returns ErrRecordVersionUnsupported instead of failing at login with a MAC error.
+const RecordVersion byte = 1
+
+// longRecordFlag is set in the version byte of serialized ClientRecords whose credential identifier and client identity
+// have 4-byte length prefixes.
+const longRecordFlag byte = 0x80
+
+// Serialize returns the byte encoding of the ClientRecord for storage, i.e. the RecordVersion byte, the serialized
+// RegistrationRecord, and the 2-byte length-prefixed credential identifier and client identity. If either is longer
+// than 65535 bytes, e.g. with Configuration.LongIdentities, both have 4-byte length prefixes instead, and the high bit
+// of the version byte is set.
+func (c *ClientRecord) Serialize() []byte {
+	version, prefix := RecordVersion, internal.IdentityLengthPrefix(false)
+	if len(c.CredentialIdentifier) > internal.MaxIdentityLength(false) ||
+		len(c.ClientIdentity) > internal.MaxIdentityLength(false) {
+		version, prefix = RecordVersion|longRecordFlag, internal.IdentityLengthPrefix(true)
+	}
+
+	return slices.Concat(
+		[]byte{version},
+		c.RegistrationRecord.Serialize(),
+		encoding.EncodeVectorLen(c.CredentialIdentifier, prefix),
+		encoding.EncodeVectorLen(c.ClientIdentity, prefix),
+	)
+}
+
+// ClientPublicKeyBytes returns the encoding of the client's AKE public key registered in the record, e.g. to list it in
+// administration tools, or nil if the record has none. Configuration.ParseClientPublicKey decodes it back.
+func (c *ClientRecord) ClientPublicKeyBytes() []byte {
+	if c.RegistrationRecord == nil || c.PublicKey == nil {
+		return nil
+	}
+
+	return c.PublicKey.Encode()
 }
 
 // RandomBytes returns random bytes of length len (wrapper for crypto/rand).
@@ -0,0 +1,113 @@
+// SPDX-License-Identifier: MIT
+//
+// Copyright (C) 2020-2025 Daniel Bourdrez. All Rights Reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree or at
+// https://spdx.org/licenses/MIT.html
+
+package opaque
+
+import (
+	"errors"
+	"io"
+
+	"github.com/bytemare/opaque/internal"
+	"github.com/bytemare/opaque/internal/oprf"
+)
+
+var (
+	// ErrInvalidOPRFState indicates that the OPRF state is nil or has already been used.
+	ErrInvalidOPRFState = errors.New("invalid or already used OPRF state")
+
+	// ErrInvalidOPRFKey indicates that the OPRF private key is not a valid non-zero scalar.
+	ErrInvalidOPRFKey = errors.New("invalid OPRF private key")
+)
+
+// OPRFClient runs the client side of the configuration's OPRF, in the base mode of RFC 9497, independently of the
+// OPAQUE protocol.
+type OPRFClient struct {
+	rand io.Reader
+	id   oprf.Identifier
+}
+
+// OPRFState holds the client's secret blind and input between OPRFClient.Blind and OPRFClient.Finalize. It can only
+// be used once, and must not leave the client.
+type OPRFState struct {
+	client *oprf.Client
+}
+
+// OPRFServer runs the server side of the configuration's OPRF, in the base mode of RFC 9497, independently of the
+// OPAQUE protocol.
+type OPRFServer struct {
+	rand io.Reader
+	id   oprf.Identifier
+}
+
+// OPRFClient returns an OPRFClient for the configuration's OPRF group.
+func (c *Configuration) OPRFClient() (*OPRFClient, error) {
+	conf, err := c.toInternal()
+	if err != nil {
+		return nil, err
+	}
+
+	return &OPRFClient{rand: conf.Rand, id: conf.OPRF}, nil
+}
+
+// OPRFServer returns an OPRFServer for the configuration's OPRF group.
+func (c *Configuration) OPRFServer() (*OPRFServer, error) {
+	conf, err := c.toInternal()
+	if err != nil {
+		return nil, err
+	}
+
+	return &OPRFServer{rand: conf.Rand, id: conf.OPRF}, nil
+}
+
+// Blind blinds the input with a fresh random scalar, and returns the encoded blinded element to send to the server
+// and the state to give to Finalize.
+func (o *OPRFClient) Blind(input []byte) (blinded []byte, state *OPRFState) {
+	client := o.id.Client()
+	blind := internal.RandomScalarFrom(o.rand, o.id.Group())
+
+	return client.Blind(input, blind).Encode(), &OPRFState{client: client}
+}
+
+// Finalize unblinds the server's encoded evaluation using the state returned by Blind, and returns the OPRF output.
+// The state is consumed, and can't be used again.
+func (o *OPRFClient) Finalize(state *OPRFState, evaluated []byte) ([]byte, error) {
+	if state == nil || state.client == nil {
+		return nil, ErrInvalidOPRFState
+	}
+
+	evaluation := o.id.Group().NewElement()
+	if err := evaluation.Decode(evaluated); err != nil || evaluation.IsIdentity() {
+		return nil, errInvalidEvaluatedData
+	}
+
+	output := state.client.Finalize(evaluation)
+	state.client = nil
+
+	return output, nil
+}
+
+// KeyGen returns a random encoded OPRF private key.
+func (o *OPRFServer) KeyGen() []byte {
+	return internal.RandomScalarFrom(o.rand, o.id.Group()).Encode()
+}
+
+// Evaluate evaluates the client's encoded blinded element with the encoded private key, and returns the encoded
+// evaluation.
+func (o *OPRFServer) Evaluate(privateKey, blinded []byte) ([]byte, error) {
+	sk := o.id.Group().NewScalar()
+	if err := sk.Decode(privateKey); err != nil || sk.IsZero() {
+		return nil, ErrInvalidOPRFKey
+	}
+
+	element := o.id.Group().NewElement()
+	if err := element.Decode(blinded); err != nil || element.IsIdentity() {
+		return nil, errInvalidBlindedData
+	}
+
+	return o.id.Evaluate(sk, element).Encode(), nil
+}
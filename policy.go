@@ -0,0 +1,189 @@
+// SPDX-License-Identifier: MIT
+//
+// Copyright (C) 2020-2025 Daniel Bourdrez. All Rights Reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree or at
+// https://spdx.org/licenses/MIT.html
+
+package opaque
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+
+	"github.com/bytemare/ksf"
+)
+
+var (
+	// ErrMixedGroups indicates that the policy requires the OPRF and AKE groups to be the same.
+	ErrMixedGroups = errors.New("policy violation: OPRF and AKE groups differ")
+
+	// ErrMixedHashes indicates that the policy requires the KDF, MAC, and Hash functions to be the same.
+	ErrMixedHashes = errors.New("policy violation: KDF, MAC, and Hash functions differ")
+
+	// ErrNoKSF indicates that the policy requires a key stretching function to be set.
+	ErrNoKSF = errors.New("policy violation: no key stretching function set")
+
+	// ErrNoContext indicates that the policy requires a non-empty application context.
+	ErrNoContext = errors.New("policy violation: empty context")
+
+	// ErrNotFIPS indicates that the policy requires FIPS-approved primitives only.
+	ErrNotFIPS = errors.New("policy violation: primitive is not FIPS-approved")
+
+	// ErrWeakKSF indicates that the Argon2id parameters are below the minimums, see ValidateKSFStrength.
+	ErrWeakKSF = errors.New("policy violation: key stretching function parameters are too weak")
+)
+
+const (
+	// MinArgon2idTime is the minimum number of Argon2id passes accepted by ValidateKSFStrength.
+	MinArgon2idTime = 2
+
+	// MinArgon2idMemory is the minimum Argon2id memory, in KiB, accepted by ValidateKSFStrength.
+	MinArgon2idMemory = 19 * 1024
+
+	// MinArgon2idThreads is the minimum Argon2id parallelism accepted by ValidateKSFStrength.
+	MinArgon2idThreads = 1
+)
+
+// SecurityPolicy bundles the optional strictness checks applied when validating a Configuration. The zero value, as
+// well as a nil policy, applies no additional checks.
+type SecurityPolicy struct {
+	// RequireMatchingGroups rejects configurations where the OPRF and AKE groups differ.
+	RequireMatchingGroups bool `json:"requireMatchingGroups"`
+
+	// RequireMatchingHashes rejects configurations where the KDF, MAC, and Hash functions differ.
+	RequireMatchingHashes bool `json:"requireMatchingHashes"`
+
+	// RequireKSF rejects configurations that don't use a key stretching function.
+	RequireKSF bool `json:"requireKSF"`
+
+	// RequireContext rejects configurations with an empty application context.
+	RequireContext bool `json:"requireContext"`
+
+	// FIPSOnly rejects primitives that are not FIPS-approved, i.e. Ristretto255, Argon2id, and Scrypt.
+	FIPSOnly bool `json:"fipsOnly"`
+}
+
+// StrictPolicy returns a SecurityPolicy with all checks enabled. Note that FIPSOnly rejects DefaultConfiguration and
+// the presets using Ristretto255 or Argon2id, and that RequireContext requires setting a Context, so a configuration
+// passing the strict policy uses e.g. P-256, PBKDF2, and an application context. StrictVerify only enforces its group
+// and hash function checks.
+func StrictPolicy() *SecurityPolicy {
+	return &SecurityPolicy{
+		RequireMatchingGroups: true,
+		RequireMatchingHashes: true,
+		RequireKSF:            true,
+		RequireContext:        true,
+		FIPSOnly:              true,
+	}
+}
+
+// PermissivePolicy returns a SecurityPolicy with all checks disabled, which is the default.
+func PermissivePolicy() *SecurityPolicy {
+	return &SecurityPolicy{
+		RequireMatchingGroups: false,
+		RequireMatchingHashes: false,
+		RequireKSF:            false,
+		RequireContext:        false,
+		FIPSOnly:              false,
+	}
+}
+
+// StrictVerify verifies the configuration as NewClient and NewServer do, including its Policy, and additionally
+// applies the group and hash function checks of StrictPolicy: it returns ErrMixedGroups if the OPRF and AKE groups
+// differ, and ErrMixedHashes if the KDF, MAC, and Hash functions differ. Such configurations are valid but
+// interoperate poorly, and are only accepted by the default, permissive, path. The other checks of StrictPolicy are
+// only enforced by setting it as the configuration's Policy.
+func (c *Configuration) StrictVerify() error {
+	if err := c.verify(); err != nil {
+		return err
+	}
+
+	return StrictPolicy().verifyConsistency(c)
+}
+
+// ValidateKSFStrength returns ErrWeakKSF if the configuration uses Argon2id with parameters set by SetKSFParameters
+// below MinArgon2idTime, MinArgon2idMemory, or MinArgon2idThreads, i.e. OWASP's minimum recommendation. The default
+// parameters are stronger. Other KSFs are not checked, a zero KSF is governed by AllowNoKSF, and parameters given per
+// call in the client options can't be checked. NewClientStrict and NewServerStrict enforce it.
+func (c *Configuration) ValidateKSFStrength() error {
+	// A mismatching number of parameters is rejected by the configuration's verification.
+	if c.KSF != ksf.Argon2id || len(c.ksfParameters) != ksfParameterCount(ksf.Argon2id) {
+		return nil
+	}
+
+	if c.ksfParameters[0] < MinArgon2idTime || c.ksfParameters[1] < MinArgon2idMemory ||
+		c.ksfParameters[2] < MinArgon2idThreads {
+		return fmt.Errorf("%w: Argon2id time %d, memory %d KiB, threads %d", ErrWeakKSF,
+			c.ksfParameters[0], c.ksfParameters[1], c.ksfParameters[2])
+	}
+
+	return nil
+}
+
+func isFIPSHash(h crypto.Hash) bool {
+	switch h { //nolint:exhaustive // all other hash functions are not approved.
+	case crypto.SHA224, crypto.SHA256, crypto.SHA384, crypto.SHA512, crypto.SHA512_224, crypto.SHA512_256,
+		crypto.SHA3_224, crypto.SHA3_256, crypto.SHA3_384, crypto.SHA3_512:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *SecurityPolicy) verifyFIPS(c *Configuration) error {
+	if c.OPRF == RistrettoSha512 || c.AKE == RistrettoSha512 {
+		return ErrNotFIPS
+	}
+
+	if c.KSF != 0 && c.KSF != ksf.PBKDF2Sha512 {
+		return ErrNotFIPS
+	}
+
+	if !isFIPSHash(c.KDF) || !isFIPSHash(c.MAC) || !isFIPSHash(c.Hash) {
+		return ErrNotFIPS
+	}
+
+	return nil
+}
+
+// verify returns an error on the first parameter of c violating the policy, nil otherwise.
+func (p *SecurityPolicy) verify(c *Configuration) error {
+	if p == nil {
+		return nil
+	}
+
+	if err := p.verifyConsistency(c); err != nil {
+		return err
+	}
+
+	if p.RequireKSF && c.KSF == 0 {
+		return ErrNoKSF
+	}
+
+	if p.RequireContext && len(c.Context) == 0 {
+		return ErrNoContext
+	}
+
+	if p.FIPSOnly {
+		return p.verifyFIPS(c)
+	}
+
+	return nil
+}
+
+// verifyConsistency returns ErrMixedGroups or ErrMixedHashes if the policy requires the same groups or hash functions,
+// and those of c differ.
+func (p *SecurityPolicy) verifyConsistency(c *Configuration) error {
+	if p.RequireMatchingGroups && c.OPRF != c.AKE {
+		return ErrMixedGroups
+	}
+
+	if p.RequireMatchingHashes && (c.KDF != c.MAC || c.MAC != c.Hash) {
+		return ErrMixedHashes
+	}
+
+	return nil
+}
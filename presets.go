@@ -0,0 +1,105 @@
+// SPDX-License-Identifier: MIT
+//
+// Copyright (C) 2020-2025 Daniel Bourdrez. All Rights Reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree or at
+// https://spdx.org/licenses/MIT.html
+
+package opaque
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/bytemare/ksf"
+)
+
+const (
+	// PresetRecommended is the name of the preset returning DefaultConfiguration, i.e. ristretto255, SHA-512, and
+	// Argon2id.
+	PresetRecommended = "recommended"
+
+	// PresetNISTP256 is the name of the preset using the NIST P-256 group, SHA-256, and Argon2id.
+	PresetNISTP256 = "nist-p256"
+
+	// PresetNISTP384 is the name of the preset using the NIST P-384 group, SHA-384, and Argon2id.
+	PresetNISTP384 = "nist-p384"
+
+	// PresetFast is the name of the preset using the NIST P-256 group, SHA-256, and a low-cost Scrypt, for fast tests.
+	// Its password stretching is too weak for production, see IsProductionPreset.
+	PresetFast = "fast"
+)
+
+// fastScryptParameters are the N, r, and p parameters of Scrypt in PresetFast, about 32 times cheaper than the
+// defaults.
+var fastScryptParameters = []int{1024, 8, 1}
+
+// ErrUnknownPreset indicates that no configuration preset has the given name.
+var ErrUnknownPreset = errors.New("unknown configuration preset")
+
+type preset struct {
+	build      func() *Configuration
+	production bool
+}
+
+var presets = map[string]preset{
+	PresetRecommended: {build: DefaultConfiguration, production: true},
+	PresetNISTP256: {
+		build:      func() *Configuration { return presetWith(P256Sha256, crypto.SHA256, ksf.Argon2id) },
+		production: true,
+	},
+	PresetNISTP384: {
+		build:      func() *Configuration { return presetWith(P384Sha384, crypto.SHA384, ksf.Argon2id) },
+		production: true,
+	},
+	PresetFast: {
+		build: func() *Configuration {
+			c := presetWith(P256Sha256, crypto.SHA256, ksf.Scrypt)
+			c.ksfParameters = slices.Clone(fastScryptParameters)
+
+			return c
+		},
+		production: false,
+	},
+}
+
+// presetWith returns the default configuration using the group for the OPRF and the AKE, the hash function for the
+// KDF, MAC, and Hash, and the key stretching function.
+func presetWith(g Group, h crypto.Hash, k ksf.Identifier) *Configuration {
+	c := DefaultConfiguration()
+	c.OPRF = g
+	c.AKE = g
+	c.KDF = h
+	c.MAC = h
+	c.Hash = h
+	c.KSF = k
+
+	return c
+}
+
+// ConfigurationPreset returns a new configuration for the named preset, as listed by ListPresets, or ErrUnknownPreset.
+// Each call returns a distinct configuration, which can be modified freely. Presets only help choosing parameters:
+// peers must still use the same configuration, and a context should be set.
+func ConfigurationPreset(name string) (*Configuration, error) {
+	p, ok := presets[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownPreset, name)
+	}
+
+	return p.build(), nil
+}
+
+// ListPresets returns the names of the configuration presets, in lexical order.
+func ListPresets() []string {
+	return slices.Sorted(maps.Keys(presets))
+}
+
+// IsProductionPreset returns whether the named preset is suitable for production. It returns false for PresetFast,
+// whose password stretching is deliberately weak, and for unknown names.
+func IsProductionPreset(name string) bool {
+	return presets[name].production
+}
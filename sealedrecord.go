@@ -0,0 +1,62 @@
+// SPDX-License-Identifier: MIT
+//
+// Copyright (C) 2020-2025 Daniel Bourdrez. All Rights Reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree or at
+// https://spdx.org/licenses/MIT.html
+
+package opaque
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/bytemare/opaque/internal"
+	"github.com/bytemare/opaque/internal/tag"
+	"github.com/bytemare/opaque/message"
+)
+
+// ErrRecordAuthentication indicates that a sealed client record failed authentication, i.e. it was tampered with or
+// was sealed under a different key.
+var ErrRecordAuthentication = errors.New("sealed client record failed authentication")
+
+// Seal returns the serialized record encrypted and authenticated with AES-256-GCM under the 32-byte key and a random
+// nonce, for storage at rest, e.g. in a database. Configuration.OpenClientRecord decrypts it. The key must be secret
+// and held by the servers only. The sealed record is not bound to where it is stored: applications should check that
+// the credential identifier of an opened record is the one it was looked up for.
+func (c *ClientRecord) Seal(key []byte) ([]byte, error) {
+	if c.RegistrationRecord == nil {
+		return nil, fmt.Errorf("%w: %w", ErrMalformedRecord, message.ErrNilRecord)
+	}
+
+	plaintext := c.Serialize()
+	defer clear(plaintext)
+
+	sealed, err := internal.Seal(key, plaintext, []byte(tag.SealedClientRecord))
+	if err != nil {
+		return nil, fmt.Errorf("sealing the client record: %w", err)
+	}
+
+	return sealed, nil
+}
+
+// OpenClientRecord decrypts a record sealed by ClientRecord.Seal under the same key, and deserializes it for the
+// configuration. It returns ErrRecordAuthentication if the sealed record was tampered with or the key is wrong.
+func (c *Configuration) OpenClientRecord(key, sealed []byte) (*ClientRecord, error) {
+	d, err := c.Deserializer()
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := internal.Open(key, sealed, []byte(tag.SealedClientRecord))
+	if err != nil {
+		if errors.Is(err, internal.ErrSealOpen) {
+			return nil, ErrRecordAuthentication
+		}
+
+		return nil, fmt.Errorf("opening the client record: %w", err)
+	}
+
+	return d.ClientRecord(plaintext)
+}
@@ -9,6 +9,9 @@
 package opaque
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
 	"errors"
 	"fmt"
 
@@ -18,6 +21,7 @@ import (
 	"github.com/bytemare/opaque/internal/ake"
 	"github.com/bytemare/opaque/internal/encoding"
 	"github.com/bytemare/opaque/internal/masking"
+	"github.com/bytemare/opaque/internal/oprf"
 	"github.com/bytemare/opaque/internal/tag"
 	"github.com/bytemare/opaque/message"
 )
@@ -33,7 +37,7 @@ var (
 	ErrInvalidState = errors.New("invalid state length")
 
 	// ErrInvalidEnvelopeLength indicates the envelope contained in the record is of invalid length.
-	ErrInvalidEnvelopeLength = errors.New("record has invalid envelope length")
+	ErrInvalidEnvelopeLength = message.ErrInvalidEnvelopeLength
 
 	// ErrInvalidPksLength indicates the input public key is not of right length.
 	ErrInvalidPksLength = errors.New("input server public key's length is invalid")
@@ -41,23 +45,116 @@ var (
 	// ErrInvalidOPRFSeedLength indicates that the OPRF seed is not of right length.
 	ErrInvalidOPRFSeedLength = errors.New("input OPRF seed length is invalid (must be of hash output length)")
 
+	// ErrSeedConfigMismatch indicates that the OPRF seed has the length of another configuration's hash output, and was
+	// thus most likely generated for, or shared with, a configuration using another hash function. Records registered
+	// under another configuration are incompatible, even with the same seed. It is returned along with
+	// ErrInvalidOPRFSeedLength.
+	ErrSeedConfigMismatch = errors.New("OPRF seed was generated for a configuration with another hash function")
+
 	// ErrZeroSKS indicates that the server's private key is a zero scalar.
 	ErrZeroSKS = errors.New("server private key is zero")
+
+	// ErrInvalidSealKey indicates that the key used to seal or open data is not 32 bytes long, or that the key used to
+	// tag a state is shorter than 32 bytes.
+	ErrInvalidSealKey = internal.ErrSealKeyLength
+
+	// ErrStateAuthentication indicates that a sealed state failed authentication, i.e. it was tampered with or was
+	// sealed under a different key or configuration.
+	ErrStateAuthentication = errors.New("sealed state failed authentication")
+
+	// ErrStateIntegrity indicates that a tagged state failed its integrity check, i.e. it was truncated, corrupted, or
+	// tagged under a different key or configuration.
+	ErrStateIntegrity = errors.New("tagged state failed integrity check")
+
+	// ErrBatchLengthMismatch indicates that the batched requests and credential identifiers differ in number.
+	ErrBatchLengthMismatch = errors.New("number of requests and credential identifiers differ")
+
+	// ErrNoPreviousOPRFSeed indicates that a record marked as created under the previous OPRF seed was given, but no
+	// previous seed is set.
+	ErrNoPreviousOPRFSeed = errors.New("record requires the previous OPRF seed, which is not set")
+
+	// ErrNoLegacyServerKey indicates that GenerateKE2WithLegacyServerKey was called, but the given key doesn't match
+	// the legacy AKE key pair set with SetLegacyServerKey, or none is set.
+	ErrNoLegacyServerKey = errors.New("no matching legacy server AKE key is set")
+
+	// ErrNoKE3 indicates that no KE3 message was provided to finish the login.
+	ErrNoKE3 = errors.New("no KE3 message provided")
+
+	// ErrInvalidServerSecretKey indicates that the server's AKE secret key can't be decoded. The returned error is an
+	// *Error also wrapping the decoding error.
+	ErrInvalidServerSecretKey = errors.New("invalid server AKE secret key")
+
+	// ErrInvalidServerPublicKey indicates that the server's AKE public key can't be decoded. The returned error is an
+	// *Error also wrapping the decoding error.
+	ErrInvalidServerPublicKey = errors.New("invalid server public key")
+
+	// ErrIdentityTooLong indicates that a server or client identity is longer than can be encoded in the transcript,
+	// i.e. 65535 bytes, or 2^31-1 bytes with Configuration.LongIdentities.
+	ErrIdentityTooLong = errors.New("identity is too long to be encoded")
+
+	// ErrFixedMultiKE2Values indicates that a key share seed, AKE nonce, masking nonce, or masking key was given to
+	// GenerateKE2Multi, which would be reused in all its KE2 messages.
+	ErrFixedMultiKE2Values = errors.New(
+		"fixed key share seed, nonces, or masking key can't be reused across several KE2 messages",
+	)
+
+	// ErrReplayedKE1 indicates that the client nonce of a KE1 message has already been seen by the server's nonce store.
+	ErrReplayedKE1 = errors.New("replayed KE1: client nonce already seen")
+
+	// ErrAssociatedDataTooLong indicates that the associated data is longer than can be encoded in the transcript, i.e.
+	// 65535 bytes, or 2^31-1 bytes with Configuration.LongIdentities.
+	ErrAssociatedDataTooLong = errors.New("associated data is too long to be encoded")
+
+	// ErrInvalidClientKeyShare indicates that the client's ephemeral public key share in KE1 is missing, of another
+	// group, or the identity element, which would make the Diffie-Hellman outputs predictable.
+	ErrInvalidClientKeyShare = errors.New("invalid client public key share")
+
+	// ErrMalformedKE1 indicates that a KE1 message has no credential request, e.g. when built by hand or partially
+	// deserialized.
+	ErrMalformedKE1 = errors.New("malformed KE1: missing credential request")
+
+	// ErrInvalidClientNonce indicates that the client nonce in KE1 is not of the configuration's nonce length.
+	ErrInvalidClientNonce = errors.New("invalid client nonce length")
+
+	// ErrMalformedRecord indicates that a client record has no registration record, e.g. when built by hand or
+	// partially loaded from storage. It is returned along with message.ErrNilRecord.
+	ErrMalformedRecord = errors.New("malformed client record: missing registration record")
+
+	// ErrInvalidBlindedMessage indicates that the blinded message of a registration or credential request is missing,
+	// of another group, or the identity element, or that its evaluation is the identity element, which would make the
+	// OPRF output independent of the password.
+	ErrInvalidBlindedMessage = errors.New("invalid blinded message")
+
+	// ErrInvalidKEMKeyShare indicates that the KEM encapsulation key in KE1 or the KEM ciphertext in KE2 is malformed.
+	ErrInvalidKEMKeyShare = internal.ErrInvalidKEMKeyShare
+
+	// ErrDerandomizedKEM indicates that a reproducible KEM encapsulation was requested, with Configuration.Rand or
+	// GenerateKE2Options.KeyShareSeed, but is not available, see Configuration.KEM.
+	ErrDerandomizedKEM = internal.ErrDerandomizedKEM
+
+	// ErrDuplicateKE2Option indicates that several options given to GenerateKE2With set the same value.
+	ErrDuplicateKE2Option = errors.New("several KE2 options set the same value")
 )
 
 // Server represents an OPAQUE Server, exposing its functions and holding its state.
 type Server struct {
-	Deserialize *Deserializer
-	conf        *internal.Configuration
-	Ake         *ake.Server
+	Deserialize  *Deserializer
+	conf         *internal.Configuration
+	Ake          *ake.Server
+	clientNonces NonceStore
 	*keyMaterial
 }
 
 type keyMaterial struct {
-	serverIdentity  []byte
-	serverSecretKey *ecc.Scalar
-	serverPublicKey []byte
-	oprfSeed        []byte
+	serverIdentity   []byte
+	serverSecretKey  *ecc.Scalar
+	serverPublicKeyE *ecc.Element
+	serverPublicKey  []byte
+	oprfSeed         []byte
+	previousOPRFSeed []byte
+	fakeRecordSeed   []byte
+	legacySecretKey  *ecc.Scalar
+	legacyPublicKey  []byte
 }
 
 // NewServer returns a Server instantiation given the application Configuration.
@@ -66,25 +163,93 @@ func NewServer(c *Configuration) (*Server, error) {
 		c = DefaultConfiguration()
 	}
 
+	if err := c.checkKSF(); err != nil {
+		return nil, err
+	}
+
 	conf, err := c.toInternal()
 	if err != nil {
 		return nil, err
 	}
 
 	return &Server{
-		Deserialize: &Deserializer{conf: conf},
-		conf:        conf,
-		Ake:         ake.NewServer(),
-		keyMaterial: nil,
+		Deserialize:  newDeserializer(c, conf),
+		conf:         conf,
+		Ake:          ake.NewServer(),
+		clientNonces: nil,
+		keyMaterial:  nil,
 	}, nil
 }
 
+// NewServerStrict returns a new Server instantiation as NewServer does, but rejects configurations failing
+// Configuration.StrictVerify or Configuration.ValidateKSFStrength.
+func NewServerStrict(c *Configuration) (*Server, error) {
+	if c == nil {
+		c = DefaultConfiguration()
+	}
+
+	if err := c.StrictVerify(); err != nil {
+		return nil, err
+	}
+
+	if err := c.ValidateKSFStrength(); err != nil {
+		return nil, err
+	}
+
+	return NewServer(c)
+}
+
+// SetSeenClientNonces sets an optional store of the client nonces seen in KE1 messages, so that GenerateKE2 fails with
+// ErrReplayedKE1 if a KE1 is replayed, e.g. to make the server repeatedly compute KE2 messages. NewNonceCache returns
+// a bounded store with a configurable window. A client nonce is only recorded once the KE1, the record, and the options
+// are validated, so that a failed login can be retried with the same KE1. Setting a nil store disables the check.
+func (s *Server) SetSeenClientNonces(store NonceStore) {
+	s.clientNonces = store
+}
+
+// Reset clears the server's per-session AKE values, i.e. the ephemeral key share, nonce, expected client MAC, and
+// session key, so that the instance can safely be reused for a new login. The key material and the client nonce store
+// are kept.
+func (s *Server) Reset() {
+	s.Ake.Flush()
+}
+
 // GetConf return the internal configuration.
 func (s *Server) GetConf() *internal.Configuration {
 	return s.conf
 }
 
-func (s *Server) oprfResponse(element *ecc.Element, oprfSeed, credentialIdentifier []byte) *ecc.Element {
+// Group returns the AKE group the server was built with, which recommended configurations also use for the OPRF.
+func (s *Server) Group() Group {
+	return Group(s.conf.Group)
+}
+
+// checkOPRFSeed returns ErrInvalidOPRFSeedLength, with the expected and given lengths, if the OPRF seed is not of the
+// configuration's hash output length. If its length is the one of another hash function, ErrSeedConfigMismatch is
+// returned as well.
+func (s *Server) checkOPRFSeed(seed []byte) error {
+	if len(seed) == s.conf.Hash.Size() {
+		return nil
+	}
+
+	switch len(seed) {
+	case sha256.Size, sha512.Size384, sha512.Size:
+		return fmt.Errorf("%w: %w: got %d bytes, the configuration requires %d",
+			ErrInvalidOPRFSeedLength, ErrSeedConfigMismatch, len(seed), s.conf.Hash.Size())
+	default:
+		return fmt.Errorf("%w: got %d bytes, the configuration requires %d",
+			ErrInvalidOPRFSeedLength, len(seed), s.conf.Hash.Size())
+	}
+}
+
+// oprfResponse evaluates the OPRF on the blinded message, with the key derived from the credential identifier. It
+// returns ErrInvalidBlindedMessage if the blinded message or its evaluation is degenerate, since the wire decoding
+// can't be relied on for messages built by the application.
+func (s *Server) oprfResponse(element *ecc.Element, oprfSeed, credentialIdentifier []byte) (*ecc.Element, error) {
+	if element == nil || element.Group() != s.conf.OPRF.Group() || element.IsIdentity() {
+		return nil, ErrInvalidBlindedMessage
+	}
+
 	seed := s.conf.KDF.Expand(
 		oprfSeed,
 		encoding.SuffixString(credentialIdentifier, tag.ExpandOPRF),
@@ -92,22 +257,152 @@ func (s *Server) oprfResponse(element *ecc.Element, oprfSeed, credentialIdentifi
 	)
 	ku := s.conf.OPRF.DeriveKey(seed, []byte(tag.DeriveKeyPair))
 
-	return s.conf.OPRF.Evaluate(ku, element)
+	evaluated := s.conf.OPRF.Evaluate(ku, element)
+	if evaluated.IsIdentity() {
+		return nil, ErrInvalidBlindedMessage
+	}
+
+	return evaluated, nil
+}
+
+// oprfResponseBatch evaluates the OPRF for each element, with the key derived from the credential identifier at the
+// same index. Each element is evaluated under its own key, so the evaluations can't be merged into a multi-scalar
+// multiplication.
+func (s *Server) oprfResponseBatch(
+	elements []*ecc.Element,
+	oprfSeed []byte,
+	credentialIdentifiers [][]byte,
+) ([]*ecc.Element, error) {
+	evaluated := make([]*ecc.Element, len(elements))
+
+	for i, element := range elements {
+		z, err := s.oprfResponse(element, oprfSeed, credentialIdentifiers[i])
+		if err != nil {
+			return nil, fmt.Errorf("%w at index %d", err, i)
+		}
+
+		evaluated[i] = z
+	}
+
+	return evaluated, nil
+}
+
+// EvaluateOPRF evaluates the OPRF on the blinded message, with the OPRF key derived from the OPRF seed and the
+// credential identifier, and returns the same evaluated element as RegistrationResponse or GenerateKE2 would for the
+// same inputs. It allows running the OPRF evaluation on a separate node holding the OPRF seed. That node must only
+// receive blinded messages and return evaluations over an authenticated channel to the AKE node, as an attacker
+// substituting evaluations can make logins fail, and anyone able to query it can run it as an oracle for online
+// guessing, which must be rate-limited as logins are. It returns ErrInvalidBlindedMessage if the blinded message is
+// invalid.
+func (s *Server) EvaluateOPRF(
+	blindedMessage *ecc.Element,
+	credentialIdentifier, oprfSeed []byte,
+) (*ecc.Element, error) {
+	if err := s.checkOPRFSeed(oprfSeed); err != nil {
+		return nil, err
+	}
+
+	return s.oprfResponse(blindedMessage, oprfSeed, credentialIdentifier)
+}
+
+// EvaluateOPRFBatch evaluates the OPRF on each blinded message, with the OPRF key derived from the OPRF seed and the
+// credential identifier at the same index, e.g. for a bulk migration. Each result is the same as the evaluated
+// element of a single RegistrationResponse or KE2 for the same inputs.
+func (s *Server) EvaluateOPRFBatch(
+	blindedMessages []*ecc.Element,
+	credentialIdentifiers [][]byte,
+	oprfSeed []byte,
+) ([]*ecc.Element, error) {
+	if len(blindedMessages) != len(credentialIdentifiers) {
+		return nil, ErrBatchLengthMismatch
+	}
+
+	if err := s.checkOPRFSeed(oprfSeed); err != nil {
+		return nil, err
+	}
+
+	return s.oprfResponseBatch(blindedMessages, oprfSeed, credentialIdentifiers)
 }
 
 // RegistrationResponse returns a RegistrationResponse message to the input RegistrationRequest message and given
-// identifiers.
+// identifiers, or ErrInvalidBlindedMessage if the request's blinded message is invalid.
 func (s *Server) RegistrationResponse(
 	req *message.RegistrationRequest,
 	serverPublicKey *ecc.Element,
 	credentialIdentifier, oprfSeed []byte,
-) *message.RegistrationResponse {
-	z := s.oprfResponse(req.BlindedMessage, oprfSeed, credentialIdentifier)
+) (*message.RegistrationResponse, error) {
+	if req == nil {
+		return nil, ErrInvalidBlindedMessage
+	}
+
+	z, err := s.oprfResponse(req.BlindedMessage, oprfSeed, credentialIdentifier)
+	if err != nil {
+		return nil, err
+	}
 
 	return &message.RegistrationResponse{
 		EvaluatedMessage: z,
 		Pks:              serverPublicKey,
+	}, nil
+}
+
+// RegistrationResponseForUpdate is like RegistrationResponse, and additionally reports whether the registration updates
+// an existing record, e.g. on a password change, so that the application can audit such changes. existing is the record
+// currently stored for the credential identifier, or nil on a first registration. The response doesn't depend on it.
+func (s *Server) RegistrationResponseForUpdate(
+	req *message.RegistrationRequest,
+	serverPublicKey *ecc.Element,
+	credentialIdentifier, oprfSeed []byte,
+	existing *ClientRecord,
+) (response *message.RegistrationResponse, update bool, err error) {
+	response, err = s.RegistrationResponse(req, serverPublicKey, credentialIdentifier, oprfSeed)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return response, existing != nil, nil
+}
+
+// BatchRegistrationResponse returns a RegistrationResponse for each RegistrationRequest, using the credential
+// identifier at the same index, and the same server public key and OPRF seed for all.
+func (s *Server) BatchRegistrationResponse(
+	reqs []*message.RegistrationRequest,
+	serverPublicKey *ecc.Element,
+	credentialIdentifiers [][]byte,
+	oprfSeed []byte,
+) ([]*message.RegistrationResponse, error) {
+	if len(reqs) != len(credentialIdentifiers) {
+		return nil, ErrBatchLengthMismatch
+	}
+
+	if err := s.checkOPRFSeed(oprfSeed); err != nil {
+		return nil, err
+	}
+
+	blindedMessages := make([]*ecc.Element, len(reqs))
+	for i, req := range reqs {
+		if req == nil {
+			return nil, fmt.Errorf("%w at index %d", ErrInvalidBlindedMessage, i)
+		}
+
+		blindedMessages[i] = req.BlindedMessage
 	}
+
+	evaluated, err := s.oprfResponseBatch(blindedMessages, oprfSeed, credentialIdentifiers)
+	if err != nil {
+		return nil, err
+	}
+
+	responses := make([]*message.RegistrationResponse, len(reqs))
+
+	for i, z := range evaluated {
+		responses[i] = &message.RegistrationResponse{
+			EvaluatedMessage: z,
+			Pks:              serverPublicKey,
+		}
+	}
+
+	return responses, nil
 }
 
 func (s *Server) credentialResponse(
@@ -115,8 +410,15 @@ func (s *Server) credentialResponse(
 	serverPublicKey []byte,
 	record *message.RegistrationRecord,
 	credentialIdentifier, oprfSeed, maskingNonce []byte,
-) *message.CredentialResponse {
-	z := s.oprfResponse(req.BlindedMessage, oprfSeed, credentialIdentifier)
+) (*message.CredentialResponse, error) {
+	if req == nil {
+		return nil, ErrInvalidBlindedMessage
+	}
+
+	z, err := s.oprfResponse(req.BlindedMessage, oprfSeed, credentialIdentifier)
+	if err != nil {
+		return nil, err
+	}
 
 	maskingNonce, maskedResponse := masking.Mask(
 		s.conf,
@@ -126,23 +428,30 @@ func (s *Server) credentialResponse(
 		record.Envelope,
 	)
 
-	return message.NewCredentialResponse(z, maskingNonce, maskedResponse)
+	return message.NewCredentialResponse(z, maskingNonce, maskedResponse), nil
 }
 
 // GenerateKE2Options enable setting optional values for the session, which default to secure random values if not
 // set.
 type GenerateKE2Options struct {
-	// KeyShareSeed: optional.
+	// KeyShareSeed: optional. With a KEM, the encapsulation randomness is also derived from it.
 	KeyShareSeed []byte
 	// AKENonce: optional.
 	AKENonce []byte
 	// MaskingNonce: optional.
 	MaskingNonce []byte
-	// AKENonceLength: optional, overrides the default length of the nonce to be created if no nonce is provided.
+	// MaskingKey: optional, the client's masking key, for deployments storing it apart from the record, e.g. in an HSM.
+	// If set, it is used instead of the record's masking key, which can then be nil.
+	MaskingKey []byte
+	// AssociatedData: optional, data bound into the AKE transcript, e.g. a TLS exporter value for channel binding. It
+	// must be the same as the client's, or the login fails, and at most 65535 bytes long.
+	AssociatedData []byte
+	// AKENonceLength: optional, overrides the configuration's nonce length for the nonce to be created if no nonce is
+	// provided. Note that messages are only deserializable if their nonces are of the configuration's nonce length.
 	AKENonceLength uint32
 }
 
-func getGenerateKE2Options(options []GenerateKE2Options) (*ake.Options, []byte) {
+func getGenerateKE2Options(options []GenerateKE2Options, nonceLength int) (*ake.Options, []byte) {
 	var (
 		op           ake.Options
 		maskingNonce []byte
@@ -155,82 +464,616 @@ func getGenerateKE2Options(options []GenerateKE2Options) (*ake.Options, []byte)
 		maskingNonce = options[0].MaskingNonce
 	}
 
+	if op.NonceLength == 0 {
+		op.NonceLength = uint32(nonceLength) //nolint:gosec // the nonce length is verified in the configuration.
+	}
+
 	return &op, maskingNonce
 }
 
+// KE2Option sets an optional value of GenerateKE2Options. Options are given to GenerateKE2With, or composed with
+// NewGenerateKE2Options, so that several of them can be given in any order, and none are dropped.
+type KE2Option func(options *GenerateKE2Options)
+
+// WithMaskingNonce sets GenerateKE2Options.MaskingNonce.
+func WithMaskingNonce(nonce []byte) KE2Option {
+	return func(options *GenerateKE2Options) {
+		options.MaskingNonce = nonce
+	}
+}
+
+// WithAKENonce sets GenerateKE2Options.AKENonce.
+func WithAKENonce(nonce []byte) KE2Option {
+	return func(options *GenerateKE2Options) {
+		options.AKENonce = nonce
+	}
+}
+
+// WithKeyShareSeed sets GenerateKE2Options.KeyShareSeed.
+func WithKeyShareSeed(seed []byte) KE2Option {
+	return func(options *GenerateKE2Options) {
+		options.KeyShareSeed = seed
+	}
+}
+
+// WithMaskingKey sets GenerateKE2Options.MaskingKey.
+func WithMaskingKey(maskingKey []byte) KE2Option {
+	return func(options *GenerateKE2Options) {
+		options.MaskingKey = maskingKey
+	}
+}
+
+// WithAssociatedData sets GenerateKE2Options.AssociatedData, e.g. for channel binding.
+func WithAssociatedData(associatedData []byte) KE2Option {
+	return func(options *GenerateKE2Options) {
+		options.AssociatedData = associatedData
+	}
+}
+
+// WithAKENonceLength sets GenerateKE2Options.AKENonceLength.
+func WithAKENonceLength(length uint32) KE2Option {
+	return func(options *GenerateKE2Options) {
+		options.AKENonceLength = length
+	}
+}
+
+// NewGenerateKE2Options returns the GenerateKE2Options with the given options applied in order, the last one winning
+// if several set the same value, to be given to GenerateKE2 and its variants, e.g.
+//
+//	server.GenerateKE2(ke1, record, NewGenerateKE2Options(WithAKENonce(nonce), WithMaskingNonce(maskingNonce)))
+//
+// The GenerateKE2 methods only read their first GenerateKE2Options, so options must be composed this way rather than
+// given as several structs. GenerateKE2With takes the options directly.
+func NewGenerateKE2Options(options ...KE2Option) GenerateKE2Options {
+	var o GenerateKE2Options
+	for _, option := range options {
+		option(&o)
+	}
+
+	return o
+}
+
 // SetKeyMaterial set the server's identity and mandatory key material to be used during GenerateKE2().
 // All these values must be the same as used during client registration and remain the same across protocol execution
 // for a given registered client.
 //
-// - serverIdentity can be nil, in which case it will be set to serverPublicKey.
+// - serverIdentity can be nil, in which case serverPublicKey is used as the server identity in the AKE transcript of
+// each login. It must be at most 65535 bytes long, or 2^31-1 with Configuration.LongIdentities, or ErrIdentityTooLong
+// is returned.
 // - serverSecretKey is the server's secret AKE key.
 // - serverPublicKey is the server's public AKE key to the serverSecretKey.
 // - oprfSeed is the long-term OPRF input seed.
 func (s *Server) SetKeyMaterial(serverIdentity, serverSecretKey, serverPublicKey, oprfSeed []byte) error {
+	if len(serverIdentity) > s.conf.MaxIdentityLength() {
+		return ErrIdentityTooLong
+	}
+
 	sks := s.conf.Group.NewScalar()
 	if err := sks.Decode(serverSecretKey); err != nil {
-		return fmt.Errorf("invalid server AKE secret key: %w", err)
+		return newError(ErrInvalidServerSecretKey, err)
 	}
 
 	if sks.IsZero() {
 		return ErrZeroSKS
 	}
 
-	if len(oprfSeed) != s.conf.Hash.Size() {
-		return ErrInvalidOPRFSeedLength
+	if err := s.checkOPRFSeed(oprfSeed); err != nil {
+		return err
 	}
 
 	if len(serverPublicKey) != s.conf.Group.ElementLength() {
 		return ErrInvalidPksLength
 	}
 
-	if err := s.conf.Group.NewElement().Decode(serverPublicKey); err != nil {
-		return fmt.Errorf("invalid server public key: %w", err)
+	pks := s.conf.Group.NewElement()
+	if err := pks.Decode(serverPublicKey); err != nil {
+		return newError(ErrInvalidServerPublicKey, err)
 	}
 
 	s.keyMaterial = &keyMaterial{
-		serverIdentity:  serverIdentity,
-		serverSecretKey: sks,
-		serverPublicKey: serverPublicKey,
-		oprfSeed:        oprfSeed,
+		serverIdentity:   serverIdentity,
+		serverSecretKey:  sks,
+		serverPublicKeyE: pks,
+		serverPublicKey:  serverPublicKey,
+		oprfSeed:         oprfSeed,
+		previousOPRFSeed: nil,
+		fakeRecordSeed:   s.conf.KDF.Expand(oprfSeed, []byte(tag.FakeRecordSeed), s.conf.Hash.Size()),
+		legacySecretKey:  nil,
+		legacyPublicKey:  nil,
 	}
 
 	return nil
 }
 
-// GenerateKE2 responds to a KE1 message with a KE2 message a client record.
+// SetKeyMaterialWithPreviousSeed behaves like SetKeyMaterial with currentSeed as the OPRF seed, and additionally
+// retains previousSeed to honor records created under it during an OPRF seed rotation window.
+//
+// Rotating the OPRF seed changes the OPRF evaluation, and thus the randomized password, masking key, and envelope
+// derived from it, so existing records can't be migrated server-side. The migration strategy is as follows:
+//
+//   - mark all existing records with PreviousOPRFSeed, and start using the new seed with this function;
+//   - new registrations use the current seed (i.e. pass it to RegistrationResponse), and are not marked;
+//   - GenerateKE2 evaluates the OPRF with the previous seed for marked records, and the current seed otherwise;
+//   - after a successful login with a marked record, have the client re-register under the current seed, and replace
+//     the record with an unmarked one;
+//   - at the end of the window, call SetKeyMaterial with the current seed only. Remaining marked records then fail
+//     with ErrNoPreviousOPRFSeed, and their clients must reset their registration.
+func (s *Server) SetKeyMaterialWithPreviousSeed(
+	serverIdentity, serverSecretKey, serverPublicKey, currentSeed, previousSeed []byte,
+) error {
+	if err := s.checkOPRFSeed(previousSeed); err != nil {
+		return fmt.Errorf("previous seed: %w", err)
+	}
+
+	if err := s.SetKeyMaterial(serverIdentity, serverSecretKey, serverPublicKey, currentSeed); err != nil {
+		return err
+	}
+
+	s.previousOPRFSeed = previousSeed
+
+	return nil
+}
+
+// SetLegacyServerKey retains the server's previous AKE key pair, to honor records registered under it during an AKE key
+// rotation window. It must be called after SetKeyMaterial, which sets the new key pair and clears the legacy one.
+//
+// The server's public key is part of the client's envelope, so records registered under the previous key pair can't
+// be used with the new one. The OPRF seed doesn't change, so the OPRF evaluation, and thus the randomized password,
+// are the same under both keys. The migration strategy is as follows:
+//
+//   - keep track of the records registered under the previous key, and set the new key pair with SetKeyMaterial and
+//     the previous one with this function;
+//   - new registrations use the new public key (i.e. pass PublicKey() to RegistrationResponse);
+//   - use GenerateKE2WithLegacyServerKey for records registered under the previous key, and GenerateKE2 otherwise;
+//   - after a successful login with such a record, have the client re-register, and replace the record;
+//   - at the end of the window, call SetKeyMaterial again without setting a legacy key.
+//
+// If no server identity is set, the public key is used as the server identity, which changes with the rotation as well.
+func (s *Server) SetLegacyServerKey(legacySecretKey, legacyPublicKey []byte) error {
+	if s.keyMaterial == nil {
+		return ErrNoServerKeyMaterial
+	}
+
+	sks := s.conf.Group.NewScalar()
+	if err := sks.Decode(legacySecretKey); err != nil {
+		return newError(ErrInvalidServerSecretKey, err)
+	}
+
+	if sks.IsZero() {
+		return ErrZeroSKS
+	}
+
+	if len(legacyPublicKey) != s.conf.Group.ElementLength() {
+		return ErrInvalidPksLength
+	}
+
+	if err := s.conf.Group.NewElement().Decode(legacyPublicKey); err != nil {
+		return newError(ErrInvalidServerPublicKey, err)
+	}
+
+	s.legacySecretKey = sks
+	s.legacyPublicKey = legacyPublicKey
+
+	return nil
+}
+
+// CanRotateAKEKey returns whether a legacy AKE key pair is set with SetLegacyServerKey, i.e. whether records
+// registered under the previous server key can still log in with GenerateKE2WithLegacyServerKey.
+func (s *Server) CanRotateAKEKey() bool {
+	return s.keyMaterial != nil && s.legacySecretKey != nil
+}
+
+// PublicKey returns a copy of the server's public key decoded once by SetKeyMaterial, e.g. for RegistrationResponse,
+// or nil if the key material is not set.
+func (s *Server) PublicKey() *ecc.Element {
+	if s.keyMaterial == nil {
+		return nil
+	}
+
+	return s.serverPublicKeyE.Copy()
+}
+
+// ClearKeyMaterial wipes the server's key material and AKE session values, after which GenerateKE2 returns
+// ErrNoServerKeyMaterial until SetKeyMaterial is called again. The OPRF seed slice given to SetKeyMaterial is
+// overwritten with zeros, and the secret key scalar is set to zero. This is a best-effort overwrite: copies of the
+// scalar's underlying representation made by the ecc library or the runtime can't be guaranteed to be wiped.
+func (s *Server) ClearKeyMaterial() {
+	if s.keyMaterial != nil {
+		clear(s.oprfSeed)
+		clear(s.previousOPRFSeed)
+		clear(s.fakeRecordSeed)
+
+		if s.serverSecretKey != nil {
+			s.serverSecretKey.Zero()
+		}
+
+		if s.legacySecretKey != nil {
+			s.legacySecretKey.Zero()
+		}
+
+		s.keyMaterial = nil
+	}
+
+	s.Ake.Flush()
+}
+
+// GenerateKE2 responds to a KE1 message with a KE2 message a client record. If a client nonce store is set with
+// SetSeenClientNonces, it returns ErrReplayedKE1 if the KE1's client nonce has already been seen.
 func (s *Server) GenerateKE2(
 	ke1 *message.KE1,
 	record *ClientRecord,
 	options ...GenerateKE2Options,
+) (*message.KE2, error) {
+	return s.generateKE2(ke1, record, options, true)
+}
+
+// GenerateKE2With is like GenerateKE2, but takes functional options, e.g.
+//
+//	server.GenerateKE2With(ke1, record, WithAssociatedData(exporter), WithMaskingNonce(maskingNonce))
+//
+// Unlike NewGenerateKE2Options, it returns ErrDuplicateKE2Option if several options set the same value.
+func (s *Server) GenerateKE2With(ke1 *message.KE1, record *ClientRecord, options ...KE2Option) (*message.KE2, error) {
+	var composed GenerateKE2Options
+
+	for _, option := range options {
+		var o GenerateKE2Options
+		option(&o)
+
+		if err := composed.merge(&o); err != nil {
+			return nil, err
+		}
+	}
+
+	return s.GenerateKE2(ke1, record, composed)
+}
+
+// merge sets the values set in other, and returns ErrDuplicateKE2Option if one of them is already set.
+func (o *GenerateKE2Options) merge(other *GenerateKE2Options) error {
+	for _, field := range [][2]*[]byte{
+		{&o.KeyShareSeed, &other.KeyShareSeed},
+		{&o.AKENonce, &other.AKENonce},
+		{&o.MaskingNonce, &other.MaskingNonce},
+		{&o.MaskingKey, &other.MaskingKey},
+		{&o.AssociatedData, &other.AssociatedData},
+	} {
+		if *field[1] == nil {
+			continue
+		}
+
+		if *field[0] != nil {
+			return ErrDuplicateKE2Option
+		}
+
+		*field[0] = *field[1]
+	}
+
+	if other.AKENonceLength != 0 {
+		if o.AKENonceLength != 0 {
+			return ErrDuplicateKE2Option
+		}
+
+		o.AKENonceLength = other.AKENonceLength
+	}
+
+	return nil
+}
+
+// GenerateKE2WithState is like GenerateKE2, but also returns the serialized AKE state of the login, as SerializeState
+// would, e.g. for stateless servers to ship it along with the KE2 and set it with SetAKEState() before calling
+// LoginFinish(). The server keeps its own AKE state.
+func (s *Server) GenerateKE2WithState(
+	ke1 *message.KE1,
+	record *ClientRecord,
+	options ...GenerateKE2Options,
+) (*message.KE2, []byte, error) {
+	ke2, err := s.GenerateKE2(ke1, record, options...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return ke2, s.SerializeState(), nil
+}
+
+// GenerateKE2WithLegacyServerKey is like GenerateKE2, but responds with the legacy AKE key pair set with
+// SetLegacyServerKey, for records registered under the server's previous key during a key rotation window. The
+// legacyPublicKey must be the one set, or ErrNoLegacyServerKey is returned.
+func (s *Server) GenerateKE2WithLegacyServerKey(
+	ke1 *message.KE1,
+	record *ClientRecord,
+	legacyPublicKey []byte,
+	options ...GenerateKE2Options,
+) (*message.KE2, error) {
+	if !s.CanRotateAKEKey() || !bytes.Equal(legacyPublicKey, s.legacyPublicKey) {
+		return nil, ErrNoLegacyServerKey
+	}
+
+	return s.generateKE2WithKeyPair(ke1, record, s.legacySecretKey, s.legacyPublicKey, options, true)
+}
+
+// checkReplay records the KE1's client nonce in the client nonce store, if any, and returns ErrReplayedKE1 if it was
+// already seen. It must only be called once the KE1, the record, and the options are validated, so that a login
+// failing for another reason doesn't burn the client nonce for a legitimate retry, nor fill the store.
+func (s *Server) checkReplay(ke1 *message.KE1) error {
+	if s.clientNonces != nil && ke1 != nil && s.clientNonces.Seen(ke1.ClientNonce) {
+		return ErrReplayedKE1
+	}
+
+	return nil
+}
+
+func (s *Server) generateKE2(
+	ke1 *message.KE1,
+	record *ClientRecord,
+	options []GenerateKE2Options,
+	replayCheck bool,
 ) (*message.KE2, error) {
 	if s.keyMaterial == nil {
 		return nil, ErrNoServerKeyMaterial
 	}
 
-	if len(record.Envelope) != s.conf.EnvelopeSize {
-		return nil, ErrInvalidEnvelopeLength
+	return s.generateKE2WithKeyPair(ke1, record, s.serverSecretKey, s.serverPublicKey, options, replayCheck)
+}
+
+// generateKE2WithKeyPair responds to the KE1 message with the given server key pair, which must be set in the key
+// material. If replayCheck is set, the KE1's client nonce is checked against the client nonce store once the inputs
+// are validated.
+func (s *Server) generateKE2WithKeyPair(
+	ke1 *message.KE1,
+	record *ClientRecord,
+	serverSecretKey *ecc.Scalar,
+	serverPublicKey []byte,
+	options []GenerateKE2Options,
+	replayCheck bool,
+) (*message.KE2, error) {
+	record, err := s.checkKE2Input(ke1, record, options)
+	if err != nil {
+		return nil, err
+	}
+
+	if replayCheck {
+		if err = s.checkReplay(ke1); err != nil {
+			return nil, err
+		}
 	}
 
 	// We've checked that the server's public key and the client's envelope are of correct length,
 	// thus ensuring that the subsequent xor-ing input is the same length as the encryption pad.
 
-	op, maskingNonce := getGenerateKE2Options(options)
+	oprfSeed := s.oprfSeed
+	if record.PreviousOPRFSeed {
+		oprfSeed = s.previousOPRFSeed
+	}
 
-	response := s.credentialResponse(ke1.CredentialRequest, s.serverPublicKey,
-		record.RegistrationRecord, record.CredentialIdentifier, s.oprfSeed, maskingNonce)
+	op, maskingNonce := getGenerateKE2Options(options, s.conf.NonceLen)
+
+	var associatedData []byte
+	if len(options) != 0 {
+		associatedData = options[0].AssociatedData
+	}
+
+	response, err := s.credentialResponse(ke1.CredentialRequest, serverPublicKey,
+		record.RegistrationRecord, record.CredentialIdentifier, oprfSeed, maskingNonce)
+	if err != nil {
+		return nil, err
+	}
 
 	identities := ake.Identities{
 		ClientIdentity: record.ClientIdentity,
 		ServerIdentity: s.serverIdentity,
+		AssociatedData: associatedData,
 	}
-	identities.SetIdentities(record.PublicKey, s.serverPublicKey)
+	identities.SetIdentities(record.PublicKey, serverPublicKey)
 
-	ke2 := s.Ake.Response(s.conf, &identities, s.serverSecretKey, record.PublicKey, ke1, response, *op)
+	ke2, err := s.Ake.Response(s.conf, &identities, serverSecretKey, record.PublicKey, ke1, response, *op)
+	if err != nil {
+		return nil, err
+	}
 
 	return ke2, nil
 }
 
+// checkKE2Input validates the KE1 message, the client record, and the options, and returns the record to respond with,
+// using the masking key of the options if set.
+func (s *Server) checkKE2Input(
+	ke1 *message.KE1,
+	record *ClientRecord,
+	options []GenerateKE2Options,
+) (*ClientRecord, error) {
+	if record == nil {
+		return nil, message.ErrNilRecord
+	}
+
+	if record.RegistrationRecord == nil {
+		return nil, fmt.Errorf("%w: %w", ErrMalformedRecord, message.ErrNilRecord)
+	}
+
+	if len(options) != 0 && options[0].MaskingKey != nil {
+		record = withMaskingKey(record, options[0].MaskingKey)
+	}
+
+	if err := record.Validate(s.conf); err != nil {
+		return nil, err
+	}
+
+	if len(record.ClientIdentity) > s.conf.MaxIdentityLength() {
+		return nil, ErrIdentityTooLong
+	}
+
+	if ke1 != nil && ke1.CredentialRequest == nil {
+		return nil, ErrMalformedKE1
+	}
+
+	// The record's public key is already checked against the identity element by Validate.
+	if ke1 == nil || ke1.ClientPublicKeyshare == nil || ke1.ClientPublicKeyshare.Group() != s.conf.Group ||
+		ke1.ClientPublicKeyshare.IsIdentity() {
+		return nil, ErrInvalidClientKeyShare
+	}
+
+	if ke1.BlindedMessage == nil || ke1.BlindedMessage.Group() != s.conf.OPRF.Group() ||
+		ke1.BlindedMessage.IsIdentity() {
+		return nil, ErrInvalidBlindedMessage
+	}
+
+	if s.conf.KEM != internal.NoKEM && len(ke1.KEMEncapsulationKey) != s.conf.KEM.EncapsulationKeyLength() {
+		return nil, ErrInvalidKEMKeyShare
+	}
+
+	if record.PreviousOPRFSeed && s.previousOPRFSeed == nil {
+		return nil, ErrNoPreviousOPRFSeed
+	}
+
+	if len(options) != 0 && len(options[0].AssociatedData) > s.conf.MaxIdentityLength() {
+		return nil, ErrAssociatedDataTooLong
+	}
+
+	return record, nil
+}
+
+// withMaskingKey returns a shallow copy of the record using the given masking key, leaving the original untouched.
+func withMaskingKey(record *ClientRecord, maskingKey []byte) *ClientRecord {
+	if record.RegistrationRecord == nil {
+		return record
+	}
+
+	registrationRecord := *record.RegistrationRecord
+	registrationRecord.MaskingKey = maskingKey
+	r := *record
+	r.RegistrationRecord = &registrationRecord
+
+	return &r
+}
+
+// GenerateKE2Multi responds to the same KE1 message with one KE2 message per client record, e.g. for an account with
+// several credential identifiers, and lets the client pick. Each KE2 has its own AKE nonce, ephemeral key share, and
+// masking nonce, so the options can only set AKENonceLength, and ErrFixedMultiKE2Values is returned otherwise. Each
+// login has its own AKE state: the states are returned in the same order as the KE2 messages, to be set with
+// SetAKEState() on a flushed server before calling LoginFinish() with the client's KE3. The server's own AKE state is
+// flushed. A masking key can't be set in the options either, as it is specific to each record.
+func (s *Server) GenerateKE2Multi(
+	ke1 *message.KE1,
+	records []*ClientRecord,
+	options ...GenerateKE2Options,
+) ([]*message.KE2, [][]byte, error) {
+	if len(options) != 0 &&
+		(options[0].KeyShareSeed != nil || options[0].AKENonce != nil || options[0].MaskingNonce != nil ||
+			options[0].MaskingKey != nil) {
+		return nil, nil, ErrFixedMultiKE2Values
+	}
+
+	if s.keyMaterial == nil {
+		return nil, nil, ErrNoServerKeyMaterial
+	}
+
+	// All records are validated before the KE1 is checked for replays, which it is only once for all records.
+	for i, record := range records {
+		if _, err := s.checkKE2Input(ke1, record, options); err != nil {
+			return nil, nil, fmt.Errorf("record %d: %w", i, err)
+		}
+	}
+
+	if err := s.checkReplay(ke1); err != nil {
+		return nil, nil, err
+	}
+
+	ke2s := make([]*message.KE2, len(records))
+	states := make([][]byte, len(records))
+
+	defer s.Ake.Flush()
+
+	for i, record := range records {
+		s.Ake.Flush()
+
+		ke2, err := s.generateKE2(ke1, record, options, false)
+		if err != nil {
+			return nil, nil, fmt.Errorf("record %d: %w", i, err)
+		}
+
+		ke2s[i] = ke2
+		states[i] = s.SerializeState()
+	}
+
+	return ke2s, states, nil
+}
+
+// fakeRecord returns a fake client record deterministically derived from the server's secret fake record seed and the
+// credential identifier, so that repeated requests for the same credential identifier get consistent responses.
+func (s *Server) fakeRecord(credentialIdentifier []byte) *ClientRecord {
+	seed := s.conf.KDF.Expand(
+		s.fakeRecordSeed,
+		encoding.SuffixString(credentialIdentifier, tag.FakeClientKey),
+		internal.SeedLength,
+	)
+	_, publicKey := oprf.IDFromGroup(s.conf.Group).DeriveKeyPair(seed, []byte(tag.DeriveDiffieHellmanKeyPair))
+	maskingKey := s.conf.KDF.Expand(
+		s.fakeRecordSeed,
+		encoding.SuffixString(credentialIdentifier, tag.FakeMaskingKey),
+		s.conf.KDF.Size(),
+	)
+
+	return &ClientRecord{
+		RegistrationRecord: &message.RegistrationRecord{
+			PublicKey:  publicKey,
+			MaskingKey: maskingKey,
+			Envelope:   make([]byte, s.conf.EnvelopeSize),
+		},
+		CredentialIdentifier: credentialIdentifier,
+		ClientIdentity:       nil,
+		PreviousOPRFSeed:     false,
+	}
+}
+
+// GenerateFakeKE2 responds to a KE1 message for a credential identifier that has no registered record with a KE2
+// message of the same size, produced with the same OPRF, masking, and AKE operations as GenerateKE2, so that client
+// enumeration is mitigated. The fake record is derived from a secret seed expanded from the OPRF seed, and the
+// subsequent call to LoginFinish will always fail.
+func (s *Server) GenerateFakeKE2(
+	ke1 *message.KE1,
+	credentialIdentifier []byte,
+	options ...GenerateKE2Options,
+) (*message.KE2, error) {
+	if s.keyMaterial == nil {
+		return nil, ErrNoServerKeyMaterial
+	}
+
+	return s.GenerateKE2(ke1, s.fakeRecord(credentialIdentifier), options...)
+}
+
+// DummyLogin is like GenerateFakeKE2, for when the server doesn't want to look up or disclose the credential identifier
+// at all, e.g. for a request rejected by a rate limiter: it runs the same OPRF, masking, and AKE operations as
+// GenerateKE2, with a fake record derived from the server's secret fake record seed and the KE1's blinded message, so
+// that the KE2 has the size and cost of a real one, and the subsequent call to LoginFinish will always fail. A
+// retransmitted KE1 gets consistent responses, but an active attacker comparing the OPRF outputs of two logins with
+// different blinds can tell it apart from a registered user, so GenerateFakeKE2 should be preferred when the
+// credential identifier is known.
+func (s *Server) DummyLogin(ke1 *message.KE1, options ...GenerateKE2Options) (*message.KE2, error) {
+	if s.keyMaterial == nil {
+		return nil, ErrNoServerKeyMaterial
+	}
+
+	if ke1 == nil || ke1.CredentialRequest == nil {
+		return nil, ErrMalformedKE1
+	}
+
+	if ke1.BlindedMessage == nil {
+		return nil, ErrInvalidBlindedMessage
+	}
+
+	credentialIdentifier := encoding.SuffixString(ke1.BlindedMessage.Encode(), tag.DummyLogin)
+
+	return s.GenerateKE2(ke1, s.fakeRecord(credentialIdentifier), options...)
+}
+
+// VerifyKE3 returns whether the KE3 received from the client holds the expected MAC for the current login, without
+// altering the server's state, e.g. to deduplicate KE3 retransmissions before the login is finished. It returns false
+// if there's no login in progress. LoginFinish remains the authoritative call to finish the login.
+func (s *Server) VerifyKE3(ke3 *message.KE3) bool {
+	if ke3 == nil || len(s.Ake.ExpectedMAC()) == 0 {
+		return false
+	}
+
+	return s.Ake.Finalize(s.conf, ke3)
+}
+
 // LoginFinish returns an error if the KE3 received from the client holds an invalid mac, and nil if correct.
 func (s *Server) LoginFinish(ke3 *message.KE3) error {
 	if !s.Ake.Finalize(s.conf, ke3) {
@@ -240,11 +1083,52 @@ func (s *Server) LoginFinish(ke3 *message.KE3) error {
 	return nil
 }
 
+// Login runs a full server-side login in a single call, for synchronous flows where the KE3 can be obtained in-process:
+// it responds to the KE1 with a KE2 message, passes it to ke3provider to obtain the client's KE3, and finalizes the
+// login, returning the session key on success. This removes the need to retain the AKE state between GenerateKE2 and
+// LoginFinish.
+func (s *Server) Login(
+	ke1 *message.KE1,
+	ke3provider func(ke2 *message.KE2) (*message.KE3, error),
+	record *ClientRecord,
+	options ...GenerateKE2Options,
+) (sessionKey []byte, err error) {
+	ke2, err := s.GenerateKE2(ke1, record, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	ke3, err := ke3provider(ke2)
+	if err != nil {
+		s.Ake.Flush()
+		return nil, fmt.Errorf("obtaining KE3: %w", err)
+	}
+
+	if ke3 == nil {
+		s.Ake.Flush()
+		return nil, ErrNoKE3
+	}
+
+	if err = s.LoginFinish(ke3); err != nil {
+		s.Ake.Flush()
+		return nil, err
+	}
+
+	return s.SessionKey(), nil
+}
+
 // SessionKey returns the session key if the previous call to GenerateKE2() was successful.
 func (s *Server) SessionKey() []byte {
 	return s.Ake.SessionKey()
 }
 
+// TranscriptHash returns the hash of the handshake preamble, binding the session to its transcript, if the previous call
+// to GenerateKE2() was successful. It can be logged for audit purposes, and matches the client's after a successful
+// login. It is not part of the serialized AKE state.
+func (s *Server) TranscriptHash() []byte {
+	return s.Ake.TranscriptHash()
+}
+
 // ExpectedMAC returns the expected client MAC if the previous call to GenerateKE2() was successful.
 func (s *Server) ExpectedMAC() []byte {
 	return s.Ake.ExpectedMAC()
@@ -267,3 +1151,73 @@ func (s *Server) SetAKEState(state []byte) error {
 func (s *Server) SerializeState() []byte {
 	return s.Ake.SerializeState()
 }
+
+func (s *Server) sealedStateAD() []byte {
+	return encoding.Concat([]byte(tag.SealedState), s.conf.EncodeIdentity(s.conf.Context))
+}
+
+// SerializeStateSealed returns the internal state of the AKE server encrypted and authenticated with AES-256-GCM under
+// the 32-byte key, so that it can be shipped to another server instance without leaking the session secret. The key
+// must be secret and shared only among server instances.
+func (s *Server) SerializeStateSealed(key []byte) ([]byte, error) {
+	sealed, err := internal.Seal(key, s.SerializeState(), s.sealedStateAD())
+	if err != nil {
+		return nil, fmt.Errorf("sealing AKE state: %w", err)
+	}
+
+	return sealed, nil
+}
+
+// minStateTagKeyLength is the minimum length of the key used to tag AKE states.
+const minStateTagKeyLength = 32
+
+func (s *Server) stateTag(key, state []byte) []byte {
+	return s.conf.MAC.MAC(key, encoding.Concat3([]byte(tag.TaggedState), s.conf.EncodeIdentity(s.conf.Context), state))
+}
+
+// SerializeStateTagged returns the internal state of the AKE server followed by a MAC over it under the key, which must
+// be secret and at least 32 bytes long. Unlike SerializeStateSealed, the state is not encrypted: this only detects
+// storage corruption or tampering early, in SetAKEStateTagged, instead of as a failed LoginFinish.
+func (s *Server) SerializeStateTagged(key []byte) ([]byte, error) {
+	if len(key) < minStateTagKeyLength {
+		return nil, ErrInvalidSealKey
+	}
+
+	state := s.SerializeState()
+
+	return encoding.Concat(state, s.stateTag(key, state)), nil
+}
+
+// SetAKEStateTagged verifies in constant time the MAC of a state produced by SerializeStateTagged under the same key,
+// and sets it as the internal state of the AKE server. It returns ErrStateIntegrity if the verification fails.
+func (s *Server) SetAKEStateTagged(key, state []byte) error {
+	if len(key) < minStateTagKeyLength {
+		return ErrInvalidSealKey
+	}
+
+	if len(state) != s.conf.MAC.Size()+s.conf.KDF.Size()+s.conf.MAC.Size() {
+		return ErrStateIntegrity
+	}
+
+	split := len(state) - s.conf.MAC.Size()
+	if !s.conf.MAC.Equal(s.stateTag(key, state[:split]), state[split:]) {
+		return ErrStateIntegrity
+	}
+
+	return s.SetAKEState(state[:split])
+}
+
+// SetAKEStateSealed decrypts a state produced by SerializeStateSealed under the same key, and sets it as the internal
+// state of the AKE server. It returns ErrStateAuthentication if the token was tampered with or the key is wrong.
+func (s *Server) SetAKEStateSealed(key, token []byte) error {
+	state, err := internal.Open(key, token, s.sealedStateAD())
+	if err != nil {
+		if errors.Is(err, internal.ErrSealOpen) {
+			return ErrStateAuthentication
+		}
+
+		return fmt.Errorf("opening AKE state: %w", err)
+	}
+
+	return s.SetAKEState(state)
+}
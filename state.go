@@ -0,0 +1,113 @@
+// SPDX-License-Identifier: MIT
+//
+// Copyright (C) 2020-2025 Daniel Bourdrez. All Rights Reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree or at
+// https://spdx.org/licenses/MIT.html
+
+package opaque
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/bytemare/opaque/internal/encoding"
+)
+
+// errNoServerState happens when ExportState is called without a previous successful call to GenerateKE2.
+var errNoServerState = errors.New("no AKE state to export: GenerateKE2 must succeed first")
+
+// ServerState is the typed AKE state of a Server between GenerateKE2 and LoginFinish, i.e. the client MAC expected in
+// KE3 and the session secret. Like the raw state of SerializeState, it holds the session secret in clear, and must be
+// protected accordingly.
+type ServerState struct {
+	ClientMac     []byte `json:"clientMac"`
+	SessionSecret []byte `json:"sessionSecret"`
+}
+
+// MarshalBinary returns the 2-byte length-prefixed client MAC and session secret.
+func (s *ServerState) MarshalBinary() ([]byte, error) {
+	// The components are encoded with the same 2-byte length prefix as identities.
+	if len(s.ClientMac) > maxIdentityLength || len(s.SessionSecret) > maxIdentityLength {
+		return nil, ErrInvalidState
+	}
+
+	return encoding.Concat(encoding.EncodeVector(s.ClientMac), encoding.EncodeVector(s.SessionSecret)), nil
+}
+
+// UnmarshalBinary decodes the output of MarshalBinary into s, and returns ErrInvalidState if a component is empty.
+// Since a custom MAC or KDF can have any output length, the exact component lengths can only be validated against a
+// configuration, which Server.UnmarshalState and Server.ImportState do.
+func (s *ServerState) UnmarshalBinary(data []byte) error {
+	clientMac, offset, err := encoding.DecodeVector(data)
+	if err != nil {
+		return fmt.Errorf("%w: decoding the client MAC: %w", ErrInvalidState, err)
+	}
+
+	sessionSecret, o, err := encoding.DecodeVector(data[offset:])
+	if err != nil {
+		return fmt.Errorf("%w: decoding the session secret: %w", ErrInvalidState, err)
+	}
+
+	if offset+o != len(data) {
+		return ErrTrailingBytes
+	}
+
+	if len(clientMac) == 0 || len(sessionSecret) == 0 {
+		return ErrInvalidState
+	}
+
+	s.ClientMac = slices.Clone(clientMac)
+	s.SessionSecret = slices.Clone(sessionSecret)
+
+	return nil
+}
+
+// ExportState returns a copy of the server's AKE state after a successful call to GenerateKE2, e.g. to persist it
+// until the client's KE3 arrives.
+func (s *Server) ExportState() (*ServerState, error) {
+	if len(s.Ake.ExpectedMAC()) == 0 || len(s.Ake.SessionKey()) == 0 {
+		return nil, errNoServerState
+	}
+
+	return &ServerState{
+		ClientMac:     slices.Clone(s.Ake.ExpectedMAC()),
+		SessionSecret: slices.Clone(s.Ake.SessionKey()),
+	}, nil
+}
+
+// UnmarshalState decodes the output of ServerState.MarshalBinary, and returns ErrInvalidState if a component is not of
+// the length of the configuration's MAC or KDF output.
+func (s *Server) UnmarshalState(data []byte) (*ServerState, error) {
+	state := new(ServerState)
+	if err := state.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+
+	if !s.validState(state) {
+		return nil, ErrInvalidState
+	}
+
+	return state, nil
+}
+
+// validState returns whether the state's components are of the configuration's MAC and KDF output lengths.
+func (s *Server) validState(state *ServerState) bool {
+	return state != nil && len(state.ClientMac) == s.conf.MAC.Size() && len(state.SessionSecret) == s.conf.KDF.Size()
+}
+
+// ImportState validates the component lengths of the state against the configuration, and sets it as the server's AKE
+// state, on a server without a current state. It returns ErrInvalidState if a component is not of the right length.
+func (s *Server) ImportState(state *ServerState) error {
+	if !s.validState(state) {
+		return ErrInvalidState
+	}
+
+	if err := s.Ake.SetState(slices.Clone(state.ClientMac), slices.Clone(state.SessionSecret)); err != nil {
+		return fmt.Errorf("setting AKE state: %w", err)
+	}
+
+	return nil
+}
@@ -9,11 +9,16 @@
 package opaque_test
 
 import (
+	"bytes"
+	"context"
 	"crypto"
 	"encoding/hex"
+	"errors"
 	"log"
+	"slices"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/bytemare/ksf"
 
@@ -21,6 +26,7 @@ import (
 	"github.com/bytemare/opaque/internal"
 	"github.com/bytemare/opaque/internal/encoding"
 	"github.com/bytemare/opaque/internal/tag"
+	"github.com/bytemare/opaque/message"
 )
 
 /*
@@ -52,7 +58,7 @@ func TestClientRegistrationFinalize_InvalidPks(t *testing.T) {
 		if err = pk.Decode(pks); err != nil {
 			panic(err)
 		}
-		r2 := server.RegistrationResponse(r1, pk, credID, oprfSeed)
+		r2, _ := server.RegistrationResponse(r1, pk, credID, oprfSeed)
 
 		// message length
 		badr2 := internal.RandomBytes(15)
@@ -149,11 +155,25 @@ func TestClientFinish_BadMaskedResponse(t *testing.T) {
 			t.Fatalf("expected error for short response - got %v", err)
 		}
 
+		if !errors.Is(err, opaque.ErrInvalidMaskedResponse) {
+			t.Fatalf("expected %q, got %v", opaque.ErrInvalidMaskedResponse, err)
+		}
+
 		// too long
 		ke2.MaskedResponse = internal.RandomBytes(goodLength + 1)
 		if _, _, err = client.GenerateKE3(ke2); err == nil || !strings.HasPrefix(err.Error(), expected) {
 			t.Fatalf("expected error for long response - got %v", err)
 		}
+
+		if !errors.Is(err, opaque.ErrInvalidMaskedResponse) {
+			t.Fatalf("expected %q, got %v", opaque.ErrInvalidMaskedResponse, err)
+		}
+
+		// empty
+		ke2.MaskedResponse = nil
+		if _, _, err = client.GenerateKE3(ke2); !errors.Is(err, opaque.ErrInvalidMaskedResponse) {
+			t.Fatalf("expected %q, got %v", opaque.ErrInvalidMaskedResponse, err)
+		}
 	})
 }
 
@@ -424,3 +444,1206 @@ func TestClientPRK(t *testing.T) {
 		})
 	}
 }
+
+func TestClientResumeLogin(t *testing.T) {
+	password := []byte("password")
+
+	testAll(t, func(t2 *testing.T, conf *configuration) {
+		client, _ := conf.conf.Client()
+		server, _ := conf.conf.Server()
+		sks, pks := conf.conf.KeyGen()
+		oprfSeed := conf.conf.GenerateOPRFSeed()
+		record := buildRecord(internal.RandomBytes(32), oprfSeed, password, pks, client, server)
+
+		if err := server.SetKeyMaterial(nil, sks, pks, oprfSeed); err != nil {
+			t.Fatal(err)
+		}
+
+		client, _ = conf.conf.Client()
+		if _, err := client.SuspendLogin(); err == nil {
+			t.Fatal("expected error when suspending before GenerateKE3")
+		}
+
+		ke1 := client.GenerateKE1(password)
+
+		ke2, err := server.GenerateKE2(ke1, record)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ke3, exportKey, err := client.GenerateKE3(ke2)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		state, err := client.SuspendLogin()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The KE3 never made it to the server. Resume on a new client instance.
+		resumed, _ := conf.conf.Client()
+
+		ke3b, exportKeyB, err := resumed.ResumeLogin(state)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(ke3.Serialize(), ke3b.Serialize()) {
+			t.Fatal("expected identical KE3 messages")
+		}
+
+		if !bytes.Equal(exportKey, exportKeyB) {
+			t.Fatal("expected identical export keys")
+		}
+
+		if err = server.LoginFinish(ke3b); err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(server.SessionKey(), resumed.SessionKey()) {
+			t.Fatal("expected identical session keys")
+		}
+
+		if _, _, err = resumed.ResumeLogin(state[:len(state)-1]); err == nil {
+			t.Fatal("expected error on truncated state")
+		}
+
+		if _, _, err = resumed.ResumeLogin(append(state, 0)); err == nil {
+			t.Fatal("expected error on trailing bytes")
+		}
+
+		// The state only holds the KE3 message, the session key, and the export key.
+		expected := encoding.Concatenate(
+			encoding.EncodeVector(ke3.Serialize()),
+			encoding.EncodeVector(server.SessionKey()),
+			encoding.EncodeVector(exportKey),
+		)
+		if !bytes.Equal(state, expected) {
+			t.Fatal("unexpected resumption state")
+		}
+
+		short := encoding.Concatenate(
+			encoding.EncodeVector(ke3.Serialize()),
+			encoding.EncodeVector(server.SessionKey()[1:]),
+			encoding.EncodeVector(exportKey),
+		)
+		if _, _, err = resumed.ResumeLogin(short); err == nil {
+			t.Fatal("expected error on short session key")
+		}
+
+		// A failed login can't be suspended.
+		client, _ = conf.conf.Client()
+		ke2, err = server.GenerateKE2(client.GenerateKE1([]byte("wrong password")), record)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, _, err = client.GenerateKE3(ke2); err == nil {
+			t.Fatal("expected error on wrong password")
+		}
+
+		if _, err = client.SuspendLogin(); err == nil {
+			t.Fatal("expected error when suspending a failed login")
+		}
+	})
+}
+
+func TestClientExportKey(t *testing.T) {
+	testAll(t, func(t2 *testing.T, conf *configuration) {
+		client, _ := conf.conf.Client()
+		server, _ := conf.conf.Server()
+		sks, pks := conf.conf.KeyGen()
+		oprfSeed := conf.conf.GenerateOPRFSeed()
+
+		if client.ExportKey() != nil {
+			t.Fatal("expected nil export key before registration")
+		}
+
+		record := buildRecord(internal.RandomBytes(32), oprfSeed, []byte("yo"), pks, client, server)
+		registrationExportKey := client.ExportKey()
+
+		if len(registrationExportKey) == 0 {
+			t.Fatal("expected export key after registration")
+		}
+
+		if err := server.SetKeyMaterial(nil, sks, pks, oprfSeed); err != nil {
+			t.Fatal(err)
+		}
+
+		client, _ = conf.conf.Client()
+		ke2, err := server.GenerateKE2(client.GenerateKE1([]byte("yo")), record)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, _, err = client.GenerateKE3(ke2); err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(registrationExportKey, client.ExportKey()) {
+			t.Fatal("registration and login export keys differ")
+		}
+
+		// A failed login resets the export key.
+		client, _ = conf.conf.Client()
+		ke2, _ = server.GenerateKE2(client.GenerateKE1([]byte("wrong")), record)
+
+		if _, _, err = client.GenerateKE3(ke2); err == nil {
+			t.Fatal("expected error on wrong password")
+		}
+
+		if client.ExportKey() != nil {
+			t.Fatal("expected nil export key after failed login")
+		}
+	})
+}
+
+func TestClientRegistrationFinalizeWithIdentities(t *testing.T) {
+	testAll(t, func(t2 *testing.T, conf *configuration) {
+		client, err := conf.conf.Client()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		server, err := conf.conf.Server()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		_, pks := conf.conf.KeyGen()
+		pk := server.GetConf().Group.NewElement()
+		if err = pk.Decode(pks); err != nil {
+			t2.Fatal(err)
+		}
+
+		r1 := client.RegistrationInit([]byte("yo"))
+		r2, _ := server.RegistrationResponse(r1, pk, internal.RandomBytes(32), conf.conf.GenerateOPRFSeed())
+
+		// Matching key.
+		record, exportKey, err := client.RegistrationFinalizeWithIdentities(r2, pks)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		if record == nil || len(exportKey) == 0 {
+			t2.Fatal("expected a record and an export key")
+		}
+
+		// Substituted key.
+		_, otherPks := conf.conf.KeyGen()
+		if _, _, err = client.RegistrationFinalizeWithIdentities(r2, otherPks); !errors.Is(
+			err,
+			opaque.ErrServerPublicKeyMismatch,
+		) {
+			t2.Fatalf("expected %q, got %v", opaque.ErrServerPublicKeyMismatch, err)
+		}
+
+		// Invalid expected key encoding.
+		if _, _, err = client.RegistrationFinalizeWithIdentities(r2, getBadElement(t2, conf)); err == nil {
+			t2.Fatal("expected error on invalid expected server public key")
+		}
+	})
+}
+
+func TestClientContextCancellation(t *testing.T) {
+	conf := opaque.DefaultConfiguration()
+
+	regClient, err := conf.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server, err := conf.Server()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	password := []byte("password")
+	sks, pks := conf.KeyGen()
+	oprfSeed := conf.GenerateOPRFSeed()
+	record := buildRecord(internal.RandomBytes(32), oprfSeed, password, pks, regClient, server)
+
+	if err = server.SetKeyMaterial(nil, sks, pks, oprfSeed); err != nil {
+		t.Fatal(err)
+	}
+
+	// login returns a new client and the server's response to its KE1.
+	login := func() (*opaque.Client, *message.KE2) {
+		client, err := conf.Client()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ke2, err := server.GenerateKE2(client.GenerateKE1(password), record)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return client, ke2
+	}
+
+	// A background context behaves like the plain API.
+	client, ke2 := login()
+	if _, _, err := client.GenerateKE3Context(context.Background(), ke2); err != nil {
+		t.Fatal(err)
+	}
+
+	// An already cancelled context returns immediately.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	client, ke2 = login()
+	if _, _, err := client.GenerateKE3Context(ctx, ke2); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected %q, got %v", context.Canceled, err)
+	}
+
+	// Cancelling mid-stretch, with expensive KSF parameters.
+	expensive := opaque.GenerateKE3Options{KSFParameters: []int{50, 64 * 1024, 1}}
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	client, ke2 = login()
+	if _, _, err := client.GenerateKE3Context(ctx, ke2, expensive); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected %q, got %v", context.DeadlineExceeded, err)
+	}
+
+	// Registration.
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	client, err = conf.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r1 := client.RegistrationInit(password)
+	pk, _ := server.Deserialize.DecodeAkePublicKey(pks)
+	r2, _ := server.RegistrationResponse(r1, pk, internal.RandomBytes(32), oprfSeed)
+
+	if _, _, err := client.RegistrationFinalizeContext(ctx, r2, opaque.ClientRegistrationFinalizeOptions{
+		KSFParameters: []int{50, 64 * 1024, 1},
+	}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected %q, got %v", context.DeadlineExceeded, err)
+	}
+
+	client, err = conf.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r1 = client.RegistrationInit(password)
+	r2, _ = server.RegistrationResponse(r1, pk, internal.RandomBytes(32), oprfSeed)
+
+	if record, _, err := client.RegistrationFinalizeContext(context.Background(), r2); err != nil || record == nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+type memoryNonceStore map[string]struct{}
+
+func (m memoryNonceStore) Seen(nonce []byte) bool {
+	if _, ok := m[string(nonce)]; ok {
+		return true
+	}
+
+	m[string(nonce)] = struct{}{}
+
+	return false
+}
+
+func TestClientMaskingNonceReuse(t *testing.T) {
+	testAll(t, func(t2 *testing.T, conf *configuration) {
+		regClient, err := conf.conf.Client()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		server, err := conf.conf.Server()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		password := []byte("password")
+		sks, pks := conf.conf.KeyGen()
+		oprfSeed := conf.conf.GenerateOPRFSeed()
+		record := buildRecord(internal.RandomBytes(32), oprfSeed, password, pks, regClient, server)
+
+		if err = server.SetKeyMaterial(nil, sks, pks, oprfSeed); err != nil {
+			t2.Fatal(err)
+		}
+
+		store := memoryNonceStore{}
+		maskingNonce := internal.RandomBytes(internal.NonceLength)
+
+		login := func() error {
+			defer server.Ake.Flush()
+
+			client, err := conf.conf.Client()
+			if err != nil {
+				t2.Fatal(err)
+			}
+
+			client.SetSeenMaskingNonces(store)
+
+			ke2, err := server.GenerateKE2(client.GenerateKE1(password), record,
+				opaque.GenerateKE2Options{MaskingNonce: maskingNonce})
+			if err != nil {
+				t2.Fatal(err)
+			}
+
+			_, _, err = client.GenerateKE3(ke2)
+
+			return err
+		}
+
+		if err := login(); err != nil {
+			t2.Fatal(err)
+		}
+
+		if err := login(); !errors.Is(err, opaque.ErrMaskingNonceReuse) {
+			t2.Fatalf("expected %q, got %v", opaque.ErrMaskingNonceReuse, err)
+		}
+
+		// Fresh nonces are accepted.
+		maskingNonce = internal.RandomBytes(internal.NonceLength)
+		if err := login(); err != nil {
+			t2.Fatal(err)
+		}
+	})
+}
+
+func TestClient_ExpectedServer(t *testing.T) {
+	testAll(t, func(t2 *testing.T, conf *configuration) {
+		regClient, err := conf.conf.Client()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		server, err := conf.conf.Server()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		password := []byte("password")
+		sks, pks := conf.conf.KeyGen()
+		oprfSeed := conf.conf.GenerateOPRFSeed()
+		record := buildRecord(internal.RandomBytes(32), oprfSeed, password, pks, regClient, server)
+
+		if err = server.SetKeyMaterial(nil, sks, pks, oprfSeed); err != nil {
+			t2.Fatal(err)
+		}
+
+		login := func() (*opaque.Client, *message.KE2) {
+			client, err := conf.conf.Client()
+			if err != nil {
+				t2.Fatal(err)
+			}
+
+			ke2, err := server.GenerateKE2(client.GenerateKE1(password), record)
+			if err != nil {
+				t2.Fatal(err)
+			}
+
+			return client, ke2
+		}
+
+		_, otherPks := conf.conf.KeyGen()
+
+		// Match.
+		for _, options := range []opaque.GenerateKE3Options{
+			{ExpectedServerPublicKey: pks},
+			{ExpectedServerIdentity: pks},
+			{ExpectedServerPublicKey: pks, ExpectedServerIdentity: pks},
+		} {
+			client, ke2 := login()
+			if _, _, err := client.GenerateKE3(ke2, options); err != nil {
+				t2.Fatal(err)
+			}
+
+			server.Ake.Flush()
+		}
+
+		// Mismatch.
+		for _, options := range []opaque.GenerateKE3Options{
+			{ExpectedServerPublicKey: otherPks},
+			{ExpectedServerIdentity: otherPks},
+			{ExpectedServerIdentity: []byte("server")},
+			{ExpectedServerPublicKey: []byte{}},
+		} {
+			client, ke2 := login()
+			if _, _, err := client.GenerateKE3(ke2, options); !errors.Is(err, opaque.ErrServerIdentityMismatch) {
+				t2.Fatalf("expected %q, got %v", opaque.ErrServerIdentityMismatch, err)
+			}
+
+			if client.SessionKey() != nil {
+				t2.Fatal("expected no session key")
+			}
+
+			server.Ake.Flush()
+		}
+	})
+}
+
+func TestClient_ExpectedServerMAC(t *testing.T) {
+	testAll(t, func(t2 *testing.T, conf *configuration) {
+		regClient, err := conf.conf.Client()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		server, err := conf.conf.Server()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		password := []byte("password")
+		sks, pks := conf.conf.KeyGen()
+		oprfSeed := conf.conf.GenerateOPRFSeed()
+		record := buildRecord(internal.RandomBytes(32), oprfSeed, password, pks, regClient, server)
+
+		if err = server.SetKeyMaterial(nil, sks, pks, oprfSeed); err != nil {
+			t2.Fatal(err)
+		}
+
+		login := func() (*opaque.Client, *message.KE2) {
+			client, err := conf.conf.Client()
+			if err != nil {
+				t2.Fatal(err)
+			}
+
+			ke2, err := server.GenerateKE2(client.GenerateKE1(password), record)
+			if err != nil {
+				t2.Fatal(err)
+			}
+
+			return client, ke2
+		}
+
+		// A tampered server MAC is rejected.
+		client, ke2 := login()
+		ke2.ServerMac = bytes.Clone(ke2.ServerMac)
+		ke2.ServerMac[0] ^= 0xff
+
+		if _, _, err := client.GenerateKE3(ke2); err == nil || !strings.Contains(err.Error(), "invalid server mac") {
+			t2.Fatalf("expected invalid server mac error, got %v", err)
+		}
+
+		if client.ExpectedServerMAC() != nil {
+			t2.Fatal("expected no server MAC after a failed login")
+		}
+
+		server.Ake.Flush()
+
+		// The accessor matches the server's emitted MAC.
+		client, ke2 = login()
+		if _, _, err := client.GenerateKE3(ke2); err != nil {
+			t2.Fatal(err)
+		}
+
+		if !bytes.Equal(client.ExpectedServerMAC(), ke2.ServerMac) {
+			t2.Fatal("expected the client's verified server MAC to match KE2")
+		}
+
+		client.Reset()
+
+		if client.ExpectedServerMAC() != nil {
+			t2.Fatal("expected no server MAC after Reset")
+		}
+	})
+}
+
+func TestClient_RegistrationInitWithIdentifier(t *testing.T) {
+	testAll(t, func(t2 *testing.T, conf *configuration) {
+		server, err := conf.conf.Server()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		password := []byte("password")
+		sks, pks := conf.conf.KeyGen()
+		oprfSeed := conf.conf.GenerateOPRFSeed()
+
+		if err = server.SetKeyMaterial(nil, sks, pks, oprfSeed); err != nil {
+			t2.Fatal(err)
+		}
+
+		client, err := conf.conf.Client()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		credID := []byte("client chosen identifier")
+
+		blob, err := client.RegistrationInitWithIdentifier(password, credID)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		request, identifier, err := server.Deserialize.RegistrationRequestWithIdentifier(blob)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		if !bytes.Equal(identifier, credID) {
+			t2.Fatalf("expected identifier %q, got %q", credID, identifier)
+		}
+
+		pk, err := server.Deserialize.DecodeAkePublicKey(pks)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		response, _ := server.RegistrationResponse(request, pk, identifier, oprfSeed)
+		upload, _ := client.RegistrationFinalize(response)
+		record := &opaque.ClientRecord{
+			RegistrationRecord:   upload,
+			CredentialIdentifier: identifier,
+			ClientIdentity:       nil,
+			PreviousOPRFSeed:     false,
+		}
+
+		// The identifier survives to the server record, with which the client can log in.
+		client, err = conf.conf.Client()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		ke2, err := server.GenerateKE2(client.GenerateKE1(password), record)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		if _, _, err = client.GenerateKE3(ke2); err != nil {
+			t2.Fatal(err)
+		}
+
+		// Errors.
+		if _, err = client.RegistrationInitWithIdentifier(password, make([]byte, 1<<16)); !errors.Is(
+			err, opaque.ErrCredentialIdentifierTooLong) {
+			t2.Fatalf("expected %q, got %v", opaque.ErrCredentialIdentifierTooLong, err)
+		}
+
+		if _, _, err = server.Deserialize.RegistrationRequestWithIdentifier(append(blob, 0)); !errors.Is(
+			err, opaque.ErrTrailingBytes) {
+			t2.Fatalf("expected %q, got %v", opaque.ErrTrailingBytes, err)
+		}
+
+		if _, _, err = server.Deserialize.RegistrationRequestWithIdentifier(blob[:len(blob)-1]); err == nil {
+			t2.Fatal("expected error on truncated identifier")
+		}
+
+		if _, _, err = server.Deserialize.RegistrationRequestWithIdentifier(blob[:2]); err == nil {
+			t2.Fatal("expected error on short request")
+		}
+	})
+}
+
+func TestClient_RegistrationUpload(t *testing.T) {
+	testAll(t, func(t2 *testing.T, conf *configuration) {
+		server, err := conf.conf.Server()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		password := []byte("password")
+		sks, pks := conf.conf.KeyGen()
+		oprfSeed := conf.conf.GenerateOPRFSeed()
+
+		if err = server.SetKeyMaterial(nil, sks, pks, oprfSeed); err != nil {
+			t2.Fatal(err)
+		}
+
+		client, err := conf.conf.Client()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		credID := []byte("client")
+
+		// First phase: the request only.
+		request := client.RegistrationInit(password)
+
+		blob, err := client.RegistrationUpload(request, nil)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		gotRequest, gotRecord, err := server.Deserialize.RegistrationUpload(blob)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		if gotRecord != nil {
+			t2.Fatal("expected no record in the first phase")
+		}
+
+		if !bytes.Equal(gotRequest.Serialize(), request.Serialize()) {
+			t2.Fatal("expected the same registration request")
+		}
+
+		pk, err := server.Deserialize.DecodeAkePublicKey(pks)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		response, err := server.RegistrationResponse(gotRequest, pk, credID, oprfSeed)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		// Second phase: the record only.
+		record, _ := client.RegistrationFinalize(response)
+
+		if blob, err = client.RegistrationUpload(nil, record); err != nil {
+			t2.Fatal(err)
+		}
+
+		if gotRequest, gotRecord, err = server.Deserialize.RegistrationUpload(blob); err != nil {
+			t2.Fatal(err)
+		}
+
+		if gotRequest != nil {
+			t2.Fatal("expected no request in the second phase")
+		}
+
+		if !bytes.Equal(gotRecord.Serialize(), record.Serialize()) {
+			t2.Fatal("expected the same registration record")
+		}
+
+		// Both messages.
+		if blob, err = client.RegistrationUpload(request, record); err != nil {
+			t2.Fatal(err)
+		}
+
+		if gotRequest, gotRecord, err = server.Deserialize.RegistrationUpload(blob); err != nil {
+			t2.Fatal(err)
+		}
+
+		if !bytes.Equal(gotRequest.Serialize(), request.Serialize()) ||
+			!bytes.Equal(gotRecord.Serialize(), record.Serialize()) {
+			t2.Fatal("expected the same registration request and record")
+		}
+
+		// Errors.
+		if _, err = client.RegistrationUpload(nil, nil); !errors.Is(err, opaque.ErrEmptyRegistrationUpload) {
+			t2.Fatalf("expected %q, got %v", opaque.ErrEmptyRegistrationUpload, err)
+		}
+
+		if _, _, err = server.Deserialize.RegistrationUpload([]byte{0, 0, 0, 0}); !errors.Is(
+			err, opaque.ErrEmptyRegistrationUpload) {
+			t2.Fatalf("expected %q, got %v", opaque.ErrEmptyRegistrationUpload, err)
+		}
+
+		if _, _, err = server.Deserialize.RegistrationUpload(append(blob, 0)); !errors.Is(
+			err, opaque.ErrTrailingBytes) {
+			t2.Fatalf("expected %q, got %v", opaque.ErrTrailingBytes, err)
+		}
+
+		if _, _, err = server.Deserialize.RegistrationUpload(blob[:len(blob)-1]); err == nil {
+			t2.Fatal("expected error on truncated container")
+		}
+
+		if _, _, err = server.Deserialize.RegistrationUpload(blob[:1]); err == nil {
+			t2.Fatal("expected error on short container")
+		}
+	})
+}
+
+func TestClient_RegistrationEnvelopeNonce(t *testing.T) {
+	conf := opaque.DefaultConfiguration()
+	blind := conf.OPRF.Group().HashToScalar([]byte("blind"), []byte("test"))
+	nonce := bytes.Repeat([]byte{3}, 32)
+	oprfSeed := bytes.Repeat([]byte{4}, conf.Hash.Size())
+	credID := []byte("client")
+
+	_, pks, err := conf.DeriveKeyPair(bytes.Repeat([]byte{5}, 32), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server, err := conf.Server()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pk, err := server.Deserialize.DecodeAkePublicKey(pks)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected, _ := hex.DecodeString("ee892d8b7bb7a081c25f979d2019fac1c53e60db8c66eb344027eed375f7c115" +
+		"a2bb18f4fe7362843ff9728435d8604cb7d131bff981e386237e7717ab655001" +
+		"a18880f4c490223ad0cf1a11567711fcf9ed1ad0f114e3341d903bfd33adcecf" +
+		"0303030303030303030303030303030303030303030303030303030303030303" +
+		"1aff1a3e211fa4727451eb29ee52c7bff26ff59682e7a0941f0ef4e4a46d5a54" +
+		"1dde343a3d0cfb549da01e9e4508ec72c4b815e29eb90bcc9a6e055a69297462")
+
+	for range 2 {
+		client, err := conf.Client()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		request := client.RegistrationInit([]byte("password"), opaque.ClientRegistrationInitOptions{
+			OPRFBlind:          blind,
+			AllowEmptyPassword: false,
+		})
+
+		response, err := server.RegistrationResponse(request, pk, credID, oprfSeed)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		record, _ := client.RegistrationFinalize(response, opaque.ClientRegistrationFinalizeOptions{
+			EnvelopeNonce: nonce,
+		})
+
+		if !bytes.Equal(record.Envelope[:len(nonce)], nonce) {
+			t.Fatal("expected the envelope to hold the given nonce")
+		}
+
+		if !bytes.Equal(record.Serialize(), expected) {
+			t.Fatalf("unexpected record %s", hex.EncodeToString(record.Serialize()))
+		}
+	}
+}
+
+func TestClient_GenerateKE1Deterministic(t *testing.T) {
+	conf := opaque.DefaultConfiguration()
+	blind := conf.AKE.Group().HashToScalar([]byte("blind"), []byte("test"))
+	options := opaque.GenerateKE1Options{
+		OPRFBlind:          blind,
+		KeyShareSeed:       bytes.Repeat([]byte{1}, 32),
+		AKENonce:           bytes.Repeat([]byte{2}, 32),
+		AKENonceLength:     0,
+		AllowEmptyPassword: false,
+	}
+
+	expected, _ := hex.DecodeString("08c2e6f6d7b00bc943cb9de7ead489462fc20b8fedc7f1eed5ed252084e47647" +
+		"0202020202020202020202020202020202020202020202020202020202020202" +
+		"561abf53b706c3c169ac33df49cc188c91a45339e10165bef3297c843741a34b")
+
+	for range 2 {
+		client, err := conf.Client()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ke1 := client.GenerateKE1([]byte("password"), options)
+		if !bytes.Equal(ke1.Serialize(), expected) {
+			t.Fatalf("unexpected KE1 %s", hex.EncodeToString(ke1.Serialize()))
+		}
+	}
+}
+
+func TestClient_EmptyPassword(t *testing.T) {
+	testAll(t, func(t2 *testing.T, conf *configuration) {
+		server, err := conf.conf.Server()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		password := []byte("password")
+		sks, pks := conf.conf.KeyGen()
+		oprfSeed := conf.conf.GenerateOPRFSeed()
+
+		if err = server.SetKeyMaterial(nil, sks, pks, oprfSeed); err != nil {
+			t2.Fatal(err)
+		}
+
+		client, err := conf.conf.Client()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		// Default rejection.
+		if _, err := client.RegistrationInitChecked(nil); !errors.Is(err, opaque.ErrEmptyPassword) {
+			t2.Fatalf("expected %q, got %v", opaque.ErrEmptyPassword, err)
+		}
+
+		if _, err := client.GenerateKE1Checked([]byte{}); !errors.Is(err, opaque.ErrEmptyPassword) {
+			t2.Fatalf("expected %q, got %v", opaque.ErrEmptyPassword, err)
+		}
+
+		// Non-empty passwords are accepted.
+		if _, err := client.RegistrationInitChecked(password); err != nil {
+			t2.Fatal(err)
+		}
+
+		if _, err := client.GenerateKE1Checked(password); err != nil {
+			t2.Fatal(err)
+		}
+
+		// Opt-out, with a full registration and login with the empty password.
+		client, err = conf.conf.Client()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		r1, err := client.RegistrationInitChecked(nil, opaque.ClientRegistrationInitOptions{AllowEmptyPassword: true})
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		pk, _ := server.Deserialize.DecodeAkePublicKey(pks)
+		credID := internal.RandomBytes(32)
+		r2, _ := server.RegistrationResponse(r1, pk, credID, oprfSeed)
+		r3, _ := client.RegistrationFinalize(r2)
+		record := &opaque.ClientRecord{
+			RegistrationRecord:   r3,
+			CredentialIdentifier: credID,
+			ClientIdentity:       nil,
+			PreviousOPRFSeed:     false,
+		}
+
+		client, err = conf.conf.Client()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		ke1, err := client.GenerateKE1Checked(nil, opaque.GenerateKE1Options{AllowEmptyPassword: true})
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		ke2, err := server.GenerateKE2(ke1, record)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		if _, _, err = client.GenerateKE3(ke2); err != nil {
+			t2.Fatal(err)
+		}
+	})
+}
+
+func TestClient_FinishLogin(t *testing.T) {
+	testAll(t, func(t2 *testing.T, conf *configuration) {
+		client, err := conf.conf.Client()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		server, err := conf.conf.Server()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		password := []byte("password")
+		sks, pks := conf.conf.KeyGen()
+		oprfSeed := conf.conf.GenerateOPRFSeed()
+		record := buildRecord(internal.RandomBytes(32), oprfSeed, password, pks, client, server)
+
+		if err = server.SetKeyMaterial(nil, sks, pks, oprfSeed); err != nil {
+			t2.Fatal(err)
+		}
+
+		ke2, err := server.GenerateKE2(client.GenerateKE1(password), record)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		result, err := client.FinishLogin(ke2)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		if result.KE3 == nil || len(result.SessionKey) == 0 || len(result.ExportKey) == 0 {
+			t2.Fatal("expected all login results to be set")
+		}
+
+		if !bytes.Equal(result.SessionKey, client.SessionKey()) || !bytes.Equal(result.ExportKey, client.ExportKey()) {
+			t2.Fatal("expected the results to match the accessors")
+		}
+
+		if err = server.LoginFinish(result.KE3); err != nil {
+			t2.Fatal(err)
+		}
+
+		if !bytes.Equal(result.SessionKey, server.SessionKey()) {
+			t2.Fatal("expected the same session key as the server")
+		}
+
+		// The export key is the registration's.
+		client, err = conf.conf.Client()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		pk, _ := server.Deserialize.DecodeAkePublicKey(pks)
+		credID := internal.RandomBytes(32)
+		response, _ := server.RegistrationResponse(client.RegistrationInit(password), pk, credID, oprfSeed)
+		upload, exportKey := client.RegistrationFinalize(response)
+		record = &opaque.ClientRecord{
+			RegistrationRecord:   upload,
+			CredentialIdentifier: credID,
+			ClientIdentity:       nil,
+			PreviousOPRFSeed:     false,
+		}
+
+		server.Ake.Flush()
+
+		client, err = conf.conf.Client()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		if ke2, err = server.GenerateKE2(client.GenerateKE1(password), record); err != nil {
+			t2.Fatal(err)
+		}
+
+		if result, err = client.FinishLogin(ke2, opaque.LoginFinishOptions{}); err != nil {
+			t2.Fatal(err)
+		}
+
+		if !bytes.Equal(result.ExportKey, exportKey) {
+			t2.Fatal("expected the registration's export key")
+		}
+
+		// Failure.
+		ke2.ServerMac = bytes.Clone(ke2.ServerMac)
+		ke2.ServerMac[0] ^= 1
+
+		if result, err = client.FinishLogin(ke2); err == nil || result != nil {
+			t2.Fatal("expected an error and no result")
+		}
+	})
+}
+
+func TestClient_BadPasswordVsCorruptEnvelope(t *testing.T) {
+	testAll(t, func(t2 *testing.T, conf *configuration) {
+		regClient, err := conf.conf.Client()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		server, err := conf.conf.Server()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		password := []byte("password")
+		sks, pks := conf.conf.KeyGen()
+		oprfSeed := conf.conf.GenerateOPRFSeed()
+		record := buildRecord(internal.RandomBytes(32), oprfSeed, password, pks, regClient, server)
+
+		if err = server.SetKeyMaterial(nil, sks, pks, oprfSeed); err != nil {
+			t2.Fatal(err)
+		}
+
+		expected := opaque.GenerateKE3Options{ExpectedServerPublicKey: pks}
+
+		login := func(password []byte, flipEnvelope bool, options ...opaque.GenerateKE3Options) error {
+			defer server.Ake.Flush()
+
+			client, err := conf.conf.Client()
+			if err != nil {
+				t2.Fatal(err)
+			}
+
+			ke2, err := server.GenerateKE2(client.GenerateKE1(password), record)
+			if err != nil {
+				t2.Fatal(err)
+			}
+
+			if flipEnvelope {
+				// The masking is a xor, so this flips a bit of the envelope's authentication tag.
+				ke2.MaskedResponse[len(ke2.MaskedResponse)-1] ^= 1
+			}
+
+			_, _, err = client.GenerateKE3(ke2, options...)
+
+			return err
+		}
+
+		// Wrong passwords, whose garbled server public keys may still decode, are never reported as a pinned server
+		// mismatch nor as a corrupted envelope.
+		for i := range 8 {
+			for _, options := range [][]opaque.GenerateKE3Options{nil, {expected}} {
+				err := login(append([]byte("wrong password"), byte(i)), false, options...)
+				if !errors.Is(err, opaque.ErrBadPassword) {
+					t2.Fatalf("expected %q, got %v", opaque.ErrBadPassword, err)
+				}
+
+				if errors.Is(err, opaque.ErrEnvelopeCorrupt) {
+					t2.Fatal("a wrong password must not be reported as a corrupted envelope")
+				}
+			}
+		}
+
+		// Bit-flipped envelope, with the server public key known to the client.
+		if err := login(password, true, expected); !errors.Is(err, opaque.ErrEnvelopeCorrupt) {
+			t2.Fatalf("expected %q, got %v", opaque.ErrEnvelopeCorrupt, err)
+		}
+
+		// Without the server public key, the corruption can't be told apart from a wrong password.
+		if err := login(password, true); !errors.Is(err, opaque.ErrBadPassword) {
+			t2.Fatalf("expected %q, got %v", opaque.ErrBadPassword, err)
+		}
+
+		// The right password still works.
+		if err := login(password, false, expected); err != nil {
+			t2.Fatal(err)
+		}
+	})
+}
+
+// checkProgress fails if fewer than two progress reports were made, or if they decrease.
+func checkProgress(t *testing.T, fractions []float64) {
+	t.Helper()
+
+	if len(fractions) < 2 {
+		t.Fatalf("expected at least 2 progress reports, got %d", len(fractions))
+	}
+
+	if !slices.IsSorted(fractions) || fractions[0] != 0 || fractions[len(fractions)-1] != 1 {
+		t.Fatalf("expected non-decreasing progress from 0 to 1, got %v", fractions)
+	}
+}
+
+func TestClient_KSFProgress(t *testing.T) {
+	testAll(t, func(t2 *testing.T, conf *configuration) {
+		server, err := conf.conf.Server()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		password := []byte("password")
+		sks, pks := conf.conf.KeyGen()
+		oprfSeed := conf.conf.GenerateOPRFSeed()
+
+		if err = server.SetKeyMaterial(nil, sks, pks, oprfSeed); err != nil {
+			t2.Fatal(err)
+		}
+
+		credID := internal.RandomBytes(32)
+
+		client, err := conf.conf.Client()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		var fractions []float64
+		progress := func(fraction float64) {
+			fractions = append(fractions, fraction)
+		}
+
+		response, _ := server.RegistrationResponse(client.RegistrationInit(password), server.PublicKey(),
+			credID, oprfSeed)
+		upload, _ := client.RegistrationFinalize(response, opaque.ClientRegistrationFinalizeOptions{
+			KSFProgress: progress,
+		})
+		checkProgress(t2, fractions)
+
+		record := &opaque.ClientRecord{
+			RegistrationRecord:   upload,
+			CredentialIdentifier: credID,
+			ClientIdentity:       nil,
+			PreviousOPRFSeed:     false,
+		}
+
+		// The login reports progress too, also when run with a cancellable context.
+		for _, ctx := range []context.Context{context.Background(), t2.Context()} {
+			fractions = nil
+
+			client, err = conf.conf.Client()
+			if err != nil {
+				t2.Fatal(err)
+			}
+
+			server.Ake.Flush()
+
+			ke2, err := server.GenerateKE2(client.GenerateKE1(password), record)
+			if err != nil {
+				t2.Fatal(err)
+			}
+
+			options := opaque.GenerateKE3Options{KSFProgress: progress}
+			if _, _, err = client.GenerateKE3Context(ctx, ke2, options); err != nil {
+				t2.Fatal(err)
+			}
+
+			checkProgress(t2, fractions)
+		}
+	})
+}
+
+func TestClient_PreStretched(t *testing.T) {
+	testAll(t, func(t2 *testing.T, conf *configuration) {
+		server, err := conf.conf.Server()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		password := []byte("password")
+		sks, pks := conf.conf.KeyGen()
+		oprfSeed := conf.conf.GenerateOPRFSeed()
+
+		if err = server.SetKeyMaterial(nil, sks, pks, oprfSeed); err != nil {
+			t2.Fatal(err)
+		}
+
+		credID := internal.RandomBytes(32)
+
+		register := func(preStretched bool) *opaque.ClientRecord {
+			client, err := conf.conf.Client()
+			if err != nil {
+				t2.Fatal(err)
+			}
+
+			response, _ := server.RegistrationResponse(client.RegistrationInit(password), server.PublicKey(),
+				credID, oprfSeed)
+			record, _ := client.RegistrationFinalize(response,
+				opaque.ClientRegistrationFinalizeOptions{PreStretched: preStretched})
+
+			return &opaque.ClientRecord{
+				RegistrationRecord:   record,
+				CredentialIdentifier: credID,
+				ClientIdentity:       nil,
+				PreviousOPRFSeed:     false,
+			}
+		}
+
+		login := func(record *opaque.ClientRecord, preStretched bool) error {
+			defer server.Ake.Flush()
+
+			client, err := conf.conf.Client()
+			if err != nil {
+				t2.Fatal(err)
+			}
+
+			ke2, err := server.GenerateKE2(client.GenerateKE1(password), record)
+			if err != nil {
+				t2.Fatal(err)
+			}
+
+			ke3, _, err := client.GenerateKE3(ke2, opaque.GenerateKE3Options{PreStretched: preStretched})
+			if err != nil {
+				return err
+			}
+
+			return server.LoginFinish(ke3)
+		}
+
+		preStretched := register(true)
+		stretched := register(false)
+
+		if err := login(preStretched, true); err != nil {
+			t2.Fatal(err)
+		}
+
+		if err := login(stretched, false); err != nil {
+			t2.Fatal(err)
+		}
+
+		// Registration and login must agree on the flag.
+		if err := login(preStretched, false); err == nil {
+			t2.Fatal("expected an error when only the registration is pre-stretched")
+		}
+
+		if err := login(stretched, true); err == nil {
+			t2.Fatal("expected an error when only the login is pre-stretched")
+		}
+	})
+}
@@ -0,0 +1,100 @@
+// SPDX-License-Identifier: MIT
+//
+// Copyright (C) 2020-2025 Daniel Bourdrez. All Rights Reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree or at
+// https://spdx.org/licenses/MIT.html
+
+//go:build opaquedebug
+
+package opaque_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/bytemare/opaque"
+	"github.com/bytemare/opaque/internal"
+)
+
+func TestServer_DebugSnapshot(t *testing.T) {
+	testAll(t, func(t2 *testing.T, conf *configuration) {
+		client, err := conf.conf.Client()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		server, err := conf.conf.Server()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		password := []byte("password")
+		sks, pks := conf.conf.KeyGen()
+		oprfSeed := conf.conf.GenerateOPRFSeed()
+		record := buildRecord(internal.RandomBytes(32), oprfSeed, password, pks, client, server)
+
+		if err = server.SetKeyMaterial(nil, sks, pks, oprfSeed); err != nil {
+			t2.Fatal(err)
+		}
+
+		ke1 := client.GenerateKE1(password)
+
+		ke2, err := server.GenerateKE2(ke1, record)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		snapshot := server.Ake.DebugSnapshot()
+
+		newServer := func() *opaque.Server {
+			restored, err := conf.conf.Server()
+			if err != nil {
+				t2.Fatal(err)
+			}
+
+			if err = restored.SetKeyMaterial(nil, sks, pks, oprfSeed); err != nil {
+				t2.Fatal(err)
+			}
+
+			restored.Ake.RestoreSnapshot(snapshot)
+
+			return restored
+		}
+
+		// Replaying the handshake with the snapshot reproduces the same KE2 and session key. The masking nonce is not
+		// part of the AKE values, and is public.
+		replay := newServer()
+
+		replayed, err := replay.GenerateKE2(ke1, record, opaque.GenerateKE2Options{MaskingNonce: ke2.MaskingNonce})
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		if !bytes.Equal(replayed.Serialize(), ke2.Serialize()) {
+			t2.Fatal("expected the replayed KE2 to be identical")
+		}
+
+		if !bytes.Equal(replay.SessionKey(), server.SessionKey()) {
+			t2.Fatal("expected the replayed session key to be identical")
+		}
+
+		// A restored server can finish the login.
+		ke3, _, err := client.GenerateKE3(ke2)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		if err = newServer().LoginFinish(ke3); err != nil {
+			t2.Fatal(err)
+		}
+
+		// The snapshot is a copy, unaffected by flushing the server.
+		server.Ake.Flush()
+
+		if snapshot.EphemeralSecretKey == nil || snapshot.EphemeralSecretKey.IsZero() || snapshot.Nonce == nil {
+			t2.Fatal("expected the snapshot to survive a flush")
+		}
+	})
+}
@@ -9,6 +9,7 @@
 package opaque_test
 
 import (
+	"bytes"
 	"encoding/hex"
 	"errors"
 	"testing"
@@ -18,6 +19,7 @@ import (
 	"github.com/bytemare/opaque"
 	"github.com/bytemare/opaque/internal"
 	"github.com/bytemare/opaque/internal/encoding"
+	"github.com/bytemare/opaque/message"
 )
 
 const testErrValidConf = "unexpected error on valid configuration: %v"
@@ -57,7 +59,7 @@ func TestDeserializeRegistrationRequest(t *testing.T) {
 
 	server, _ := c.Server()
 	conf := server.GetConf()
-	length := conf.OPRF.Group().ElementLength() + 1
+	length := conf.OPRF.Group().ElementLength() - 1
 	if _, err := server.Deserialize.RegistrationRequest(internal.RandomBytes(length)); err == nil ||
 		err.Error() != errInvalidMessageLength.Error() {
 		t.Fatalf("Expected error for DeserializeRegistrationRequest. want %q, got %q", errInvalidMessageLength, err)
@@ -75,7 +77,7 @@ func TestDeserializeRegistrationResponse(t *testing.T) {
 
 	server, _ := c.Server()
 	conf := server.GetConf()
-	length := conf.OPRF.Group().ElementLength() + conf.Group.ElementLength() + 1
+	length := conf.OPRF.Group().ElementLength() + conf.Group.ElementLength() - 1
 	if _, err := server.Deserialize.RegistrationResponse(internal.RandomBytes(length)); err == nil ||
 		err.Error() != errInvalidMessageLength.Error() {
 		t.Fatalf("Expected error for DeserializeRegistrationRequest. want %q, got %q", errInvalidMessageLength, err)
@@ -95,14 +97,14 @@ func TestDeserializeRegistrationRecord(t *testing.T) {
 			t.Fatal(err)
 		}
 		c := server.GetConf()
-		length := c.Group.ElementLength() + c.Hash.Size() + c.EnvelopeSize + 1
+		length := c.Group.ElementLength() + c.KDF.Size() + c.EnvelopeSize - 1
 		if _, err := server.Deserialize.RegistrationRecord(internal.RandomBytes(length)); err == nil ||
 			err.Error() != errInvalidMessageLength.Error() {
 			t.Fatalf("Expected error for DeserializeRegistrationRequest. want %q, got %q", errInvalidMessageLength, err)
 		}
 
 		badPKu := getBadElement(t, conf)
-		rec := encoding.Concat(badPKu, internal.RandomBytes(c.Hash.Size()+c.EnvelopeSize))
+		rec := encoding.Concat(badPKu, internal.RandomBytes(c.KDF.Size()+c.EnvelopeSize))
 
 		expect := "invalid client public key"
 		if _, err := server.Deserialize.RegistrationRecord(rec); err == nil || err.Error() != expect {
@@ -126,13 +128,13 @@ func TestDeserializeKE1(t *testing.T) {
 	ke1Length := g.ElementLength() + internal.NonceLength + g.ElementLength()
 
 	server, _ := c.Server()
-	if _, err := server.Deserialize.KE1(internal.RandomBytes(ke1Length + 1)); err == nil ||
+	if _, err := server.Deserialize.KE1(internal.RandomBytes(ke1Length - 1)); err == nil ||
 		err.Error() != errInvalidMessageLength.Error() {
 		t.Fatalf("Expected error for DeserializeKE1. want %q, got %q", errInvalidMessageLength, err)
 	}
 
 	client, _ := c.Client()
-	if _, err := client.Deserialize.KE1(internal.RandomBytes(ke1Length + 1)); err == nil ||
+	if _, err := client.Deserialize.KE1(internal.RandomBytes(ke1Length - 1)); err == nil ||
 		err.Error() != errInvalidMessageLength.Error() {
 		t.Fatalf("Expected error for DeserializeKE1. want %q, got %q", errInvalidMessageLength, err)
 	}
@@ -146,7 +148,7 @@ func TestDeserializeKE2(t *testing.T) {
 	ke2Length := conf.OPRF.Group().
 		ElementLength() +
 		2*conf.NonceLen + 2*conf.Group.ElementLength() + conf.EnvelopeSize + conf.MAC.Size()
-	if _, err := client.Deserialize.KE2(internal.RandomBytes(ke2Length + 1)); err == nil ||
+	if _, err := client.Deserialize.KE2(internal.RandomBytes(ke2Length - 1)); err == nil ||
 		err.Error() != errInvalidMessageLength.Error() {
 		t.Fatalf("Expected error for DeserializeKE1. want %q, got %q", errInvalidMessageLength, err)
 	}
@@ -156,7 +158,7 @@ func TestDeserializeKE2(t *testing.T) {
 	ke2Length = conf.OPRF.Group().
 		ElementLength() +
 		2*conf.NonceLen + 2*conf.Group.ElementLength() + conf.EnvelopeSize + conf.MAC.Size()
-	if _, err := server.Deserialize.KE2(internal.RandomBytes(ke2Length + 1)); err == nil ||
+	if _, err := server.Deserialize.KE2(internal.RandomBytes(ke2Length - 1)); err == nil ||
 		err.Error() != errInvalidMessageLength.Error() {
 		t.Fatalf("Expected error for DeserializeKE1. want %q, got %q", errInvalidMessageLength, err)
 	}
@@ -167,13 +169,13 @@ func TestDeserializeKE3(t *testing.T) {
 	ke3Length := c.MAC.Size()
 
 	server, _ := c.Server()
-	if _, err := server.Deserialize.KE3(internal.RandomBytes(ke3Length + 1)); err == nil ||
+	if _, err := server.Deserialize.KE3(internal.RandomBytes(ke3Length - 1)); err == nil ||
 		err.Error() != errInvalidMessageLength.Error() {
 		t.Fatalf("Expected error for DeserializeKE1. want %q, got %q", errInvalidMessageLength, err)
 	}
 
 	client, _ := c.Client()
-	if _, err := client.Deserialize.KE3(internal.RandomBytes(ke3Length + 1)); err == nil ||
+	if _, err := client.Deserialize.KE3(internal.RandomBytes(ke3Length - 1)); err == nil ||
 		err.Error() != errInvalidMessageLength.Error() {
 		t.Fatalf("Expected error for DeserializeKE1. want %q, got %q", errInvalidMessageLength, err)
 	}
@@ -232,3 +234,243 @@ func TestDecodeAkePublicKey(t *testing.T) {
 		}
 	})
 }
+
+func TestDeserializeClientRecord(t *testing.T) {
+	testAll(t, func(t2 *testing.T, conf *configuration) {
+		client, _ := conf.conf.Client()
+		server, _ := conf.conf.Server()
+		_, pks := conf.conf.KeyGen()
+		record := buildRecord(internal.RandomBytes(32), conf.conf.GenerateOPRFSeed(), []byte("yo"), pks, client, server)
+
+		for _, clientIdentity := range [][]byte{nil, []byte("client")} {
+			record.ClientIdentity = clientIdentity
+			encoded := record.Serialize()
+
+			decoded, err := server.Deserialize.ClientRecord(encoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(decoded.Serialize(), encoded) ||
+				!bytes.Equal(decoded.CredentialIdentifier, record.CredentialIdentifier) ||
+				!bytes.Equal(decoded.RegistrationRecord.Serialize(), record.RegistrationRecord.Serialize()) {
+				t.Fatal("client record round trip failed")
+			}
+
+			if (clientIdentity == nil) != (decoded.ClientIdentity == nil) ||
+				!bytes.Equal(decoded.ClientIdentity, clientIdentity) {
+				t.Fatalf("unexpected client identity %v", decoded.ClientIdentity)
+			}
+
+			if _, err = server.Deserialize.ClientRecord(append(encoded, 0)); !errors.Is(err, opaque.ErrTrailingBytes) {
+				t.Fatalf("expected error on trailing bytes, got %v", err)
+			}
+
+			if _, err = server.Deserialize.ClientRecord(encoded[:len(encoded)-1]); err == nil {
+				t.Fatal("expected error on truncated record")
+			}
+
+			if encoded[0] != opaque.RecordVersion {
+				t.Fatalf("expected record version %d, got %d", opaque.RecordVersion, encoded[0])
+			}
+
+			for _, version := range []byte{0, opaque.RecordVersion + 1, 0xff} {
+				encoded[0] = version
+				if _, err = server.Deserialize.ClientRecord(encoded); !errors.Is(err, opaque.ErrRecordVersionUnsupported) {
+					t.Fatalf("expected %q for version %d, got %v", opaque.ErrRecordVersionUnsupported, version, err)
+				}
+			}
+		}
+
+		c := server.GetConf()
+		if _, err := server.Deserialize.ClientRecord(
+			internal.RandomBytes(c.Group.ElementLength() + c.KDF.Size() + c.EnvelopeSize - 1),
+		); err == nil || err.Error() != errInvalidMessageLength.Error() {
+			t.Fatalf("expected error on short record, got %v", err)
+		}
+	})
+}
+
+func TestDeserializer_TrailingBytes(t *testing.T) {
+	testAll(t, func(t2 *testing.T, conf *configuration) {
+		client, err := conf.conf.Client()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		server, err := conf.conf.Server()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		password := []byte("password")
+		sks, pks := conf.conf.KeyGen()
+		oprfSeed := conf.conf.GenerateOPRFSeed()
+
+		if err = server.SetKeyMaterial(nil, sks, pks, oprfSeed); err != nil {
+			t2.Fatal(err)
+		}
+
+		credID := internal.RandomBytes(32)
+		r1 := client.RegistrationInit(password)
+		pk, _ := server.Deserialize.DecodeAkePublicKey(pks)
+
+		r2, err := server.RegistrationResponse(r1, pk, credID, oprfSeed)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		r3, _ := client.RegistrationFinalize(r2)
+		record := &opaque.ClientRecord{
+			CredentialIdentifier: credID,
+			RegistrationRecord:   r3,
+		}
+
+		ke1 := client.GenerateKE1(password)
+
+		ke2, err := server.GenerateKE2(ke1, record)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		ke3, _, err := client.GenerateKE3(ke2)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		d := server.Deserialize
+		tests := map[string]struct {
+			decode  func([]byte) error
+			encoded []byte
+		}{
+			"RegistrationRequest": {
+				func(b []byte) error { _, err := d.RegistrationRequest(b); return err },
+				r1.Serialize(),
+			},
+			"RegistrationResponse": {
+				func(b []byte) error { _, err := d.RegistrationResponse(b); return err },
+				r2.Serialize(),
+			},
+			"RegistrationRecord": {
+				func(b []byte) error { _, err := d.RegistrationRecord(b); return err },
+				record.RegistrationRecord.Serialize(),
+			},
+			"KE1": {func(b []byte) error { _, err := d.KE1(b); return err }, ke1.Serialize()},
+			"KE2": {func(b []byte) error { _, err := d.KE2(b); return err }, ke2.Serialize()},
+			"KE3": {func(b []byte) error { _, err := d.KE3(b); return err }, ke3.Serialize()},
+		}
+
+		for name, test := range tests {
+			if err = test.decode(test.encoded); err != nil {
+				t2.Fatalf("%s: unexpected error on valid message: %v", name, err)
+			}
+
+			if err = test.decode(append(test.encoded, 0)); !errors.Is(err, opaque.ErrTrailingBytes) {
+				t2.Fatalf("%s: expected %q, got %v", name, opaque.ErrTrailingBytes, err)
+			}
+		}
+	})
+}
+
+func TestDeserializer_ConfigFingerprint(t *testing.T) {
+	ristretto, err := opaque.DefaultConfiguration().Deserializer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p256, err := configurationTable[1].conf.Deserializer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ristretto.ConfigFingerprint()) != message.FingerprintLength {
+		t.Fatalf("unexpected fingerprint length %d", len(ristretto.ConfigFingerprint()))
+	}
+
+	if bytes.Equal(ristretto.ConfigFingerprint(), p256.ConfigFingerprint()) {
+		t.Fatal("expected different fingerprints for different configurations")
+	}
+
+	// A P-256 message wrapped by its sender is rejected by a Ristretto255 deserializer.
+	client, err := configurationTable[1].conf.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ke1 := client.GenerateKE1([]byte("password"))
+	wrapped := p256.Wrap(ke1.Serialize())
+
+	if _, err = ristretto.Unwrap(wrapped); !errors.Is(err, opaque.ErrCiphersuiteMismatch) {
+		t.Fatalf("expected %q, got %q", opaque.ErrCiphersuiteMismatch, err)
+	}
+
+	if _, err = ristretto.Unwrap(wrapped[:message.FingerprintLength-1]); !errors.Is(err, opaque.ErrCiphersuiteMismatch) {
+		t.Fatalf("expected %q, got %q", opaque.ErrCiphersuiteMismatch, err)
+	}
+
+	// The matching deserializer unwraps and decodes it.
+	unwrapped, err := p256.Unwrap(wrapped)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := p256.KE1(unwrapped)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !decoded.Equal(ke1) {
+		t.Fatal("expected identical KE1 messages")
+	}
+
+	// The context is bound to the fingerprint.
+	conf := opaque.DefaultConfiguration()
+	conf.Context = []byte("context")
+
+	withContext, err := conf.Deserializer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Equal(ristretto.ConfigFingerprint(), withContext.ConfigFingerprint()) {
+		t.Fatal("expected different fingerprints for different contexts")
+	}
+}
+
+func TestDeserializer_Configuration(t *testing.T) {
+	testAll(t, func(t2 *testing.T, conf *configuration) {
+		c := conf.conf.Clone()
+		c.Context = []byte("context")
+		c.NonceLength = 48
+
+		server, err := c.Server()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		recovered := server.Deserialize.Configuration()
+		if !bytes.Equal(recovered.Serialize(), c.Serialize()) {
+			t2.Fatal("expected the recovered configuration to serialize as the original")
+		}
+
+		// The recovered configuration is a copy.
+		recovered.Context[0] = 'C'
+
+		if !bytes.Equal(server.Deserialize.Configuration().Context, c.Context) {
+			t2.Fatal("expected modifying the recovered configuration not to affect the deserializer")
+		}
+
+		d, err := c.Deserializer()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		if !bytes.Equal(d.Configuration().Serialize(), c.Serialize()) {
+			t2.Fatal("expected the recovered configuration to serialize as the original")
+		}
+	})
+
+	if (&opaque.Deserializer{}).Configuration() != nil {
+		t.Fatal("expected no configuration for a zero deserializer")
+	}
+}
@@ -11,6 +11,7 @@ package opaque_test
 import (
 	"bytes"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"testing"
 
@@ -47,6 +48,37 @@ func TestDecodeVector(t *testing.T) {
 	}
 }
 
+func TestDecodeVectorLenMax(t *testing.T) {
+	// A length prefix exceeding the remaining buffer.
+	if _, _, err := encoding.DecodeVectorLenMax([]byte{0, 3, 0, 0}, 2, 8); err == nil ||
+		err.Error() != "insufficient total length for decoding" {
+		t.Fatalf("expected error for short input. Got %q", err)
+	}
+
+	// A length prefix exceeding the maximum, even with enough input.
+	if _, _, err := encoding.DecodeVectorLenMax([]byte{0, 3, 1, 2, 3}, 2, 2); !errors.Is(
+		err, encoding.ErrVectorTooLong) {
+		t.Fatalf("expected %q, got %q", encoding.ErrVectorTooLong, err)
+	}
+
+	if _, _, err := encoding.DecodeVectorLenMax([]byte{0xff, 0xff}, 2, 2); !errors.Is(
+		err, encoding.ErrVectorTooLong) {
+		t.Fatalf("expected %q, got %q", encoding.ErrVectorTooLong, err)
+	}
+
+	// At the maximum.
+	data, offset, err := encoding.DecodeVectorLenMax([]byte{0, 2, 1, 2, 3}, 2, 2)
+	if err != nil || offset != 4 || !bytes.Equal(data, []byte{1, 2}) {
+		t.Fatalf("unexpected decoding: %v, %d, %v", data, offset, err)
+	}
+
+	// A 4-byte length prefix.
+	data, offset, err = encoding.DecodeVectorLen([]byte{0, 0, 0, 2, 1, 2, 3}, 4)
+	if err != nil || offset != 6 || !bytes.Equal(data, []byte{1, 2}) {
+		t.Fatalf("unexpected decoding: %v, %d, %v", data, offset, err)
+	}
+}
+
 type i2ospTest struct {
 	encoded []byte
 	value   int
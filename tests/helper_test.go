@@ -60,14 +60,14 @@ var configurationTable = []configuration{
 		curve: elliptic.P256(),
 	},
 	{
-		name: "P384Sha512",
+		name: "P384Sha384",
 		conf: &opaque.Configuration{
-			OPRF: opaque.P384Sha512,
+			OPRF: opaque.P384Sha384,
 			KDF:  crypto.SHA512,
 			MAC:  crypto.SHA512,
 			Hash: crypto.SHA512,
 			KSF:  ksf.Argon2id,
-			AKE:  opaque.P384Sha512,
+			AKE:  opaque.P384Sha384,
 		},
 		curve: elliptic.P384(),
 	},
@@ -83,6 +83,18 @@ var configurationTable = []configuration{
 		},
 		curve: elliptic.P521(),
 	},
+	{
+		name: "P256Sha256-Sha512KDF",
+		conf: &opaque.Configuration{
+			OPRF: opaque.P256Sha256,
+			KDF:  crypto.SHA512,
+			MAC:  crypto.SHA512,
+			Hash: crypto.SHA256,
+			KSF:  ksf.Argon2id,
+			AKE:  opaque.P256Sha256,
+		},
+		curve: elliptic.P256(),
+	},
 }
 
 func testAll(t *testing.T, f func(*testing.T, *configuration)) {
@@ -179,7 +191,11 @@ func buildRecord(
 		panic(err)
 	}
 
-	r2 := server.RegistrationResponse(r1, pk, credID, oprfSeed)
+	r2, err := server.RegistrationResponse(r1, pk, credID, oprfSeed)
+	if err != nil {
+		panic(err)
+	}
+
 	r3, _ := client.RegistrationFinalize(r2)
 
 	return &opaque.ClientRecord{
@@ -0,0 +1,429 @@
+// SPDX-License-Identifier: MIT
+//
+// Copyright (C) 2020-2025 Daniel Bourdrez. All Rights Reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree or at
+// https://spdx.org/licenses/MIT.html
+
+package opaque_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"slices"
+	"testing"
+
+	"github.com/bytemare/opaque"
+	"github.com/bytemare/opaque/internal"
+	"github.com/bytemare/opaque/message"
+)
+
+func TestCredentialResponse_EvaluatedElement(t *testing.T) {
+	testAll(t, func(t2 *testing.T, conf *configuration) {
+		client, _ := conf.conf.Client()
+		server, _ := conf.conf.Server()
+		sks, pks := conf.conf.KeyGen()
+		oprfSeed := conf.conf.GenerateOPRFSeed()
+		credID := internal.RandomBytes(32)
+		record := buildRecord(credID, oprfSeed, []byte("password"), pks, client, server)
+
+		if err := server.SetKeyMaterial(nil, sks, pks, oprfSeed); err != nil {
+			t.Fatal(err)
+		}
+
+		client, _ = conf.conf.Client()
+		ke1 := client.GenerateKE1([]byte("password"))
+
+		ke2, err := server.GenerateKE2(ke1, record)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		evaluated := ke2.EvaluatedElement()
+		if !bytes.Equal(evaluated, ke2.EvaluatedMessage.Encode()) {
+			t.Fatal("unexpected evaluated element encoding")
+		}
+
+		if !bytes.Equal(evaluated, ke2.Serialize()[:len(evaluated)]) {
+			t.Fatal("evaluated element is not the prefix of the serialized KE2")
+		}
+	})
+}
+
+func TestMessageEqual(t *testing.T) {
+	testAll(t, func(t2 *testing.T, conf *configuration) {
+		client, err := conf.conf.Client()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		server, err := conf.conf.Server()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		password := []byte("password")
+		sks, pks := conf.conf.KeyGen()
+		oprfSeed := conf.conf.GenerateOPRFSeed()
+		record := buildRecord(internal.RandomBytes(32), oprfSeed, password, pks, client, server)
+
+		if err = server.SetKeyMaterial(nil, sks, pks, oprfSeed); err != nil {
+			t.Fatal(err)
+		}
+
+		ke1 := client.GenerateKE1(password)
+
+		ke2, err := server.GenerateKE2(ke1, record)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		d := server.Deserialize
+		g := server.GetConf().Group
+		randomElement := g.Base().Multiply(g.NewScalar().Random())
+
+		ke3, _, err := client.GenerateKE3(ke2)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		flip := func(b []byte) []byte {
+			b = slices.Clone(b)
+			b[0] ^= 1
+
+			return b
+		}
+
+		// KE1
+		ke1b, _ := d.KE1(ke1.Serialize())
+		if !ke1.Equal(ke1b) || ke1.Equal(nil) || !(*message.KE1)(nil).Equal(nil) {
+			t.Fatal("unexpected KE1 equality result")
+		}
+
+		ke1b.ClientNonce = flip(ke1b.ClientNonce)
+		if ke1.Equal(ke1b) {
+			t.Fatal("expected KE1 inequality on nonce")
+		}
+
+		ke1b, _ = d.KE1(ke1.Serialize())
+		ke1b.ClientPublicKeyshare = randomElement
+		if ke1.Equal(ke1b) {
+			t.Fatal("expected KE1 inequality on key share")
+		}
+
+		ke1b, _ = d.KE1(ke1.Serialize())
+		ke1b.CredentialRequest = nil
+		if ke1.Equal(ke1b) || ke1b.Equal(ke1) {
+			t.Fatal("expected KE1 inequality on nil credential request")
+		}
+
+		// KE2
+		mutations := []func(m *message.KE2){
+			func(m *message.KE2) { m.ServerNonce = flip(m.ServerNonce) },
+			func(m *message.KE2) { m.ServerMac = flip(m.ServerMac) },
+			func(m *message.KE2) { m.MaskingNonce = flip(m.MaskingNonce) },
+			func(m *message.KE2) { m.MaskedResponse = flip(m.MaskedResponse) },
+			func(m *message.KE2) { m.ServerPublicKeyshare = randomElement },
+			func(m *message.KE2) { m.EvaluatedMessage = randomElement },
+			func(m *message.KE2) { m.ServerPublicKeyshare = nil },
+		}
+
+		ke2b, _ := d.KE2(ke2.Serialize())
+		if !ke2.Equal(ke2b) || ke2.Equal(nil) {
+			t.Fatal("unexpected KE2 equality result")
+		}
+
+		for i, mutate := range mutations {
+			ke2b, _ = d.KE2(ke2.Serialize())
+			mutate(ke2b)
+
+			if ke2.Equal(ke2b) {
+				t.Fatalf("expected KE2 inequality for mutation %d", i)
+			}
+		}
+
+		// KE3
+		ke3b := &message.KE3{ClientMac: slices.Clone(ke3.ClientMac)}
+		if !ke3.Equal(ke3b) || ke3.Equal(&message.KE3{ClientMac: flip(ke3.ClientMac)}) || ke3.Equal(nil) {
+			t.Fatal("unexpected KE3 equality result")
+		}
+
+		// Registration messages
+		regClient, err := conf.conf.Client()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req := regClient.RegistrationInit(password)
+		reqb, _ := d.RegistrationRequest(req.Serialize())
+
+		if !req.Equal(reqb) || req.Equal(&message.RegistrationRequest{BlindedMessage: randomElement}) {
+			t.Fatal("unexpected RegistrationRequest equality result")
+		}
+
+		pk, _ := d.DecodeAkePublicKey(pks)
+		resp, _ := server.RegistrationResponse(req, pk, record.CredentialIdentifier, oprfSeed)
+		respb, _ := d.RegistrationResponse(resp.Serialize())
+
+		if !resp.Equal(respb) {
+			t.Fatal("expected RegistrationResponse equality")
+		}
+
+		respb.Pks = randomElement
+		if resp.Equal(respb) {
+			t.Fatal("expected RegistrationResponse inequality on server public key")
+		}
+
+		respb, _ = d.RegistrationResponse(resp.Serialize())
+		respb.EvaluatedMessage = nil
+		if resp.Equal(respb) {
+			t.Fatal("expected RegistrationResponse inequality on nil evaluated message")
+		}
+
+		registration := record.RegistrationRecord
+		recordb, _ := d.RegistrationRecord(registration.Serialize())
+
+		if !registration.Equal(recordb) {
+			t.Fatal("expected RegistrationRecord equality")
+		}
+
+		for i, mutate := range []func(r *message.RegistrationRecord){
+			func(r *message.RegistrationRecord) { r.PublicKey = randomElement },
+			func(r *message.RegistrationRecord) { r.MaskingKey = flip(r.MaskingKey) },
+			func(r *message.RegistrationRecord) { r.Envelope = flip(r.Envelope) },
+			func(r *message.RegistrationRecord) { r.Envelope = r.Envelope[1:] },
+		} {
+			recordb, _ = d.RegistrationRecord(registration.Serialize())
+			mutate(recordb)
+
+			if registration.Equal(recordb) {
+				t.Fatalf("expected RegistrationRecord inequality for mutation %d", i)
+			}
+		}
+	})
+}
+
+func TestRegistrationRecord_Validate(t *testing.T) {
+	testAll(t, func(t2 *testing.T, conf *configuration) {
+		client, err := conf.conf.Client()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		server, err := conf.conf.Server()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		password := []byte("password")
+		sks, pks := conf.conf.KeyGen()
+		oprfSeed := conf.conf.GenerateOPRFSeed()
+		record := buildRecord(internal.RandomBytes(32), oprfSeed, password, pks, client, server)
+
+		if err = server.SetKeyMaterial(nil, sks, pks, oprfSeed); err != nil {
+			t2.Fatal(err)
+		}
+
+		c := server.GetConf()
+		valid := record.RegistrationRecord
+
+		if err := valid.Validate(c); err != nil {
+			t2.Fatal(err)
+		}
+
+		tests := map[string]struct {
+			record *message.RegistrationRecord
+			err    error
+		}{
+			"nil record": {nil, message.ErrNilRecord},
+			"zero public key": {&message.RegistrationRecord{
+				PublicKey:  c.Group.NewElement(),
+				MaskingKey: valid.MaskingKey,
+				Envelope:   valid.Envelope,
+			}, message.ErrInvalidRecordPublicKey},
+			"short masking key": {&message.RegistrationRecord{
+				PublicKey:  valid.PublicKey,
+				MaskingKey: valid.MaskingKey[1:],
+				Envelope:   valid.Envelope,
+			}, message.ErrInvalidMaskingKeyLength},
+			"wrong envelope length": {&message.RegistrationRecord{
+				PublicKey:  valid.PublicKey,
+				MaskingKey: valid.MaskingKey,
+				Envelope:   append(bytes.Clone(valid.Envelope), 0),
+			}, message.ErrInvalidEnvelopeLength},
+		}
+
+		for name, test := range tests {
+			if err := test.record.Validate(c); !errors.Is(err, test.err) {
+				t2.Fatalf("%s: expected %q, got %v", name, test.err, err)
+			}
+
+			// GenerateKE2 applies the same checks.
+			client, err = conf.conf.Client()
+			if err != nil {
+				t2.Fatal(err)
+			}
+
+			ke1 := client.GenerateKE1(password)
+			record := &opaque.ClientRecord{RegistrationRecord: test.record}
+
+			if _, err := server.GenerateKE2(ke1, record); !errors.Is(err, test.err) {
+				t2.Fatalf("%s: expected %q from GenerateKE2, got %v", name, test.err, err)
+			}
+		}
+	})
+}
+
+func TestFrame(t *testing.T) {
+	testAll(t, func(t2 *testing.T, conf *configuration) {
+		client, _ := conf.conf.Client()
+		ke1 := client.GenerateKE1([]byte("password")).Serialize()
+
+		// Round trip, with two frames in the same stream.
+		stream := bytes.NewReader(append(message.Frame(ke1), message.Frame(nil)...))
+
+		msg, err := message.ReadFrame(stream)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		if !bytes.Equal(msg, ke1) {
+			t2.Fatal("expected same message")
+		}
+
+		if msg, err = message.ReadFrame(stream); err != nil || len(msg) != 0 {
+			t2.Fatalf("expected empty message, got %v, %v", msg, err)
+		}
+
+		if _, err = message.ReadFrame(stream); !errors.Is(err, io.EOF) {
+			t2.Fatalf("expected %v, got %v", io.EOF, err)
+		}
+
+		// Short read.
+		framed := message.Frame(ke1)
+		for _, l := range []int{2, 4, len(framed) - 1} {
+			if _, err = message.ReadFrame(bytes.NewReader(framed[:l])); !errors.Is(err, io.ErrUnexpectedEOF) {
+				t2.Fatalf("expected %v for length %d, got %v", io.ErrUnexpectedEOF, l, err)
+			}
+		}
+	})
+}
+
+func TestFrame_TooLarge(t *testing.T) {
+	defer func(m uint32) { message.MaxFrameLength = m }(message.MaxFrameLength)
+
+	msg := make([]byte, 64)
+	message.MaxFrameLength = 63
+
+	if _, err := message.ReadFrame(bytes.NewReader(message.Frame(msg))); !errors.Is(err, message.ErrFrameTooLarge) {
+		t.Fatalf("expected %v, got %v", message.ErrFrameTooLarge, err)
+	}
+
+	// A huge announced length must be rejected before allocating.
+	if _, err := message.ReadFrame(bytes.NewReader([]byte{0xff, 0xff, 0xff, 0xff})); !errors.Is(
+		err, message.ErrFrameTooLarge) {
+		t.Fatalf("expected %v, got %v", message.ErrFrameTooLarge, err)
+	}
+
+	message.MaxFrameLength = 64
+	if _, err := message.ReadFrame(bytes.NewReader(message.Frame(msg))); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMessage_SerializeTo(t *testing.T) {
+	testAll(t, func(t2 *testing.T, conf *configuration) {
+		client, err := conf.conf.Client()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		server, err := conf.conf.Server()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		password := []byte("password")
+		sks, pks := conf.conf.KeyGen()
+		oprfSeed := conf.conf.GenerateOPRFSeed()
+		record := buildRecord(internal.RandomBytes(32), oprfSeed, password, pks, client, server)
+
+		if err = server.SetKeyMaterial(nil, sks, pks, oprfSeed); err != nil {
+			t2.Fatal(err)
+		}
+
+		ke1 := client.GenerateKE1(password)
+
+		ke2, err := server.GenerateKE2(ke1, record)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		ke3, _, err := client.GenerateKE3(ke2)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		prefix := []byte("prefix")
+		tests := []struct {
+			name        string
+			serialize   func() []byte
+			serializeTo func([]byte) []byte
+		}{
+			{"CredentialRequest", ke1.CredentialRequest.Serialize, ke1.CredentialRequest.SerializeTo},
+			{"CredentialResponse", ke2.CredentialResponse.Serialize, ke2.CredentialResponse.SerializeTo},
+			{"KE1", ke1.Serialize, ke1.SerializeTo},
+			{"KE2", ke2.Serialize, ke2.SerializeTo},
+			{"KE3", ke3.Serialize, ke3.SerializeTo},
+		}
+
+		for _, test := range tests {
+			expected := test.serialize()
+
+			if !bytes.Equal(test.serializeTo(nil), expected) {
+				t2.Fatalf("%s: SerializeTo does not match Serialize", test.name)
+			}
+
+			// Appending must preserve the existing content of the destination buffer, and reuse its capacity.
+			dst := make([]byte, len(prefix), len(prefix)+len(expected))
+			copy(dst, prefix)
+
+			out := test.serializeTo(dst)
+			if !bytes.Equal(out, slices.Concat(prefix, expected)) {
+				t2.Fatalf("%s: SerializeTo did not append to the destination", test.name)
+			}
+
+			if &out[0] != &dst[0] {
+				t2.Fatalf("%s: SerializeTo did not reuse the destination buffer", test.name)
+			}
+		}
+	})
+}
+
+func BenchmarkKE1_Serialize(b *testing.B) {
+	client, err := opaque.DefaultConfiguration().Client()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	ke1 := client.GenerateKE1([]byte("password"))
+
+	b.Run("Serialize", func(b *testing.B) {
+		b.ReportAllocs()
+
+		for range b.N {
+			_ = ke1.Serialize()
+		}
+	})
+
+	b.Run("SerializeTo", func(b *testing.B) {
+		b.ReportAllocs()
+
+		var buf []byte
+		for range b.N {
+			buf = ke1.SerializeTo(buf[:0])
+		}
+	})
+}
@@ -0,0 +1,204 @@
+// SPDX-License-Identifier: MIT
+//
+// Copyright (C) 2020-2025 Daniel Bourdrez. All Rights Reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree or at
+// https://spdx.org/licenses/MIT.html
+
+package opaque_test
+
+import (
+	"crypto"
+	"slices"
+	"testing"
+
+	"github.com/bytemare/ksf"
+
+	"github.com/bytemare/opaque"
+	"github.com/bytemare/opaque/internal"
+)
+
+func TestConfiguration_MigrationPlan_Context(t *testing.T) {
+	old := opaque.DefaultConfiguration()
+	old.Context = []byte("v1")
+	conf := old.Clone()
+	conf.Context = []byte("v2")
+
+	plan, err := conf.MigrationPlan(old)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(plan.Incompatible) != 0 || plan.ReRegistrationRequired() || plan.RegenerateServerKeys {
+		t.Fatalf("expected compatible records and keys, got %+v", plan)
+	}
+
+	if !slices.Equal(plan.Changed, []string{"context"}) {
+		t.Fatalf("expected the context to be changed, got %v", plan.Changed)
+	}
+
+	// The records and key material of the old configuration are indeed usable with the new one.
+	password := []byte("password")
+	sks, pks := old.KeyGen()
+	oprfSeed := old.GenerateOPRFSeed()
+
+	oldClient, err := old.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldServer, err := old.Server()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	record := buildRecord(internal.RandomBytes(32), oprfSeed, password, pks, oldClient, oldServer)
+
+	server, err := conf.Server()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = server.SetKeyMaterial(nil, sks, pks, oprfSeed); err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := conf.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ke2, err := server.GenerateKE2(client.GenerateKE1(password), record)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ke3, _, err := client.GenerateKE3(ke2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = server.LoginFinish(ke3); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConfiguration_MigrationPlan_Hash(t *testing.T) {
+	old := opaque.DefaultConfiguration()
+	old.OPRF = opaque.P256Sha256
+	old.AKE = opaque.P256Sha256
+	old.KDF = crypto.SHA256
+	old.MAC = crypto.SHA256
+	old.Hash = crypto.SHA256
+
+	conf := old.Clone()
+	conf.KDF = crypto.SHA512
+	conf.MAC = crypto.SHA512
+	conf.Hash = crypto.SHA512
+
+	plan, err := conf.MigrationPlan(old)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(plan.Incompatible, []string{"KDF", "MAC", "Hash"}) {
+		t.Fatalf("expected KDF, MAC, and Hash to be incompatible, got %v", plan.Incompatible)
+	}
+
+	if len(plan.Changed) != 0 {
+		t.Fatalf("expected no compatible changes, got %v", plan.Changed)
+	}
+
+	if !plan.RegenerateMaskingKeys || !plan.RegenerateEnvelopes || !plan.RegenerateServerKeys ||
+		!plan.ReRegistrationRequired() {
+		t.Fatalf("expected records and server keys to be regenerated, got %+v", plan)
+	}
+}
+
+func TestConfiguration_MigrationPlan(t *testing.T) {
+	for _, test := range []struct {
+		mutate                             func(c *opaque.Configuration)
+		name                               string
+		maskingKeys, envelopes, serverKeys bool
+	}{
+		{
+			name:        "AKE group",
+			mutate:      func(c *opaque.Configuration) { c.AKE = opaque.P256Sha256 },
+			maskingKeys: false, envelopes: true, serverKeys: true,
+		},
+		{
+			name:        "KSF",
+			mutate:      func(c *opaque.Configuration) { c.KSF = ksf.Scrypt },
+			maskingKeys: true, envelopes: true, serverKeys: false,
+		},
+		{
+			name:        "KSF parameters",
+			mutate:      func(c *opaque.Configuration) { _ = c.SetKSFParameters(4, 64*1024, 4) },
+			maskingKeys: true, envelopes: true, serverKeys: false,
+		},
+		{
+			name:        "nonce length",
+			mutate:      func(c *opaque.Configuration) { c.NonceLength = 64 },
+			maskingKeys: false, envelopes: true, serverKeys: false,
+		},
+		{
+			name:        "long identities",
+			mutate:      func(c *opaque.Configuration) { c.LongIdentities = true },
+			maskingKeys: false, envelopes: true, serverKeys: false,
+		},
+		{
+			name:        "KEM",
+			mutate:      func(c *opaque.Configuration) { c.KEM = opaque.MLKEM768 },
+			maskingKeys: false, envelopes: false, serverKeys: false,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			conf := opaque.DefaultConfiguration()
+			test.mutate(conf)
+
+			plan, err := conf.MigrationPlan(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			differing := plan.Incompatible
+			if !test.maskingKeys && !test.envelopes && !test.serverKeys {
+				differing = plan.Changed
+			}
+
+			if !slices.Equal(differing, []string{test.name}) {
+				t.Fatalf("expected %s to differ, got %+v", test.name, plan)
+			}
+
+			if plan.RegenerateMaskingKeys != test.maskingKeys || plan.RegenerateEnvelopes != test.envelopes ||
+				plan.RegenerateServerKeys != test.serverKeys {
+				t.Fatalf("unexpected plan %+v", plan)
+			}
+		})
+	}
+
+	// Explicitly set default KSF parameters are the same as none.
+	conf := opaque.DefaultConfiguration()
+	if err := conf.SetKSFParameters(ksf.Argon2id.Get().Params()...); err != nil {
+		t.Fatal(err)
+	}
+
+	plan, err := conf.MigrationPlan(opaque.DefaultConfiguration())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(plan.Incompatible) != 0 || len(plan.Changed) != 0 {
+		t.Fatalf("expected no differences, got %+v", plan)
+	}
+
+	// Invalid configurations.
+	if _, err = conf.MigrationPlan(new(opaque.Configuration)); err == nil {
+		t.Fatal("expected error on invalid old configuration")
+	}
+
+	if _, err = new(opaque.Configuration).MigrationPlan(conf); err == nil {
+		t.Fatal("expected error on invalid new configuration")
+	}
+}
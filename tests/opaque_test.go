@@ -11,8 +11,13 @@ package opaque_test
 import (
 	"bytes"
 	"crypto"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"math/rand/v2"
 	"reflect"
+	"slices"
 	"strings"
 	"testing"
 
@@ -22,6 +27,7 @@ import (
 	"github.com/bytemare/opaque"
 	"github.com/bytemare/opaque/internal"
 	"github.com/bytemare/opaque/internal/oprf"
+	"github.com/bytemare/opaque/message"
 )
 
 const dbgErr = "%v"
@@ -101,7 +107,10 @@ func testRegistration(t *testing.T, p *testParams) (*opaque.Client, *opaque.Serv
 			t.Fatalf(dbgErr, err)
 		}
 
-		respReg := server.RegistrationResponse(m1, pks, credID, p.oprfSeed)
+		respReg, err := server.RegistrationResponse(m1, pks, credID, p.oprfSeed)
+		if err != nil {
+			t.Fatalf(dbgErr, err)
+		}
 
 		m2s = respReg.Serialize()
 	}
@@ -256,6 +265,12 @@ func isSameConf(a, b *opaque.Configuration) bool {
 	if a.AKE != b.AKE {
 		return false
 	}
+	if a.NonceLength != b.NonceLength {
+		return false
+	}
+	if a.KEM != b.KEM {
+		return false
+	}
 
 	return bytes.Equal(a.Context, b.Context)
 }
@@ -511,3 +526,2199 @@ func TestFakeRecord(t *testing.T) {
 		t.Fatal("expected error on invalid configuration")
 	}
 }
+
+func TestFakeRecordGenerator(t *testing.T) {
+	testAll(t, func(t2 *testing.T, conf *configuration) {
+		generate, err := conf.conf.FakeRecordGenerator()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		credID := internal.RandomBytes(32)
+		record := generate(credID)
+		record2 := generate(credID)
+
+		if !bytes.Equal(record.CredentialIdentifier, credID) {
+			t2.Fatal("unexpected credential identifier")
+		}
+
+		if record.PublicKey.Equal(record2.PublicKey) {
+			t2.Fatal("expected different fake records")
+		}
+
+		// Fake records are usable as real ones.
+		server, err := conf.conf.Server()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		sks, pks := conf.conf.KeyGen()
+		if err = server.SetKeyMaterial(nil, sks, pks, conf.conf.GenerateOPRFSeed()); err != nil {
+			t2.Fatal(err)
+		}
+
+		client, err := conf.conf.Client()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		if _, err = server.GenerateKE2(client.GenerateKE1([]byte("password")), record); err != nil {
+			t2.Fatal(err)
+		}
+	})
+
+	conf := &opaque.Configuration{
+		OPRF:    0,
+		AKE:     0,
+		KSF:     0,
+		KDF:     0,
+		MAC:     0,
+		Hash:    0,
+		Context: nil,
+	}
+
+	if _, err := conf.FakeRecordGenerator(); err == nil {
+		t.Fatal("expected error on invalid configuration")
+	}
+}
+
+func BenchmarkFakeRecord(b *testing.B) {
+	conf := opaque.DefaultConfiguration()
+	credID := internal.RandomBytes(32)
+
+	b.Run("GetFakeRecord", func(b *testing.B) {
+		for range b.N {
+			if _, err := conf.GetFakeRecord(credID); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("FakeRecordGenerator", func(b *testing.B) {
+		generate, err := conf.FakeRecordGenerator()
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		for range b.N {
+			_ = generate(credID)
+		}
+	})
+}
+
+func TestGroup_Lengths(t *testing.T) {
+	tests := []struct {
+		group         opaque.Group
+		elementLength int
+		scalarLength  int
+	}{
+		{opaque.RistrettoSha512, 32, 32},
+		{opaque.Group(2), 0, 0}, // Decaf448 is not available.
+		{opaque.P256Sha256, 33, 32},
+		{opaque.P384Sha384, 49, 48},
+		{opaque.P521Sha512, 67, 66},
+	}
+
+	for _, test := range tests {
+		if l := test.group.ElementLength(); l != test.elementLength {
+			t.Fatalf("%s: expected element length %d, got %d", test.group, test.elementLength, l)
+		}
+
+		if l := test.group.ScalarLength(); l != test.scalarLength {
+			t.Fatalf("%s: expected scalar length %d, got %d", test.group, test.scalarLength, l)
+		}
+
+		if !test.group.Available() {
+			continue
+		}
+
+		// The lengths match the encoding of generated keys.
+		conf := opaque.DefaultConfiguration()
+		conf.AKE = test.group
+		sk, pk := conf.KeyGen()
+
+		if len(sk) != test.scalarLength || len(pk) != test.elementLength {
+			t.Fatalf("%s: unexpected key lengths %d and %d", test.group, len(sk), len(pk))
+		}
+	}
+}
+
+func TestDecaf448Unavailable(t *testing.T) {
+	decaf448 := opaque.Group(2)
+
+	if decaf448.Available() {
+		t.Fatal("Decaf448 is not implemented by the ecc library and must not be available")
+	}
+
+	conf := opaque.DefaultConfiguration()
+	conf.OPRF = decaf448
+	conf.AKE = decaf448
+
+	if _, err := conf.Server(); err == nil || err.Error() != "invalid OPRF group id" {
+		t.Fatalf("expected error on Decaf448 configuration, got %v", err)
+	}
+
+	encoded := opaque.DefaultConfiguration().Serialize()
+	encoded[0] = byte(decaf448)
+
+	if _, err := opaque.DeserializeConfiguration(encoded); err == nil {
+		t.Fatal("expected error on Decaf448 configuration")
+	}
+}
+
+func TestConfiguration_DeriveKeyPair(t *testing.T) {
+	seed := internal.RandomBytes(32)
+	info := []byte("server key")
+
+	testAll(t, func(t2 *testing.T, conf *configuration) {
+		sk, pk, err := conf.conf.DeriveKeyPair(seed, info)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Simulate a process restart with a freshly decoded configuration.
+		restored, err := opaque.DeserializeConfiguration(conf.conf.Serialize())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sk2, pk2, err := restored.DeriveKeyPair(seed, info)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(sk, sk2) || !bytes.Equal(pk, pk2) {
+			t.Fatal("expected the same key pair for the same seed")
+		}
+
+		if sk3, _, _ := conf.conf.DeriveKeyPair(seed, []byte("other")); bytes.Equal(sk, sk3) {
+			t.Fatal("expected different key pairs for different info")
+		}
+
+		g := group.Group(conf.conf.AKE)
+		scalar := g.NewScalar()
+		if err = scalar.Decode(sk); err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(g.Base().Multiply(scalar).Encode(), pk) {
+			t.Fatal("public key does not match the secret key")
+		}
+
+		server, _ := conf.conf.Server()
+		if err = server.SetKeyMaterial(nil, sk, pk, conf.conf.GenerateOPRFSeed()); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, _, err = conf.conf.DeriveKeyPair(seed[:31], info); err == nil {
+			t.Fatal("expected error on short seed")
+		}
+	})
+}
+
+func TestConfiguration_CredentialIdentifier(t *testing.T) {
+	salt := internal.RandomBytes(32)
+
+	testAll(t, func(t2 *testing.T, conf *configuration) {
+		length := conf.conf.KDF.Size()
+		seen := make(map[string]bool)
+
+		for _, username := range []string{"", "alice", "bob", strings.Repeat("long username ", 100)} {
+			id, err := conf.conf.CredentialIdentifier(salt, []byte(username))
+			if err != nil {
+				t2.Fatal(err)
+			}
+
+			if len(id) != length {
+				t2.Fatalf("expected a %d byte identifier, got %d", length, len(id))
+			}
+
+			again, _ := conf.conf.CredentialIdentifier(salt, []byte(username))
+			if !bytes.Equal(id, again) {
+				t2.Fatal("expected the same identifier for the same username")
+			}
+
+			if seen[string(id)] {
+				t2.Fatal("expected different identifiers for different usernames")
+			}
+
+			seen[string(id)] = true
+		}
+
+		// A different salt yields different identifiers.
+		id, _ := conf.conf.CredentialIdentifier(salt, []byte("alice"))
+		other, _ := conf.conf.CredentialIdentifier(internal.RandomBytes(32), []byte("alice"))
+		if bytes.Equal(id, other) {
+			t2.Fatal("expected different identifiers for different salts")
+		}
+
+		if _, err := conf.conf.CredentialIdentifier(salt[:31], []byte("alice")); err == nil {
+			t2.Fatal("expected error on short salt")
+		}
+	})
+}
+
+func TestConfiguration_JSON(t *testing.T) {
+	long := make([]byte, 4096)
+	for i := range long {
+		long[i] = byte(i)
+	}
+
+	for _, ctx := range [][]byte{nil, []byte("context"), long} {
+		conf := opaque.DefaultConfiguration()
+		conf.Context = ctx
+
+		encoded, err := json.Marshal(conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !strings.Contains(string(encoded), `"context":"`+hex.EncodeToString(ctx)+`"`) {
+			t.Fatalf("context is not hex encoded: %s", encoded)
+		}
+
+		decoded := new(opaque.Configuration)
+		if err = json.Unmarshal(encoded, decoded); err != nil {
+			t.Fatal(err)
+		}
+
+		if !isSameConf(conf, decoded) {
+			t.Fatalf("Unexpected inequality:\n\t%v\n\t%v", conf, decoded)
+		}
+	}
+}
+
+func TestConfiguration_JSON_Invalid(t *testing.T) {
+	encoded, err := json.Marshal(opaque.DefaultConfiguration())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var raw map[string]any
+	if err = json.Unmarshal(encoded, &raw); err != nil {
+		t.Fatal(err)
+	}
+
+	// Invalid KSF.
+	raw["ksf"] = 10
+	bad, _ := json.Marshal(raw)
+
+	if err = json.Unmarshal(bad, new(opaque.Configuration)); err == nil || err.Error() != "invalid KSF id" {
+		t.Fatalf("expected error on invalid KSF id, got %v", err)
+	}
+
+	// Unknown field.
+	raw["ksf"] = 1
+	raw["unknown"] = true
+	bad, _ = json.Marshal(raw)
+
+	if err = json.Unmarshal(bad, new(opaque.Configuration)); err == nil {
+		t.Fatal("expected error on unknown field")
+	}
+
+	// Invalid hex context.
+	delete(raw, "unknown")
+	raw["context"] = "zz"
+	bad, _ = json.Marshal(raw)
+
+	if err = json.Unmarshal(bad, new(opaque.Configuration)); err == nil {
+		t.Fatal("expected error on invalid context encoding")
+	}
+}
+
+func TestConfiguration_NonceLength(t *testing.T) {
+	testAll(t, func(t2 *testing.T, conf *configuration) {
+		c := *conf.conf
+		c.NonceLength = 64
+
+		client, err := c.Client()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		server, err := c.Server()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		password := []byte("password")
+		sks, pks := c.KeyGen()
+		oprfSeed := c.GenerateOPRFSeed()
+		record := buildRecord(internal.RandomBytes(32), oprfSeed, password, pks, client, server)
+
+		if err = server.SetKeyMaterial(nil, sks, pks, oprfSeed); err != nil {
+			t2.Fatal(err)
+		}
+
+		ke2, err := server.GenerateKE2(client.GenerateKE1(password), record)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		if len(ke2.ServerNonce) != 64 || len(ke2.MaskingNonce) != 64 {
+			t2.Fatalf("unexpected nonce lengths %d and %d", len(ke2.ServerNonce), len(ke2.MaskingNonce))
+		}
+
+		// Round-trip the messages through the deserializer.
+		d, err := c.Deserializer()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		if _, err = d.RegistrationRecord(record.RegistrationRecord.Serialize()); err != nil {
+			t2.Fatal(err)
+		}
+
+		if ke2, err = d.KE2(ke2.Serialize()); err != nil {
+			t2.Fatal(err)
+		}
+
+		ke3, _, err := client.GenerateKE3(ke2)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		if err = server.LoginFinish(ke3); err != nil {
+			t2.Fatal(err)
+		}
+
+		// Serialization round trip.
+		decoded, err := opaque.DeserializeConfiguration(c.Serialize())
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		if !isSameConf(&c, decoded) {
+			t2.Fatalf("Unexpected inequality:\n\t%v\n\t%v", c, decoded)
+		}
+	})
+}
+
+func TestConfiguration_NonceLength_Default(t *testing.T) {
+	conf := opaque.DefaultConfiguration()
+	conf.NonceLength = internal.NonceLength
+
+	if !bytes.Equal(conf.Serialize(), opaque.DefaultConfiguration().Serialize()) {
+		t.Fatal("explicit default nonce length should not change the encoding")
+	}
+}
+
+func TestConfiguration_NonceLength_Invalid(t *testing.T) {
+	conf := opaque.DefaultConfiguration()
+	conf.NonceLength = 16
+
+	if _, err := conf.Client(); err == nil {
+		t.Fatal("expected error on short nonce length")
+	}
+
+	// Trailing bytes that are not a nonce length.
+	encoded := append(opaque.DefaultConfiguration().Serialize(), 0)
+	if _, err := opaque.DeserializeConfiguration(encoded); !errors.Is(err, internal.ErrConfigurationInvalidLength) {
+		t.Fatalf("expected %q, got %q", internal.ErrConfigurationInvalidLength, err)
+	}
+
+	// Encoded nonce length too short.
+	encoded = append(opaque.DefaultConfiguration().Serialize(), 0, 16)
+	if _, err := opaque.DeserializeConfiguration(encoded); err == nil {
+		t.Fatal("expected error on short nonce length")
+	}
+}
+
+func TestConfiguration_SetKSFParameters(t *testing.T) {
+	tests := []struct {
+		name   string
+		params []int
+		other  []int
+		id     ksf.Identifier
+	}{
+		{name: "Scrypt", id: ksf.Scrypt, params: []int{1024, 8, 1}, other: []int{2048, 8, 1}},
+		{name: "PBKDF2", id: ksf.PBKDF2Sha512, params: []int{1000}, other: []int{1001}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conf := opaque.DefaultConfiguration()
+			conf.KSF = test.id
+
+			if err := conf.SetKSFParameters(test.params...); err != nil {
+				t.Fatal(err)
+			}
+
+			regClient, err := conf.Client()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			server, err := conf.Server()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			password := []byte("password")
+			sks, pks := conf.KeyGen()
+			oprfSeed := conf.GenerateOPRFSeed()
+			record := buildRecord(internal.RandomBytes(32), oprfSeed, password, pks, regClient, server)
+
+			if err = server.SetKeyMaterial(nil, sks, pks, oprfSeed); err != nil {
+				t.Fatal(err)
+			}
+
+			// login returns a new client with the given configuration and the server's response to its KE1.
+			login := func(c *opaque.Configuration) (*opaque.Client, *message.KE2) {
+				client, err := c.Client()
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				ke2, err := server.GenerateKE2(client.GenerateKE1(password), record)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				return client, ke2
+			}
+
+			// Same parameters at login succeed.
+			client, ke2 := login(conf)
+			if _, _, err := client.GenerateKE3(ke2); err != nil {
+				t.Fatal(err)
+			}
+
+			// The parameters don't change the serialized configuration.
+			plain := opaque.DefaultConfiguration()
+			plain.KSF = test.id
+
+			if !bytes.Equal(conf.Serialize(), plain.Serialize()) {
+				t.Fatal("KSF parameters must not be part of the serialized configuration")
+			}
+
+			// Mismatched parameters at login silently fail envelope recovery.
+			other := *conf
+			if err := other.SetKSFParameters(test.other...); err != nil {
+				t.Fatal(err)
+			}
+
+			client, ke2 = login(&other)
+			if _, _, err := client.GenerateKE3(ke2); err == nil {
+				t.Fatal("expected login to fail with mismatched KSF parameters")
+			}
+		})
+	}
+}
+
+func TestConfiguration_SetKSFParameters_Invalid(t *testing.T) {
+	conf := opaque.DefaultConfiguration()
+	conf.KSF = ksf.Scrypt
+
+	if err := conf.SetKSFParameters(1000); err == nil {
+		t.Fatal("expected error on wrong number of parameters")
+	}
+
+	if err := conf.SetKSFParameters(1024, 8, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// Changing the KSF invalidates the parameters.
+	conf.KSF = ksf.PBKDF2Sha512
+	if _, err := conf.Client(); err == nil {
+		t.Fatal("expected error on parameters not matching the KSF")
+	}
+
+	// Resetting the parameters.
+	if err := conf.SetKSFParameters(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := conf.Client(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConfiguration_SetKSFParameters_InvalidValues(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		params []int
+		id     ksf.Identifier
+	}{
+		{name: "Scrypt N not a power of 2", id: ksf.Scrypt, params: []int{1000, 8, 1}},
+		{name: "Scrypt N of 1", id: ksf.Scrypt, params: []int{1, 8, 1}},
+		{name: "Scrypt zero r", id: ksf.Scrypt, params: []int{1024, 0, 1}},
+		{name: "Scrypt zero p", id: ksf.Scrypt, params: []int{1024, 8, 0}},
+		{name: "Scrypt r*p too large", id: ksf.Scrypt, params: []int{1024, 1 << 15, 1 << 15}},
+		{name: "Argon2id zero time", id: ksf.Argon2id, params: []int{0, 64 * 1024, 4}},
+		{name: "Argon2id zero memory", id: ksf.Argon2id, params: []int{3, 0, 4}},
+		{name: "Argon2id zero threads", id: ksf.Argon2id, params: []int{3, 64 * 1024, 0}},
+		{name: "Argon2id too many threads", id: ksf.Argon2id, params: []int{3, 64 * 1024, 256}},
+		{name: "PBKDF2 zero iterations", id: ksf.PBKDF2Sha512, params: []int{0}},
+		{name: "PBKDF2 negative iterations", id: ksf.PBKDF2Sha512, params: []int{-1}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			conf := opaque.DefaultConfiguration()
+			conf.KSF = test.id
+
+			if err := conf.SetKSFParameters(test.params...); err == nil {
+				t.Fatal("expected error on invalid KSF parameter values")
+			}
+		})
+	}
+
+	// Valid parameters for a KSF are rejected, instead of panicking, once the configuration uses another KSF.
+	conf := opaque.DefaultConfiguration()
+	if err := conf.SetKSFParameters(3, 64*1024, 4); err != nil {
+		t.Fatal(err)
+	}
+
+	conf.KSF = ksf.Scrypt
+
+	if _, err := conf.Client(); err == nil {
+		t.Fatal("expected error on Argon2id parameters used with Scrypt")
+	}
+}
+
+func TestConfiguration_Clone(t *testing.T) {
+	conf := opaque.DefaultConfiguration()
+	conf.Context = []byte("context")
+	conf.Policy = opaque.PermissivePolicy()
+
+	clone := conf.Clone()
+	if !isSameConf(conf, clone) || !bytes.Equal(conf.Serialize(), clone.Serialize()) {
+		t.Fatal("clone differs from the original")
+	}
+
+	clone.Context[0] = 'x'
+	clone.Policy.RequireContext = true
+
+	if conf.Context[0] != 'c' || conf.Policy.RequireContext {
+		t.Fatal("mutating the clone affected the original")
+	}
+}
+
+func TestConfiguration_ContextAliasing(t *testing.T) {
+	conf := opaque.DefaultConfiguration()
+	conf.Context = []byte("context")
+
+	client, err := conf.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server, err := conf.Server()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	password := []byte("password")
+	sks, pks := conf.KeyGen()
+	oprfSeed := conf.GenerateOPRFSeed()
+	record := buildRecord(internal.RandomBytes(32), oprfSeed, password, pks, client, server)
+
+	if err = server.SetKeyMaterial(nil, sks, pks, oprfSeed); err != nil {
+		t.Fatal(err)
+	}
+
+	// Mutating the original context after creating the server and the clients must not affect them.
+	ke1 := client.GenerateKE1(password)
+	conf.Context[0] = 'x'
+
+	ke2, err := server.GenerateKE2(ke1, record)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ke3, _, err := client.GenerateKE3(ke2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = server.LoginFinish(ke3); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(server.GetConf().Context, []byte("context")) {
+		t.Fatal("server context was aliased")
+	}
+}
+
+func TestConfiguration_WithContextSuffix(t *testing.T) {
+	testAll(t, func(t2 *testing.T, conf *configuration) {
+		base := conf.conf.Clone()
+		base.Context = []byte("base")
+
+		regClient, err := base.Client()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		regServer, err := base.Server()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		password := []byte("password")
+		sks, pks := base.KeyGen()
+		oprfSeed := base.GenerateOPRFSeed()
+		record := buildRecord(internal.RandomBytes(32), oprfSeed, password, pks, regClient, regServer)
+
+		tenantA, err := base.WithContextSuffix([]byte("tenant-a"))
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		tenantB, err := base.WithContextSuffix([]byte("tenant-b"))
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		if !bytes.Equal(tenantA.Context, []byte("base\x00\x08tenant-a")) || !bytes.Equal(base.Context, []byte("base")) {
+			t2.Fatalf("unexpected composed context %q", tenantA.Context)
+		}
+
+		// login runs a login with the base record, keys, and password, and returns the session key.
+		login := func(clientConf, serverConf *opaque.Configuration) ([]byte, error) {
+			server, err := serverConf.Server()
+			if err != nil {
+				t2.Fatal(err)
+			}
+
+			if err = server.SetKeyMaterial(nil, sks, pks, oprfSeed); err != nil {
+				t2.Fatal(err)
+			}
+
+			client, err := clientConf.Client()
+			if err != nil {
+				t2.Fatal(err)
+			}
+
+			ke2, err := server.GenerateKE2(client.GenerateKE1(password), record)
+			if err != nil {
+				t2.Fatal(err)
+			}
+
+			ke3, _, err := client.GenerateKE3(ke2)
+			if err != nil {
+				return nil, err
+			}
+
+			if err = server.LoginFinish(ke3); err != nil {
+				return nil, err
+			}
+
+			return server.SessionKey(), nil
+		}
+
+		keyA, err := login(tenantA, tenantA)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		keyB, err := login(tenantB, tenantB)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		if bytes.Equal(keyA, keyB) {
+			t2.Fatal("expected different session keys for different context suffixes")
+		}
+
+		if _, err = login(tenantA, tenantB); err == nil {
+			t2.Fatal("expected a login with mismatched context suffixes to fail")
+		}
+
+		// A suffix making the context too long is rejected instead of panicking.
+		if _, err = base.WithContextSuffix(make([]byte, 1<<16)); err == nil {
+			t2.Fatal("expected error on too long context suffix")
+		}
+
+		if _, err = base.WithContextSuffix(make([]byte, 1<<16-1-len(base.Context)-2)); err != nil {
+			t2.Fatal(err)
+		}
+	})
+}
+
+func TestConfiguration_ReservedContext(t *testing.T) {
+	for _, context := range []string{"OPAQUEv1-", "OPAQUEv1-app", "OPRFV1-app"} {
+		conf := opaque.DefaultConfiguration()
+		conf.Context = []byte(context)
+
+		if _, err := conf.Client(); !errors.Is(err, opaque.ErrReservedContext) {
+			t.Fatalf("expected %q for context %q, got %v", opaque.ErrReservedContext, context, err)
+		}
+
+		if _, err := conf.Server(); !errors.Is(err, opaque.ErrReservedContext) {
+			t.Fatalf("expected %q for context %q, got %v", opaque.ErrReservedContext, context, err)
+		}
+	}
+
+	// Contexts only resembling the reserved prefixes are accepted.
+	for _, context := range []string{"OPAQUE-POC", "app-OPAQUEv1-", "OPAQUEv1"} {
+		conf := opaque.DefaultConfiguration()
+		conf.Context = []byte(context)
+
+		if _, err := conf.Client(); err != nil {
+			t.Fatalf("unexpected error for context %q: %v", context, err)
+		}
+
+		if _, err := conf.Server(); err != nil {
+			t.Fatalf("unexpected error for context %q: %v", context, err)
+		}
+	}
+}
+
+func TestConfiguration_ContextTooLong(t *testing.T) {
+	conf := opaque.DefaultConfiguration()
+	conf.Context = make([]byte, 1<<16)
+
+	if _, err := conf.Client(); err == nil {
+		t.Fatal("expected error on too long context")
+	}
+
+	if _, err := conf.Server(); err == nil {
+		t.Fatal("expected error on too long context")
+	}
+
+	if _, err := conf.Deserializer(); err == nil {
+		t.Fatal("expected error on too long context")
+	}
+
+	if _, err := conf.MarshalBinary(); err == nil {
+		t.Fatal("expected error on too long context")
+	}
+
+	if conf.RegistrationRecordSize() != 0 || conf.KE1Size() != 0 || conf.KE2Size() != 0 || conf.KE3Size() != 0 {
+		t.Fatal("expected size 0 for an invalid configuration")
+	}
+
+	// The longest encodable context is accepted.
+	conf.Context = make([]byte, 1<<16-1)
+
+	if _, err := conf.Client(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClientServer_Group(t *testing.T) {
+	testAll(t, func(t2 *testing.T, conf *configuration) {
+		client, err := conf.conf.Client()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		server, err := conf.conf.Server()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		if client.Group() != conf.conf.AKE {
+			t2.Fatalf("expected client group %s, got %s", conf.conf.AKE, client.Group())
+		}
+
+		if server.Group() != conf.conf.AKE {
+			t2.Fatalf("expected server group %s, got %s", conf.conf.AKE, server.Group())
+		}
+	})
+
+	// With different OPRF and AKE groups, the AKE group is returned.
+	conf := opaque.DefaultConfiguration()
+	conf.AKE = opaque.P256Sha256
+
+	server, err := conf.Server()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if server.Group() != opaque.P256Sha256 {
+		t.Fatalf("expected group %s, got %s", opaque.P256Sha256, server.Group())
+	}
+}
+
+func TestConfiguration_LongIdentities(t *testing.T) {
+	serverIdentity := bytes.Repeat([]byte{1}, 100*1024)
+	clientIdentity := bytes.Repeat([]byte{2}, 100*1024)
+
+	// The default 2-byte length prefixes can't encode a 100KB identity.
+	plain := opaque.DefaultConfiguration()
+
+	plainClient, err := plain.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plainServer, err := plain.Server()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	password := []byte("password")
+	sks, pks := plain.KeyGen()
+	oprfSeed := plain.GenerateOPRFSeed()
+	long := buildRecord(internal.RandomBytes(32), oprfSeed, password, pks, plainClient, plainServer)
+	long.ClientIdentity = clientIdentity
+
+	if err = plainServer.SetKeyMaterial(nil, sks, pks, oprfSeed); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = plainServer.SetKeyMaterial(serverIdentity, sks, pks, oprfSeed); !errors.Is(
+		err, opaque.ErrIdentityTooLong) {
+		t.Fatalf("expected %q, got %v", opaque.ErrIdentityTooLong, err)
+	}
+
+	if _, err = plainServer.GenerateKE2(plainClient.GenerateKE1(password), long); !errors.Is(
+		err, opaque.ErrIdentityTooLong) {
+		t.Fatalf("expected %q, got %v", opaque.ErrIdentityTooLong, err)
+	}
+
+	// With 4-byte length prefixes, the registration and login succeed.
+	conf := opaque.DefaultConfiguration()
+	conf.LongIdentities = true
+
+	regClient, err := conf.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server, err := conf.Server()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sks, pks = conf.KeyGen()
+	oprfSeed = conf.GenerateOPRFSeed()
+	shortRecord := buildRecord(internal.RandomBytes(32), oprfSeed, password, pks, regClient, server)
+
+	if err = server.SetKeyMaterial(serverIdentity, sks, pks, oprfSeed); err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := conf.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pk, _ := server.Deserialize.DecodeAkePublicKey(pks)
+	credID := []byte("client")
+
+	response, err := server.RegistrationResponse(client.RegistrationInit(password), pk, credID, oprfSeed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	upload, _ := client.RegistrationFinalize(response, opaque.ClientRegistrationFinalizeOptions{
+		ClientIdentity: clientIdentity,
+		ServerIdentity: serverIdentity,
+	})
+	record := &opaque.ClientRecord{
+		RegistrationRecord:   upload,
+		CredentialIdentifier: credID,
+		ClientIdentity:       clientIdentity,
+		PreviousOPRFSeed:     false,
+	}
+
+	// The record is stored and sealed with 4-byte length prefixes, which a default configuration rejects.
+	recordKey := internal.RandomBytes(32)
+
+	sealedRecord, err := record.Seal(recordKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if record, err = conf.OpenClientRecord(recordKey, sealedRecord); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(record.ClientIdentity, clientIdentity) || !bytes.Equal(record.CredentialIdentifier, credID) {
+		t.Fatal("expected the opened record to hold the same identities")
+	}
+
+	if _, err = opaque.DefaultConfiguration().OpenClientRecord(recordKey, sealedRecord); !errors.Is(
+		err, opaque.ErrIdentityTooLong) {
+		t.Fatalf("expected %q, got %v", opaque.ErrIdentityTooLong, err)
+	}
+
+	client, err = conf.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ke2, err := server.GenerateKE2(client.GenerateKE1(password), record)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ke3, _, err := client.GenerateKE3(ke2, opaque.GenerateKE3Options{
+		ClientIdentity: clientIdentity,
+		ServerIdentity: serverIdentity,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = server.LoginFinish(ke3); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(client.SessionKey(), server.SessionKey()) {
+		t.Fatal("expected the same session key")
+	}
+
+	// The long server identity survives sealing the key material.
+	key := internal.RandomBytes(32)
+
+	sealed, err := server.ExportKeyMaterialSealed(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	standby, _ := conf.Server()
+	if err = standby.ImportKeyMaterialSealed(key, sealed); err != nil {
+		t.Fatal(err)
+	}
+
+	// The encodings are not compatible: a default client can't log in with a long identities server.
+	defaultClient, _ := opaque.DefaultConfiguration().Client()
+	server.Ake.Flush()
+
+	if err = server.SetKeyMaterial(nil, sks, pks, oprfSeed); err != nil {
+		t.Fatal(err)
+	}
+
+	if ke2, err = server.GenerateKE2(defaultClient.GenerateKE1(password), shortRecord); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err = defaultClient.GenerateKE3(ke2); err == nil {
+		t.Fatal("expected a login with mismatched identity encodings to fail")
+	}
+}
+
+func TestConfiguration_LongContext(t *testing.T) {
+	context := bytes.Repeat([]byte{3}, 100*1024)
+
+	// The default 2-byte length prefix can't encode a 100KB context.
+	conf := opaque.DefaultConfiguration()
+	conf.Context = context
+
+	if _, err := conf.Client(); err == nil {
+		t.Fatal("expected error on too long context")
+	}
+
+	// With LongIdentities, the configuration round-trips.
+	conf.LongIdentities = true
+
+	encoded, err := conf.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := opaque.DeserializeConfiguration(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !decoded.LongIdentities || !bytes.Equal(decoded.Context, context) || !bytes.Equal(decoded.Serialize(), encoded) {
+		t.Fatal("expected the long context configuration to round-trip")
+	}
+
+	// Long identities are part of the serialized configuration and its fingerprint.
+	short := opaque.DefaultConfiguration()
+	short.KEM = opaque.MLKEM768
+	long := short.Clone()
+	long.LongIdentities = true
+
+	if decoded, err = opaque.DeserializeConfiguration(long.Serialize()); err != nil || !decoded.LongIdentities ||
+		decoded.KEM != opaque.MLKEM768 {
+		t.Fatalf("expected the long identities configuration to round-trip, got %v", err)
+	}
+
+	if err = short.Compatible(long.Serialize()); !errors.Is(err, opaque.ErrIncompatibleConfiguration) {
+		t.Fatalf("expected %q, got %v", opaque.ErrIncompatibleConfiguration, err)
+	}
+
+	shortDeserializer, _ := short.Deserializer()
+	longDeserializer, _ := long.Deserializer()
+
+	if bytes.Equal(shortDeserializer.ConfigFingerprint(), longDeserializer.ConfigFingerprint()) {
+		t.Fatal("expected different fingerprints with and without long identities")
+	}
+
+	// A login with the long context, finished by another server from a sealed or tagged state.
+	client, err := conf.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server, err := conf.Server()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	password := []byte("password")
+	sks, pks := conf.KeyGen()
+	oprfSeed := conf.GenerateOPRFSeed()
+	record := buildRecord(internal.RandomBytes(32), oprfSeed, password, pks, client, server)
+
+	if err = server.SetKeyMaterial(nil, sks, pks, oprfSeed); err != nil {
+		t.Fatal(err)
+	}
+
+	ke2, err := server.GenerateKE2(client.GenerateKE1(password), record)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := internal.RandomBytes(32)
+
+	sealed, err := server.SerializeStateSealed(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tagged, err := server.SerializeStateTagged(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ke3, _, err := client.GenerateKE3(ke2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, set := range []func(s *opaque.Server) error{
+		func(s *opaque.Server) error { return s.SetAKEStateSealed(key, sealed) },
+		func(s *opaque.Server) error { return s.SetAKEStateTagged(key, tagged) },
+	} {
+		server, _ := conf.Server()
+		if err = set(server); err != nil {
+			t.Fatal(err)
+		}
+
+		if err = server.LoginFinish(ke3); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestConfiguration_KEM(t *testing.T) {
+	conf := opaque.DefaultConfiguration()
+	conf.KEM = opaque.MLKEM768
+
+	decoded, err := opaque.DeserializeConfiguration(conf.Serialize())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !isSameConf(conf, decoded) {
+		t.Fatalf("Unexpected inequality:\n\t%v\n\t%v", conf, decoded)
+	}
+
+	conf.NonceLength = 64
+
+	if decoded, err = opaque.DeserializeConfiguration(conf.Serialize()); err != nil {
+		t.Fatal(err)
+	}
+
+	if !isSameConf(conf, decoded) {
+		t.Fatalf("Unexpected inequality:\n\t%v\n\t%v", conf, decoded)
+	}
+
+	// Invalid KEM identifiers.
+	conf.KEM = 2
+	if _, err = conf.Client(); err == nil {
+		t.Fatal("expected error on invalid KEM id")
+	}
+
+	encoded := append(opaque.DefaultConfiguration().Serialize(), 0, 32, 0)
+	if _, err = opaque.DeserializeConfiguration(encoded); err == nil {
+		t.Fatal("expected error on zero KEM id")
+	}
+
+	encoded = append(opaque.DefaultConfiguration().Serialize(), 0, 32, 2)
+	if _, err = opaque.DeserializeConfiguration(encoded); err == nil {
+		t.Fatal("expected error on invalid KEM id")
+	}
+}
+
+func TestHybridKEM(t *testing.T) {
+	testAll(t, func(t2 *testing.T, conf *configuration) {
+		c := conf.conf.Clone()
+		c.KEM = opaque.MLKEM768
+
+		client, err := c.Client()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		server, err := c.Server()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		password := []byte("password")
+		sks, pks := c.KeyGen()
+		oprfSeed := c.GenerateOPRFSeed()
+		record := buildRecord(internal.RandomBytes(32), oprfSeed, password, pks, client, server)
+
+		if err = server.SetKeyMaterial(nil, sks, pks, oprfSeed); err != nil {
+			t2.Fatal(err)
+		}
+
+		ke1 := client.GenerateKE1(password)
+
+		if len(ke1.KEMEncapsulationKey) == 0 {
+			t2.Fatal("expected a KEM encapsulation key in KE1")
+		}
+
+		d, err := c.Deserializer()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		if ke1, err = d.KE1(ke1.Serialize()); err != nil {
+			t2.Fatal(err)
+		}
+
+		ke2, err := server.GenerateKE2(ke1, record)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		if ke2, err = d.KE2(ke2.Serialize()); err != nil {
+			t2.Fatal(err)
+		}
+
+		ke3, _, err := client.GenerateKE3(ke2)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		if err = server.LoginFinish(ke3); err != nil {
+			t2.Fatal(err)
+		}
+
+		if !bytes.Equal(client.SessionKey(), server.SessionKey()) {
+			t2.Fatal("expected identical session keys")
+		}
+
+		// Resuming the hybrid login restores its KE3 message and session key.
+		state, err := client.SuspendLogin()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		resumed, err := c.Client()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		ke3b, _, err := resumed.ResumeLogin(state)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		if !bytes.Equal(ke3.Serialize(), ke3b.Serialize()) || !bytes.Equal(resumed.SessionKey(), client.SessionKey()) {
+			t2.Fatal("expected identical KE3 messages and session keys")
+		}
+
+		// Classic messages are rejected by a hybrid deserializer.
+		classic, err := conf.conf.Client()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		if _, err = d.KE1(classic.GenerateKE1(password).Serialize()); err == nil {
+			t2.Fatal("expected error on KE1 without KEM encapsulation key")
+		}
+	})
+}
+
+func TestHybridKEM_DeterministicKE2(t *testing.T) {
+	testAll(t, func(t2 *testing.T, conf *configuration) {
+		c := conf.conf.Clone()
+		c.KEM = opaque.MLKEM768
+
+		client, err := c.Client()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		server, err := c.Server()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		sks, pks := c.KeyGen()
+		oprfSeed := c.GenerateOPRFSeed()
+		record := buildRecord(internal.RandomBytes(32), oprfSeed, []byte("yo"), pks, client, server)
+
+		if err = server.SetKeyMaterial(nil, sks, pks, oprfSeed); err != nil {
+			t2.Fatal(err)
+		}
+
+		ke1 := client.GenerateKE1([]byte("yo"))
+		options := opaque.NewGenerateKE2Options(
+			opaque.WithKeyShareSeed(internal.RandomBytes(32)),
+			opaque.WithAKENonce(internal.RandomBytes(32)),
+			opaque.WithMaskingNonce(internal.RandomBytes(32)),
+		)
+
+		ke2, err := server.GenerateKE2(ke1, record, options)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		server.Reset()
+
+		ke2b, err := server.GenerateKE2(ke1, record, options)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		if !bytes.Equal(ke2.Serialize(), ke2b.Serialize()) {
+			t2.Fatal("expected identical KE2 messages from the same options")
+		}
+
+		// The reproducible KE2 is a valid one.
+		ke3, _, err := client.GenerateKE3(ke2b)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		if err = server.LoginFinish(ke3); err != nil {
+			t2.Fatal(err)
+		}
+
+		// Without a seed nor a custom random source, the encapsulation is random.
+		server.Reset()
+
+		ke2c, err := server.GenerateKE2(ke1, record)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		server.Reset()
+
+		ke2d, err := server.GenerateKE2(ke1, record)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		if bytes.Equal(ke2c.KEMCiphertext, ke2d.KEMCiphertext) {
+			t2.Fatal("expected different KEM ciphertexts")
+		}
+	})
+}
+
+func TestHybridKEM_Tampered(t *testing.T) {
+	testAll(t, func(t2 *testing.T, conf *configuration) {
+		c := conf.conf.Clone()
+		c.KEM = opaque.MLKEM768
+
+		client, err := c.Client()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		server, err := c.Server()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		password := []byte("password")
+		sks, pks := c.KeyGen()
+		oprfSeed := c.GenerateOPRFSeed()
+		record := buildRecord(internal.RandomBytes(32), oprfSeed, password, pks, client, server)
+
+		if err = server.SetKeyMaterial(nil, sks, pks, oprfSeed); err != nil {
+			t2.Fatal(err)
+		}
+
+		// A tampered ciphertext yields a different shared secret, and the server MAC fails to verify.
+		ke2, err := server.GenerateKE2(client.GenerateKE1(password), record)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		ke2.KEMCiphertext[0] ^= 0xff
+
+		if _, _, err := client.GenerateKE3(ke2); err == nil {
+			t2.Fatal("expected error on tampered KEM ciphertext")
+		}
+
+		server.Ake.Flush()
+
+		// A malformed encapsulation key is rejected by the server.
+		client, err = c.Client()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		ke1 := client.GenerateKE1(password)
+		ke1.KEMEncapsulationKey = bytes.Repeat([]byte{0xff}, len(ke1.KEMEncapsulationKey))
+
+		if _, err := server.GenerateKE2(ke1, record); !errors.Is(err, opaque.ErrInvalidKEMKeyShare) {
+			t2.Fatalf("expected %q, got %q", opaque.ErrInvalidKEMKeyShare, err)
+		}
+	})
+}
+
+func TestHybridKEM_ClassicUnchanged(t *testing.T) {
+	testAll(t, func(t2 *testing.T, conf *configuration) {
+		client, err := conf.conf.Client()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		server, err := conf.conf.Server()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		password := []byte("password")
+		sks, pks := conf.conf.KeyGen()
+		oprfSeed := conf.conf.GenerateOPRFSeed()
+		record := buildRecord(internal.RandomBytes(32), oprfSeed, password, pks, client, server)
+
+		if err = server.SetKeyMaterial(nil, sks, pks, oprfSeed); err != nil {
+			t2.Fatal(err)
+		}
+
+		ke1 := client.GenerateKE1(password)
+
+		ke2, err := server.GenerateKE2(ke1, record)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		if ke1.KEMEncapsulationKey != nil || ke2.KEMCiphertext != nil {
+			t2.Fatal("unexpected KEM key share in the classic path")
+		}
+	})
+}
+
+func TestConfiguration_Binary(t *testing.T) {
+	testAll(t, func(t2 *testing.T, conf *configuration) {
+		c := conf.conf.Clone()
+		c.Context = []byte("context")
+		c.NonceLength = 64
+
+		encoded, err := c.MarshalBinary()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		if !bytes.Equal(encoded, c.Serialize()) {
+			t2.Fatal("expected MarshalBinary to match Serialize")
+		}
+
+		decoded := new(opaque.Configuration)
+		if err = decoded.UnmarshalBinary(encoded); err != nil {
+			t2.Fatal(err)
+		}
+
+		if !isSameConf(c, decoded) {
+			t2.Fatalf("Unexpected inequality:\n\t%v\n\t%v", c, decoded)
+		}
+
+		// Appending preserves the prefix.
+		prefix := []byte("prefix")
+		appended, err := c.AppendBinary(bytes.Clone(prefix))
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		if !bytes.Equal(appended[:len(prefix)], prefix) || !bytes.Equal(appended[len(prefix):], encoded) {
+			t2.Fatal("unexpected AppendBinary output")
+		}
+
+		// Appending into a buffer with enough capacity doesn't allocate.
+		buf := make([]byte, 0, len(encoded))
+		if allocs := testing.AllocsPerRun(10, func() { _, _ = c.AppendBinary(buf[:0]) }); allocs != 0 {
+			t2.Fatalf("expected no allocations, got %v", allocs)
+		}
+
+		// Embedding in a gob container.
+		var network bytes.Buffer
+		if err = gob.NewEncoder(&network).Encode(c); err != nil {
+			t2.Fatal(err)
+		}
+
+		decoded = new(opaque.Configuration)
+		if err = gob.NewDecoder(&network).Decode(decoded); err != nil {
+			t2.Fatal(err)
+		}
+
+		if !isSameConf(c, decoded) {
+			t2.Fatalf("Unexpected inequality:\n\t%v\n\t%v", c, decoded)
+		}
+	})
+
+	if err := new(opaque.Configuration).UnmarshalBinary([]byte{1, 2, 3}); err == nil {
+		t.Fatal("expected error on invalid encoding")
+	}
+
+	conf := opaque.DefaultConfiguration()
+	conf.Context = make([]byte, 1<<16)
+
+	if _, err := conf.AppendBinary(nil); err == nil {
+		t.Fatal("expected error on too long context")
+	}
+}
+
+func TestReset(t *testing.T) {
+	testAll(t, func(t2 *testing.T, conf *configuration) {
+		client, err := conf.conf.Client()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		server, err := conf.conf.Server()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		password := []byte("password")
+		sks, pks := conf.conf.KeyGen()
+		oprfSeed := conf.conf.GenerateOPRFSeed()
+		record := buildRecord(internal.RandomBytes(32), oprfSeed, password, pks, client, server)
+
+		if err = server.SetKeyMaterial(nil, sks, pks, oprfSeed); err != nil {
+			t2.Fatal(err)
+		}
+
+		sessionKeys := make([][]byte, 2)
+
+		for i := range sessionKeys {
+			ke1 := client.GenerateKE1(password)
+
+			ke2, err := server.GenerateKE2(ke1, record)
+			if err != nil {
+				t2.Fatal(err)
+			}
+
+			ke3, _, err := client.GenerateKE3(ke2)
+			if err != nil {
+				t2.Fatal(err)
+			}
+
+			if err = server.LoginFinish(ke3); err != nil {
+				t2.Fatal(err)
+			}
+
+			if !bytes.Equal(client.SessionKey(), server.SessionKey()) {
+				t2.Fatal("expected identical session keys")
+			}
+
+			sessionKeys[i] = server.SessionKey()
+
+			client.Reset()
+			server.Reset()
+
+			if client.SessionKey() != nil || client.ExportKey() != nil || client.Ake.GetEphemeralSecretKey() != nil {
+				t2.Fatal("client reset failed")
+			}
+
+			if _, err = client.SuspendLogin(); err == nil {
+				t2.Fatal("expected error when suspending after Reset")
+			}
+
+			if server.SessionKey() != nil || server.ExpectedMAC() != nil || server.Ake.GetNonce() != nil {
+				t2.Fatal("server reset failed")
+			}
+		}
+
+		if bytes.Equal(sessionKeys[0], sessionKeys[1]) {
+			t2.Fatal("expected distinct session keys")
+		}
+	})
+}
+
+func TestConfiguration_Rand(t *testing.T) {
+	seed := [32]byte{1, 2, 3}
+
+	run := func(t *testing.T, conf *opaque.Configuration) [][]byte {
+		c := conf.Clone()
+		c.Rand = rand.NewChaCha8(seed)
+
+		client, err := c.Client()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		server, err := c.Server()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sks, pks := c.KeyGen()
+		oprfSeed := c.GenerateOPRFSeed()
+
+		if err = server.SetKeyMaterial(nil, sks, pks, oprfSeed); err != nil {
+			t.Fatal(err)
+		}
+
+		pk, err := server.Deserialize.DecodeAkePublicKey(pks)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		credID := []byte("client")
+		request := client.RegistrationInit([]byte("password"))
+		response, _ := server.RegistrationResponse(request, pk, credID, oprfSeed)
+		record, _ := client.RegistrationFinalize(response)
+
+		client.Reset()
+		ke1 := client.GenerateKE1([]byte("password"))
+
+		ke2, err := server.GenerateKE2(ke1, &opaque.ClientRecord{
+			RegistrationRecord:   record,
+			CredentialIdentifier: credID,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		fake, err := c.GetFakeRecord(credID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return [][]byte{
+			sks, pks, oprfSeed,
+			request.Serialize(),
+			record.Serialize(),
+			ke1.Serialize(),
+			ke2.Serialize(),
+			fake.Serialize(),
+		}
+	}
+
+	testAll(t, func(t2 *testing.T, conf *configuration) {
+		for _, kem := range []opaque.KEM{0, opaque.MLKEM768} {
+			c := conf.conf.Clone()
+			c.KEM = kem
+			first := run(t2, c)
+			second := run(t2, c)
+
+			for i := range first {
+				if !bytes.Equal(first[i], second[i]) {
+					t2.Fatalf("expected identical outputs from the same random source at index %d (KEM %s)", i, kem)
+				}
+			}
+		}
+
+		// The default source is still random.
+		client, _ := conf.conf.Client()
+		client2, _ := conf.conf.Client()
+
+		if bytes.Equal(client.GenerateKE1([]byte("password")).Serialize(),
+			client2.GenerateKE1([]byte("password")).Serialize()) {
+			t2.Fatal("expected different KE1 messages with the default random source")
+		}
+	})
+}
+
+func TestConfiguration_DebugString(t *testing.T) {
+	expected := "OPRF: RistrettoSha512, AKE: RistrettoSha512, KSF: Argon2id, KDF: SHA-512, MAC: SHA-512, " +
+		"Hash: SHA-512, NonceLength: 32, KEM: none, Context: 0 bytes"
+	if s := opaque.DefaultConfiguration().DebugString(); s != expected {
+		t.Fatalf("unexpected output:\n\twant: %s\n\tgot : %s", expected, s)
+	}
+
+	conf := opaque.DefaultConfiguration()
+	conf.OPRF = opaque.P256Sha256
+	conf.AKE = opaque.P384Sha384
+	conf.KSF = 0
+	conf.KDF = crypto.SHA256
+	conf.NonceLength = 64
+	conf.KEM = opaque.MLKEM768
+	conf.Context = []byte("context")
+
+	expected = "OPRF: P256Sha256, AKE: P384Sha384, KSF: Identity, KDF: SHA-256, MAC: SHA-512, " +
+		"Hash: SHA-512, NonceLength: 64, KEM: MLKEM768, Context: 7 bytes"
+	if s := conf.DebugString(); s != expected {
+		t.Fatalf("unexpected output:\n\twant: %s\n\tgot : %s", expected, s)
+	}
+}
+
+func TestConfiguration_SessionKeyAndMACLength(t *testing.T) {
+	testAll(t, func(t2 *testing.T, conf *configuration) {
+		client, err := conf.conf.Client()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		server, err := conf.conf.Server()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		password := []byte("password")
+		sks, pks := conf.conf.KeyGen()
+		oprfSeed := conf.conf.GenerateOPRFSeed()
+		record := buildRecord(internal.RandomBytes(32), oprfSeed, password, pks, client, server)
+
+		if err = server.SetKeyMaterial(nil, sks, pks, oprfSeed); err != nil {
+			t2.Fatal(err)
+		}
+
+		ke2, err := server.GenerateKE2(client.GenerateKE1(password), record)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		ke3, _, err := client.GenerateKE3(ke2)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		if err = server.LoginFinish(ke3); err != nil {
+			t2.Fatal(err)
+		}
+
+		if l := conf.conf.SessionKeyLength(); l != len(client.SessionKey()) || l != len(server.SessionKey()) {
+			t2.Fatalf("expected session key length %d, got %d", len(client.SessionKey()), l)
+		}
+
+		if l := conf.conf.MACLength(); l != len(ke2.ServerMac) || l != len(ke3.ClientMac) {
+			t2.Fatalf("expected MAC length %d, got %d", len(ke3.ClientMac), l)
+		}
+	})
+
+	conf := opaque.DefaultConfiguration()
+	conf.KDF = 0
+	conf.MAC = 0
+
+	if conf.SessionKeyLength() != 0 || conf.MACLength() != 0 {
+		t.Fatal("expected zero lengths for unavailable primitives")
+	}
+}
+
+func TestClientRecord_ClientPublicKeyBytes(t *testing.T) {
+	testAll(t, func(t2 *testing.T, conf *configuration) {
+		client, err := conf.conf.Client()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		server, err := conf.conf.Server()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		password := []byte("password")
+		sks, pks := conf.conf.KeyGen()
+		oprfSeed := conf.conf.GenerateOPRFSeed()
+		record := buildRecord(internal.RandomBytes(32), oprfSeed, password, pks, client, server)
+
+		if err = server.SetKeyMaterial(nil, sks, pks, oprfSeed); err != nil {
+			t2.Fatal(err)
+		}
+
+		encoded := record.ClientPublicKeyBytes()
+		if len(encoded) != conf.conf.AKE.ElementLength() {
+			t2.Fatalf("expected %d bytes, got %d", conf.conf.AKE.ElementLength(), len(encoded))
+		}
+
+		pk, err := conf.conf.ParseClientPublicKey(encoded)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		if !pk.Equal(record.PublicKey) {
+			t2.Fatal("expected the parsed public key to match the record's")
+		}
+
+		if _, err = conf.conf.ParseClientPublicKey(encoded[1:]); !errors.Is(err, message.ErrInvalidRecordPublicKey) {
+			t2.Fatalf("expected %q, got %v", message.ErrInvalidRecordPublicKey, err)
+		}
+
+		if (&opaque.ClientRecord{}).ClientPublicKeyBytes() != nil {
+			t2.Fatal("expected no public key for an empty record")
+		}
+	})
+}
+
+func TestClientRecord_Seal(t *testing.T) {
+	testAll(t, func(t2 *testing.T, conf *configuration) {
+		client, err := conf.conf.Client()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		server, err := conf.conf.Server()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		password := []byte("password")
+		sks, pks := conf.conf.KeyGen()
+		oprfSeed := conf.conf.GenerateOPRFSeed()
+		record := buildRecord(internal.RandomBytes(32), oprfSeed, password, pks, client, server)
+
+		if err = server.SetKeyMaterial(nil, sks, pks, oprfSeed); err != nil {
+			t2.Fatal(err)
+		}
+
+		key := internal.RandomBytes(32)
+
+		sealed, err := record.Seal(key)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		if bytes.Contains(sealed, record.Serialize()) {
+			t2.Fatal("expected the sealed record to be encrypted")
+		}
+
+		opened, err := conf.conf.OpenClientRecord(key, sealed)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		if !bytes.Equal(opened.Serialize(), record.Serialize()) {
+			t2.Fatal("expected the opened record to match the sealed one")
+		}
+
+		// The opened record can be used to log in.
+		client, err = conf.conf.Client()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		ke2, err := server.GenerateKE2(client.GenerateKE1(password), opened)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		if _, _, err = client.GenerateKE3(ke2); err != nil {
+			t2.Fatal(err)
+		}
+
+		// Wrong key and tampering.
+		if _, err = conf.conf.OpenClientRecord(internal.RandomBytes(32), sealed); !errors.Is(
+			err, opaque.ErrRecordAuthentication) {
+			t2.Fatalf("expected %q, got %v", opaque.ErrRecordAuthentication, err)
+		}
+
+		for _, i := range []int{0, len(sealed) / 2, len(sealed) - 1} {
+			tampered := slices.Clone(sealed)
+			tampered[i] ^= 1
+
+			if _, err = conf.conf.OpenClientRecord(key, tampered); !errors.Is(err, opaque.ErrRecordAuthentication) {
+				t2.Fatalf("expected %q, got %v", opaque.ErrRecordAuthentication, err)
+			}
+		}
+
+		if _, err = conf.conf.OpenClientRecord(key, sealed[:10]); !errors.Is(err, opaque.ErrRecordAuthentication) {
+			t2.Fatalf("expected %q, got %v", opaque.ErrRecordAuthentication, err)
+		}
+
+		// Invalid keys and records.
+		if _, err = record.Seal(key[:16]); !errors.Is(err, opaque.ErrInvalidSealKey) {
+			t2.Fatalf("expected %q, got %v", opaque.ErrInvalidSealKey, err)
+		}
+
+		if _, err = conf.conf.OpenClientRecord(key[:16], sealed); !errors.Is(err, opaque.ErrInvalidSealKey) {
+			t2.Fatalf("expected %q, got %v", opaque.ErrInvalidSealKey, err)
+		}
+
+		if _, err = (&opaque.ClientRecord{}).Seal(key); !errors.Is(err, opaque.ErrMalformedRecord) {
+			t2.Fatalf("expected %q, got %v", opaque.ErrMalformedRecord, err)
+		}
+	})
+}
+
+func TestConfiguration_MessageSizes(t *testing.T) {
+	testAll(t, func(t2 *testing.T, conf *configuration) {
+		for _, kem := range []opaque.KEM{0, opaque.MLKEM768} {
+			c := conf.conf.Clone()
+			c.KEM = kem
+
+			client, err := c.Client()
+			if err != nil {
+				t2.Fatal(err)
+			}
+
+			server, err := c.Server()
+			if err != nil {
+				t2.Fatal(err)
+			}
+
+			password := []byte("password")
+			sks, pks := c.KeyGen()
+			oprfSeed := c.GenerateOPRFSeed()
+			record := buildRecord(internal.RandomBytes(32), oprfSeed, password, pks, client, server)
+
+			if err = server.SetKeyMaterial(nil, sks, pks, oprfSeed); err != nil {
+				t2.Fatal(err)
+			}
+
+			ke1 := client.GenerateKE1(password)
+
+			ke2, err := server.GenerateKE2(ke1, record)
+			if err != nil {
+				t2.Fatal(err)
+			}
+
+			ke3, _, err := client.GenerateKE3(ke2)
+			if err != nil {
+				t2.Fatal(err)
+			}
+
+			for _, test := range []struct {
+				name             string
+				expected, actual int
+			}{
+				{"RegistrationRecord", c.RegistrationRecordSize(), len(record.RegistrationRecord.Serialize())},
+				{"KE1", c.KE1Size(), len(ke1.Serialize())},
+				{"KE2", c.KE2Size(), len(ke2.Serialize())},
+				{"KE3", c.KE3Size(), len(ke3.Serialize())},
+			} {
+				if test.expected != test.actual {
+					t2.Fatalf("%s (KEM %s): expected size %d, got %d", test.name, kem, test.expected, test.actual)
+				}
+			}
+		}
+	})
+
+	conf := opaque.DefaultConfiguration()
+	conf.OPRF = 0
+
+	if conf.RegistrationRecordSize() != 0 || conf.KE1Size() != 0 || conf.KE2Size() != 0 || conf.KE3Size() != 0 {
+		t.Fatal("expected zero sizes for an invalid configuration")
+	}
+}
+
+func TestConfiguration_RegistrationRecordSize_MixedHashes(t *testing.T) {
+	// The masking key has the KDF's output length, which here differs from the Hash's.
+	conf := &opaque.Configuration{
+		OPRF: opaque.P256Sha256,
+		KDF:  crypto.SHA512,
+		MAC:  crypto.SHA512,
+		Hash: crypto.SHA256,
+		KSF:  ksf.Argon2id,
+		AKE:  opaque.P256Sha256,
+	}
+
+	client, err := conf.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server, err := conf.Server()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, pks := conf.KeyGen()
+	record := buildRecord(internal.RandomBytes(32), conf.GenerateOPRFSeed(), []byte("yo"), pks, client, server)
+	encoded := record.RegistrationRecord.Serialize()
+
+	if conf.RegistrationRecordSize() != len(encoded) {
+		t.Fatalf("expected size %d, got %d", len(encoded), conf.RegistrationRecordSize())
+	}
+
+	if err = record.RegistrationRecord.Validate(server.GetConf()); err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := server.Deserialize.RegistrationRecord(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = decoded.Validate(server.GetConf()); err != nil {
+		t.Fatal(err)
+	}
+
+	if !decoded.Equal(record.RegistrationRecord) {
+		t.Fatal("registration record round trip failed")
+	}
+}
+
+func TestConfiguration_Compatible(t *testing.T) {
+	testAll(t, func(t2 *testing.T, conf *configuration) {
+		// Identical configurations, the context being ignored.
+		peer := conf.conf.Clone()
+		peer.Context = []byte("other application")
+
+		if err := conf.conf.Compatible(peer.Serialize()); err != nil {
+			t2.Fatal(err)
+		}
+
+		// Differing hash.
+		peer.Hash = crypto.SHA3_256
+		if peer.Hash == conf.conf.Hash {
+			peer.Hash = crypto.SHA384
+		}
+
+		err := conf.conf.Compatible(peer.Serialize())
+		if !errors.Is(err, opaque.ErrIncompatibleConfiguration) || !strings.Contains(err.Error(), "Hash") {
+			t2.Fatalf("expected %q naming the hash, got %v", opaque.ErrIncompatibleConfiguration, err)
+		}
+
+		// Differing nonce length.
+		peer = conf.conf.Clone()
+		peer.NonceLength = 64
+
+		if err = conf.conf.Compatible(peer.Serialize()); !errors.Is(err, opaque.ErrIncompatibleConfiguration) ||
+			!strings.Contains(err.Error(), "nonce length") {
+			t2.Fatalf("expected %q naming the nonce length, got %v", opaque.ErrIncompatibleConfiguration, err)
+		}
+
+		// Malformed peer blob.
+		for _, blob := range [][]byte{nil, {1, 2, 3}, append(conf.conf.Serialize(), 1)} {
+			if err = conf.conf.Compatible(blob); !errors.Is(err, opaque.ErrIncompatibleConfiguration) {
+				t2.Fatalf("expected %q, got %v", opaque.ErrIncompatibleConfiguration, err)
+			}
+		}
+	})
+}
+
+func TestConfiguration_AllowNoKSF(t *testing.T) {
+	conf := opaque.DefaultConfiguration()
+	conf.KSF = 0
+
+	if conf.HasKSF() {
+		t.Fatal("expected no KSF")
+	}
+
+	if _, err := conf.Client(); !errors.Is(err, opaque.ErrKSFRequired) {
+		t.Fatalf("expected %q, got %v", opaque.ErrKSFRequired, err)
+	}
+
+	if _, err := conf.Server(); !errors.Is(err, opaque.ErrKSFRequired) {
+		t.Fatalf("expected %q, got %v", opaque.ErrKSFRequired, err)
+	}
+
+	conf.AllowNoKSF = true
+
+	if _, err := conf.Client(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := conf.Server(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The opt-in survives a JSON round trip.
+	encoded, err := json.Marshal(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded := new(opaque.Configuration)
+	if err = json.Unmarshal(encoded, decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	if !decoded.AllowNoKSF || decoded.HasKSF() {
+		t.Fatalf("unexpected decoded configuration: %s", encoded)
+	}
+
+	if !opaque.DefaultConfiguration().HasKSF() {
+		t.Fatal("expected the default configuration to have a KSF")
+	}
+}
+
+// countingKDF wraps the standard HKDF and counts its invocations.
+type countingKDF struct {
+	kdf   *internal.KDF
+	calls int
+}
+
+func (k *countingKDF) Extract(salt, ikm []byte) []byte {
+	k.calls++
+	return k.kdf.Extract(salt, ikm)
+}
+
+func (k *countingKDF) Expand(key, info []byte, length int) []byte {
+	k.calls++
+	return k.kdf.Expand(key, info, length)
+}
+
+func (k *countingKDF) Size() int {
+	return k.kdf.Size()
+}
+
+// countingMAC wraps the standard HMAC and counts its invocations.
+type countingMAC struct {
+	mac   *internal.Mac
+	calls int
+}
+
+func (m *countingMAC) MAC(key, message []byte) []byte {
+	m.calls++
+	return m.mac.MAC(key, message)
+}
+
+func (m *countingMAC) Size() int {
+	return m.mac.Size()
+}
+
+func TestConfiguration_CustomProviders(t *testing.T) {
+	testAll(t, func(t2 *testing.T, conf *configuration) {
+		kdf := &countingKDF{kdf: internal.NewKDF(conf.conf.KDF), calls: 0}
+		mac := &countingMAC{mac: internal.NewMac(conf.conf.MAC), calls: 0}
+
+		c := conf.conf.Clone()
+		c.CustomKDF = kdf
+		c.CustomMAC = mac
+
+		if c.SessionKeyLength() != conf.conf.SessionKeyLength() || c.MACLength() != conf.conf.MACLength() {
+			t2.Fatal("expected the lengths of the wrapped primitives")
+		}
+
+		regClient, err := c.Client()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		server, err := c.Server()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		password := []byte("password")
+		sks, pks := c.KeyGen()
+		oprfSeed := c.GenerateOPRFSeed()
+		record := buildRecord(internal.RandomBytes(32), oprfSeed, password, pks, regClient, server)
+
+		if err = server.SetKeyMaterial(nil, sks, pks, oprfSeed); err != nil {
+			t2.Fatal(err)
+		}
+
+		if kdf.calls == 0 || mac.calls == 0 {
+			t2.Fatalf("expected the custom providers to be used, got %d KDF and %d MAC calls", kdf.calls, mac.calls)
+		}
+
+		// The providers wrap the standard primitives, so a client without them interoperates with the server.
+		client, err := conf.conf.Client()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		ke2, err := server.GenerateKE2(client.GenerateKE1(password), record)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		ke3, _, err := client.GenerateKE3(ke2)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		if err = server.LoginFinish(ke3); err != nil {
+			t2.Fatal(err)
+		}
+	})
+}
+
+func TestConfiguration_TranscriptSink(t *testing.T) {
+	testAll(t, func(t2 *testing.T, conf *configuration) {
+		regClient, err := conf.conf.Client()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		server, err := conf.conf.Server()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		password := []byte("password")
+		sks, pks := conf.conf.KeyGen()
+		oprfSeed := conf.conf.GenerateOPRFSeed()
+		record := buildRecord(internal.RandomBytes(32), oprfSeed, password, pks, regClient, server)
+
+		if err = server.SetKeyMaterial(nil, sks, pks, oprfSeed); err != nil {
+			t2.Fatal(err)
+		}
+
+		var sink bytes.Buffer
+		c := conf.conf.Clone()
+		c.TranscriptSink = &sink
+
+		client, err := c.Client()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		ke2, err := server.GenerateKE2(client.GenerateKE1(password), record)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		ke3, _, err := client.GenerateKE3(ke2)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		// The sink doesn't alter the transcript, so the login succeeds.
+		if err = server.LoginFinish(ke3); err != nil {
+			t2.Fatal(err)
+		}
+
+		// The captured bytes are the transcript preamble followed by the server MAC.
+		captured := sink.Bytes()
+		preamble := captured[:len(captured)-len(ke2.ServerMac)]
+
+		if !bytes.Equal(captured[len(preamble):], ke2.ServerMac) {
+			t2.Fatal("expected the server MAC at the end of the captured transcript")
+		}
+
+		h := conf.conf.Hash.New()
+		h.Write(preamble)
+
+		if !bytes.Equal(h.Sum(nil), client.TranscriptHash()) {
+			t2.Fatal("the captured transcript doesn't reproduce the transcript hash")
+		}
+	})
+}
+
+func TestConfigurationPreset(t *testing.T) {
+	names := opaque.ListPresets()
+	if len(names) == 0 || !slices.IsSorted(names) {
+		t.Fatalf("expected a sorted list of presets, got %v", names)
+	}
+
+	for _, name := range names {
+		conf, err := opaque.ConfigurationPreset(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err = conf.StrictVerify(); err != nil {
+			t.Fatalf("preset %q: %v", name, err)
+		}
+
+		if _, err = conf.Client(); err != nil {
+			t.Fatalf("preset %q: %v", name, err)
+		}
+
+		// Each call returns a distinct configuration.
+		conf.Context = []byte("modified")
+
+		other, _ := opaque.ConfigurationPreset(name)
+		if other.Context != nil {
+			t.Fatalf("preset %q: expected a new configuration on each call", name)
+		}
+
+		if opaque.IsProductionPreset(name) == (name == opaque.PresetFast) {
+			t.Fatalf("preset %q: unexpected production flag", name)
+		}
+	}
+
+	recommended, _ := opaque.ConfigurationPreset(opaque.PresetRecommended)
+	if !bytes.Equal(recommended.Serialize(), opaque.DefaultConfiguration().Serialize()) {
+		t.Fatal("expected the recommended preset to be the default configuration")
+	}
+
+	if _, err := opaque.ConfigurationPreset("unknown"); !errors.Is(err, opaque.ErrUnknownPreset) {
+		t.Fatalf("expected %q, got %v", opaque.ErrUnknownPreset, err)
+	}
+
+	if opaque.IsProductionPreset("unknown") {
+		t.Fatal("expected an unknown preset not to be flagged for production")
+	}
+}
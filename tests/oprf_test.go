@@ -10,8 +10,10 @@ package opaque_test
 
 import (
 	"bytes"
+	"crypto"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"strings"
@@ -19,6 +21,8 @@ import (
 
 	group "github.com/bytemare/ecc"
 
+	"github.com/bytemare/opaque"
+	"github.com/bytemare/opaque/internal"
 	"github.com/bytemare/opaque/internal/encoding"
 	"github.com/bytemare/opaque/internal/oprf"
 	"github.com/bytemare/opaque/internal/tag"
@@ -26,6 +30,7 @@ import (
 
 type oprfVector struct {
 	DST       string          `json:"groupDST"`
+	PkSm      string          `json:"pkSm"`
 	Hash      string          `json:"hash"`
 	KeyInfo   string          `json:"keyInfo"`
 	Seed      string          `json:"seed"`
@@ -47,13 +52,19 @@ type test struct {
 
 type testVectors []oprfVector
 
+type proofVector struct {
+	Proof string `json:"proof"`
+	R     string `json:"r"`
+}
+
 type testVector struct {
-	Blind             string `json:"Blind"`
-	BlindedElement    string `json:"BlindedElement"`
-	EvaluationElement string `json:"EvaluationElement"`
-	Input             string `json:"Input"`
-	Output            string `json:"Output"`
-	Batch             int    `json:"Batch"`
+	Proof             *proofVector `json:"Proof"`
+	Blind             string       `json:"Blind"`
+	BlindedElement    string       `json:"BlindedElement"`
+	EvaluationElement string       `json:"EvaluationElement"`
+	Input             string       `json:"Input"`
+	Output            string       `json:"Output"`
+	Batch             int          `json:"Batch"`
 }
 
 func decodeBatch(nb int, in string) ([][]byte, error) {
@@ -266,3 +277,232 @@ func TestVOPRFVectors(t *testing.T) {
 		t.Run(string(tv.Mode)+" - "+string(tv.SuiteID), tv.test)
 	}
 }
+
+func decodeElements(t *testing.T, c oprf.Identifier, encoded [][]byte) []*group.Element {
+	elements := make([]*group.Element, len(encoded))
+	for i, e := range encoded {
+		elements[i] = c.Group().NewElement()
+		if err := elements[i].Decode(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return elements
+}
+
+func (v oprfVector) testVerifiable(t *testing.T) {
+	seed, _ := hex.DecodeString(v.Seed)
+	keyInfo, _ := hex.DecodeString(v.KeyInfo)
+	sks, pks := v.SuiteID.DeriveVerifiableKeyPair(seed, keyInfo)
+
+	if hex.EncodeToString(sks.Encode()) != v.SkSm || hex.EncodeToString(pks.Encode()) != v.PkSm {
+		t.Fatal("DeriveVerifiableKeyPair did not yield the expected key pair")
+	}
+
+	for i, tv := range v.Vectors {
+		t.Run(fmt.Sprintf("Vector %d", i), func(t *testing.T) {
+			test, err := tv.Decode()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			blinded := make([]*group.Element, test.Batch)
+			for j := range test.Batch {
+				blind := v.SuiteID.Group().NewScalar()
+				if err = blind.Decode(test.Blind[j]); err != nil {
+					t.Fatal(err)
+				}
+
+				blinded[j] = v.SuiteID.VerifiableClient(pks).Blind(test.Input[j], blind)
+				if !bytes.Equal(blinded[j].Encode(), test.BlindedElement[j]) {
+					t.Fatal("unexpected blinded output")
+				}
+			}
+
+			r := v.SuiteID.Group().NewScalar()
+			if err = r.DecodeHex(tv.Proof.R); err != nil {
+				t.Fatal(err)
+			}
+
+			evaluated, proof := v.SuiteID.VerifiableEvaluate(sks, r, blinded)
+			for j := range evaluated {
+				if !bytes.Equal(evaluated[j].Encode(), test.EvaluationElement[j]) {
+					t.Fatal("unexpected evaluation")
+				}
+			}
+
+			if hex.EncodeToString(proof) != tv.Proof.Proof {
+				t.Fatalf("unexpected proof\n\twant: %s\n\tgot : %s", tv.Proof.Proof, hex.EncodeToString(proof))
+			}
+
+			expected := decodeElements(t, v.SuiteID, test.EvaluationElement)
+			if !v.SuiteID.VerifyProof(pks, blinded, expected, proof) {
+				t.Fatal("valid proof did not verify")
+			}
+
+			// Tampered proof.
+			tampered := bytes.Clone(proof)
+			tampered[len(tampered)-1] ^= 1
+			if v.SuiteID.VerifyProof(pks, blinded, expected, tampered) {
+				t.Fatal("tampered proof verified")
+			}
+
+			// Client finalization, verifying the proof.
+			if test.Batch != 1 {
+				return
+			}
+
+			client := v.SuiteID.VerifiableClient(pks)
+			blind := v.SuiteID.Group().NewScalar()
+			_ = blind.Decode(test.Blind[0])
+			client.Blind(test.Input[0], blind)
+
+			output, err := client.Finalize(expected[0], proof)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(output, test.Output[0]) {
+				t.Fatal("unexpected output")
+			}
+
+			if _, err = client.Finalize(expected[0], tampered); !errors.Is(err, oprf.ErrInvalidProof) {
+				t.Fatalf("expected %q, got %v", oprf.ErrInvalidProof, err)
+			}
+		})
+	}
+}
+
+func TestVOPRFVectors_Verifiable(t *testing.T) {
+	v, err := loadVOPRFVectors("oprfVectors.json")
+	if err != nil || v == nil {
+		t.Fatal(err)
+	}
+
+	for _, tv := range v {
+		if tv.Mode != byte(oprf.Verifiable) || tv.SuiteID == "decaf448-SHAKE256" {
+			continue
+		}
+
+		t.Run(string(tv.SuiteID), tv.testVerifiable)
+	}
+}
+
+func TestGroup_P384Sha384(t *testing.T) {
+	if opaque.P384Sha512 != opaque.P384Sha384 { //nolint:staticcheck // the deprecated name must remain the same group.
+		t.Fatal("expected the deprecated P384Sha512 to be P384Sha384")
+	}
+
+	if opaque.P384Sha384.String() != "P384Sha384" {
+		t.Fatalf("expected name P384Sha384, got %s", opaque.P384Sha384)
+	}
+
+	id := opaque.P384Sha384.OPRF()
+	if id != oprf.P384Sha384 {
+		t.Fatalf("expected OPRF ciphersuite %s, got %s", oprf.P384Sha384, id)
+	}
+
+	// The context string of the P384-SHA384 ciphersuite in RFC 9497.
+	expected := "HashToGroup-OPRFV1-\x00-P384-SHA384"
+	if dst := getDST([]byte(tag.OPRFPointPrefix), id); string(dst) != expected {
+		t.Fatalf("expected DST %q, got %q", expected, dst)
+	}
+
+	// The OPRF output is a SHA-384 digest.
+	client := id.Client()
+	blinded := client.Blind([]byte("input"), nil)
+	evaluated := id.Evaluate(id.DeriveKey(internal.RandomBytes(internal.SeedLength), nil), blinded)
+
+	if output := client.Finalize(evaluated); len(output) != crypto.SHA384.Size() {
+		t.Fatalf("expected a %d-byte OPRF output, got %d", crypto.SHA384.Size(), len(output))
+	}
+}
+
+func TestOPRF_Public(t *testing.T) {
+	testAll(t, func(t2 *testing.T, conf *configuration) {
+		client, err := conf.conf.OPRFClient()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		server, err := conf.conf.OPRFServer()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		// Derive the OPRF key the OPAQUE server uses for the credential identifier.
+		opaqueServer, err := conf.conf.Server()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		password := []byte("password")
+		credID := internal.RandomBytes(32)
+		oprfSeed := conf.conf.GenerateOPRFSeed()
+		internalConf := opaqueServer.GetConf()
+		seed := internalConf.KDF.Expand(
+			oprfSeed,
+			encoding.SuffixString(credID, tag.ExpandOPRF),
+			internal.SeedLength,
+		)
+		privateKey := internalConf.OPRF.DeriveKey(seed, []byte(tag.DeriveKeyPair)).Encode()
+
+		blinded, state := client.Blind(password)
+
+		evaluated, err := server.Evaluate(privateKey, blinded)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		output, err := client.Finalize(state, evaluated)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		// The in-protocol OPRF output.
+		opaqueClient, err := conf.conf.Client()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		_, pks := conf.conf.KeyGen()
+		pk, _ := opaqueServer.Deserialize.DecodeAkePublicKey(pks)
+		request := opaqueClient.RegistrationInit(password)
+		response, _ := opaqueServer.RegistrationResponse(request, pk, credID, oprfSeed)
+
+		if !bytes.Equal(output, opaqueClient.OPRF.Finalize(response.EvaluatedMessage)) {
+			t2.Fatal("expected the standalone OPRF output to match the in-protocol output")
+		}
+
+		// The state is consumed.
+		if _, err = client.Finalize(state, evaluated); !errors.Is(err, opaque.ErrInvalidOPRFState) {
+			t2.Fatalf("expected %q, got %v", opaque.ErrInvalidOPRFState, err)
+		}
+
+		if _, err = client.Finalize(nil, evaluated); !errors.Is(err, opaque.ErrInvalidOPRFState) {
+			t2.Fatalf("expected %q, got %v", opaque.ErrInvalidOPRFState, err)
+		}
+
+		// Invalid inputs.
+		_, state = client.Blind(password)
+		if _, err = client.Finalize(state, getBadElement(t2, conf)); err == nil {
+			t2.Fatal("expected error on invalid evaluation")
+		}
+
+		if _, err = server.Evaluate(make([]byte, len(privateKey)), blinded); !errors.Is(err, opaque.ErrInvalidOPRFKey) {
+			t2.Fatalf("expected %q, got %v", opaque.ErrInvalidOPRFKey, err)
+		}
+
+		if _, err = server.Evaluate(server.KeyGen(), getBadElement(t2, conf)); err == nil {
+			t2.Fatal("expected error on invalid blinded element")
+		}
+	})
+
+	if _, err := new(opaque.Configuration).OPRFClient(); err == nil {
+		t.Fatal("expected error on invalid configuration")
+	}
+
+	if _, err := new(opaque.Configuration).OPRFServer(); err == nil {
+		t.Fatal("expected error on invalid configuration")
+	}
+}
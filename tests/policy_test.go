@@ -0,0 +1,267 @@
+// SPDX-License-Identifier: MIT
+//
+// Copyright (C) 2020-2025 Daniel Bourdrez. All Rights Reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree or at
+// https://spdx.org/licenses/MIT.html
+
+package opaque_test
+
+import (
+	"crypto"
+	"errors"
+	"testing"
+
+	"github.com/bytemare/ksf"
+
+	"github.com/bytemare/opaque"
+)
+
+func TestSecurityPolicy_Permissive(t *testing.T) {
+	conf := &opaque.Configuration{
+		OPRF:       opaque.RistrettoSha512,
+		AKE:        opaque.P256Sha256,
+		KSF:        0,
+		KDF:        crypto.SHA256,
+		MAC:        crypto.SHA512,
+		Hash:       crypto.SHA512,
+		Policy:     opaque.PermissivePolicy(),
+		AllowNoKSF: true,
+	}
+
+	if _, err := conf.Server(); err != nil {
+		t.Fatalf(testErrValidConf, err)
+	}
+
+	conf.Policy = nil
+	if _, err := conf.Server(); err != nil {
+		t.Fatalf(testErrValidConf, err)
+	}
+}
+
+func TestSecurityPolicy_Strict(t *testing.T) {
+	valid := func() *opaque.Configuration {
+		return &opaque.Configuration{
+			OPRF:    opaque.P256Sha256,
+			AKE:     opaque.P256Sha256,
+			KSF:     ksf.PBKDF2Sha512,
+			KDF:     crypto.SHA256,
+			MAC:     crypto.SHA256,
+			Hash:    crypto.SHA256,
+			Context: []byte("context"),
+			Policy:  opaque.StrictPolicy(),
+		}
+	}
+
+	if _, err := valid().Server(); err != nil {
+		t.Fatalf(testErrValidConf, err)
+	}
+
+	tests := []struct {
+		name   string
+		expect error
+		mutate func(c *opaque.Configuration)
+	}{
+		{
+			name:   "mixed groups",
+			expect: opaque.ErrMixedGroups,
+			mutate: func(c *opaque.Configuration) { c.AKE = opaque.P384Sha384 },
+		},
+		{
+			name:   "mixed hashes",
+			expect: opaque.ErrMixedHashes,
+			mutate: func(c *opaque.Configuration) { c.MAC = crypto.SHA512 },
+		},
+		{
+			name:   "no KSF",
+			expect: opaque.ErrNoKSF,
+			mutate: func(c *opaque.Configuration) { c.KSF, c.AllowNoKSF = 0, true },
+		},
+		{
+			name:   "no context",
+			expect: opaque.ErrNoContext,
+			mutate: func(c *opaque.Configuration) { c.Context = nil },
+		},
+		{
+			name:   "ristretto",
+			expect: opaque.ErrNotFIPS,
+			mutate: func(c *opaque.Configuration) { c.OPRF, c.AKE = opaque.RistrettoSha512, opaque.RistrettoSha512 },
+		},
+		{
+			name:   "argon2id",
+			expect: opaque.ErrNotFIPS,
+			mutate: func(c *opaque.Configuration) { c.KSF = ksf.Argon2id },
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conf := valid()
+			test.mutate(conf)
+
+			if _, err := conf.Client(); !errors.Is(err, test.expect) {
+				t.Fatalf("expected %q, got %q", test.expect, err)
+			}
+
+			if _, err := conf.Server(); !errors.Is(err, test.expect) {
+				t.Fatalf("expected %q, got %q", test.expect, err)
+			}
+
+			if _, err := conf.Deserializer(); !errors.Is(err, test.expect) {
+				t.Fatalf("expected %q, got %q", test.expect, err)
+			}
+		})
+	}
+}
+
+func TestConfiguration_StrictVerify(t *testing.T) {
+	testAll(t, func(t2 *testing.T, conf *configuration) {
+		var expect error
+		if conf.conf.KDF != conf.conf.MAC || conf.conf.MAC != conf.conf.Hash {
+			expect = opaque.ErrMixedHashes
+		}
+
+		if err := conf.conf.StrictVerify(); !errors.Is(err, expect) {
+			t2.Fatalf("expected %v, got %v", expect, err)
+		}
+	})
+
+	if _, err := opaque.NewClientStrict(nil); err != nil {
+		t.Fatalf(testErrValidConf, err)
+	}
+
+	if _, err := opaque.NewServerStrict(nil); err != nil {
+		t.Fatalf(testErrValidConf, err)
+	}
+
+	// StrictVerify only applies the group and hash checks of StrictPolicy, but enforces the configuration's Policy.
+	conf := opaque.DefaultConfiguration()
+	if err := conf.StrictVerify(); err != nil {
+		t.Fatalf(testErrValidConf, err)
+	}
+
+	conf.Context = []byte("context")
+	conf.Policy = opaque.StrictPolicy()
+	if err := conf.StrictVerify(); !errors.Is(err, opaque.ErrNotFIPS) {
+		t.Fatalf("expected %q, got %q", opaque.ErrNotFIPS, err)
+	}
+
+	tests := []struct {
+		conf   *opaque.Configuration
+		expect error
+		name   string
+	}{
+		{
+			name:   "mixed groups",
+			expect: opaque.ErrMixedGroups,
+			conf: &opaque.Configuration{
+				OPRF: opaque.RistrettoSha512,
+				AKE:  opaque.P256Sha256,
+				KSF:  ksf.Argon2id,
+				KDF:  crypto.SHA512,
+				MAC:  crypto.SHA512,
+				Hash: crypto.SHA512,
+			},
+		},
+		{
+			name:   "mixed hashes",
+			expect: opaque.ErrMixedHashes,
+			conf: &opaque.Configuration{
+				OPRF: opaque.P256Sha256,
+				AKE:  opaque.P256Sha256,
+				KSF:  ksf.Argon2id,
+				KDF:  crypto.SHA256,
+				MAC:  crypto.SHA512,
+				Hash: crypto.SHA256,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// Accepted by the default path.
+			if _, err := opaque.NewClient(test.conf); err != nil {
+				t.Fatalf(testErrValidConf, err)
+			}
+
+			if _, err := opaque.NewServer(test.conf); err != nil {
+				t.Fatalf(testErrValidConf, err)
+			}
+
+			// Rejected by the strict path.
+			if err := test.conf.StrictVerify(); !errors.Is(err, test.expect) {
+				t.Fatalf("expected %q, got %v", test.expect, err)
+			}
+
+			if _, err := opaque.NewClientStrict(test.conf); !errors.Is(err, test.expect) {
+				t.Fatalf("expected %q, got %v", test.expect, err)
+			}
+
+			if _, err := opaque.NewServerStrict(test.conf); !errors.Is(err, test.expect) {
+				t.Fatalf("expected %q, got %v", test.expect, err)
+			}
+		})
+	}
+
+	// Invalid configurations are rejected as by the default path.
+	if err := (&opaque.Configuration{}).StrictVerify(); err == nil {
+		t.Fatal("expected error on invalid configuration")
+	}
+}
+
+func TestConfiguration_ValidateKSFStrength(t *testing.T) {
+	// The default parameters are strong enough.
+	if err := opaque.DefaultConfiguration().ValidateKSFStrength(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		expect error
+		name   string
+		params []int
+	}{
+		{name: "minimums", params: []int{opaque.MinArgon2idTime, opaque.MinArgon2idMemory, opaque.MinArgon2idThreads}},
+		{name: "stronger", params: []int{4, 128 * 1024, 4}},
+		{name: "low time", params: []int{1, 64 * 1024, 4}, expect: opaque.ErrWeakKSF},
+		{name: "low memory", params: []int{3, 1024, 4}, expect: opaque.ErrWeakKSF},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conf := opaque.DefaultConfiguration()
+			if err := conf.SetKSFParameters(test.params...); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := conf.ValidateKSFStrength(); !errors.Is(err, test.expect) {
+				t.Fatalf("expected %v, got %v", test.expect, err)
+			}
+
+			if _, err := opaque.NewServerStrict(conf); !errors.Is(err, test.expect) {
+				t.Fatalf("expected %v, got %v", test.expect, err)
+			}
+
+			if _, err := opaque.NewClientStrict(conf); !errors.Is(err, test.expect) {
+				t.Fatalf("expected %v, got %v", test.expect, err)
+			}
+
+			// The default path doesn't enforce it.
+			if _, err := opaque.NewServer(conf); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+
+	// Parameters for other KSFs are not checked.
+	conf := opaque.DefaultConfiguration()
+	conf.KSF = ksf.Scrypt
+
+	if err := conf.SetKSFParameters(2, 1, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := conf.ValidateKSFStrength(); err != nil {
+		t.Fatal(err)
+	}
+}
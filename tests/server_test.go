@@ -9,15 +9,26 @@
 package opaque_test
 
 import (
+	"bytes"
+	"crypto"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
 	"errors"
+	"fmt"
+	"slices"
 	"strings"
 	"testing"
+	"time"
 
 	group "github.com/bytemare/ecc"
 
 	"github.com/bytemare/opaque"
 	"github.com/bytemare/opaque/internal"
 	"github.com/bytemare/opaque/internal/encoding"
+	"github.com/bytemare/opaque/internal/masking"
+	"github.com/bytemare/opaque/internal/tag"
+	"github.com/bytemare/opaque/message"
 )
 
 var (
@@ -280,6 +291,65 @@ func TestServerFinish_InvalidKE3Mac(t *testing.T) {
 	}
 }
 
+func TestServer_VerifyKE3(t *testing.T) {
+	testAll(t, func(t2 *testing.T, conf *configuration) {
+		client, err := conf.conf.Client()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		server, err := conf.conf.Server()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		password := []byte("password")
+		sks, pks := conf.conf.KeyGen()
+		oprfSeed := conf.conf.GenerateOPRFSeed()
+		record := buildRecord(internal.RandomBytes(32), oprfSeed, password, pks, client, server)
+
+		if err = server.SetKeyMaterial(nil, sks, pks, oprfSeed); err != nil {
+			t2.Fatal(err)
+		}
+
+		if server.VerifyKE3(&message.KE3{ClientMac: nil}) {
+			t2.Fatal("expected no verification without a login in progress")
+		}
+
+		ke2, err := server.GenerateKE2(client.GenerateKE1(password), record)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		ke3, _, err := client.GenerateKE3(ke2)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		// A retransmitted KE3 verifies as many times as it's received, and the login can still be finished.
+		for range 2 {
+			if !server.VerifyKE3(ke3) {
+				t2.Fatal("expected the KE3 to verify")
+			}
+		}
+
+		if server.VerifyKE3(nil) {
+			t2.Fatal("expected a nil KE3 not to verify")
+		}
+
+		tampered := &message.KE3{ClientMac: slices.Clone(ke3.ClientMac)}
+		tampered.ClientMac[0] = ^tampered.ClientMac[0]
+
+		if server.VerifyKE3(tampered) {
+			t2.Fatal("expected a tampered KE3 not to verify")
+		}
+
+		if err = server.LoginFinish(ke3); err != nil {
+			t2.Fatal(err)
+		}
+	})
+}
+
 func TestServerSetAKEState_InvalidInput(t *testing.T) {
 	conf := opaque.DefaultConfiguration()
 
@@ -312,3 +382,2802 @@ func TestServerSetAKEState_InvalidInput(t *testing.T) {
 		t.Fatalf("Expected error for SetAKEState. want %q, got %q", errStateExists, err)
 	}
 }
+
+func TestServer_ClearKeyMaterial(t *testing.T) {
+	testAll(t, func(t2 *testing.T, conf *configuration) {
+		client, _ := conf.conf.Client()
+		server, _ := conf.conf.Server()
+		sks, pks := conf.conf.KeyGen()
+		oprfSeed := conf.conf.GenerateOPRFSeed()
+		record := buildRecord(internal.RandomBytes(32), oprfSeed, []byte("yo"), pks, client, server)
+
+		if err := server.SetKeyMaterial(nil, sks, pks, oprfSeed); err != nil {
+			t.Fatal(err)
+		}
+
+		ke1 := client.GenerateKE1([]byte("yo"))
+		if _, err := server.GenerateKE2(ke1, record); err != nil {
+			t.Fatal(err)
+		}
+
+		server.ClearKeyMaterial()
+
+		for _, b := range oprfSeed {
+			if b != 0 {
+				t.Fatalf("expected zeroed OPRF seed, got %v", oprfSeed)
+			}
+		}
+
+		if server.SessionKey() != nil || server.ExpectedMAC() != nil {
+			t.Fatal("expected flushed AKE state")
+		}
+
+		if _, err := server.GenerateKE2(ke1, record); !errors.Is(err, opaque.ErrNoServerKeyMaterial) {
+			t.Fatalf("expected %q, got %v", opaque.ErrNoServerKeyMaterial, err)
+		}
+
+		// Clearing twice must not panic.
+		server.ClearKeyMaterial()
+	})
+}
+
+func makeRegistrationBatch(
+	t testing.TB,
+	conf *opaque.Configuration,
+	n int,
+) ([]*message.RegistrationRequest, [][]byte) {
+	reqs := make([]*message.RegistrationRequest, n)
+	credIDs := make([][]byte, n)
+
+	for i := range n {
+		client, err := conf.Client()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		reqs[i] = client.RegistrationInit(internal.RandomBytes(8))
+		credIDs[i] = internal.RandomBytes(32)
+	}
+
+	return reqs, credIDs
+}
+
+func TestServer_BatchRegistrationResponse(t *testing.T) {
+	testAll(t, func(t2 *testing.T, conf *configuration) {
+		server, _ := conf.conf.Server()
+		_, pks := conf.conf.KeyGen()
+		oprfSeed := conf.conf.GenerateOPRFSeed()
+
+		pk, err := server.Deserialize.DecodeAkePublicKey(pks)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		reqs, credIDs := makeRegistrationBatch(t, conf.conf, 5)
+
+		responses, err := server.BatchRegistrationResponse(reqs, pk, credIDs, oprfSeed)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i, req := range reqs {
+			single, _ := server.RegistrationResponse(req, pk, credIDs[i], oprfSeed)
+			if !bytes.Equal(single.Serialize(), responses[i].Serialize()) {
+				t.Fatalf("batched response %d differs from the single call", i)
+			}
+		}
+
+		if _, err = server.BatchRegistrationResponse(reqs, pk, credIDs[1:], oprfSeed); !errors.Is(
+			err,
+			opaque.ErrBatchLengthMismatch,
+		) {
+			t.Fatalf("expected %q, got %v", opaque.ErrBatchLengthMismatch, err)
+		}
+
+		if _, err = server.BatchRegistrationResponse(reqs, pk, credIDs, oprfSeed[1:]); !errors.Is(
+			err,
+			opaque.ErrInvalidOPRFSeedLength,
+		) {
+			t.Fatalf("expected %q, got %v", opaque.ErrInvalidOPRFSeedLength, err)
+		}
+	})
+}
+
+func TestServer_InvalidBlindedMessage(t *testing.T) {
+	testAll(t, func(t2 *testing.T, conf *configuration) {
+		client, err := conf.conf.Client()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		server, err := conf.conf.Server()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		password := []byte("password")
+		sks, pks := conf.conf.KeyGen()
+		oprfSeed := conf.conf.GenerateOPRFSeed()
+		record := buildRecord(internal.RandomBytes(32), oprfSeed, password, pks, client, server)
+
+		if err = server.SetKeyMaterial(nil, sks, pks, oprfSeed); err != nil {
+			t2.Fatal(err)
+		}
+
+		pk := server.PublicKey()
+		identity := conf.conf.OPRF.Group().NewElement().Identity()
+		credID := record.CredentialIdentifier
+
+		for _, blinded := range []*group.Element{nil, identity} {
+			req := &message.RegistrationRequest{BlindedMessage: blinded}
+			if _, err := server.RegistrationResponse(req, pk, credID, oprfSeed); !errors.Is(
+				err,
+				opaque.ErrInvalidBlindedMessage,
+			) {
+				t2.Fatalf("expected %q, got %v", opaque.ErrInvalidBlindedMessage, err)
+			}
+
+			if _, err := server.BatchRegistrationResponse(
+				[]*message.RegistrationRequest{req}, pk, [][]byte{credID}, oprfSeed,
+			); !errors.Is(err, opaque.ErrInvalidBlindedMessage) {
+				t2.Fatalf("expected %q in batch, got %v", opaque.ErrInvalidBlindedMessage, err)
+			}
+
+			ke1 := client.GenerateKE1(password)
+			ke1.BlindedMessage = blinded
+
+			if _, err := server.GenerateKE2(ke1, record); !errors.Is(err, opaque.ErrInvalidBlindedMessage) {
+				t2.Fatalf("expected %q in KE2, got %v", opaque.ErrInvalidBlindedMessage, err)
+			}
+		}
+	})
+}
+
+func TestServer_RegistrationResponseForUpdate(t *testing.T) {
+	testAll(t, func(t2 *testing.T, conf *configuration) {
+		client, err := conf.conf.Client()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		server, err := conf.conf.Server()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		password := []byte("password")
+		sks, pks := conf.conf.KeyGen()
+		oprfSeed := conf.conf.GenerateOPRFSeed()
+		record := buildRecord(internal.RandomBytes(32), oprfSeed, password, pks, client, server)
+
+		if err = server.SetKeyMaterial(nil, sks, pks, oprfSeed); err != nil {
+			t2.Fatal(err)
+		}
+
+		pk := server.PublicKey()
+		reqs, credIDs := makeRegistrationBatch(t2, conf.conf, 1)
+		expected, _ := server.RegistrationResponse(reqs[0], pk, credIDs[0], oprfSeed)
+
+		// First registration.
+		response, update, err := server.RegistrationResponseForUpdate(reqs[0], pk, credIDs[0], oprfSeed, nil)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		if update {
+			t2.Fatal("expected a first registration")
+		}
+
+		if !bytes.Equal(response.Serialize(), expected.Serialize()) {
+			t2.Fatal("unexpected response on first registration")
+		}
+
+		// Update of an existing record.
+		response, update, err = server.RegistrationResponseForUpdate(reqs[0], pk, credIDs[0], oprfSeed, record)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		if !update {
+			t2.Fatal("expected an update")
+		}
+
+		if !bytes.Equal(response.Serialize(), expected.Serialize()) {
+			t2.Fatal("unexpected response on update")
+		}
+	})
+}
+
+func TestServer_EvaluateOPRF(t *testing.T) {
+	testAll(t, func(t2 *testing.T, conf *configuration) {
+		client, err := conf.conf.Client()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		server, err := conf.conf.Server()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		password := []byte("password")
+		sks, pks := conf.conf.KeyGen()
+		oprfSeed := conf.conf.GenerateOPRFSeed()
+		record := buildRecord(internal.RandomBytes(32), oprfSeed, password, pks, client, server)
+
+		if err = server.SetKeyMaterial(nil, sks, pks, oprfSeed); err != nil {
+			t2.Fatal(err)
+		}
+
+		credID := record.CredentialIdentifier
+		request := client.RegistrationInit(password)
+
+		evaluated, err := server.EvaluateOPRF(request.BlindedMessage, credID, oprfSeed)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		response, err := server.RegistrationResponse(request, server.PublicKey(), credID, oprfSeed)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		if !evaluated.Equal(response.EvaluatedMessage) {
+			t2.Fatal("expected the standalone evaluation to match the registration response's")
+		}
+
+		// It also matches the evaluation in KE2.
+		ke1 := client.GenerateKE1(password)
+
+		ke2, err := server.GenerateKE2(ke1, record)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		evaluated, err = server.EvaluateOPRF(ke1.BlindedMessage, credID, oprfSeed)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		if !bytes.Equal(evaluated.Encode(), ke2.EvaluatedElement()) {
+			t2.Fatal("expected the standalone evaluation to match the KE2's")
+		}
+
+		identity := conf.conf.OPRF.Group().NewElement().Identity()
+		if _, err = server.EvaluateOPRF(identity, credID, oprfSeed); !errors.Is(
+			err, opaque.ErrInvalidBlindedMessage) {
+			t2.Fatalf("expected %q, got %v", opaque.ErrInvalidBlindedMessage, err)
+		}
+
+		if _, err = server.EvaluateOPRF(ke1.BlindedMessage, credID, oprfSeed[1:]); !errors.Is(
+			err, opaque.ErrInvalidOPRFSeedLength) {
+			t2.Fatalf("expected %q, got %v", opaque.ErrInvalidOPRFSeedLength, err)
+		}
+	})
+}
+
+func TestServer_EvaluateOPRFBatch(t *testing.T) {
+	testAll(t, func(t2 *testing.T, conf *configuration) {
+		server, _ := conf.conf.Server()
+		pk := server.GetConf().Group.Base()
+		oprfSeed := conf.conf.GenerateOPRFSeed()
+		reqs, credIDs := makeRegistrationBatch(t2, conf.conf, 5)
+
+		blindedMessages := make([]*group.Element, len(reqs))
+		for i, req := range reqs {
+			blindedMessages[i] = req.BlindedMessage
+		}
+
+		evaluated, err := server.EvaluateOPRFBatch(blindedMessages, credIDs, oprfSeed)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		for i, req := range reqs {
+			single, _ := server.RegistrationResponse(req, pk, credIDs[i], oprfSeed)
+			if !single.EvaluatedMessage.Equal(evaluated[i]) {
+				t2.Fatalf("batched evaluation %d differs from the single call", i)
+			}
+		}
+
+		if _, err = server.EvaluateOPRFBatch(blindedMessages, credIDs[1:], oprfSeed); !errors.Is(
+			err, opaque.ErrBatchLengthMismatch) {
+			t2.Fatalf("expected %q, got %v", opaque.ErrBatchLengthMismatch, err)
+		}
+
+		if _, err = server.EvaluateOPRFBatch(blindedMessages, credIDs, oprfSeed[1:]); !errors.Is(
+			err, opaque.ErrInvalidOPRFSeedLength) {
+			t2.Fatalf("expected %q, got %v", opaque.ErrInvalidOPRFSeedLength, err)
+		}
+	})
+}
+
+const benchmarkBatchSize = 32
+
+func BenchmarkServer_EvaluateOPRFBatch(b *testing.B) {
+	conf := opaque.DefaultConfiguration()
+	server, _ := conf.Server()
+	oprfSeed := conf.GenerateOPRFSeed()
+	reqs, credIDs := makeRegistrationBatch(b, conf, benchmarkBatchSize)
+
+	blindedMessages := make([]*group.Element, len(reqs))
+	for i, req := range reqs {
+		blindedMessages[i] = req.BlindedMessage
+	}
+
+	b.Run("Batch", func(b *testing.B) {
+		for range b.N {
+			if _, err := server.EvaluateOPRFBatch(blindedMessages, credIDs, oprfSeed); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("Sequential", func(b *testing.B) {
+		for range b.N {
+			for i := range blindedMessages {
+				if _, err := server.EvaluateOPRFBatch(blindedMessages[i:i+1], credIDs[i:i+1], oprfSeed); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+}
+
+func BenchmarkServer_BatchRegistrationResponse(b *testing.B) {
+	conf := opaque.DefaultConfiguration()
+	server, _ := conf.Server()
+	_, pks := conf.KeyGen()
+	pk, _ := server.Deserialize.DecodeAkePublicKey(pks)
+	oprfSeed := conf.GenerateOPRFSeed()
+	reqs, credIDs := makeRegistrationBatch(b, conf, benchmarkBatchSize)
+
+	b.Run("Batch", func(b *testing.B) {
+		for range b.N {
+			if _, err := server.BatchRegistrationResponse(reqs, pk, credIDs, oprfSeed); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("Sequential", func(b *testing.B) {
+		for range b.N {
+			for i, req := range reqs {
+				_, _ = server.RegistrationResponse(req, pk, credIDs[i], oprfSeed)
+			}
+		}
+	})
+}
+
+func TestServer_SealedState(t *testing.T) {
+	testAll(t, func(t2 *testing.T, conf *configuration) {
+		client, err := conf.conf.Client()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		server, err := conf.conf.Server()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		password := []byte("password")
+		sks, pks := conf.conf.KeyGen()
+		oprfSeed := conf.conf.GenerateOPRFSeed()
+		record := buildRecord(internal.RandomBytes(32), oprfSeed, password, pks, client, server)
+
+		if err = server.SetKeyMaterial(nil, sks, pks, oprfSeed); err != nil {
+			t.Fatal(err)
+		}
+
+		ke2, err := server.GenerateKE2(client.GenerateKE1(password), record)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		key := internal.RandomBytes(32)
+
+		token, err := server.SerializeStateSealed(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if bytes.Contains(token, server.SessionKey()) {
+			t.Fatal("sealed state contains the session secret in clear")
+		}
+
+		ke3, _, err := client.GenerateKE3(ke2)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Tampered token.
+		tampered := slices.Clone(token)
+		tampered[len(tampered)-1] ^= 1
+		other, _ := conf.conf.Server()
+
+		if err = other.SetAKEStateSealed(key, tampered); !errors.Is(err, opaque.ErrStateAuthentication) {
+			t.Fatalf("expected %q, got %v", opaque.ErrStateAuthentication, err)
+		}
+
+		// Wrong key.
+		if err = other.SetAKEStateSealed(internal.RandomBytes(32), token); !errors.Is(
+			err,
+			opaque.ErrStateAuthentication,
+		) {
+			t.Fatalf("expected %q, got %v", opaque.ErrStateAuthentication, err)
+		}
+
+		// Invalid key length.
+		if err = other.SetAKEStateSealed(key[:16], token); !errors.Is(err, opaque.ErrInvalidSealKey) {
+			t.Fatalf("expected %q, got %v", opaque.ErrInvalidSealKey, err)
+		}
+
+		if _, err = server.SerializeStateSealed(nil); !errors.Is(err, opaque.ErrInvalidSealKey) {
+			t.Fatalf("expected %q, got %v", opaque.ErrInvalidSealKey, err)
+		}
+
+		// Valid token on another server instance.
+		if err = other.SetAKEStateSealed(key, token); err != nil {
+			t.Fatal(err)
+		}
+
+		if err = other.LoginFinish(ke3); err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(other.SessionKey(), client.SessionKey()) {
+			t.Fatal("session keys differ")
+		}
+	})
+}
+
+func TestServer_GenerateFakeKE2(t *testing.T) {
+	testAll(t, func(t2 *testing.T, conf *configuration) {
+		client, err := conf.conf.Client()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		server, err := conf.conf.Server()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		password := []byte("password")
+		sks, pks := conf.conf.KeyGen()
+		oprfSeed := conf.conf.GenerateOPRFSeed()
+		record := buildRecord(internal.RandomBytes(32), oprfSeed, password, pks, client, server)
+
+		if err = server.SetKeyMaterial(nil, sks, pks, oprfSeed); err != nil {
+			t.Fatal(err)
+		}
+
+		ke2, err := server.GenerateKE2(client.GenerateKE1(password), record)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ke1 := client.GenerateKE1(password)
+
+		fake, err := server.GenerateFakeKE2(ke1, []byte("unknown"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(fake.Serialize()) != len(ke2.Serialize()) {
+			t.Fatal("fake and real KE2 differ in size")
+		}
+
+		if _, err = client.Deserialize.KE2(fake.Serialize()); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, _, err = client.GenerateKE3(fake); err == nil {
+			t.Fatal("expected client error on fake KE2")
+		}
+
+		ke3 := &message.KE3{ClientMac: internal.RandomBytes(server.GetConf().MAC.Size())}
+		if err = server.LoginFinish(ke3); err == nil {
+			t.Fatal("expected error on fake login")
+		}
+
+		// The same credential identifier yields the same OPRF evaluation.
+		fake2, err := server.GenerateFakeKE2(ke1, []byte("unknown"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(fake.EvaluatedElement(), fake2.EvaluatedElement()) {
+			t.Fatal("expected consistent OPRF evaluations for the same credential identifier")
+		}
+
+		unset, _ := conf.conf.Server()
+		if _, err = unset.GenerateFakeKE2(ke1, nil); !errors.Is(err, opaque.ErrNoServerKeyMaterial) {
+			t.Fatalf("expected %q, got %v", opaque.ErrNoServerKeyMaterial, err)
+		}
+	})
+}
+
+func TestServer_DummyLogin(t *testing.T) {
+	testAll(t, func(t2 *testing.T, conf *configuration) {
+		client, err := conf.conf.Client()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		server, err := conf.conf.Server()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		password := []byte("password")
+		sks, pks := conf.conf.KeyGen()
+		oprfSeed := conf.conf.GenerateOPRFSeed()
+		record := buildRecord(internal.RandomBytes(32), oprfSeed, password, pks, client, server)
+
+		if err = server.SetKeyMaterial(nil, sks, pks, oprfSeed); err != nil {
+			t2.Fatal(err)
+		}
+
+		ke2, err := server.GenerateKE2(client.GenerateKE1(password), record)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		server.Ake.Flush()
+		ke1 := client.GenerateKE1(password)
+
+		dummy, err := server.DummyLogin(ke1)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		if len(dummy.Serialize()) != len(ke2.Serialize()) {
+			t2.Fatal("dummy and real KE2 differ in size")
+		}
+
+		if _, _, err = client.GenerateKE3(dummy); err == nil {
+			t2.Fatal("expected client error on dummy KE2")
+		}
+
+		ke3 := &message.KE3{ClientMac: internal.RandomBytes(server.GetConf().MAC.Size())}
+		if err = server.LoginFinish(ke3); !errors.Is(err, opaque.ErrAkeInvalidClientMac) {
+			t2.Fatalf("expected %q, got %v", opaque.ErrAkeInvalidClientMac, err)
+		}
+
+		// A retransmitted KE1 yields the same OPRF evaluation.
+		server.Ake.Flush()
+
+		dummy2, err := server.DummyLogin(ke1)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		if !bytes.Equal(dummy.EvaluatedElement(), dummy2.EvaluatedElement()) {
+			t2.Fatal("expected consistent OPRF evaluations for the same KE1")
+		}
+
+		if _, err = server.DummyLogin(nil); !errors.Is(err, opaque.ErrMalformedKE1) {
+			t2.Fatalf("expected %q, got %v", opaque.ErrMalformedKE1, err)
+		}
+
+		unset, _ := conf.conf.Server()
+		if _, err = unset.DummyLogin(ke1); !errors.Is(err, opaque.ErrNoServerKeyMaterial) {
+			t2.Fatalf("expected %q, got %v", opaque.ErrNoServerKeyMaterial, err)
+		}
+	})
+}
+
+func TestServer_FakeKE2_MixedGroups(t *testing.T) {
+	// The fake record's client public key must be in the AKE group, not the OPRF group.
+	conf := opaque.DefaultConfiguration()
+	conf.AKE = opaque.P256Sha256
+
+	client, err := conf.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server, err := conf.Server()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	password := []byte("password")
+	sks, pks := conf.KeyGen()
+	oprfSeed := conf.GenerateOPRFSeed()
+	record := buildRecord(internal.RandomBytes(32), oprfSeed, password, pks, client, server)
+
+	if err = server.SetKeyMaterial(nil, sks, pks, oprfSeed); err != nil {
+		t.Fatal(err)
+	}
+
+	ke2, err := server.GenerateKE2(client.GenerateKE1(password), record)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server.Ake.Flush()
+	ke1 := client.GenerateKE1(password)
+
+	fake, err := server.GenerateFakeKE2(ke1, []byte("unknown"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server.Ake.Flush()
+
+	dummy, err := server.DummyLogin(ke1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(fake.Serialize()) != len(ke2.Serialize()) || len(dummy.Serialize()) != len(ke2.Serialize()) {
+		t.Fatal("fake and real KE2 differ in size")
+	}
+}
+
+func BenchmarkServer_GenerateKE2_RealVsFake(b *testing.B) {
+	conf := opaque.DefaultConfiguration()
+
+	client, err := conf.Client()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	server, err := conf.Server()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	password := []byte("password")
+	sks, pks := conf.KeyGen()
+	oprfSeed := conf.GenerateOPRFSeed()
+	record := buildRecord(internal.RandomBytes(32), oprfSeed, password, pks, client, server)
+
+	if err = server.SetKeyMaterial(nil, sks, pks, oprfSeed); err != nil {
+		b.Fatal(err)
+	}
+
+	ke1 := client.GenerateKE1(password)
+
+	b.Run("Real", func(b *testing.B) {
+		for range b.N {
+			server.Ake.Flush()
+
+			if _, err := server.GenerateKE2(ke1, record); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("Fake", func(b *testing.B) {
+		for range b.N {
+			server.Ake.Flush()
+
+			if _, err := server.GenerateFakeKE2(ke1, record.CredentialIdentifier); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("Dummy", func(b *testing.B) {
+		for range b.N {
+			server.Ake.Flush()
+
+			if _, err := server.DummyLogin(ke1); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestServer_Login(t *testing.T) {
+	testAll(t, func(t2 *testing.T, conf *configuration) {
+		client, err := conf.conf.Client()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		server, err := conf.conf.Server()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		password := []byte("password")
+		sks, pks := conf.conf.KeyGen()
+		oprfSeed := conf.conf.GenerateOPRFSeed()
+		record := buildRecord(internal.RandomBytes(32), oprfSeed, password, pks, client, server)
+
+		if err = server.SetKeyMaterial(nil, sks, pks, oprfSeed); err != nil {
+			t2.Fatal(err)
+		}
+
+		ke1 := client.GenerateKE1(password)
+
+		sessionKey, err := server.Login(ke1, func(ke2 *message.KE2) (*message.KE3, error) {
+			ke3, _, err := client.GenerateKE3(ke2)
+			return ke3, err
+		}, record)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		if !bytes.Equal(sessionKey, client.SessionKey()) {
+			t2.Fatal("session keys differ")
+		}
+
+		// Wrong password.
+		client, err = conf.conf.Client()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		ke1 = client.GenerateKE1([]byte("wrong"))
+
+		if _, err = server.Login(ke1, func(ke2 *message.KE2) (*message.KE3, error) {
+			ke3, _, err := client.GenerateKE3(ke2)
+			return ke3, err
+		}, record); err == nil {
+			t2.Fatal("expected error on wrong password")
+		}
+
+		// Nil KE3.
+		ke1 = client.GenerateKE1(password)
+		if _, err = server.Login(ke1, func(*message.KE2) (*message.KE3, error) {
+			return nil, nil
+		}, record); !errors.Is(err, opaque.ErrNoKE3) {
+			t2.Fatalf("expected %q, got %v", opaque.ErrNoKE3, err)
+		}
+
+		// Invalid client MAC.
+		ke1 = client.GenerateKE1(password)
+		if _, err = server.Login(ke1, func(*message.KE2) (*message.KE3, error) {
+			return &message.KE3{ClientMac: internal.RandomBytes(conf.conf.MAC.Size())}, nil
+		}, record); !errors.Is(err, opaque.ErrAkeInvalidClientMac) {
+			t2.Fatalf("expected %q, got %v", opaque.ErrAkeInvalidClientMac, err)
+		}
+	})
+}
+
+func TestTranscriptHash(t *testing.T) {
+	testAll(t, func(t2 *testing.T, conf *configuration) {
+		regClient, err := conf.conf.Client()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		server, err := conf.conf.Server()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		password := []byte("password")
+		sks, pks := conf.conf.KeyGen()
+		oprfSeed := conf.conf.GenerateOPRFSeed()
+		record := buildRecord(internal.RandomBytes(32), oprfSeed, password, pks, regClient, server)
+
+		if err = server.SetKeyMaterial(nil, sks, pks, oprfSeed); err != nil {
+			t2.Fatal(err)
+		}
+
+		login := func(record *opaque.ClientRecord, serverIdentity []byte) []byte {
+			if err := server.SetKeyMaterial(serverIdentity, sks, pks, oprfSeed); err != nil {
+				t2.Fatal(err)
+			}
+
+			client, err := conf.conf.Client()
+			if err != nil {
+				t2.Fatal(err)
+			}
+
+			ke2, err := server.GenerateKE2(client.GenerateKE1(password), record)
+			if err != nil {
+				t2.Fatal(err)
+			}
+
+			ke3, _, err := client.GenerateKE3(ke2, opaque.GenerateKE3Options{ServerIdentity: serverIdentity})
+			if err != nil {
+				t2.Fatal(err)
+			}
+
+			if err = server.LoginFinish(ke3); err != nil {
+				t2.Fatal(err)
+			}
+
+			if len(client.TranscriptHash()) != conf.conf.Hash.Size() ||
+				!bytes.Equal(client.TranscriptHash(), server.TranscriptHash()) {
+				t2.Fatal("client and server transcript hashes differ")
+			}
+
+			h := bytes.Clone(server.TranscriptHash())
+			server.Ake.Flush()
+
+			if server.TranscriptHash() != nil {
+				t2.Fatal("transcript hash not flushed")
+			}
+
+			return h
+		}
+
+		// A record registered with a server identity.
+		serverIdentity := []byte("server")
+
+		client, err := conf.conf.Client()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		pk, _ := server.Deserialize.DecodeAkePublicKey(pks)
+		r2, _ := server.RegistrationResponse(
+			client.RegistrationInit(password), pk, record.CredentialIdentifier, oprfSeed,
+		)
+		r3, _ := client.RegistrationFinalize(r2, opaque.ClientRegistrationFinalizeOptions{ServerIdentity: serverIdentity})
+		withIdentity := &opaque.ClientRecord{
+			CredentialIdentifier: record.CredentialIdentifier,
+			ClientIdentity:       nil,
+			RegistrationRecord:   r3,
+		}
+
+		// Repeated logins on the same server each get their own transcript.
+		h1 := login(record, nil)
+		h2 := login(record, nil)
+		h3 := login(withIdentity, serverIdentity)
+
+		if bytes.Equal(h1, h2) || bytes.Equal(h1, h3) {
+			t2.Fatal("transcript hashes of different sessions must differ")
+		}
+	})
+}
+
+func TestServer_SetKeyMaterialWithPreviousSeed(t *testing.T) {
+	testAll(t, func(t2 *testing.T, conf *configuration) {
+		// The record is created under the old seed.
+		regClient, err := conf.conf.Client()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		server, err := conf.conf.Server()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		password := []byte("password")
+		sks, pks := conf.conf.KeyGen()
+		oprfSeed := conf.conf.GenerateOPRFSeed()
+		record := buildRecord(internal.RandomBytes(32), oprfSeed, password, pks, regClient, server)
+
+		if err = server.SetKeyMaterial(nil, sks, pks, oprfSeed); err != nil {
+			t2.Fatal(err)
+		}
+
+		previousSeed := oprfSeed
+		currentSeed := conf.conf.GenerateOPRFSeed()
+
+		if err := server.SetKeyMaterialWithPreviousSeed(
+			nil, sks, pks, currentSeed, previousSeed[1:],
+		); !errors.Is(err, opaque.ErrInvalidOPRFSeedLength) {
+			t2.Fatalf("expected %q, got %v", opaque.ErrInvalidOPRFSeedLength, err)
+		}
+
+		if err := server.SetKeyMaterialWithPreviousSeed(
+			nil, sks, pks, currentSeed, previousSeed,
+		); err != nil {
+			t2.Fatal(err)
+		}
+
+		login := func(record *opaque.ClientRecord) error {
+			defer server.Ake.Flush()
+
+			client, err := conf.conf.Client()
+			if err != nil {
+				t2.Fatal(err)
+			}
+
+			ke2, err := server.GenerateKE2(client.GenerateKE1(password), record)
+			if err != nil {
+				return err
+			}
+
+			ke3, _, err := client.GenerateKE3(ke2)
+			if err != nil {
+				return err
+			}
+
+			return server.LoginFinish(ke3)
+		}
+
+		// Unmarked, the old record fails.
+		if err := login(record); err == nil {
+			t2.Fatal("expected old record to fail under the current seed")
+		}
+
+		// Marked, the old record logs in during the window.
+		old := *record
+		old.PreviousOPRFSeed = true
+
+		if err := login(&old); err != nil {
+			t2.Fatal(err)
+		}
+
+		// New registrations use the current seed.
+		client, err := conf.conf.Client()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		newRecord := buildRecord(internal.RandomBytes(32), currentSeed, password, pks, client, server)
+
+		if err := login(newRecord); err != nil {
+			t2.Fatal(err)
+		}
+
+		// After the window, marked records are rejected.
+		if err := server.SetKeyMaterial(nil, sks, pks, currentSeed); err != nil {
+			t2.Fatal(err)
+		}
+
+		if err := login(&old); !errors.Is(err, opaque.ErrNoPreviousOPRFSeed) {
+			t2.Fatalf("expected %q, got %v", opaque.ErrNoPreviousOPRFSeed, err)
+		}
+	})
+}
+
+func TestServer_KE2Options(t *testing.T) {
+	testAll(t, func(t2 *testing.T, conf *configuration) {
+		client, err := conf.conf.Client()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		server, err := conf.conf.Server()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		password := []byte("password")
+		sks, pks := conf.conf.KeyGen()
+		oprfSeed := conf.conf.GenerateOPRFSeed()
+		record := buildRecord(internal.RandomBytes(32), oprfSeed, password, pks, client, server)
+
+		if err = server.SetKeyMaterial(nil, sks, pks, oprfSeed); err != nil {
+			t2.Fatal(err)
+		}
+
+		ke1 := client.GenerateKE1(password)
+		seed := internal.RandomBytes(internal.SeedLength)
+		akeNonce := internal.RandomBytes(internal.NonceLength)
+		maskingNonce := internal.RandomBytes(internal.NonceLength)
+
+		// All options take effect, in any order, and the last one wins.
+		options := opaque.NewGenerateKE2Options(
+			opaque.WithMaskingNonce(internal.RandomBytes(internal.NonceLength)),
+			opaque.WithAKENonce(akeNonce),
+			opaque.WithKeyShareSeed(seed),
+			opaque.WithMaskingNonce(maskingNonce),
+		)
+
+		ke2, err := server.GenerateKE2(ke1, record, options)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		if !bytes.Equal(ke2.MaskingNonce, maskingNonce) {
+			t2.Fatal("expected the masking nonce option to take effect")
+		}
+
+		if !bytes.Equal(ke2.ServerNonce, akeNonce) {
+			t2.Fatal("expected the AKE nonce option to take effect")
+		}
+
+		server.Ake.Flush()
+
+		expected, err := server.GenerateKE2(ke1, record, opaque.GenerateKE2Options{
+			KeyShareSeed: seed,
+			AKENonce:     akeNonce,
+			MaskingNonce: maskingNonce,
+		})
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		if !bytes.Equal(ke2.Serialize(), expected.Serialize()) {
+			t2.Fatal("expected the same KE2 as with the equivalent options struct")
+		}
+
+		// The AKE nonce length applies when no AKE nonce is given.
+		server.Ake.Flush()
+
+		ke2, err = server.GenerateKE2(ke1, record, opaque.NewGenerateKE2Options(
+			opaque.WithKeyShareSeed(seed),
+			opaque.WithAKENonceLength(2*internal.NonceLength),
+		))
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		if len(ke2.ServerNonce) != 2*internal.NonceLength {
+			t2.Fatalf("expected an AKE nonce of %d bytes, got %d", 2*internal.NonceLength, len(ke2.ServerNonce))
+		}
+
+		if !ke2.ServerPublicKeyshare.Equal(expected.ServerPublicKeyshare) {
+			t2.Fatal("expected the key share seed option to take effect")
+		}
+
+		// GenerateKE2With takes the options directly.
+		server.Ake.Flush()
+
+		ke2, err = server.GenerateKE2With(ke1, record,
+			opaque.WithMaskingNonce(maskingNonce),
+			opaque.WithAKENonce(akeNonce),
+			opaque.WithKeyShareSeed(seed),
+		)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		if !bytes.Equal(ke2.Serialize(), expected.Serialize()) {
+			t2.Fatal("expected the same KE2 as with the equivalent options struct")
+		}
+
+		// The masking key and associated data options take effect, here with a record stored without masking key.
+		server.Ake.Flush()
+
+		associatedData := []byte("channel binding")
+		registration := *record.RegistrationRecord
+		registration.MaskingKey = nil
+		stored := *record
+		stored.RegistrationRecord = &registration
+
+		client, err = conf.conf.Client()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		ke2, err = server.GenerateKE2With(client.GenerateKE1(password), &stored,
+			opaque.WithMaskingKey(record.MaskingKey),
+			opaque.WithAssociatedData(associatedData),
+		)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		ke3, _, err := client.GenerateKE3(ke2, opaque.GenerateKE3Options{AssociatedData: associatedData})
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		if err = server.LoginFinish(ke3); err != nil {
+			t2.Fatal(err)
+		}
+
+		// The struct form only reads the first options struct, for backward compatibility.
+		server.Ake.Flush()
+
+		first := opaque.GenerateKE2Options{AKENonceLength: 2 * internal.NonceLength}
+		second := opaque.GenerateKE2Options{AKENonceLength: 3 * internal.NonceLength}
+
+		ke2, err = server.GenerateKE2(client.GenerateKE1(password), record, first, second)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		if len(ke2.ServerNonce) != 2*internal.NonceLength {
+			t2.Fatalf("expected the first options struct to be used, got a nonce of length %d", len(ke2.ServerNonce))
+		}
+
+		// GenerateKE2With rejects several options setting the same value instead of silently dropping one.
+		for _, options := range [][]opaque.KE2Option{
+			{opaque.WithAKENonce(internal.RandomBytes(32)), opaque.WithAKENonce(internal.RandomBytes(32))},
+			{opaque.WithAKENonceLength(internal.NonceLength), opaque.WithAKENonceLength(internal.NonceLength)},
+			{opaque.WithAssociatedData(associatedData), opaque.WithMaskingNonce(nil),
+				opaque.WithAssociatedData(associatedData)},
+		} {
+			if _, err = server.GenerateKE2With(ke1, record, options...); !errors.Is(
+				err, opaque.ErrDuplicateKE2Option) {
+				t2.Fatalf("expected %q, got %v", opaque.ErrDuplicateKE2Option, err)
+			}
+		}
+	})
+}
+
+func TestServer_GenerateKE2_Pooling(t *testing.T) {
+	testAll(t, func(t2 *testing.T, conf *configuration) {
+		client, err := conf.conf.Client()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		server, err := conf.conf.Server()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		sks, pks := conf.conf.KeyGen()
+		oprfSeed := conf.conf.GenerateOPRFSeed()
+		record := buildRecord(internal.RandomBytes(32), oprfSeed, []byte("yo"), pks, client, server)
+
+		if err = server.SetKeyMaterial(nil, sks, pks, oprfSeed); err != nil {
+			t2.Fatal(err)
+		}
+
+		ke1 := client.GenerateKE1([]byte("yo"))
+		options := opaque.GenerateKE2Options{
+			KeyShareSeed: internal.RandomBytes(internal.SeedLength),
+			AKENonce:     internal.RandomBytes(internal.NonceLength),
+			MaskingNonce: internal.RandomBytes(internal.NonceLength),
+		}
+
+		generate := func() *message.KE2 {
+			server.Ake.Flush()
+
+			ke2, err := server.GenerateKE2(ke1, record, options)
+			if err != nil {
+				t2.Fatal(err)
+			}
+
+			return ke2
+		}
+
+		// The pooled masking matches the unpooled reference.
+		ke2 := generate()
+		c := server.GetConf()
+		expected := xorResponse(c, record.MaskingKey, options.MaskingNonce, encoding.Concat(pks, record.Envelope))
+
+		if !bytes.Equal(ke2.MaskedResponse, expected) {
+			t2.Fatal("unexpected masked response")
+		}
+
+		// Reuse of pooled buffers doesn't change the output.
+		if !bytes.Equal(ke2.Serialize(), generate().Serialize()) {
+			t2.Fatal("expected identical KE2 messages with reused pooled buffers")
+		}
+	})
+}
+
+func TestPool_Wipes(t *testing.T) {
+	b := internal.GetBuffer()
+	*b = append(*b, internal.RandomBytes(100)...)
+	backing := (*b)[:cap(*b)]
+
+	internal.PutBuffer(b)
+
+	if !bytes.Equal(backing, make([]byte, len(backing))) {
+		t.Fatal("expected the pooled buffer to be wiped")
+	}
+
+	if len(*b) != 0 {
+		t.Fatal("expected the pooled buffer to be emptied")
+	}
+	e := internal.GetElement(group.Ristretto255Sha512)
+	if e.Set(group.Ristretto255Sha512.Base()).IsIdentity() {
+		t.Fatal("expected the base point")
+	}
+
+	internal.PutElement(e)
+
+	if !e.IsIdentity() {
+		t.Fatal("expected the pooled element to be wiped")
+	}
+}
+
+func BenchmarkServer_GenerateKE2(b *testing.B) {
+	conf := opaque.DefaultConfiguration()
+	client, _ := conf.Client()
+	server, _ := conf.Server()
+	sks, pks := conf.KeyGen()
+	oprfSeed := conf.GenerateOPRFSeed()
+	record := buildRecord(internal.RandomBytes(32), oprfSeed, []byte("yo"), pks, client, server)
+
+	if err := server.SetKeyMaterial(nil, sks, pks, oprfSeed); err != nil {
+		b.Fatal(err)
+	}
+
+	ke1 := client.GenerateKE1([]byte("yo"))
+
+	b.ReportAllocs()
+
+	for range b.N {
+		server.Ake.Flush()
+
+		if _, err := server.GenerateKE2(ke1, record); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestServer_IdentityTooLong(t *testing.T) {
+	conf := opaque.DefaultConfiguration()
+
+	client, err := conf.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server, err := conf.Server()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	password := []byte("password")
+	sks, pks := conf.KeyGen()
+	oprfSeed := conf.GenerateOPRFSeed()
+	record := buildRecord(internal.RandomBytes(32), oprfSeed, password, pks, client, server)
+
+	if err = server.SetKeyMaterial(nil, sks, pks, oprfSeed); err != nil {
+		t.Fatal(err)
+	}
+
+	// Server identity.
+	if err := server.SetKeyMaterial(make([]byte, 65535), sks, pks, oprfSeed); err != nil {
+		t.Fatal(err)
+	}
+
+	err = server.SetKeyMaterial(make([]byte, 65536), sks, pks, oprfSeed)
+	if !errors.Is(err, opaque.ErrIdentityTooLong) {
+		t.Fatalf("expected %q, got %v", opaque.ErrIdentityTooLong, err)
+	}
+
+	if err = server.SetKeyMaterial(nil, sks, pks, oprfSeed); err != nil {
+		t.Fatal(err)
+	}
+
+	// Client identity.
+	long := *record
+	long.ClientIdentity = make([]byte, 65535)
+
+	if _, err = server.GenerateKE2(client.GenerateKE1(password), &long); err != nil {
+		t.Fatal(err)
+	}
+
+	server.Ake.Flush()
+
+	long.ClientIdentity = make([]byte, 65536)
+
+	_, err = server.GenerateKE2(client.GenerateKE1(password), &long)
+	if !errors.Is(err, opaque.ErrIdentityTooLong) {
+		t.Fatalf("expected %q, got %v", opaque.ErrIdentityTooLong, err)
+	}
+}
+
+func TestServerInit_ErrorsIs(t *testing.T) {
+	testAll(t, func(t2 *testing.T, conf *configuration) {
+		server, err := conf.conf.Server()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		sk, pk := conf.conf.KeyGen()
+		oprfSeed := internal.RandomBytes(conf.conf.Hash.Size())
+
+		err = server.SetKeyMaterial(nil, getBadScalar(t2, conf), pk, oprfSeed)
+		if !errors.Is(err, opaque.ErrInvalidServerSecretKey) {
+			t2.Fatalf("expected %q, got %v", opaque.ErrInvalidServerSecretKey, err)
+		}
+
+		var opaqueErr *opaque.Error
+		if !errors.As(err, &opaqueErr) || opaqueErr.Cause == nil {
+			t2.Fatalf("expected an *opaque.Error with a cause, got %v", err)
+		}
+
+		// The decoding error is matched through the wrapping.
+		if !errors.Is(err, opaqueErr.Cause) {
+			t2.Fatal("expected the cause to match")
+		}
+
+		err = server.SetKeyMaterial(nil, sk, getBadElement(t2, conf), oprfSeed)
+		if !errors.Is(err, opaque.ErrInvalidServerPublicKey) {
+			t2.Fatalf("expected %q, got %v", opaque.ErrInvalidServerPublicKey, err)
+		}
+
+		if errors.Is(err, opaque.ErrInvalidServerSecretKey) {
+			t2.Fatal("unexpected match of the secret key sentinel")
+		}
+	})
+}
+
+func TestServer_MalformedInputs(t *testing.T) {
+	testAll(t, func(t2 *testing.T, conf *configuration) {
+		client, err := conf.conf.Client()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		server, err := conf.conf.Server()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		password := []byte("password")
+		sks, pks := conf.conf.KeyGen()
+		oprfSeed := conf.conf.GenerateOPRFSeed()
+		record := buildRecord(internal.RandomBytes(32), oprfSeed, password, pks, client, server)
+
+		if err = server.SetKeyMaterial(nil, sks, pks, oprfSeed); err != nil {
+			t2.Fatal(err)
+		}
+
+		ke1 := client.GenerateKE1(password)
+
+		noRequest := *ke1
+		noRequest.CredentialRequest = nil
+
+		if _, err := server.GenerateKE2(&noRequest, record); !errors.Is(err, opaque.ErrMalformedKE1) {
+			t2.Fatalf("expected %q, got %v", opaque.ErrMalformedKE1, err)
+		}
+
+		noRecord := &opaque.ClientRecord{
+			RegistrationRecord:   nil,
+			CredentialIdentifier: record.CredentialIdentifier,
+			ClientIdentity:       nil,
+			PreviousOPRFSeed:     false,
+		}
+
+		if _, err := server.GenerateKE2(ke1, noRecord); !errors.Is(err, opaque.ErrMalformedRecord) {
+			t2.Fatalf("expected %q, got %v", opaque.ErrMalformedRecord, err)
+		}
+
+		if _, _, err := server.GenerateKE2Multi(ke1, []*opaque.ClientRecord{noRecord}); !errors.Is(
+			err,
+			opaque.ErrMalformedRecord,
+		) {
+			t2.Fatalf("expected %q, got %v", opaque.ErrMalformedRecord, err)
+		}
+
+		// The well-formed messages are still accepted.
+		if _, err := server.GenerateKE2(ke1, record); err != nil {
+			t2.Fatal(err)
+		}
+	})
+}
+
+func TestConfiguration_ValidateKE1(t *testing.T) {
+	testAll(t, func(t2 *testing.T, conf *configuration) {
+		client, err := conf.conf.Client()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		ke1 := client.GenerateKE1([]byte("password"))
+
+		if err = conf.conf.ValidateKE1(ke1); err != nil {
+			t2.Fatal(err)
+		}
+
+		other := group.Ristretto255Sha512
+		if conf.conf.AKE == opaque.RistrettoSha512 {
+			other = group.P256Sha256
+		}
+
+		noRequest := *ke1
+		noRequest.CredentialRequest = nil
+
+		for name, test := range map[string]struct {
+			ke1      *message.KE1
+			expected error
+		}{
+			"nil KE1":    {ke1: nil, expected: opaque.ErrMalformedKE1},
+			"no request": {ke1: &noRequest, expected: opaque.ErrMalformedKE1},
+		} {
+			if err = conf.conf.ValidateKE1(test.ke1); !errors.Is(err, test.expected) {
+				t2.Fatalf("%s: expected %q, got %v", name, test.expected, err)
+			}
+		}
+
+		for name, blinded := range map[string]*group.Element{
+			"nil":         nil,
+			"identity":    conf.conf.OPRF.Group().NewElement(),
+			"other group": other.Base(),
+		} {
+			malformed := *ke1
+			malformed.CredentialRequest = message.NewCredentialRequest(blinded)
+
+			if err = conf.conf.ValidateKE1(&malformed); !errors.Is(err, opaque.ErrInvalidBlindedMessage) {
+				t2.Fatalf("%s blinded message: expected %q, got %v", name, opaque.ErrInvalidBlindedMessage, err)
+			}
+		}
+
+		for name, keyShare := range map[string]*group.Element{
+			"nil":         nil,
+			"identity":    conf.conf.AKE.Group().NewElement(),
+			"other group": other.Base(),
+		} {
+			malformed := *ke1
+			malformed.ClientPublicKeyshare = keyShare
+
+			if err = conf.conf.ValidateKE1(&malformed); !errors.Is(err, opaque.ErrInvalidClientKeyShare) {
+				t2.Fatalf("%s key share: expected %q, got %v", name, opaque.ErrInvalidClientKeyShare, err)
+			}
+		}
+
+		shortNonce := *ke1
+		shortNonce.ClientNonce = ke1.ClientNonce[1:]
+
+		if err = conf.conf.ValidateKE1(&shortNonce); !errors.Is(err, opaque.ErrInvalidClientNonce) {
+			t2.Fatalf("expected %q, got %v", opaque.ErrInvalidClientNonce, err)
+		}
+
+		// The KEM encapsulation key must be of the configured KEM's length.
+		kemConf := conf.conf.Clone()
+		kemConf.KEM = opaque.MLKEM768
+
+		if err = kemConf.ValidateKE1(ke1); !errors.Is(err, opaque.ErrInvalidKEMKeyShare) {
+			t2.Fatalf("expected %q, got %v", opaque.ErrInvalidKEMKeyShare, err)
+		}
+
+		kemClient, err := kemConf.Client()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		if err = kemConf.ValidateKE1(kemClient.GenerateKE1([]byte("password"))); err != nil {
+			t2.Fatal(err)
+		}
+
+		if err = new(opaque.Configuration).ValidateKE1(ke1); err == nil {
+			t2.Fatal("expected error on invalid configuration")
+		}
+	})
+}
+
+func TestServer_InvalidClientKeyShare(t *testing.T) {
+	testAll(t, func(t2 *testing.T, conf *configuration) {
+		client, err := conf.conf.Client()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		server, err := conf.conf.Server()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		password := []byte("password")
+		sks, pks := conf.conf.KeyGen()
+		oprfSeed := conf.conf.GenerateOPRFSeed()
+		record := buildRecord(internal.RandomBytes(32), oprfSeed, password, pks, client, server)
+
+		if err = server.SetKeyMaterial(nil, sks, pks, oprfSeed); err != nil {
+			t2.Fatal(err)
+		}
+
+		ke1 := client.GenerateKE1(password)
+
+		other := group.Ristretto255Sha512
+		if conf.conf.AKE == opaque.RistrettoSha512 {
+			other = group.P256Sha256
+		}
+
+		for name, keyShare := range map[string]*group.Element{
+			"nil":      nil,
+			"identity": conf.conf.AKE.Group().NewElement(),
+			"group":    other.Base(),
+		} {
+			bad := *ke1
+			bad.ClientPublicKeyshare = keyShare
+
+			if _, err := server.GenerateKE2(&bad, record); !errors.Is(err, opaque.ErrInvalidClientKeyShare) {
+				t2.Fatalf("%s: expected %q, got %v", name, opaque.ErrInvalidClientKeyShare, err)
+			}
+		}
+
+		if _, err := server.GenerateKE2(nil, record); !errors.Is(err, opaque.ErrInvalidClientKeyShare) {
+			t2.Fatalf("expected %q, got %v", opaque.ErrInvalidClientKeyShare, err)
+		}
+
+		// The untouched KE1 is still accepted.
+		if _, err := server.GenerateKE2(ke1, record); err != nil {
+			t2.Fatal(err)
+		}
+	})
+}
+
+func TestServer_GenerateKE2WithState(t *testing.T) {
+	testAll(t, func(t2 *testing.T, conf *configuration) {
+		client, err := conf.conf.Client()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		server, err := conf.conf.Server()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		password := []byte("password")
+		sks, pks := conf.conf.KeyGen()
+		oprfSeed := conf.conf.GenerateOPRFSeed()
+		record := buildRecord(internal.RandomBytes(32), oprfSeed, password, pks, client, server)
+
+		if err = server.SetKeyMaterial(nil, sks, pks, oprfSeed); err != nil {
+			t2.Fatal(err)
+		}
+
+		ke2, state, err := server.GenerateKE2WithState(client.GenerateKE1(password), record)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		if !bytes.Equal(state, server.SerializeState()) {
+			t2.Fatal("expected the returned state to be the server's AKE state")
+		}
+
+		ke3, _, err := client.GenerateKE3(ke2)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		// A fresh server with the same key material finalizes the login with the returned state.
+		other, err := conf.conf.Server()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		if err = other.SetKeyMaterial(nil, sks, pks, oprfSeed); err != nil {
+			t2.Fatal(err)
+		}
+
+		if err = other.SetAKEState(state); err != nil {
+			t2.Fatal(err)
+		}
+
+		if err = other.LoginFinish(ke3); err != nil {
+			t2.Fatal(err)
+		}
+
+		if !bytes.Equal(client.SessionKey(), other.SessionKey()) {
+			t2.Fatal("expected same session key")
+		}
+
+		// Errors are those of GenerateKE2.
+		if _, _, err = other.GenerateKE2WithState(nil, record); err == nil {
+			t2.Fatal("expected error on nil KE1")
+		}
+	})
+}
+
+func TestServer_GenerateKE2Multi(t *testing.T) {
+	testAll(t, func(t2 *testing.T, conf *configuration) {
+		regClient, err := conf.conf.Client()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		server, err := conf.conf.Server()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		password := []byte("password")
+		sks, pks := conf.conf.KeyGen()
+		oprfSeed := conf.conf.GenerateOPRFSeed()
+		record := buildRecord(internal.RandomBytes(32), oprfSeed, password, pks, regClient, server)
+
+		if err = server.SetKeyMaterial(nil, sks, pks, oprfSeed); err != nil {
+			t2.Fatal(err)
+		}
+
+		records := []*opaque.ClientRecord{record}
+
+		for range 2 {
+			client, err := conf.conf.Client()
+			if err != nil {
+				t2.Fatal(err)
+			}
+
+			records = append(records, buildRecord(internal.RandomBytes(32), oprfSeed, password, pks, client, server))
+		}
+
+		client, err := conf.conf.Client()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		ke1 := client.GenerateKE1(password)
+
+		ke2s, states, err := server.GenerateKE2Multi(ke1, records)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		if len(ke2s) != len(records) || len(states) != len(records) {
+			t2.Fatalf("expected %d KE2 and states, got %d and %d", len(records), len(ke2s), len(states))
+		}
+
+		if server.SessionKey() != nil {
+			t2.Fatal("expected the server's AKE state to be flushed")
+		}
+
+		for i, ke2 := range ke2s {
+			for j := range i {
+				if bytes.Equal(ke2.ServerNonce, ke2s[j].ServerNonce) ||
+					ke2.ServerPublicKeyshare.Equal(ke2s[j].ServerPublicKeyshare) ||
+					bytes.Equal(ke2.MaskingNonce, ke2s[j].MaskingNonce) {
+					t2.Fatal("expected fresh nonces and key shares per KE2")
+				}
+			}
+
+			ke3, _, err := client.GenerateKE3(ke2)
+			if err != nil {
+				t2.Fatalf("record %d: %v", i, err)
+			}
+
+			// The KE3 is only accepted with its own login's state.
+			if err = server.SetAKEState(states[(i+1)%len(states)]); err != nil {
+				t2.Fatal(err)
+			}
+
+			if err = server.LoginFinish(ke3); !errors.Is(err, opaque.ErrAkeInvalidClientMac) {
+				t2.Fatalf("expected %q, got %v", opaque.ErrAkeInvalidClientMac, err)
+			}
+
+			server.Ake.Flush()
+
+			if err = server.SetAKEState(states[i]); err != nil {
+				t2.Fatal(err)
+			}
+
+			if err = server.LoginFinish(ke3); err != nil {
+				t2.Fatalf("record %d: %v", i, err)
+			}
+
+			if !bytes.Equal(client.SessionKey(), server.SessionKey()) {
+				t2.Fatalf("record %d: expected same session key", i)
+			}
+
+			server.Ake.Flush()
+		}
+
+		// Fixed values would be shared by all KE2.
+		for _, options := range []opaque.GenerateKE2Options{
+			{KeyShareSeed: internal.RandomBytes(32)},
+			{AKENonce: internal.RandomBytes(32)},
+			{MaskingNonce: internal.RandomBytes(32)},
+			{MaskingKey: record.MaskingKey},
+		} {
+			if _, _, err = server.GenerateKE2Multi(ke1, records, options); !errors.Is(
+				err, opaque.ErrFixedMultiKE2Values) {
+				t2.Fatalf("expected %q, got %v", opaque.ErrFixedMultiKE2Values, err)
+			}
+		}
+
+		// An invalid record fails the whole batch.
+		if _, _, err = server.GenerateKE2Multi(ke1, []*opaque.ClientRecord{record, nil}); !errors.Is(
+			err, message.ErrNilRecord) {
+			t2.Fatalf("expected %q, got %v", message.ErrNilRecord, err)
+		}
+	})
+}
+
+func TestServer_TaggedState(t *testing.T) {
+	testAll(t, func(t2 *testing.T, conf *configuration) {
+		client, err := conf.conf.Client()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		server, err := conf.conf.Server()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		password := []byte("password")
+		sks, pks := conf.conf.KeyGen()
+		oprfSeed := conf.conf.GenerateOPRFSeed()
+		record := buildRecord(internal.RandomBytes(32), oprfSeed, password, pks, client, server)
+
+		if err = server.SetKeyMaterial(nil, sks, pks, oprfSeed); err != nil {
+			t2.Fatal(err)
+		}
+
+		ke2, err := server.GenerateKE2(client.GenerateKE1(password), record)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		key := internal.RandomBytes(32)
+
+		state, err := server.SerializeStateTagged(key)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		ke3, _, err := client.GenerateKE3(ke2)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		other, _ := conf.conf.Server()
+
+		// Truncated state.
+		for _, l := range []int{0, len(state) - 1, len(server.SerializeState())} {
+			if err = other.SetAKEStateTagged(key, state[:l]); !errors.Is(err, opaque.ErrStateIntegrity) {
+				t2.Fatalf("expected %q for length %d, got %v", opaque.ErrStateIntegrity, l, err)
+			}
+		}
+
+		// Flipped byte, in the state and in the tag.
+		for _, i := range []int{0, len(state) - 1} {
+			flipped := slices.Clone(state)
+			flipped[i] ^= 1
+
+			if err = other.SetAKEStateTagged(key, flipped); !errors.Is(err, opaque.ErrStateIntegrity) {
+				t2.Fatalf("expected %q, got %v", opaque.ErrStateIntegrity, err)
+			}
+		}
+
+		// Wrong key.
+		if err = other.SetAKEStateTagged(internal.RandomBytes(32), state); !errors.Is(err, opaque.ErrStateIntegrity) {
+			t2.Fatalf("expected %q, got %v", opaque.ErrStateIntegrity, err)
+		}
+
+		// Short key.
+		if err = other.SetAKEStateTagged(key[:16], state); !errors.Is(err, opaque.ErrInvalidSealKey) {
+			t2.Fatalf("expected %q, got %v", opaque.ErrInvalidSealKey, err)
+		}
+
+		if _, err = server.SerializeStateTagged(nil); !errors.Is(err, opaque.ErrInvalidSealKey) {
+			t2.Fatalf("expected %q, got %v", opaque.ErrInvalidSealKey, err)
+		}
+
+		// Good tag.
+		if err = other.SetAKEStateTagged(key, state); err != nil {
+			t2.Fatal(err)
+		}
+
+		if err = other.LoginFinish(ke3); err != nil {
+			t2.Fatal(err)
+		}
+
+		if !bytes.Equal(other.SessionKey(), client.SessionKey()) {
+			t2.Fatal("session keys differ")
+		}
+	})
+}
+
+func TestServer_ReplayedKE1(t *testing.T) {
+	testAll(t, func(t2 *testing.T, conf *configuration) {
+		client, err := conf.conf.Client()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		server, err := conf.conf.Server()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		password := []byte("password")
+		sks, pks := conf.conf.KeyGen()
+		oprfSeed := conf.conf.GenerateOPRFSeed()
+		record := buildRecord(internal.RandomBytes(32), oprfSeed, password, pks, client, server)
+
+		if err = server.SetKeyMaterial(nil, sks, pks, oprfSeed); err != nil {
+			t2.Fatal(err)
+		}
+
+		server.SetSeenClientNonces(opaque.NewNonceCache(16, time.Minute))
+		ke1 := client.GenerateKE1(password)
+
+		// Fresh KE1.
+		ke2, err := server.GenerateKE2(ke1, record)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		ke3, _, err := client.GenerateKE3(ke2)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		if err = server.LoginFinish(ke3); err != nil {
+			t2.Fatal(err)
+		}
+
+		server.Reset()
+
+		// Replayed KE1, also to the fake and multi-record variants.
+		if _, err = server.GenerateKE2(ke1, record); !errors.Is(err, opaque.ErrReplayedKE1) {
+			t2.Fatalf("expected %q, got %v", opaque.ErrReplayedKE1, err)
+		}
+
+		if _, err = server.GenerateFakeKE2(ke1, []byte("unknown")); !errors.Is(err, opaque.ErrReplayedKE1) {
+			t2.Fatalf("expected %q, got %v", opaque.ErrReplayedKE1, err)
+		}
+
+		if _, _, err = server.GenerateKE2Multi(ke1, []*opaque.ClientRecord{record}); !errors.Is(
+			err, opaque.ErrReplayedKE1) {
+			t2.Fatalf("expected %q, got %v", opaque.ErrReplayedKE1, err)
+		}
+
+		// A new KE1 is accepted, once, by all records of a multi-record response.
+		client, err = conf.conf.Client()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		ke1 = client.GenerateKE1(password)
+		if _, _, err = server.GenerateKE2Multi(ke1, []*opaque.ClientRecord{record, record}); err != nil {
+			t2.Fatal(err)
+		}
+
+		// Disabling the check.
+		server.SetSeenClientNonces(nil)
+
+		if _, err = server.GenerateKE2(ke1, record); err != nil {
+			t2.Fatal(err)
+		}
+	})
+}
+
+func TestServer_ReplayedKE1_FailedAttempt(t *testing.T) {
+	testAll(t, func(t2 *testing.T, conf *configuration) {
+		client, err := conf.conf.Client()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		server, err := conf.conf.Server()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		server.SetSeenClientNonces(opaque.NewNonceCache(16, time.Minute))
+		sks, pks := conf.conf.KeyGen()
+		oprfSeed := conf.conf.GenerateOPRFSeed()
+		record := buildRecord(internal.RandomBytes(32), oprfSeed, []byte("yo"), pks, client, server)
+		ke1 := client.GenerateKE1([]byte("yo"))
+
+		// Failed attempts don't record the client nonce.
+		if _, err = server.GenerateKE2(ke1, record); !errors.Is(err, opaque.ErrNoServerKeyMaterial) {
+			t2.Fatalf("expected %q, got %v", opaque.ErrNoServerKeyMaterial, err)
+		}
+
+		if err = server.SetKeyMaterial(nil, sks, pks, oprfSeed); err != nil {
+			t2.Fatal(err)
+		}
+
+		if _, err = server.GenerateKE2(ke1, nil); !errors.Is(err, message.ErrNilRecord) {
+			t2.Fatalf("expected %q, got %v", message.ErrNilRecord, err)
+		}
+
+		if _, _, err = server.GenerateKE2Multi(ke1, []*opaque.ClientRecord{record, nil}); !errors.Is(
+			err, message.ErrNilRecord) {
+			t2.Fatalf("expected %q, got %v", message.ErrNilRecord, err)
+		}
+
+		// The retry is accepted, once.
+		ke2, err := server.GenerateKE2(ke1, record)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		ke3, _, err := client.GenerateKE3(ke2)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		if err = server.LoginFinish(ke3); err != nil {
+			t2.Fatal(err)
+		}
+
+		server.Reset()
+
+		if _, err = server.GenerateKE2(ke1, record); !errors.Is(err, opaque.ErrReplayedKE1) {
+			t2.Fatalf("expected %q, got %v", opaque.ErrReplayedKE1, err)
+		}
+	})
+}
+
+func TestNonceCache(t *testing.T) {
+	nonce := func(i byte) []byte { return []byte{i} }
+
+	// Eviction of the oldest nonce.
+	cache := opaque.NewNonceCache(2, 0)
+	if cache.Seen(nonce(1)) || cache.Seen(nonce(2)) {
+		t.Fatal("expected fresh nonces")
+	}
+
+	if !cache.Seen(nonce(1)) || !cache.Seen(nonce(2)) {
+		t.Fatal("expected seen nonces")
+	}
+
+	if cache.Seen(nonce(3)) {
+		t.Fatal("expected fresh nonce")
+	}
+
+	if cache.Seen(nonce(1)) {
+		t.Fatal("expected the oldest nonce to be evicted")
+	}
+
+	// Expiry.
+	ttl := 20 * time.Millisecond
+	cache = opaque.NewNonceCache(0, ttl)
+
+	if cache.Seen(nonce(1)) || !cache.Seen(nonce(1)) {
+		t.Fatal("expected the nonce to be seen once")
+	}
+
+	time.Sleep(2 * ttl)
+
+	if cache.Seen(nonce(1)) {
+		t.Fatal("expected the nonce to be expired")
+	}
+
+	if !cache.Seen(nonce(1)) {
+		t.Fatal("expected the nonce to be recorded again")
+	}
+}
+
+func TestServer_ExportImportState(t *testing.T) {
+	testAll(t, func(t2 *testing.T, conf *configuration) {
+		client, err := conf.conf.Client()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		server, err := conf.conf.Server()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		password := []byte("password")
+		sks, pks := conf.conf.KeyGen()
+		oprfSeed := conf.conf.GenerateOPRFSeed()
+		record := buildRecord(internal.RandomBytes(32), oprfSeed, password, pks, client, server)
+
+		if err = server.SetKeyMaterial(nil, sks, pks, oprfSeed); err != nil {
+			t2.Fatal(err)
+		}
+
+		if _, err := server.ExportState(); err == nil {
+			t2.Fatal("expected error when there is no state to export")
+		}
+
+		ke2, err := server.GenerateKE2(client.GenerateKE1(password), record)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		state, err := server.ExportState()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		if !bytes.Equal(slices.Concat(state.ClientMac, state.SessionSecret), server.SerializeState()) {
+			t2.Fatal("expected the exported state to match the serialized state")
+		}
+
+		encoded, err := state.MarshalBinary()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		decoded, err := server.UnmarshalState(encoded)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		if err = decoded.UnmarshalBinary(append(encoded, 0)); !errors.Is(err, opaque.ErrTrailingBytes) {
+			t2.Fatalf("expected %q, got %v", opaque.ErrTrailingBytes, err)
+		}
+
+		if err = decoded.UnmarshalBinary(encoded[:len(encoded)-1]); !errors.Is(err, opaque.ErrInvalidState) {
+			t2.Fatalf("expected %q, got %v", opaque.ErrInvalidState, err)
+		}
+
+		other, _ := conf.conf.Server()
+
+		// Wrong-length fields are rejected.
+		for name, bad := range map[string]*opaque.ServerState{
+			"nil":          nil,
+			"empty":        {ClientMac: nil, SessionSecret: nil},
+			"short mac":    {ClientMac: state.ClientMac[1:], SessionSecret: state.SessionSecret},
+			"long mac":     {ClientMac: append(slices.Clone(state.ClientMac), 0), SessionSecret: state.SessionSecret},
+			"short secret": {ClientMac: state.ClientMac, SessionSecret: state.SessionSecret[1:]},
+			"long secret":  {ClientMac: state.ClientMac, SessionSecret: append(slices.Clone(state.SessionSecret), 0)},
+		} {
+			if err = other.ImportState(bad); !errors.Is(err, opaque.ErrInvalidState) {
+				t2.Fatalf("%s: expected %q, got %v", name, opaque.ErrInvalidState, err)
+			}
+
+			if bad == nil {
+				continue
+			}
+
+			// They are also rejected at decoding.
+			encodedBad, err := bad.MarshalBinary()
+			if err != nil {
+				t2.Fatal(err)
+			}
+
+			if _, err = other.UnmarshalState(encodedBad); !errors.Is(err, opaque.ErrInvalidState) {
+				t2.Fatalf("%s: expected %q when decoding, got %v", name, opaque.ErrInvalidState, err)
+			}
+		}
+
+		// The decoded state finishes the login on another instance.
+		if err = other.ImportState(decoded); err != nil {
+			t2.Fatal(err)
+		}
+
+		ke3, _, err := client.GenerateKE3(ke2)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		if err = other.LoginFinish(ke3); err != nil {
+			t2.Fatal(err)
+		}
+
+		if !bytes.Equal(other.SessionKey(), client.SessionKey()) {
+			t2.Fatal("session keys differ")
+		}
+	})
+}
+
+// wideMAC is a MAC with an output longer than the standard ones, i.e. HMAC-SHA512 followed by HMAC-SHA256.
+type wideMAC struct{}
+
+func (wideMAC) MAC(key, message []byte) []byte {
+	long, short := hmac.New(sha512.New, key), hmac.New(sha256.New, key)
+	long.Write(message)
+	short.Write(message)
+
+	return short.Sum(long.Sum(nil))
+}
+
+func (wideMAC) Size() int {
+	return 96
+}
+
+func TestServer_ExportImportState_WideMAC(t *testing.T) {
+	conf := opaque.DefaultConfiguration()
+	conf.CustomMAC = wideMAC{}
+
+	client, err := conf.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server, err := conf.Server()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	password := []byte("password")
+	sks, pks := conf.KeyGen()
+	oprfSeed := conf.GenerateOPRFSeed()
+	record := buildRecord(internal.RandomBytes(32), oprfSeed, password, pks, client, server)
+
+	if err = server.SetKeyMaterial(nil, sks, pks, oprfSeed); err != nil {
+		t.Fatal(err)
+	}
+
+	ke2, err := server.GenerateKE2(client.GenerateKE1(password), record)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state, err := server.ExportState()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encoded, err := state.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, _ := conf.Server()
+
+	decoded, err := other.UnmarshalState(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = other.ImportState(decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	ke3, _, err := client.GenerateKE3(ke2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = other.LoginFinish(ke3); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTranscriptHash_LargeContext(t *testing.T) {
+	testAll(t, func(t2 *testing.T, conf *configuration) {
+		c := conf.conf.Clone()
+		c.Context = internal.RandomBytes(1<<16 - 1)
+
+		client, err := c.Client()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		server, err := c.Server()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		password := []byte("password")
+		sks, pks := c.KeyGen()
+		oprfSeed := c.GenerateOPRFSeed()
+		record := buildRecord(internal.RandomBytes(32), oprfSeed, password, pks, client, server)
+
+		if err = server.SetKeyMaterial(nil, sks, pks, oprfSeed); err != nil {
+			t2.Fatal(err)
+		}
+
+		ke1 := client.GenerateKE1(password)
+
+		ke2, err := server.GenerateKE2(ke1, record)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		// The transcript, as the concatenation of its components.
+		h := c.Hash.New()
+		h.Write(encoding.Concatenate([]byte(tag.VersionTag), encoding.EncodeVector(c.Context),
+			encoding.EncodeVector(record.PublicKey.Encode()), ke1.Serialize(),
+			encoding.EncodeVector(pks), ke2.CredentialResponse.Serialize(), ke2.ServerNonce,
+			ke2.ServerPublicKeyshare.Encode(), ke2.KEMCiphertext))
+
+		if !bytes.Equal(h.Sum(nil), server.TranscriptHash()) {
+			t2.Fatal("unexpected transcript hash")
+		}
+
+		if _, _, err = client.GenerateKE3(ke2); err != nil {
+			t2.Fatal(err)
+		}
+
+		if !bytes.Equal(client.TranscriptHash(), server.TranscriptHash()) {
+			t2.Fatal("client and server transcript hashes differ")
+		}
+	})
+}
+
+func TestServer_PublicKey(t *testing.T) {
+	testAll(t, func(t2 *testing.T, conf *configuration) {
+		unset, _ := conf.conf.Server()
+		if unset.PublicKey() != nil {
+			t2.Fatal("expected no public key without key material")
+		}
+
+		client, err := conf.conf.Client()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		server, err := conf.conf.Server()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		password := []byte("password")
+		sks, pks := conf.conf.KeyGen()
+		oprfSeed := conf.conf.GenerateOPRFSeed()
+		record := buildRecord(internal.RandomBytes(32), oprfSeed, password, pks, client, server)
+
+		if err = server.SetKeyMaterial(nil, sks, pks, oprfSeed); err != nil {
+			t2.Fatal(err)
+		}
+
+		decoded, err := server.Deserialize.DecodeAkePublicKey(pks)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		if !server.PublicKey().Equal(decoded) {
+			t2.Fatal("expected the cached public key to match the encoded one")
+		}
+
+		ke1 := client.GenerateKE1(password)
+		options := opaque.GenerateKE2Options{
+			KeyShareSeed:   internal.RandomBytes(32),
+			AKENonce:       internal.RandomBytes(32),
+			MaskingNonce:   internal.RandomBytes(32),
+			AKENonceLength: 0,
+			AssociatedData: nil,
+		}
+
+		ke2, err := server.GenerateKE2(ke1, record, options)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		// Mutating the returned element must not affect the server.
+		server.PublicKey().Double()
+		server.Ake.Flush()
+
+		again, err := server.GenerateKE2(ke1, record, options)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		if !bytes.Equal(ke2.Serialize(), again.Serialize()) || !server.PublicKey().Equal(decoded) {
+			t2.Fatal("expected identical KE2 output")
+		}
+
+		// The cached key produces the same registration response as a decoded one.
+		request := client.RegistrationInit(password)
+		credID := internal.RandomBytes(32)
+
+		cached, _ := server.RegistrationResponse(request, server.PublicKey(), credID, oprfSeed)
+		fromDecoded, _ := server.RegistrationResponse(request, decoded, credID, oprfSeed)
+
+		if !bytes.Equal(cached.Serialize(), fromDecoded.Serialize()) {
+			t2.Fatal("expected identical registration responses")
+		}
+
+		server.ClearKeyMaterial()
+
+		if server.PublicKey() != nil {
+			t2.Fatal("expected no public key after clearing the key material")
+		}
+	})
+}
+
+func BenchmarkServer_RegistrationResponse_PublicKey(b *testing.B) {
+	conf := opaque.DefaultConfiguration()
+
+	server, err := conf.Server()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	password := []byte("password")
+	sks, pks := conf.KeyGen()
+	oprfSeed := conf.GenerateOPRFSeed()
+
+	if err = server.SetKeyMaterial(nil, sks, pks, oprfSeed); err != nil {
+		b.Fatal(err)
+	}
+
+	client, err := conf.Client()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	request := client.RegistrationInit(password)
+	credID := internal.RandomBytes(32)
+
+	b.Run("decoded", func(b *testing.B) {
+		for range b.N {
+			pk, _ := server.Deserialize.DecodeAkePublicKey(pks)
+			_, _ = server.RegistrationResponse(request, pk, credID, oprfSeed)
+		}
+	})
+
+	b.Run("cached", func(b *testing.B) {
+		for range b.N {
+			_, _ = server.RegistrationResponse(request, server.PublicKey(), credID, oprfSeed)
+		}
+	})
+}
+
+func TestAssociatedData(t *testing.T) {
+	testAll(t, func(t2 *testing.T, conf *configuration) {
+		regClient, err := conf.conf.Client()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		server, err := conf.conf.Server()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		password := []byte("password")
+		sks, pks := conf.conf.KeyGen()
+		oprfSeed := conf.conf.GenerateOPRFSeed()
+		record := buildRecord(internal.RandomBytes(32), oprfSeed, password, pks, regClient, server)
+
+		if err = server.SetKeyMaterial(nil, sks, pks, oprfSeed); err != nil {
+			t2.Fatal(err)
+		}
+
+		ad := []byte("tls-exporter")
+
+		login := func(serverAD, clientAD []byte) (*opaque.Client, error) {
+			defer server.Ake.Flush()
+
+			client, err := conf.conf.Client()
+			if err != nil {
+				t2.Fatal(err)
+			}
+
+			ke2, err := server.GenerateKE2(client.GenerateKE1(password), record,
+				opaque.GenerateKE2Options{AssociatedData: serverAD})
+			if err != nil {
+				t2.Fatal(err)
+			}
+
+			ke3, _, err := client.GenerateKE3(ke2, opaque.GenerateKE3Options{AssociatedData: clientAD})
+			if err != nil {
+				return nil, err
+			}
+
+			if err = server.LoginFinish(ke3); err != nil {
+				t2.Fatal(err)
+			}
+
+			if !bytes.Equal(client.SessionKey(), server.SessionKey()) {
+				t2.Fatal("session keys differ")
+			}
+
+			return client, nil
+		}
+
+		// Matching associated data.
+		withAD, err := login(ad, ad)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		withoutAD, err := login(nil, nil)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		if bytes.Equal(withAD.TranscriptHash(), withoutAD.TranscriptHash()) {
+			t2.Fatal("expected the associated data to change the transcript")
+		}
+
+		// Mismatched associated data.
+		for _, pair := range [][2][]byte{{ad, []byte("other")}, {ad, nil}, {nil, ad}} {
+			if _, err = login(pair[0], pair[1]); err == nil || !strings.Contains(err.Error(), "invalid server mac") {
+				t2.Fatalf("expected invalid server mac error, got %v", err)
+			}
+		}
+
+		// Too long.
+		client, err := conf.conf.Client()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		if _, err = server.GenerateKE2(client.GenerateKE1(password), record,
+			opaque.GenerateKE2Options{AssociatedData: make([]byte, 1<<16)}); !errors.Is(
+			err, opaque.ErrAssociatedDataTooLong) {
+			t2.Fatalf("expected %q, got %v", opaque.ErrAssociatedDataTooLong, err)
+		}
+
+		ke2, err := server.GenerateKE2(client.GenerateKE1(password), record)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		tooLong := opaque.GenerateKE3Options{AssociatedData: make([]byte, 1<<16)}
+		if _, _, err = client.GenerateKE3(ke2, tooLong); !errors.Is(err, opaque.ErrAssociatedDataTooLong) {
+			t2.Fatalf("expected %q, got %v", opaque.ErrAssociatedDataTooLong, err)
+		}
+	})
+}
+
+func TestServer_ExternalMaskingKey(t *testing.T) {
+	testAll(t, func(t2 *testing.T, conf *configuration) {
+		client, err := conf.conf.Client()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		server, err := conf.conf.Server()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		password := []byte("password")
+		sks, pks := conf.conf.KeyGen()
+		oprfSeed := conf.conf.GenerateOPRFSeed()
+		record := buildRecord(internal.RandomBytes(32), oprfSeed, password, pks, client, server)
+
+		if err = server.SetKeyMaterial(nil, sks, pks, oprfSeed); err != nil {
+			t2.Fatal(err)
+		}
+
+		maskingKey := record.MaskingKey
+
+		// The record is stored without its masking key.
+		registrationRecord := *record.RegistrationRecord
+		registrationRecord.MaskingKey = nil
+		stored := *record
+		stored.RegistrationRecord = &registrationRecord
+
+		ke1 := client.GenerateKE1(password)
+
+		if _, err := server.GenerateKE2(ke1, &stored); !errors.Is(err, message.ErrInvalidMaskingKeyLength) {
+			t2.Fatalf("expected %q, got %v", message.ErrInvalidMaskingKeyLength, err)
+		}
+
+		ke2, err := server.GenerateKE2(ke1, &stored, opaque.GenerateKE2Options{MaskingKey: maskingKey})
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		if stored.MaskingKey != nil {
+			t2.Fatal("the record must not be modified")
+		}
+
+		ke3, _, err := client.GenerateKE3(ke2)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		if err = server.LoginFinish(ke3); err != nil {
+			t2.Fatal(err)
+		}
+
+		// A wrong masking key prevents the client from unmasking the response.
+		server.Ake.Flush()
+
+		client, err = conf.conf.Client()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		ke2, err = server.GenerateKE2(client.GenerateKE1(password), &stored,
+			opaque.GenerateKE2Options{MaskingKey: internal.RandomBytes(len(maskingKey))})
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		if _, _, err = client.GenerateKE3(ke2); err == nil {
+			t2.Fatal("expected an error with a wrong masking key")
+		}
+	})
+}
+
+// referenceMask is the original byte-by-byte masking implementation.
+func referenceMask(conf *internal.Configuration, nonce, maskingKey, serverPublicKey, envelope []byte) []byte {
+	clearText := encoding.Concat(serverPublicKey, envelope)
+	pad := conf.KDF.Expand(
+		maskingKey,
+		encoding.SuffixString(nonce, tag.CredentialResponsePad),
+		conf.Group.ElementLength()+conf.EnvelopeSize,
+	)
+
+	for i := range pad {
+		pad[i] ^= clearText[i]
+	}
+
+	return pad
+}
+
+func TestMask_Reference(t *testing.T) {
+	testAll(t, func(t2 *testing.T, conf *configuration) {
+		server, _ := conf.conf.Server()
+		c := server.GetConf()
+
+		for range 32 {
+			nonce := internal.RandomBytes(c.NonceLen)
+			maskingKey := internal.RandomBytes(c.KDF.Size())
+			serverPublicKey := internal.RandomBytes(c.Group.ElementLength())
+			envelope := internal.RandomBytes(c.EnvelopeSize)
+
+			outNonce, masked := masking.Mask(c, nonce, maskingKey, serverPublicKey, envelope)
+			if !bytes.Equal(outNonce, nonce) {
+				t2.Fatal("unexpected masking nonce")
+			}
+
+			if !bytes.Equal(masked, referenceMask(c, nonce, maskingKey, serverPublicKey, envelope)) {
+				t2.Fatal("masked response differs from the reference implementation")
+			}
+		}
+	})
+}
+
+func TestServer_LegacyServerKey(t *testing.T) {
+	testAll(t, func(t2 *testing.T, conf *configuration) {
+		regClient, err := conf.conf.Client()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		server, err := conf.conf.Server()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		password := []byte("password")
+		sks, pks := conf.conf.KeyGen()
+		oprfSeed := conf.conf.GenerateOPRFSeed()
+		record := buildRecord(internal.RandomBytes(32), oprfSeed, password, pks, regClient, server)
+
+		if err = server.SetKeyMaterial(nil, sks, pks, oprfSeed); err != nil {
+			t2.Fatal(err)
+		}
+
+		if server.CanRotateAKEKey() {
+			t2.Fatal("no legacy key is set")
+		}
+
+		// Rotate the AKE key pair, keeping the OPRF seed.
+		newSecretKey, newPublicKey := conf.conf.KeyGen()
+		if err := server.SetKeyMaterial(nil, newSecretKey, newPublicKey, oprfSeed); err != nil {
+			t2.Fatal(err)
+		}
+
+		if err := server.SetLegacyServerKey(sks, pks); err != nil {
+			t2.Fatal(err)
+		}
+
+		if !server.CanRotateAKEKey() {
+			t2.Fatal("expected a legacy key to be set")
+		}
+
+		login := func(record *opaque.ClientRecord, legacyPublicKey []byte) error {
+			defer server.Ake.Flush()
+
+			client, err := conf.conf.Client()
+			if err != nil {
+				t2.Fatal(err)
+			}
+
+			ke1 := client.GenerateKE1(password)
+
+			var ke2 *message.KE2
+
+			if legacyPublicKey == nil {
+				ke2, err = server.GenerateKE2(ke1, record)
+			} else {
+				ke2, err = server.GenerateKE2WithLegacyServerKey(ke1, record, legacyPublicKey)
+			}
+
+			if err != nil {
+				return err
+			}
+
+			ke3, _, err := client.GenerateKE3(ke2)
+			if err != nil {
+				return err
+			}
+
+			return server.LoginFinish(ke3)
+		}
+
+		// The old record logs in with the legacy key, but not with the new one.
+		if err := login(record, pks); err != nil {
+			t2.Fatal(err)
+		}
+
+		if err := login(record, nil); err == nil {
+			t2.Fatal("expected an error logging in with the new key and an old record")
+		}
+
+		// A record registered under the new key logs in with GenerateKE2.
+		client, err := conf.conf.Client()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		other, _ := conf.conf.Server()
+		newRecord := buildRecord(internal.RandomBytes(32), oprfSeed, password, newPublicKey, client, other)
+
+		if err := login(newRecord, nil); err != nil {
+			t2.Fatal(err)
+		}
+
+		// Wrong legacy key.
+		if err := login(record, newPublicKey); !errors.Is(err, opaque.ErrNoLegacyServerKey) {
+			t2.Fatalf("expected %q, got %v", opaque.ErrNoLegacyServerKey, err)
+		}
+
+		// End of the rotation window.
+		if err := server.SetKeyMaterial(nil, newSecretKey, newPublicKey, oprfSeed); err != nil {
+			t2.Fatal(err)
+		}
+
+		if server.CanRotateAKEKey() {
+			t2.Fatal("expected the legacy key to be cleared")
+		}
+
+		if err := login(record, pks); !errors.Is(err, opaque.ErrNoLegacyServerKey) {
+			t2.Fatalf("expected %q, got %v", opaque.ErrNoLegacyServerKey, err)
+		}
+
+		// Invalid legacy keys.
+		if err := server.SetLegacyServerKey(sks, pks[1:]); !errors.Is(
+			err, opaque.ErrInvalidPksLength) {
+			t2.Fatalf("expected %q, got %v", opaque.ErrInvalidPksLength, err)
+		}
+
+		unset, _ := conf.conf.Server()
+		if err := unset.SetLegacyServerKey(sks, pks); !errors.Is(
+			err, opaque.ErrNoServerKeyMaterial) {
+			t2.Fatalf("expected %q, got %v", opaque.ErrNoServerKeyMaterial, err)
+		}
+	})
+}
+
+func TestServer_KeyMaterialSealed(t *testing.T) {
+	testAll(t, func(t2 *testing.T, conf *configuration) {
+		client, err := conf.conf.Client()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		server, err := conf.conf.Server()
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		password := []byte("password")
+		sks, pks := conf.conf.KeyGen()
+		oprfSeed := conf.conf.GenerateOPRFSeed()
+		record := buildRecord(internal.RandomBytes(32), oprfSeed, password, pks, client, server)
+
+		if err = server.SetKeyMaterial(nil, sks, pks, oprfSeed); err != nil {
+			t2.Fatal(err)
+		}
+
+		key := internal.RandomBytes(32)
+
+		if err := server.SetKeyMaterial([]byte("server"), sks, pks,
+			oprfSeed); err != nil {
+			t2.Fatal(err)
+		}
+
+		sealed, err := server.ExportKeyMaterialSealed(key)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		standby, _ := conf.conf.Server()
+		if err = standby.ImportKeyMaterialSealed(key, sealed); err != nil {
+			t2.Fatal(err)
+		}
+
+		// Both servers produce the same KE2 for the same inputs.
+		ke1 := client.GenerateKE1(password)
+		options := opaque.GenerateKE2Options{
+			KeyShareSeed: internal.RandomBytes(32),
+			AKENonce:     internal.RandomBytes(32),
+			MaskingNonce: internal.RandomBytes(32),
+		}
+
+		ke2, err := server.GenerateKE2(ke1, record, options)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		ke2Standby, err := standby.GenerateKE2(ke1, record, options)
+		if err != nil {
+			t2.Fatal(err)
+		}
+
+		if !bytes.Equal(ke2.Serialize(), ke2Standby.Serialize()) {
+			t2.Fatal("expected the same KE2 from the restored server")
+		}
+
+		// The previous OPRF seed and legacy key pair are kept.
+		legacySecretKey, legacyPublicKey := conf.conf.KeyGen()
+		if err = server.SetKeyMaterialWithPreviousSeed(nil, sks, pks,
+			conf.conf.GenerateOPRFSeed(), oprfSeed); err != nil {
+			t2.Fatal(err)
+		}
+
+		if err = server.SetLegacyServerKey(legacySecretKey, legacyPublicKey); err != nil {
+			t2.Fatal(err)
+		}
+
+		if sealed, err = server.ExportKeyMaterialSealed(key); err != nil {
+			t2.Fatal(err)
+		}
+
+		standby, _ = conf.conf.Server()
+		if err = standby.ImportKeyMaterialSealed(key, sealed); err != nil {
+			t2.Fatal(err)
+		}
+
+		if !standby.CanRotateAKEKey() {
+			t2.Fatal("expected the legacy key pair to be restored")
+		}
+
+		previous := *record
+		previous.PreviousOPRFSeed = true
+
+		if _, err = standby.GenerateKE2(client.GenerateKE1(password), &previous); err != nil {
+			t2.Fatal(err)
+		}
+
+		// Wrong key, tampering, and other configurations.
+		if err = standby.ImportKeyMaterialSealed(internal.RandomBytes(32), sealed); !errors.Is(
+			err, opaque.ErrStateAuthentication) {
+			t2.Fatalf("expected %q, got %v", opaque.ErrStateAuthentication, err)
+		}
+
+		tampered := slices.Clone(sealed)
+		tampered[len(tampered)-1] ^= 1
+
+		if err = standby.ImportKeyMaterialSealed(key, tampered); !errors.Is(err, opaque.ErrStateAuthentication) {
+			t2.Fatalf("expected %q, got %v", opaque.ErrStateAuthentication, err)
+		}
+
+		other := conf.conf.Clone()
+		other.Context = []byte("other")
+		otherServer, _ := other.Server()
+
+		if err = otherServer.ImportKeyMaterialSealed(key, sealed); !errors.Is(err, opaque.ErrStateAuthentication) {
+			t2.Fatalf("expected %q, got %v", opaque.ErrStateAuthentication, err)
+		}
+
+		// A secret key that doesn't match the public key.
+		ad := encoding.Concat([]byte(tag.SealedKeyMaterial), standby.GetConf().Fingerprint)
+		forged, _ := internal.Seal(key, encoding.Concatenate(
+			encoding.EncodeVector(nil),
+			encoding.EncodeVector(legacySecretKey),
+			encoding.EncodeVector(pks),
+			encoding.EncodeVector(oprfSeed),
+			encoding.EncodeVector(nil),
+			encoding.EncodeVector(nil),
+			encoding.EncodeVector(nil),
+		), ad)
+
+		if err = standby.ImportKeyMaterialSealed(key, forged); !errors.Is(err, opaque.ErrInvalidKeyMaterial) {
+			t2.Fatalf("expected %q, got %v", opaque.ErrInvalidKeyMaterial, err)
+		}
+
+		if _, err = server.ExportKeyMaterialSealed(key[:31]); !errors.Is(err, opaque.ErrInvalidSealKey) {
+			t2.Fatalf("expected %q, got %v", opaque.ErrInvalidSealKey, err)
+		}
+
+		unset, _ := conf.conf.Server()
+		if _, err = unset.ExportKeyMaterialSealed(key); !errors.Is(err, opaque.ErrNoServerKeyMaterial) {
+			t2.Fatalf("expected %q, got %v", opaque.ErrNoServerKeyMaterial, err)
+		}
+	})
+}
+
+func TestServer_SeedConfigMismatch(t *testing.T) {
+	sha512Conf := opaque.DefaultConfiguration()
+	sha256Conf := opaque.DefaultConfiguration()
+	sha256Conf.OPRF = opaque.P256Sha256
+	sha256Conf.AKE = opaque.P256Sha256
+	sha256Conf.KDF = crypto.SHA256
+	sha256Conf.MAC = crypto.SHA256
+	sha256Conf.Hash = crypto.SHA256
+
+	for _, test := range []struct {
+		conf, other *opaque.Configuration
+	}{
+		{sha512Conf, sha256Conf},
+		{sha256Conf, sha512Conf},
+	} {
+		server, err := test.conf.Server()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sk, pk := test.conf.KeyGen()
+
+		// A seed generated for the other configuration.
+		err = server.SetKeyMaterial(nil, sk, pk, test.other.GenerateOPRFSeed())
+		if !errors.Is(err, opaque.ErrSeedConfigMismatch) || !errors.Is(err, opaque.ErrInvalidOPRFSeedLength) {
+			t.Fatalf("expected %q, got %v", opaque.ErrSeedConfigMismatch, err)
+		}
+
+		expected := fmt.Sprintf("got %d bytes, the configuration requires %d", test.other.Hash.Size(),
+			test.conf.Hash.Size())
+		if !strings.Contains(err.Error(), expected) {
+			t.Fatalf("expected the error to contain %q, got %q", expected, err)
+		}
+
+		// A seed of no hash function's output length.
+		err = server.SetKeyMaterial(nil, sk, pk, internal.RandomBytes(33))
+		if !errors.Is(err, opaque.ErrInvalidOPRFSeedLength) || errors.Is(err, opaque.ErrSeedConfigMismatch) {
+			t.Fatalf("expected only %q, got %v", opaque.ErrInvalidOPRFSeedLength, err)
+		}
+
+		if err = server.SetKeyMaterial(nil, sk, pk, test.conf.GenerateOPRFSeed()); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+// SPDX-License-Identifier: MIT
+//
+// Copyright (C) 2020-2025 Daniel Bourdrez. All Rights Reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree or at
+// https://spdx.org/licenses/MIT.html
+
+//go:build timingtest
+
+package opaque_test
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/bytemare/opaque"
+	"github.com/bytemare/opaque/internal"
+)
+
+const (
+	// timingRounds is the number of interleaved measurements of each comparison.
+	timingRounds = 301
+
+	// timingBatch is the number of comparisons timed per measurement, to rise above the timer's resolution.
+	timingBatch = 20000
+
+	// timingTolerance is the maximum relative difference between the median timings, above the noise of repeated
+	// measurements.
+	timingTolerance = 0.10
+)
+
+// timingSink receives the comparison results, so that the compiler can't optimize the comparisons away.
+var timingSink bool
+
+// timeBatch returns the duration of a batch of calls to compare.
+func timeBatch(compare func() bool) time.Duration {
+	start := time.Now()
+
+	for range timingBatch {
+		timingSink = compare()
+	}
+
+	return time.Since(start)
+}
+
+// TestMAC_EqualConstantTime checks that the MAC comparison used to authenticate KE2 and KE3 doesn't take measurably
+// longer for matching MACs than for MACs differing in their first byte, as an early-exit comparison would.
+func TestMAC_EqualConstantTime(t *testing.T) {
+	server, err := opaque.DefaultConfiguration().Server()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mac := server.GetConf().MAC
+	expected := internal.RandomBytes(mac.Size())
+	matching := slices.Clone(expected)
+	mismatching := slices.Clone(expected)
+	mismatching[0] ^= 0xff
+
+	equal := make([]time.Duration, timingRounds)
+	early := make([]time.Duration, timingRounds)
+
+	for i := range timingRounds {
+		equal[i] = timeBatch(func() bool { return mac.Equal(expected, matching) })
+		early[i] = timeBatch(func() bool { return mac.Equal(expected, mismatching) })
+	}
+
+	slices.Sort(equal)
+	slices.Sort(early)
+
+	medianEqual, medianEarly := equal[timingRounds/2], early[timingRounds/2]
+	difference := float64(medianEqual-medianEarly) / float64(max(medianEqual, medianEarly))
+
+	if difference > timingTolerance || difference < -timingTolerance {
+		t.Fatalf("MAC comparison timing depends on the input: median %v for matching MACs, %v for early mismatch",
+			medianEqual, medianEarly)
+	}
+}
@@ -13,6 +13,7 @@ import (
 	"crypto"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -140,7 +141,10 @@ func (v *vector) testRegistration(conf *opaque.Configuration, t *testing.T) {
 		panic(err)
 	}
 
-	regResp := server.RegistrationResponse(regReq, pks, v.Inputs.CredentialIdentifier, v.Inputs.OprfSeed)
+	regResp, err := server.RegistrationResponse(regReq, pks, v.Inputs.CredentialIdentifier, v.Inputs.OprfSeed)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	vRegResp, err := client.Deserialize.RegistrationResponse(v.Outputs.RegistrationResponse)
 	if err != nil {
@@ -307,6 +311,9 @@ func (v *vector) test(t *testing.T) {
 		KDF:     kdfToHash(v.Config.KDF),
 		MAC:     macToHash(v.Config.MAC),
 		Context: []byte(v.Config.Context),
+
+		// The test vectors use the identity KSF.
+		AllowNoKSF: true,
 	}
 
 	// Registration
@@ -486,7 +493,7 @@ func groupToGroup(g string) opaque.Group {
 	case "P256_XMD:SHA-256_SSWU_RO_":
 		return opaque.P256Sha256
 	case "P384_XMD:SHA-384_SSWU_RO_":
-		return opaque.P384Sha512
+		return opaque.P384Sha384
 	case "P521_XMD:SHA-512_SSWU_RO_":
 		return opaque.P521Sha512
 	// case "curve25519_XMD:SHA-512_ELL2_RO_":
@@ -529,3 +536,31 @@ func TestOpaqueVectors(t *testing.T) {
 		t.Run(fmt.Sprintf("%s - %s - Fake:%s", tv.Config.Name, tv.Config.Group, tv.Config.Fake), tv.test)
 	}
 }
+
+func TestRunTestVector(t *testing.T) {
+	for _, file := range []string{"ristretto255-sha512.json", "p256-sha256.json"} {
+		contents, err := os.ReadFile("testdata/vectors/" + file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var vectors []opaque.TestVector
+		if err = json.Unmarshal(contents, &vectors); err != nil {
+			t.Fatal(err)
+		}
+
+		for i, tv := range vectors {
+			t.Run(fmt.Sprintf("%s - %d - Fake:%s", file, i, tv.Config.Fake), func(t *testing.T) {
+				if err := opaque.RunTestVector(tv); err != nil {
+					t.Fatal(err)
+				}
+
+				// Tampering with an expected output must be detected.
+				tv.Outputs.KE2 = strings.Repeat("00", len(tv.Outputs.KE2)/2)
+				if err := opaque.RunTestVector(tv); !errors.Is(err, opaque.ErrTestVectorMismatch) {
+					t.Fatalf("expected %q, got %v", opaque.ErrTestVectorMismatch, err)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,69 @@
+// SPDX-License-Identifier: MIT
+//
+// Copyright (C) 2020-2025 Daniel Bourdrez. All Rights Reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree or at
+// https://spdx.org/licenses/MIT.html
+
+//go:build js && wasm
+
+package opaque_test
+
+import (
+	"bytes"
+	"runtime"
+	"testing"
+
+	"github.com/bytemare/opaque"
+	"github.com/bytemare/opaque/internal"
+)
+
+// TestWASM_Roundtrip runs a full registration and login under js/wasm, e.g. with
+// GOOS=js GOARCH=wasm go test -exec="$(go env GOROOT)/lib/wasm/go_js_wasm_exec" -run WASM ./tests.
+func TestWASM_Roundtrip(t *testing.T) {
+	goroutines := runtime.NumGoroutine()
+
+	conf := opaque.DefaultConfiguration()
+
+	client, err := conf.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server, err := conf.Server()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	password := []byte("password")
+	sks, pks := conf.KeyGen()
+	oprfSeed := conf.GenerateOPRFSeed()
+	record := buildRecord(internal.RandomBytes(32), oprfSeed, password, pks, client, server)
+
+	if err = server.SetKeyMaterial(nil, sks, pks, oprfSeed); err != nil {
+		t.Fatal(err)
+	}
+
+	ke2, err := server.GenerateKE2(client.GenerateKE1(password), record)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ke3, _, err := client.GenerateKE3(ke2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = server.LoginFinish(ke3); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(client.SessionKey(), server.SessionKey()) {
+		t.Fatal("expected the same session key")
+	}
+
+	if n := runtime.NumGoroutine(); n != goroutines {
+		t.Fatalf("expected no goroutine to be started, got %d instead of %d", n, goroutines)
+	}
+}
@@ -0,0 +1,430 @@
+// SPDX-License-Identifier: MIT
+//
+// Copyright (C) 2020-2025 Daniel Bourdrez. All Rights Reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree or at
+// https://spdx.org/licenses/MIT.html
+
+package opaque
+
+import (
+	"bytes"
+	"crypto"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/bytemare/ksf"
+
+	"github.com/bytemare/opaque/internal/encoding"
+	"github.com/bytemare/opaque/message"
+)
+
+var (
+	// ErrTestVectorMismatch indicates that a value produced when running a test vector differs from the expected one.
+	ErrTestVectorMismatch = errors.New("test vector mismatch")
+
+	// errTestVectorUnsupported happens when a test vector uses a primitive that is not supported.
+	errTestVectorUnsupported = errors.New("unsupported test vector configuration")
+)
+
+// TestVectorConfig mirrors the "config" field of the draft's JSON test vectors.
+type TestVectorConfig struct {
+	Context string `json:"Context"`
+	Fake    string `json:"Fake"`
+	Group   string `json:"Group"`
+	Hash    string `json:"Hash"`
+	KDF     string `json:"KDF"`
+	KSF     string `json:"KSF"`
+	MAC     string `json:"MAC"`
+	Name    string `json:"Name"`
+	OPRF    string `json:"OPRF"`
+}
+
+// TestVectorInputs mirrors the "inputs" field of the draft's JSON test vectors. All values are hex encoded.
+type TestVectorInputs struct {
+	BlindLogin           string `json:"blind_login,omitempty"`
+	BlindRegistration    string `json:"blind_registration,omitempty"`
+	ClientIdentity       string `json:"client_identity,omitempty"`
+	ClientKeyshareSeed   string `json:"client_keyshare_seed"`
+	ClientNonce          string `json:"client_nonce,omitempty"`
+	ClientPublicKey      string `json:"client_public_key,omitempty"`
+	CredentialIdentifier string `json:"credential_identifier"`
+	EnvelopeNonce        string `json:"envelope_nonce,omitempty"`
+	KE1                  string `json:"KE1,omitempty"`
+	MaskingKey           string `json:"masking_key,omitempty"`
+	MaskingNonce         string `json:"masking_nonce"`
+	OprfSeed             string `json:"oprf_seed"`
+	Password             string `json:"password,omitempty"`
+	ServerIdentity       string `json:"server_identity,omitempty"`
+	ServerKeyshareSeed   string `json:"server_keyshare_seed"`
+	ServerNonce          string `json:"server_nonce"`
+	ServerPrivateKey     string `json:"server_private_key"`
+	ServerPublicKey      string `json:"server_public_key"`
+}
+
+// TestVectorOutputs mirrors the "outputs" field of the draft's JSON test vectors. All values are hex encoded.
+type TestVectorOutputs struct {
+	KE1                  string `json:"KE1,omitempty"`
+	KE2                  string `json:"KE2"`
+	KE3                  string `json:"KE3,omitempty"`
+	ExportKey            string `json:"export_key,omitempty"`
+	RegistrationRequest  string `json:"registration_request,omitempty"`
+	RegistrationResponse string `json:"registration_response,omitempty"`
+	RegistrationUpload   string `json:"registration_upload,omitempty"`
+	SessionKey           string `json:"session_key,omitempty"`
+}
+
+// TestVector mirrors the draft's JSON test vectors, and can be directly unmarshalled from them.
+type TestVector struct {
+	Config  TestVectorConfig  `json:"config"`
+	Inputs  TestVectorInputs  `json:"inputs"`
+	Outputs TestVectorOutputs `json:"outputs"`
+}
+
+// hexDecoder decodes hex strings, retaining the first error encountered.
+type hexDecoder struct {
+	err error
+}
+
+func (h *hexDecoder) decode(name, s string) []byte {
+	if h.err != nil || s == "" {
+		return nil
+	}
+
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		h.err = fmt.Errorf("decoding %s: %w", name, err)
+	}
+
+	return b
+}
+
+// testVectorValues holds the decoded test vector values.
+type testVectorValues struct {
+	blindLogin, blindRegistration, clientIdentity, clientKeyshareSeed, clientNonce, clientPublicKey []byte
+	credentialIdentifier, envelopeNonce, maskingKey, maskingNonce, oprfSeed, password               []byte
+	serverIdentity, serverKeyshareSeed, serverNonce, serverPrivateKey, serverPublicKey              []byte
+	ke1, ke2, ke3, exportKey, registrationRequest, registrationResponse, registrationUpload         []byte
+	sessionKey                                                                                      []byte
+}
+
+func (tv *TestVector) decode() (*testVectorValues, error) {
+	var h hexDecoder
+
+	v := &testVectorValues{
+		blindLogin:           h.decode("blind_login", tv.Inputs.BlindLogin),
+		blindRegistration:    h.decode("blind_registration", tv.Inputs.BlindRegistration),
+		clientIdentity:       h.decode("client_identity", tv.Inputs.ClientIdentity),
+		clientKeyshareSeed:   h.decode("client_keyshare_seed", tv.Inputs.ClientKeyshareSeed),
+		clientNonce:          h.decode("client_nonce", tv.Inputs.ClientNonce),
+		clientPublicKey:      h.decode("client_public_key", tv.Inputs.ClientPublicKey),
+		credentialIdentifier: h.decode("credential_identifier", tv.Inputs.CredentialIdentifier),
+		envelopeNonce:        h.decode("envelope_nonce", tv.Inputs.EnvelopeNonce),
+		maskingKey:           h.decode("masking_key", tv.Inputs.MaskingKey),
+		maskingNonce:         h.decode("masking_nonce", tv.Inputs.MaskingNonce),
+		oprfSeed:             h.decode("oprf_seed", tv.Inputs.OprfSeed),
+		password:             h.decode("password", tv.Inputs.Password),
+		serverIdentity:       h.decode("server_identity", tv.Inputs.ServerIdentity),
+		serverKeyshareSeed:   h.decode("server_keyshare_seed", tv.Inputs.ServerKeyshareSeed),
+		serverNonce:          h.decode("server_nonce", tv.Inputs.ServerNonce),
+		serverPrivateKey:     h.decode("server_private_key", tv.Inputs.ServerPrivateKey),
+		serverPublicKey:      h.decode("server_public_key", tv.Inputs.ServerPublicKey),
+		ke1:                  h.decode("KE1", tv.Outputs.KE1),
+		ke2:                  h.decode("KE2", tv.Outputs.KE2),
+		ke3:                  h.decode("KE3", tv.Outputs.KE3),
+		exportKey:            h.decode("export_key", tv.Outputs.ExportKey),
+		registrationRequest:  h.decode("registration_request", tv.Outputs.RegistrationRequest),
+		registrationResponse: h.decode("registration_response", tv.Outputs.RegistrationResponse),
+		registrationUpload:   h.decode("registration_upload", tv.Outputs.RegistrationUpload),
+		sessionKey:           h.decode("session_key", tv.Outputs.SessionKey),
+	}
+
+	if tv.Config.Fake == "True" {
+		v.ke1 = h.decode("KE1", tv.Inputs.KE1)
+	}
+
+	if h.err != nil {
+		return nil, h.err
+	}
+
+	return v, nil
+}
+
+func testVectorGroup(name string) (Group, error) {
+	switch name {
+	case "ristretto255", "ristretto255-SHA512":
+		return RistrettoSha512, nil
+	case "P256_XMD:SHA-256_SSWU_RO_", "P256-SHA256":
+		return P256Sha256, nil
+	case "P384_XMD:SHA-384_SSWU_RO_", "P384-SHA384":
+		return P384Sha384, nil
+	case "P521_XMD:SHA-512_SSWU_RO_", "P521-SHA512":
+		return P521Sha512, nil
+	default:
+		return 0, fmt.Errorf("%w: group %q", errTestVectorUnsupported, name)
+	}
+}
+
+func testVectorHash(name string) (crypto.Hash, error) {
+	switch name {
+	case "SHA256", "HKDF-SHA256", "HMAC-SHA256":
+		return crypto.SHA256, nil
+	case "SHA384", "HKDF-SHA384", "HMAC-SHA384":
+		return crypto.SHA384, nil
+	case "SHA512", "HKDF-SHA512", "HMAC-SHA512":
+		return crypto.SHA512, nil
+	default:
+		return 0, fmt.Errorf("%w: hash function %q", errTestVectorUnsupported, name)
+	}
+}
+
+func testVectorKSF(name string) (ksf.Identifier, error) {
+	switch name {
+	case "Identity":
+		return 0, nil
+	case "Scrypt":
+		return ksf.Scrypt, nil
+	default:
+		return 0, fmt.Errorf("%w: KSF %q", errTestVectorUnsupported, name)
+	}
+}
+
+// configuration returns the Configuration described by the test vector.
+func (tv *TestVector) configuration() (*Configuration, error) {
+	oprf, err := testVectorGroup(tv.Config.OPRF)
+	if err != nil {
+		return nil, err
+	}
+
+	group, err := testVectorGroup(tv.Config.Group)
+	if err != nil {
+		return nil, err
+	}
+
+	k, err := testVectorKSF(tv.Config.KSF)
+	if err != nil {
+		return nil, err
+	}
+
+	var hashes [3]crypto.Hash
+	for i, name := range []string{tv.Config.KDF, tv.Config.MAC, tv.Config.Hash} {
+		if hashes[i], err = testVectorHash(name); err != nil {
+			return nil, err
+		}
+	}
+
+	context, err := hex.DecodeString(tv.Config.Context)
+	if err != nil {
+		return nil, fmt.Errorf("decoding context: %w", err)
+	}
+
+	return &Configuration{
+		OPRF:           oprf,
+		AKE:            group,
+		KSF:            k,
+		KDF:            hashes[0],
+		MAC:            hashes[1],
+		Hash:           hashes[2],
+		NonceLength:    0,
+		AllowNoKSF:     true,
+		LongIdentities: false,
+		Context:        context,
+		Policy:         nil,
+		ksfParameters:  nil,
+	}, nil
+}
+
+func compareVector(name string, expected, got []byte) error {
+	if !bytes.Equal(expected, got) {
+		return fmt.Errorf("%w: %s\n\twant: %x\n\tgot : %x", ErrTestVectorMismatch, name, expected, got)
+	}
+
+	return nil
+}
+
+// RunTestVector drives registration and login as described by the test vector, with all randomness injected from it,
+// and returns an error if any message emitted differs from the expected one. This allows verifying a build against the
+// official test vectors. For fake credential vectors, only the server's KE2 is verified.
+func RunTestVector(tv TestVector) error {
+	conf, err := tv.configuration()
+	if err != nil {
+		return err
+	}
+
+	v, err := tv.decode()
+	if err != nil {
+		return err
+	}
+
+	server, err := conf.Server()
+	if err != nil {
+		return err
+	}
+
+	if tv.Config.Fake == "True" {
+		record, err := server.Deserialize.RegistrationRecord(
+			encoding.Concat3(v.clientPublicKey, v.maskingKey, make([]byte, server.conf.EnvelopeSize)),
+		)
+		if err != nil {
+			return fmt.Errorf("fake record: %w", err)
+		}
+
+		_, err = runTestVectorServer(server, v, &ClientRecord{
+			CredentialIdentifier: v.credentialIdentifier,
+			ClientIdentity:       v.clientIdentity,
+			PreviousOPRFSeed:     false,
+			RegistrationRecord:   record,
+		})
+
+		return err
+	}
+
+	client, err := conf.Client()
+	if err != nil {
+		return err
+	}
+
+	record, err := runTestVectorRegistration(client, server, v)
+	if err != nil {
+		return err
+	}
+
+	return runTestVectorLogin(client, server, v, record)
+}
+
+func runTestVectorRegistration(client *Client, server *Server, v *testVectorValues) (*ClientRecord, error) {
+	blind := client.conf.OPRF.Group().NewScalar()
+	if err := blind.Decode(v.blindRegistration); err != nil {
+		return nil, fmt.Errorf("blind_registration: %w", err)
+	}
+
+	request := client.RegistrationInit(v.password, ClientRegistrationInitOptions{
+		OPRFBlind:          blind,
+		AllowEmptyPassword: false,
+	})
+	if err := compareVector("registration_request", v.registrationRequest, request.Serialize()); err != nil {
+		return nil, err
+	}
+
+	pks, err := server.Deserialize.DecodeAkePublicKey(v.serverPublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("server_public_key: %w", err)
+	}
+
+	response, err := server.RegistrationResponse(request, pks, v.credentialIdentifier, v.oprfSeed)
+	if err != nil {
+		return nil, fmt.Errorf("registration_response: %w", err)
+	}
+
+	if err = compareVector("registration_response", v.registrationResponse, response.Serialize()); err != nil {
+		return nil, err
+	}
+
+	upload, exportKey := client.RegistrationFinalize(response, ClientRegistrationFinalizeOptions{
+		ClientIdentity: v.clientIdentity,
+		ServerIdentity: v.serverIdentity,
+		EnvelopeNonce:  v.envelopeNonce,
+		KDFSalt:        nil,
+		KSFSalt:        nil,
+		KSFParameters:  nil,
+		KSFLength:      0,
+	})
+
+	if err = compareVector("registration_upload", v.registrationUpload, upload.Serialize()); err != nil {
+		return nil, err
+	}
+
+	if err = compareVector("export_key", v.exportKey, exportKey); err != nil {
+		return nil, err
+	}
+
+	return &ClientRecord{
+		CredentialIdentifier: v.credentialIdentifier,
+		ClientIdentity:       v.clientIdentity,
+		PreviousOPRFSeed:     false,
+		RegistrationRecord:   upload,
+	}, nil
+}
+
+func runTestVectorServer(server *Server, v *testVectorValues, record *ClientRecord) (*message.KE2, error) {
+	if err := server.SetKeyMaterial(v.serverIdentity, v.serverPrivateKey, v.serverPublicKey, v.oprfSeed); err != nil {
+		return nil, err
+	}
+
+	ke1, err := server.Deserialize.KE1(v.ke1)
+	if err != nil {
+		return nil, fmt.Errorf("KE1: %w", err)
+	}
+
+	ke2, err := server.GenerateKE2(ke1, record, GenerateKE2Options{
+		KeyShareSeed:   v.serverKeyshareSeed,
+		AKENonce:       v.serverNonce,
+		AKENonceLength: 0,
+		MaskingNonce:   v.maskingNonce,
+		AssociatedData: nil,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err = compareVector("KE2", v.ke2, ke2.Serialize()); err != nil {
+		return nil, err
+	}
+
+	return ke2, nil
+}
+
+func runTestVectorLogin(client *Client, server *Server, v *testVectorValues, record *ClientRecord) error {
+	blind := client.conf.OPRF.Group().NewScalar()
+	if err := blind.Decode(v.blindLogin); err != nil {
+		return fmt.Errorf("blind_login: %w", err)
+	}
+
+	ke1 := client.GenerateKE1(v.password, GenerateKE1Options{
+		OPRFBlind:          blind,
+		KeyShareSeed:       v.clientKeyshareSeed,
+		AKENonce:           v.clientNonce,
+		AKENonceLength:     0,
+		AllowEmptyPassword: false,
+	})
+	if err := compareVector("KE1", v.ke1, ke1.Serialize()); err != nil {
+		return err
+	}
+
+	ke2, err := runTestVectorServer(server, v, record)
+	if err != nil {
+		return err
+	}
+
+	ke3, exportKey, err := client.GenerateKE3(ke2, GenerateKE3Options{
+		ClientIdentity: v.clientIdentity,
+		ServerIdentity: v.serverIdentity,
+		KDFSalt:        nil,
+		KSFSalt:        nil,
+		KSFParameters:  nil,
+		KSFLength:      0,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err = compareVector("KE3", v.ke3, ke3.Serialize()); err != nil {
+		return err
+	}
+
+	if err = compareVector("export_key", v.exportKey, exportKey); err != nil {
+		return err
+	}
+
+	if err = compareVector("client session_key", v.sessionKey, client.SessionKey()); err != nil {
+		return err
+	}
+
+	if err = server.LoginFinish(ke3); err != nil {
+		return err
+	}
+
+	return compareVector("server session_key", v.sessionKey, server.SessionKey())
+}